@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -13,11 +14,30 @@ import (
 	"time"
 
 	"github.com/CLIAIRMONITOR/internal/aider"
+	"github.com/CLIAIRMONITOR/internal/api"
+	"github.com/CLIAIRMONITOR/internal/dashboard"
 	"github.com/CLIAIRMONITOR/internal/memory"
+	"github.com/CLIAIRMONITOR/internal/sergeant"
+	"github.com/CLIAIRMONITOR/internal/stream"
 	"github.com/nats-io/nats-server/v2/server"
 )
 
 func main() {
+	// Subcommands run without starting the server
+	if len(os.Args) > 1 && os.Args[1] == "transcript" {
+		if err := runTranscript(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "openapi" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(api.OpenAPI())
+		return
+	}
+
 	// Parse command line flags
 	configPath := flag.String("config", "configs/agents.yaml", "Path to configuration file")
 	port := flag.Int("port", 0, "Override server port (0 = use config)")
@@ -54,6 +74,7 @@ func main() {
 	log.Printf("[MAIN] NATS port: %d", config.Server.NATSPort)
 	log.Printf("[MAIN] LM Studio URL: %s", config.Ollama.URL)
 	log.Printf("[MAIN] Model: %s", config.Ollama.Model)
+	log.Printf("[MAIN] Aider model: %s", config.Aider.Model)
 
 	// Initialize memory databases
 	dataDir := "data"
@@ -73,9 +94,19 @@ func main() {
 	}
 	defer learningDB.Close()
 
-	// Configure LM Studio embedding provider
-	embeddingProvider := memory.NewLMStudioEmbedding("http://localhost:1234/v1", "qwen2.5-coder-7b-instruct")
-	learningDB.SetEmbeddingProvider(embeddingProvider)
+	// Configure LM Studio embedding provider, with retries and a persistent
+	// cache; anything embedded by another model is re-embedded in the background
+	learningDB.SetEmbedRate(config.Memory.EmbedRate)
+	embeddingProvider := memory.NewCachingEmbedder(
+		memory.NewRetryingEmbedder(memory.NewLMStudioEmbedding(config.Ollama.URL, config.Ollama.Model), memory.DefaultRetryPolicy),
+		learningDB.EmbeddingCache(),
+	)
+	if err := learningDB.SetEmbeddingProvider(embeddingProvider); err != nil {
+		log.Printf("[MAIN] Failed to load embeddings for %s: %v", embeddingProvider.Model(), err)
+	}
+	if config.Memory.LLMSummaries {
+		learningDB.SetSummarizer(memory.NewLMStudioSummarizer(config.Ollama.URL, config.Ollama.Model))
+	}
 
 	log.Println("[MAIN] Memory system initialized (operational + learning databases)")
 
@@ -106,90 +137,71 @@ func main() {
 
 	// Create Aider spawner (it will create individual NATS clients for each agent)
 	spawner := aider.NewSpawner(natsURL, config)
+	spawner.SetOperationalDB(operationalDB)
+	spawner.SetLearningDB(learningDB)
 	log.Println("[MAIN] Aider spawner initialized")
 
-	// Make databases available (can be used by handlers)
-	_ = operationalDB
-	_ = learningDB
-
-	// Set up HTTP server for dashboard
-	mux := http.NewServeMux()
-
-	// Health check endpoint
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, `{"status":"ok","agents":%d}`, len(spawner.ListAgents()))
-	})
-
-	// List agents endpoint
-	mux.HandleFunc("/api/agents", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		agents := spawner.ListAgents()
-
-		result := "["
-		for i, agent := range agents {
-			if i > 0 {
-				result += ","
-			}
-			status, task := agent.Bridge.GetStatus()
-			result += fmt.Sprintf(`{"id":"%s","project":"%s","model":"%s","status":"%s","task":"%s","uptime":"%s"}`,
-				agent.ID, agent.ProjectPath, agent.Model, status, task, time.Since(agent.StartedAt).Round(time.Second))
-		}
-		result += "]"
-		w.Write([]byte(result))
-	})
-
-	// Spawn agent endpoint
-	mux.HandleFunc("/api/agents/spawn", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-
-		projectPath := r.URL.Query().Get("project")
-		if projectPath == "" {
-			http.Error(w, "project parameter required", http.StatusBadRequest)
-			return
+	// Pick up supervised agents left running by a previous monitor
+	if config.Supervisor.Enabled {
+		count, err := spawner.Reattach()
+		if err != nil {
+			log.Printf("[MAIN] Failed to reattach agents: %v", err)
+		} else {
+			log.Printf("[MAIN] Reattached %d running agents", count)
 		}
+	}
 
-		agentConfig := aider.AgentConfig{
-			Name:        "Qwen-Agent",
-			Role:        "developer",
-			ProjectPath: projectPath,
-		}
+	// Start Sergeant to dispatch queued tasks to idle agents; it adopts the
+	// tasks the reattached agents are still working on
+	sgt := sergeant.New(natsURL, config, spawner, operationalDB)
+	sgt.SetLearningDB(learningDB)
+	if err := sgt.Start(); err != nil {
+		log.Fatalf("[MAIN] Failed to start sergeant: %v", err)
+	}
 
-		agent, err := spawner.SpawnAgent(agentConfig)
+	// Start the agents: entries that have a project_path, keeping any that
+	// were just reattached. SIGHUP or POST /api/agents/reload re-reads the
+	// section and reconciles again; other sections need a restart.
+	spawner.Reconcile(config.Agents)
+	reloadAgents := func() (aider.ReconcileResult, error) {
+		reloaded, err := aider.LoadConfig(*configPath)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return aider.ReconcileResult{}, err
 		}
+		log.Printf("[MAIN] Reloaded agents from %s", *configPath)
+		return spawner.Reconcile(reloaded.Agents), nil
+	}
+
+	// Set up HTTP server for dashboard
+	mux := http.NewServeMux()
 
+	// Health check endpoint
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"id":"%s","project":"%s","model":"%s"}`, agent.ID, agent.ProjectPath, agent.Model)
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "agents": len(spawner.ListAgents())})
 	})
 
-	// Stop agent endpoint
-	mux.HandleFunc("/api/agents/stop", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-
-		agentID := r.URL.Query().Get("id")
-		if agentID == "" {
-			http.Error(w, "id parameter required", http.StatusBadRequest)
-			return
-		}
+	// REST API over the operational and learning databases, and control of
+	// the running agents
+	apiServer := api.New(operationalDB, learningDB)
+	apiServer.SetAgents(spawner, reloadAgents)
+	mux.Handle(api.Prefix+"/", apiServer)
 
-		if err := spawner.StopAgent(agentID); err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
-		}
+	// Web dashboard (embedded, no external assets) with live updates from NATS
+	dash := dashboard.New(spawner, operationalDB, learningDB)
+	if err := dash.Start(natsURL); err != nil {
+		log.Printf("[MAIN] Dashboard live updates unavailable: %v", err)
+	}
+	mux.Handle("/", dash)
 
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"status":"stopped","id":"%s"}`, agentID)
-	})
+	// NATS over HTTP: SSE or WebSocket streams of agent status and output,
+	// broadcasts and escalations, and commands posted back to agents
+	streamBridge := stream.New(func(agentID string) bool { return spawner.GetAgent(agentID) != nil })
+	if err := streamBridge.Start(natsURL); err != nil {
+		log.Printf("[MAIN] NATS stream bridge unavailable: %v", err)
+	}
+	mux.Handle("/api/stream", streamBridge)
+	mux.Handle("/api/stream/", streamBridge)
 
 	// Create HTTP server
 	httpServer := &http.Server{
@@ -209,9 +221,21 @@ func main() {
 	log.Printf("  CLIAIRMONITOR ready!")
 	log.Printf("  Dashboard: http://localhost:%d", config.Server.Port)
 	log.Printf("  Health:    http://localhost:%d/health", config.Server.Port)
-	log.Printf("  Agents:    http://localhost:%d/api/agents", config.Server.Port)
+	log.Printf("  Agents:    http://localhost:%d%s/agents/running", config.Server.Port, api.Prefix)
+	log.Printf("  API:       http://localhost:%d%s/openapi.json", config.Server.Port, api.Prefix)
 	log.Println("===============================================")
 
+	// Reload configured agents on SIGHUP
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for range hupCh {
+			if _, err := reloadAgents(); err != nil {
+				log.Printf("[MAIN] Failed to reload agents: %v", err)
+			}
+		}
+	}()
+
 	// Wait for shutdown signal
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -223,10 +247,20 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Stop all agents first
-	spawner.StopAll()
+	// Stop dispatching, then stop (or detach from) all agents. Detaching
+	// leaves supervised agents their tasks, for the next monitor to adopt,
+	// and ignores the prompts the bridges abandon on the way out.
+	if config.Supervisor.Enabled && config.Supervisor.DetachOnShutdown {
+		sgt.Detach()
+		spawner.DetachAll()
+	} else {
+		sgt.Stop()
+		spawner.StopAll()
+	}
 
-	// Shutdown HTTP server
+	// Close event streams and WebSockets, then shut down HTTP server
+	dash.Stop()
+	streamBridge.Stop()
 	if err := httpServer.Shutdown(ctx); err != nil {
 		log.Printf("[MAIN] HTTP server shutdown error: %v", err)
 	}
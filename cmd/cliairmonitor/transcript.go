@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/CLIAIRMONITOR/internal/memory"
+)
+
+// maxReplayPause caps the pause between lines when replaying, so long idle
+// stretches in a session do not stall the replay
+const maxReplayPause = 2 * time.Second
+
+// runTranscript implements "cliairmonitor transcript": print, search or
+// replay a session transcript straight from the operational database
+func runTranscript(args []string) error {
+	fs := flag.NewFlagSet("transcript", flag.ExitOnError)
+	dbPath := fs.String("db", "data/operational.db", "Path to the operational database")
+	agentID := fs.String("agent", "", "Only lines from this agent")
+	stream := fs.String("stream", "", "Only this stream (stdin, stdout, stderr)")
+	contains := fs.String("grep", "", "Only lines containing this text (case-insensitive)")
+	replay := fs.Bool("replay", false, "Replay with the original timing")
+	speed := fs.Float64("speed", 1.0, "Replay speed multiplier")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cliairmonitor transcript [flags] [session-id]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	filter := memory.TranscriptFilter{
+		SessionID: fs.Arg(0),
+		AgentID:   *agentID,
+		Stream:    *stream,
+		Contains:  *contains,
+	}
+	if filter.SessionID == "" && filter.AgentID == "" {
+		fs.Usage()
+		return fmt.Errorf("a session ID or -agent is required")
+	}
+	if *speed <= 0 {
+		return fmt.Errorf("speed must be positive")
+	}
+
+	if _, err := os.Stat(*dbPath); err != nil {
+		return fmt.Errorf("operational database not found: %w", err)
+	}
+	db, err := memory.NewSQLiteOperationalDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	lines, err := db.GetTranscript(filter)
+	if err != nil {
+		return fmt.Errorf("failed to read transcript: %w", err)
+	}
+	if len(lines) == 0 {
+		return fmt.Errorf("no transcript lines found")
+	}
+
+	var previous time.Time
+	for _, line := range lines {
+		if *replay && !previous.IsZero() {
+			pause := time.Duration(float64(line.Timestamp.Sub(previous)) / *speed)
+			if pause > maxReplayPause {
+				pause = maxReplayPause
+			}
+			time.Sleep(pause)
+		}
+		previous = line.Timestamp
+		fmt.Println(formatTranscriptLine(line))
+	}
+	return nil
+}
+
+// formatTranscriptLine renders a line as "15:04:05.000 stdout | content"
+func formatTranscriptLine(line *memory.TranscriptLine) string {
+	prefix := fmt.Sprintf("%s %-6s | ", line.Timestamp.Format("15:04:05.000"), line.Stream)
+	// Multi-line prompts stay aligned under the prefix
+	content := strings.ReplaceAll(line.Content, "\n", "\n"+strings.Repeat(" ", len(prefix)))
+	return prefix + content
+}
@@ -2,14 +2,17 @@ package aider
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/CLIAIRMONITOR/internal/memory"
 	natslib "github.com/CLIAIRMONITOR/internal/nats"
 )
 
@@ -19,11 +22,38 @@ type Bridge struct {
 	status      string
 	currentTask string
 
+	// Files added to Aider's chat, re-added after a restart
+	files map[string]bool
+
 	// Process I/O
 	stdin  io.WriteCloser
 	stdout io.ReadCloser
 	stderr io.ReadCloser
 
+	// Structured parsing of stdout; used only by the stdout reader
+	parser *outputParser
+
+	// Prompt queue: pending prompts, the one running and its output so far.
+	// atPrompt is set when the parser sees Aider's input prompt and cleared
+	// when input is sent; unlike status, stderr noise never overwrites it.
+	atPrompt  bool
+	queue     []*queuedPrompt
+	running   *queuedPrompt
+	turn      *turn
+	queueCh   chan struct{}
+	interrupt func() error
+
+	// Optional persistence of status transitions and the session transcript
+	opDB       memory.OperationalDB
+	transcript *transcriptRecorder
+
+	// Optional memory injection into prompts, and episodes recorded from activity
+	memory      *memoryInjector
+	learning    memory.LearningDB
+	sessionID   string
+	projectPath string
+	lastError   string
+
 	// NATS connection
 	natsClient *natslib.Client
 	connected  bool
@@ -42,11 +72,36 @@ func NewBridge(agentID string, nc *natslib.Client, stdin io.WriteCloser, stdout,
 		stdout:     stdout,
 		stderr:     stderr,
 		status:     "starting",
+		files:      make(map[string]bool),
+		parser:     newOutputParser(),
+		queueCh:    make(chan struct{}, 1),
 		connected:  false,
 		stopCh:     make(chan struct{}),
 	}
 }
 
+// SetOperationalDB enables persisting status transitions and heartbeats.
+// Must be called before Start.
+func (b *Bridge) SetOperationalDB(db memory.OperationalDB) {
+	b.opDB = db
+}
+
+// SetSession ties the transcript and recorded episodes to the given session.
+// The transcript requires SetOperationalDB first; must be called before Start.
+func (b *Bridge) SetSession(sessionID string) {
+	b.sessionID = sessionID
+	if b.opDB == nil || sessionID == "" {
+		return
+	}
+	b.transcript = newTranscriptRecorder(b.opDB, b.agentID, sessionID)
+}
+
+// SetInterrupt sets how to send Ctrl-C to the Aider process.
+// Must be called before Start.
+func (b *Bridge) SetInterrupt(interrupt func() error) {
+	b.interrupt = interrupt
+}
+
 // Start begins bridging Aider I/O to NATS
 func (b *Bridge) Start() error {
 	// Subscribe to commands for this agent
@@ -56,9 +111,22 @@ func (b *Bridge) Start() error {
 		return fmt.Errorf("failed to subscribe to commands: %w", err)
 	}
 
+	// Serve request-reply prompts
+	promptSubject := fmt.Sprintf(natslib.SubjectAgentPrompt, b.agentID)
+	if _, err := b.natsClient.Subscribe(promptSubject, b.handlePrompt); err != nil {
+		return fmt.Errorf("failed to subscribe to prompts: %w", err)
+	}
+
+	queueSubject := fmt.Sprintf(natslib.SubjectAgentQueue, b.agentID)
+	if _, err := b.natsClient.Subscribe(queueSubject, b.handleQueue); err != nil {
+		return fmt.Errorf("failed to subscribe to queue requests: %w", err)
+	}
+
 	// Start output parsing goroutines
 	go b.parseAiderOutput()
 	go b.parseAiderErrors()
+	go b.runQueue()
+	b.transcript.start()
 
 	// Mark as connected and publish initial status
 	b.mu.Lock()
@@ -75,6 +143,17 @@ func (b *Bridge) Start() error {
 
 // Stop terminates the bridge and cleans up resources
 func (b *Bridge) Stop() {
+	b.stop(true)
+}
+
+// Detach stops the bridge without sending /quit, leaving a supervised
+// Aider process running so a later monitor can reattach to it
+func (b *Bridge) Detach() {
+	b.stop(false)
+}
+
+// stop tears down the bridge, optionally asking Aider to quit first
+func (b *Bridge) stop(quit bool) {
 	select {
 	case <-b.stopCh:
 		// Already stopped
@@ -89,7 +168,9 @@ func (b *Bridge) Stop() {
 
 	// Send quit command to Aider
 	if b.stdin != nil {
-		fmt.Fprintln(b.stdin, "/quit")
+		if quit {
+			b.writeInput("/quit")
+		}
 		b.stdin.Close()
 	}
 
@@ -101,7 +182,12 @@ func (b *Bridge) Stop() {
 		b.stderr.Close()
 	}
 
-	b.publishStatus("disconnected", "Bridge stopped")
+	if quit {
+		b.publishStatus("disconnected", "Bridge stopped")
+	}
+
+	// Write out the rest of the session transcript
+	b.transcript.close()
 
 	// Close NATS client
 	if b.natsClient != nil {
@@ -113,23 +199,28 @@ func (b *Bridge) Stop() {
 
 // parseAiderOutput continuously reads and parses stdout from Aider
 func (b *Bridge) parseAiderOutput() {
-	scanner := bufio.NewScanner(b.stdout)
-	for scanner.Scan() {
+	err := scanOutput(b.stdout, func(line string) {
 		select {
 		case <-b.stopCh:
 			return
 		default:
 		}
 
-		line := scanner.Text()
+		b.transcript.record(memory.StreamStdout, line)
 		b.parseAiderLine(line)
 
 		// Publish raw output for logging
 		b.publishOutput("stdout", line)
+	})
+	if err != nil {
+		log.Printf("[BRIDGE] Stdout read error: %v", err)
 	}
 
-	if err := scanner.Err(); err != nil {
-		log.Printf("[BRIDGE] Stdout scanner error: %v", err)
+	// Stopped or detached by the spawner, which reports the final state
+	select {
+	case <-b.stopCh:
+		return
+	default:
 	}
 
 	// Process ended
@@ -139,6 +230,47 @@ func (b *Bridge) parseAiderOutput() {
 	b.publishStatus("disconnected", "Aider process ended")
 }
 
+// scanOutput splits Aider's stdout into lines. A trailing partial line that
+// is Aider's input prompt is yielded straight away, because Aider prints the
+// prompt without a newline and then waits for input.
+func scanOutput(r io.Reader, fn func(line string)) error {
+	buf := make([]byte, 4096)
+	var pending []byte
+	for {
+		n, err := r.Read(buf)
+		pending = append(pending, buf[:n]...)
+
+		for {
+			i := bytes.IndexByte(pending, '\n')
+			if i < 0 {
+				break
+			}
+			fn(strings.TrimRight(string(pending[:i]), "\r"))
+			pending = append(pending[:0], pending[i+1:]...)
+		}
+
+		if len(pending) > 0 && isPromptFragment(string(pending)) {
+			fn(string(pending))
+			pending = pending[:0]
+		}
+
+		if err != nil {
+			if len(pending) > 0 {
+				fn(string(pending))
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// isPromptFragment reports whether unterminated output is Aider's input prompt
+func isPromptFragment(s string) bool {
+	return promptPattern.MatchString(s)
+}
+
 // parseAiderErrors continuously reads stderr from Aider
 func (b *Bridge) parseAiderErrors() {
 	scanner := bufio.NewScanner(b.stderr)
@@ -150,10 +282,13 @@ func (b *Bridge) parseAiderErrors() {
 		}
 
 		line := scanner.Text()
+		b.transcript.record(memory.StreamStderr, line)
 		b.publishOutput("stderr", line)
 
 		// Check for errors and update status
 		if strings.Contains(strings.ToLower(line), "error") {
+			b.recordTurnWarning(line)
+			b.recordEpisode(&memory.Episode{EventType: "error", Content: line, Importance: importanceError})
 			b.publishStatus("error", line)
 		}
 	}
@@ -163,59 +298,61 @@ func (b *Bridge) parseAiderErrors() {
 	}
 }
 
-// parseAiderLine interprets Aider output patterns to determine status
+// parseAiderLine feeds a line of output to the parser, publishing any
+// structured events and status change it yields
 func (b *Bridge) parseAiderLine(line string) {
-	lower := strings.ToLower(line)
-	trimmed := strings.TrimSpace(line)
-
-	var newStatus, newTask string
-
-	switch {
-	case strings.Contains(lower, "thinking"):
-		newStatus = "working"
-		newTask = "Thinking..."
-
-	case strings.Contains(lower, "applied edit"):
-		newStatus = "working"
-		newTask = "Applied edit to files"
-
-	case strings.HasPrefix(trimmed, ">"):
-		// Aider prompt indicates ready for input
-		newStatus = "idle"
-		newTask = "Awaiting prompt"
+	result := b.parser.parse(line)
 
-	case strings.Contains(lower, "error"):
-		newStatus = "error"
-		newTask = line
+	b.mu.Lock()
+	if b.turn != nil {
+		b.turn.record(line, result)
+	}
+	for _, event := range result.events {
+		if event.Type == natslib.EventPrompt {
+			b.atPrompt = true
+		}
+	}
+	b.mu.Unlock()
 
-	case strings.Contains(lower, "commit"):
-		newStatus = "working"
-		newTask = "Committing changes"
+	for _, event := range result.events {
+		b.trackFiles(event)
+		b.publishEvent(event)
+		b.recordEpisode(eventEpisode(event))
+	}
 
-	case strings.Contains(lower, "added"):
-		newStatus = "working"
-		newTask = "Adding files to context"
+	if result.status == "" {
+		// No status change for unrecognized patterns
+		return
+	}
 
-	case strings.Contains(lower, "searching"):
-		newStatus = "working"
-		newTask = "Searching codebase"
+	b.publishStatus(result.status, result.task)
+}
 
-	case strings.Contains(lower, "reading"):
-		newStatus = "working"
-		newTask = "Reading files"
+// recordTurnWarning adds a stderr line mentioning an error to the prompt in
+// flight, if any. Warnings such as litellm's do not mean the prompt failed.
+func (b *Bridge) recordTurnWarning(line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.turn != nil {
+		b.turn.response.Warnings = append(b.turn.response.Warnings, line)
+	}
+}
 
-	default:
-		// No status change for unrecognized patterns
+// trackFiles keeps the added-file set in line with Aider's own confirmations
+func (b *Bridge) trackFiles(event natslib.EventMessage) {
+	file, ok := event.Data.(natslib.FileEvent)
+	if !ok {
 		return
 	}
 
-	// Update status and publish
 	b.mu.Lock()
-	b.status = newStatus
-	b.currentTask = newTask
-	b.mu.Unlock()
-
-	b.publishStatus(newStatus, newTask)
+	defer b.mu.Unlock()
+	switch event.Type {
+	case natslib.EventFileAdded:
+		b.files[file.File] = true
+	case natslib.EventFileDropped:
+		delete(b.files, file.File)
+	}
 }
 
 // handleCommand processes incoming commands from NATS
@@ -231,38 +368,55 @@ func (b *Bridge) handleCommand(msg *natslib.Message) {
 
 	switch cmd.Type {
 	case "prompt":
-		// Send user prompt to Aider
+		// Queue user prompt; it is sent once Aider is idle
 		if text, ok := cmd.Payload["text"].(string); ok {
-			b.mu.Lock()
-			b.currentTask = text
-			b.mu.Unlock()
+			timeout, _ := cmd.Payload["timeout_seconds"].(float64)
+			opts := PromptOptions{Timeout: time.Duration(timeout) * time.Second}
+			if inject, ok := cmd.Payload["inject_memory"].(bool); ok {
+				opts.InjectMemory = &inject
+			}
+			opts.TaskType, _ = cmd.Payload["task_type"].(string)
+			if _, err := b.Enqueue(text, opts); err != nil {
+				log.Printf("[BRIDGE] Failed to queue prompt for agent %s: %v", b.agentID, err)
+			}
+		}
 
-			b.publishStatus("working", "Processing prompt")
-			fmt.Fprintln(b.stdin, text)
+	case "cancel":
+		// Drop a queued prompt, or interrupt it if running
+		if promptID, ok := cmd.Payload["prompt_id"].(string); ok {
+			if err := b.Cancel(promptID); err != nil {
+				log.Printf("[BRIDGE] Failed to cancel prompt %s: %v", promptID, err)
+			}
+		}
+
+	case "interrupt":
+		// Send Ctrl-C to abandon the running prompt
+		if err := b.Interrupt(); err != nil {
+			log.Printf("[BRIDGE] Failed to interrupt agent %s: %v", b.agentID, err)
 		}
 
 	case "stop":
-		// Send /quit command to Aider
-		fmt.Fprintln(b.stdin, "/quit")
-		b.publishStatus("stopping", "Quitting Aider")
+		// Queue /quit so Aider exits after the work queued before it
+		if err := b.SendCommand("/quit"); err != nil {
+			log.Printf("[BRIDGE] Failed to queue /quit for agent %s: %v", b.agentID, err)
+		}
 
 	case "clear":
 		// Clear Aider's chat history
-		fmt.Fprintln(b.stdin, "/clear")
-		b.publishStatus("working", "Clearing chat history")
+		if err := b.SendCommand("/clear"); err != nil {
+			log.Printf("[BRIDGE] Failed to queue /clear for agent %s: %v", b.agentID, err)
+		}
 
 	case "add":
 		// Add file to Aider's context
 		if file, ok := cmd.Payload["file"].(string); ok {
-			fmt.Fprintf(b.stdin, "/add %s\n", file)
-			b.publishStatus("working", fmt.Sprintf("Adding file: %s", file))
+			b.AddFile(file)
 		}
 
 	case "drop":
 		// Remove file from Aider's context
 		if file, ok := cmd.Payload["file"].(string); ok {
-			fmt.Fprintf(b.stdin, "/drop %s\n", file)
-			b.publishStatus("working", fmt.Sprintf("Dropping file: %s", file))
+			b.DropFile(file)
 		}
 
 	default:
@@ -275,8 +429,19 @@ func (b *Bridge) publishStatus(status, task string) {
 	b.mu.Lock()
 	b.status = status
 	b.currentTask = task
+	connected := b.connected
 	b.mu.Unlock()
 
+	// Persist while connected; the spawner records the final stopped state
+	if b.opDB != nil && connected {
+		if err := b.opDB.UpdateAgentStatus(b.agentID, memory.AgentStatus(status), task); err != nil {
+			log.Printf("[BRIDGE] Failed to persist status: %v", err)
+		}
+		if err := b.opDB.RecordHeartbeat(b.agentID); err != nil {
+			log.Printf("[BRIDGE] Failed to record heartbeat: %v", err)
+		}
+	}
+
 	msg := natslib.StatusMessage{
 		AgentID:     b.agentID,
 		Status:      status,
@@ -290,6 +455,17 @@ func (b *Bridge) publishStatus(status, task string) {
 	}
 }
 
+// publishEvent publishes a structured event parsed from Aider output
+func (b *Bridge) publishEvent(event natslib.EventMessage) {
+	event.AgentID = b.agentID
+	event.Timestamp = time.Now()
+
+	subject := fmt.Sprintf(natslib.SubjectAgentEvent, b.agentID)
+	if err := b.natsClient.PublishJSON(subject, event); err != nil {
+		log.Printf("[BRIDGE] Failed to publish %s event: %v", event.Type, err)
+	}
+}
+
 // publishOutput publishes raw output to NATS for logging and monitoring
 func (b *Bridge) publishOutput(stream, line string) {
 	msg := natslib.OutputMessage{
@@ -325,12 +501,65 @@ func (b *Bridge) SendPrompt(prompt string) error {
 
 	b.publishStatus("working", "Processing prompt")
 
-	_, err := fmt.Fprintln(b.stdin, prompt)
-	return err
+	return b.writeInput(formatPrompt(prompt))
+}
+
+// formatPrompt wraps multi-line prompts in Aider's { ... } block syntax so
+// they are submitted as a single message instead of one message per line.
+// Prompts containing a bare "}" line (code, injected memory) use a tagged
+// {prompt ... prompt} block so that line does not end the message early.
+func formatPrompt(prompt string) string {
+	prompt = strings.TrimRight(prompt, "\r\n")
+	if !strings.Contains(prompt, "\n") {
+		return prompt
+	}
+	for _, line := range strings.Split(prompt, "\n") {
+		if strings.TrimSpace(line) == "}" {
+			return "{prompt\n" + prompt + "\nprompt}"
+		}
+	}
+	return "{\n" + prompt + "\n}"
+}
+
+// AddFile queues adding a file to Aider's chat context and remembers it
+func (b *Bridge) AddFile(file string) error {
+	b.mu.Lock()
+	b.files[file] = true
+	b.mu.Unlock()
+
+	return b.enqueueCommand("/add " + file)
+}
+
+// DropFile queues removing a file from Aider's chat context
+func (b *Bridge) DropFile(file string) error {
+	b.mu.Lock()
+	delete(b.files, file)
+	b.mu.Unlock()
+
+	return b.enqueueCommand("/drop " + file)
+}
+
+// AddedFiles returns the files currently added to Aider's chat
+func (b *Bridge) AddedFiles() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	files := make([]string, 0, len(b.files))
+	for file := range b.files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
 }
 
-// SendCommand sends a special command to Aider (e.g., /quit, /clear)
+// SendCommand queues a special command to Aider (e.g., /quit, /clear)
 func (b *Bridge) SendCommand(cmd string) error {
-	_, err := fmt.Fprintln(b.stdin, cmd)
+	return b.enqueueCommand(cmd)
+}
+
+// writeInput sends a line to Aider's stdin and records it in the transcript
+func (b *Bridge) writeInput(line string) error {
+	b.transcript.record(memory.StreamStdin, line)
+	_, err := fmt.Fprintln(b.stdin, line)
 	return err
 }
@@ -3,41 +3,243 @@ package aider
 import (
 	"fmt"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // AiderConfig holds configuration for Aider CLI
 type AiderConfig struct {
-	Model          string `yaml:"model" json:"model"`                       // e.g., "ollama/qwen2.5-coder:32b"
-	OllamaURL      string `yaml:"ollama_url" json:"ollama_url"`             // e.g., "http://localhost:11434"
-	AutoCommit     bool   `yaml:"auto_commit" json:"auto_commit"`           // false recommended
-	EditFormat     string `yaml:"edit_format" json:"edit_format"`           // whole, diff, udiff
-	MapTokens      int    `yaml:"map_tokens" json:"map_tokens"`             // repo map token limit
-	MaxChatHistory int    `yaml:"max_chat_history" json:"max_chat_history"` // chat history limit
+	Model           string `yaml:"model" json:"model"`                         // e.g., "openai/qwen2.5-coder-7b-instruct"
+	APIBase         string `yaml:"api_base" json:"api_base"`                   // OpenAI-compatible base URL, e.g. LM Studio
+	APIKey          string `yaml:"api_key" json:"-"`                           // passed via OPENAI_API_KEY, never on the command line
+	OllamaURL       string `yaml:"ollama_url" json:"ollama_url"`               // e.g., "http://localhost:11434"
+	AutoCommit      bool   `yaml:"auto_commit" json:"auto_commit"`             // false recommended
+	EditFormat      string `yaml:"edit_format" json:"edit_format"`             // whole, diff, udiff
+	MapTokens       int    `yaml:"map_tokens" json:"map_tokens"`               // repo map token limit
+	MaxChatHistory  int    `yaml:"max_chat_history" json:"max_chat_history"`   // chat history token limit (0 = aider default)
+	ChatHistoryFile string `yaml:"chat_history_file" json:"chat_history_file"` // optional, relative to project
 }
 
-// AgentConfig holds configuration for a single Aider agent
+// AgentConfig holds configuration for a single Aider agent.
+// The model fields are optional per-agent overrides of the global AiderConfig.
 type AgentConfig struct {
 	Name        string `yaml:"name" json:"name"`
 	Role        string `yaml:"role" json:"role"`
 	Color       string `yaml:"color" json:"color"`
 	ProjectPath string `yaml:"project_path" json:"project_path"`
+
+	Model      string `yaml:"model,omitempty" json:"model,omitempty"`
+	APIBase    string `yaml:"api_base,omitempty" json:"api_base,omitempty"`
+	APIKey     string `yaml:"api_key,omitempty" json:"-"`
+	EditFormat string `yaml:"edit_format,omitempty" json:"edit_format,omitempty"`
+	MapTokens  int    `yaml:"map_tokens,omitempty" json:"map_tokens,omitempty"`
+	AutoCommit *bool  `yaml:"auto_commit,omitempty" json:"auto_commit,omitempty"`
+
+	// InjectMemory overrides memory.inject for this agent
+	InjectMemory *bool `yaml:"inject_memory,omitempty" json:"inject_memory,omitempty"`
+
+	Restart RestartPolicy `yaml:"restart,omitempty" json:"restart,omitempty"`
+
+	// Autostart keeps an agents: entry with a project_path running from boot
+	// (the default); false leaves it as a template for the spawn endpoint
+	Autostart *bool `yaml:"autostart,omitempty" json:"autostart,omitempty"`
+
+	// Chat setup on spawn: files to /add, read-only context files passed
+	// with --read, and a prompt queued once Aider is ready
+	InitialFiles  []string `yaml:"initial_files,omitempty" json:"initial_files,omitempty"`
+	ReadFiles     []string `yaml:"read_files,omitempty" json:"read_files,omitempty"`
+	InitialPrompt string   `yaml:"initial_prompt,omitempty" json:"initial_prompt,omitempty"`
+
+	// Env is added to Aider's environment; like APIKey it is not persisted
+	Env map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
+}
+
+// Validate checks an agent's settings. The project path is checked when
+// the agent is spawned, since templates in the config may leave it empty.
+func (a *AgentConfig) Validate() error {
+	switch a.Restart.Mode {
+	case "", RestartNever, RestartOnFailure, RestartAlways:
+	default:
+		return fmt.Errorf("invalid restart mode %q", a.Restart.Mode)
+	}
+	// Files are sent to Aider as /add commands, one per line
+	for _, file := range append(append([]string{}, a.InitialFiles...), a.ReadFiles...) {
+		if strings.TrimSpace(file) == "" || strings.ContainsAny(file, "\r\n") {
+			return fmt.Errorf("invalid file name %q", file)
+		}
+	}
+	for key := range a.Env {
+		if key == "" || strings.ContainsAny(key, "=\x00") {
+			return fmt.Errorf("invalid environment variable name %q", key)
+		}
+	}
+	return nil
+}
+
+// AutoStarts reports whether the agent is started on boot and kept running
+// by Spawner.Reconcile
+func (a *AgentConfig) AutoStarts() bool {
+	return a.ProjectPath != "" && (a.Autostart == nil || *a.Autostart)
+}
+
+// Clone returns a copy that shares no slices or maps with a
+func (a AgentConfig) Clone() AgentConfig {
+	a.InitialFiles = append([]string(nil), a.InitialFiles...)
+	a.ReadFiles = append([]string(nil), a.ReadFiles...)
+	if a.Env != nil {
+		env := make(map[string]string, len(a.Env))
+		for key, value := range a.Env {
+			env[key] = value
+		}
+		a.Env = env
+	}
+	return a
+}
+
+// persisted is the part of the config stored with supervised agents: APIKey
+// is not serialized and Env, which may also hold secrets, is left out
+func (a AgentConfig) persisted() AgentConfig {
+	a = a.Clone()
+	a.APIKey = ""
+	a.Env = nil
+	return a
+}
+
+// EnvList returns Env as KEY=value pairs in a stable order
+func (a *AgentConfig) EnvList() []string {
+	env := make([]string, 0, len(a.Env))
+	for key, value := range a.Env {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// Restart modes for RestartPolicy
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+// RestartPolicy controls whether a crashed agent is respawned.
+// Backoff doubles after each restart; MaxRestarts consecutive crashes
+// (without a stable run of ResetAfter seconds) trip the circuit breaker.
+type RestartPolicy struct {
+	Mode        string `yaml:"mode" json:"mode"`                 // never, on-failure, always
+	MaxRestarts int    `yaml:"max_restarts" json:"max_restarts"` // default 3
+	Backoff     int    `yaml:"backoff" json:"backoff"`           // initial delay in seconds, default 2
+	MaxBackoff  int    `yaml:"max_backoff" json:"max_backoff"`   // delay cap in seconds, default 60
+	ResetAfter  int    `yaml:"reset_after" json:"reset_after"`   // seconds of uptime that reset the count, default 300
+}
+
+// ShouldRestart reports whether an agent that exited should be respawned
+func (p RestartPolicy) ShouldRestart(failed bool) bool {
+	switch p.Mode {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return failed
+	default:
+		return false
+	}
+}
+
+// Limit returns the maximum number of consecutive restarts
+func (p RestartPolicy) Limit() int {
+	if p.MaxRestarts <= 0 {
+		return 3
+	}
+	return p.MaxRestarts
+}
+
+// Delay returns the backoff before the given restart attempt (0-based)
+func (p RestartPolicy) Delay(attempt int) time.Duration {
+	base := time.Duration(p.Backoff) * time.Second
+	if base <= 0 {
+		base = 2 * time.Second
+	}
+	limit := time.Duration(p.MaxBackoff) * time.Second
+	if limit <= 0 {
+		limit = 60 * time.Second
+	}
+
+	delay := base
+	for i := 0; i < attempt && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
+
+// StableAfter returns the uptime after which a run counts as stable
+func (p RestartPolicy) StableAfter() time.Duration {
+	if p.ResetAfter <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(p.ResetAfter) * time.Second
 }
 
 // SergeantConfig holds configuration for the Aider Sergeant
 type SergeantConfig struct {
 	MaxConcurrentAgents int `yaml:"max_concurrent_agents" json:"max_concurrent_agents"`
-	IdleTimeout         int `yaml:"idle_timeout" json:"idle_timeout"` // seconds
+	IdleTimeout         int `yaml:"idle_timeout" json:"idle_timeout"`       // seconds
+	PollInterval        int `yaml:"poll_interval" json:"poll_interval"`     // seconds between task queue polls
+	StatusInterval      int `yaml:"status_interval" json:"status_interval"` // seconds between sergeant.status publishes
+
+	// Auto-scaling: spawn an agent for a project when its pending task count
+	// exceeds ScaleUpThreshold; stop the agents it spawned once idle longer than IdleTimeout.
+	AutoScale        bool `yaml:"auto_scale" json:"auto_scale"`
+	ScaleUpThreshold int  `yaml:"scale_up_threshold" json:"scale_up_threshold"`
+}
+
+// SupervisorConfig controls running Aider detached from the monitor process.
+// Supervised agents talk through named pipes in StateDir so they survive a
+// monitor restart and can be reattached on the next boot (Unix only).
+type SupervisorConfig struct {
+	Enabled          bool   `yaml:"enabled" json:"enabled"`
+	StateDir         string `yaml:"state_dir" json:"state_dir"`                   // per-agent pipe directories
+	DetachOnShutdown bool   `yaml:"detach_on_shutdown" json:"detach_on_shutdown"` // leave agents running on exit
+}
+
+// Memory injection modes for MemoryConfig
+const (
+	MemoryModePrompt = "prompt" // prepend memory to each prompt
+	MemoryModeRead   = "read"   // write memory to a file Aider loads with --read
+)
+
+// MemoryConfig controls injecting learned memory (knowledge, recent episodes,
+// procedures) into the prompts sent to Aider. Agents may override Inject and
+// individual prompts may turn injection on or off.
+type MemoryConfig struct {
+	Inject  bool   `yaml:"inject" json:"inject"`
+	Mode    string `yaml:"mode" json:"mode"`         // prompt or read
+	ReadDir string `yaml:"read_dir" json:"read_dir"` // read mode files, one per agent, kept out of projects
+	Budget  int    `yaml:"budget" json:"budget"`     // memory token budget (0 = builder default)
+
+	// LLMSummaries summarizes sessions with the ollama section's chat model,
+	// falling back to a basic summary when it is unavailable
+	LLMSummaries bool `yaml:"llm_summaries" json:"llm_summaries"`
+
+	// EmbedRate caps background embedding, which re-embeds everything after
+	// an embedding model change, in texts per second (0 = no limit)
+	EmbedRate float64 `yaml:"embed_rate" json:"embed_rate"`
 }
 
 // Config is the root configuration for CLIAIRMONITOR
 type Config struct {
-	Server   ServerConfig   `yaml:"server" json:"server"`
-	Ollama   OllamaConfig   `yaml:"ollama" json:"ollama"`
-	Aider    AiderConfig    `yaml:"aider" json:"aider"`
-	Agents   []AgentConfig  `yaml:"agents" json:"agents"`
-	Sergeant SergeantConfig `yaml:"sergeant" json:"sergeant"`
+	Server     ServerConfig     `yaml:"server" json:"server"`
+	Ollama     OllamaConfig     `yaml:"ollama" json:"ollama"`
+	Aider      AiderConfig      `yaml:"aider" json:"aider"`
+	Agents     []AgentConfig    `yaml:"agents" json:"agents"`
+	Sergeant   SergeantConfig   `yaml:"sergeant" json:"sergeant"`
+	Supervisor SupervisorConfig `yaml:"supervisor" json:"supervisor"`
+	Memory     MemoryConfig     `yaml:"memory" json:"memory"`
 }
 
 // ServerConfig holds server settings
@@ -60,8 +262,8 @@ func DefaultConfig() *Config {
 			NATSPort: 4223, // Different NATS port
 		},
 		Ollama: OllamaConfig{
-			URL:   "http://localhost:11434",
-			Model: "qwen2.5-coder:32b",
+			URL:   "http://localhost:1234/v1", // LM Studio (OpenAI-compatible)
+			Model: "qwen2.5-coder-7b-instruct",
 		},
 		Aider: DefaultAiderConfig(),
 		Agents: []AgentConfig{
@@ -75,6 +277,21 @@ func DefaultConfig() *Config {
 		Sergeant: SergeantConfig{
 			MaxConcurrentAgents: 4,
 			IdleTimeout:         300,
+			PollInterval:        5,
+			StatusInterval:      30,
+			AutoScale:           false,
+			ScaleUpThreshold:    2,
+		},
+		Supervisor: SupervisorConfig{
+			Enabled:          false,
+			StateDir:         "data/agents",
+			DetachOnShutdown: true,
+		},
+		Memory: MemoryConfig{
+			Inject:    false,
+			Mode:      MemoryModePrompt,
+			ReadDir:   "data/memory",
+			EmbedRate: 10,
 		},
 	}
 }
@@ -82,30 +299,132 @@ func DefaultConfig() *Config {
 // DefaultAiderConfig returns sensible defaults for Aider
 func DefaultAiderConfig() AiderConfig {
 	return AiderConfig{
-		Model:          "ollama/qwen2.5-coder:32b",
+		Model:          "openai/qwen2.5-coder-7b-instruct",
+		APIBase:        "http://localhost:1234/v1",
+		APIKey:         "lm-studio",
 		OllamaURL:      "http://localhost:11434",
 		AutoCommit:     false,
 		EditFormat:     "diff",
 		MapTokens:      1024,
-		MaxChatHistory: 10,
+		MaxChatHistory: 2048,
+	}
+}
+
+// AiderConfigFor resolves the effective Aider configuration for an agent.
+// Per-agent overrides win over the global aider section; the ollama section
+// fills in the model and API base when neither is set. The global API base
+// and key are for an OpenAI-compatible server, so they only apply to
+// openai/ models.
+func (c *Config) AiderConfigFor(agent AgentConfig) AiderConfig {
+	resolved := c.Aider
+
+	if agent.Model != "" {
+		resolved.Model = agent.Model
 	}
+	if agent.APIBase != "" {
+		resolved.APIBase = agent.APIBase
+	}
+	if agent.APIKey != "" {
+		resolved.APIKey = agent.APIKey
+	}
+	if agent.EditFormat != "" {
+		resolved.EditFormat = agent.EditFormat
+	}
+	if agent.MapTokens > 0 {
+		resolved.MapTokens = agent.MapTokens
+	}
+	if agent.AutoCommit != nil {
+		resolved.AutoCommit = *agent.AutoCommit
+	}
+
+	// Fall back to the LM Studio / Ollama endpoint section
+	if resolved.Model == "" && c.Ollama.Model != "" {
+		resolved.Model = "openai/" + c.Ollama.Model
+	}
+	if resolved.APIBase == "" && strings.HasPrefix(resolved.Model, "openai/") {
+		resolved.APIBase = c.Ollama.URL
+	}
+
+	// Other model families get a base and key only from the agent itself
+	if !strings.HasPrefix(resolved.Model, "openai/") {
+		if agent.APIBase == "" {
+			resolved.APIBase = ""
+		}
+		if agent.APIKey == "" {
+			resolved.APIKey = ""
+		}
+	}
+
+	return resolved
+}
+
+// AgentTemplate returns a copy of the configured agent with the given name
+func (c *Config) AgentTemplate(name string) (AgentConfig, bool) {
+	return findAgent(c.Agents, name)
+}
+
+func findAgent(agents []AgentConfig, name string) (AgentConfig, bool) {
+	for _, agent := range agents {
+		if agent.Name == name {
+			return agent.Clone(), true
+		}
+	}
+	return AgentConfig{}, false
+}
+
+// MemoryFor resolves the memory injection settings for an agent
+func (c *Config) MemoryFor(agent AgentConfig) MemoryConfig {
+	resolved := c.Memory
+	if agent.InjectMemory != nil {
+		resolved.Inject = *agent.InjectMemory
+	}
+	return resolved
 }
 
 // ToArgs converts AiderConfig to command line arguments
 func (c *AiderConfig) ToArgs() []string {
-	args := []string{
-		"--model", c.Model,
-		"--edit-format", c.EditFormat,
-		"--map-tokens", fmt.Sprintf("%d", c.MapTokens),
+	args := []string{"--model", c.Model}
+
+	if c.APIBase != "" {
+		args = append(args, "--openai-api-base", c.APIBase)
+	}
+	if c.EditFormat != "" {
+		args = append(args, "--edit-format", c.EditFormat)
+	}
+	if c.MapTokens > 0 {
+		args = append(args, "--map-tokens", fmt.Sprintf("%d", c.MapTokens))
+	}
+	if c.MaxChatHistory > 0 {
+		args = append(args, "--max-chat-history-tokens", fmt.Sprintf("%d", c.MaxChatHistory))
+	}
+	if c.ChatHistoryFile != "" {
+		args = append(args, "--chat-history-file", c.ChatHistoryFile)
 	}
 
-	if !c.AutoCommit {
+	if c.AutoCommit {
+		args = append(args, "--auto-commits")
+	} else {
 		args = append(args, "--no-auto-commits")
 	}
 
 	return args
 }
 
+// ToEnv returns the environment variables Aider needs for this configuration.
+// Secrets go through the environment so they don't show up in process listings.
+func (c *AiderConfig) ToEnv() []string {
+	var env []string
+
+	if c.APIKey != "" {
+		env = append(env, "OPENAI_API_KEY="+c.APIKey)
+	}
+	if strings.HasPrefix(c.Model, "ollama/") && c.OllamaURL != "" {
+		env = append(env, "OLLAMA_API_BASE="+c.OllamaURL)
+	}
+
+	return env
+}
+
 // LoadConfig loads configuration from a YAML file
 func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
@@ -113,7 +432,9 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	var config Config
+	// Start from defaults so omitted sections keep sensible values
+	config := *DefaultConfig()
+	config.Agents = nil
 	if err := yaml.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse config YAML: %w", err)
 	}
@@ -139,5 +460,34 @@ func (c *Config) Validate() error {
 	if c.Ollama.Model == "" {
 		return fmt.Errorf("ollama model is required")
 	}
+	names := make(map[string]bool)
+	for _, agent := range c.Agents {
+		if err := agent.Validate(); err != nil {
+			return fmt.Errorf("agent %s: %w", agent.Name, err)
+		}
+		// Agents double as spawn templates looked up by name, and autostarted
+		// agents are tracked by name across reloads
+		if agent.Name == "" && agent.AutoStarts() {
+			return fmt.Errorf("agent with project_path %s needs a name", agent.ProjectPath)
+		}
+		if agent.Name != "" {
+			if names[agent.Name] {
+				return fmt.Errorf("duplicate agent name %q", agent.Name)
+			}
+			names[agent.Name] = true
+		}
+	}
+	switch c.Memory.Mode {
+	case "", MemoryModePrompt:
+	case MemoryModeRead:
+		if c.Memory.ReadDir == "" {
+			return fmt.Errorf("memory read_dir is required in read mode")
+		}
+	default:
+		return fmt.Errorf("invalid memory mode %q", c.Memory.Mode)
+	}
+	if c.Memory.EmbedRate < 0 {
+		return fmt.Errorf("memory embed_rate must not be negative")
+	}
 	return nil
 }
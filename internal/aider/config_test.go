@@ -0,0 +1,226 @@
+package aider
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func containsArgPair(args []string, flag, value string) bool {
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == flag && args[i+1] == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAiderConfigForDefaults(t *testing.T) {
+	config := DefaultConfig()
+
+	resolved := config.AiderConfigFor(AgentConfig{Name: "Qwen-Dev-1"})
+	args := resolved.ToArgs()
+
+	if !containsArgPair(args, "--model", "openai/qwen2.5-coder-7b-instruct") {
+		t.Errorf("Expected default model in args, got %v", args)
+	}
+	if !containsArgPair(args, "--openai-api-base", "http://localhost:1234/v1") {
+		t.Errorf("Expected API base in args, got %v", args)
+	}
+	if !containsArgPair(args, "--edit-format", "diff") {
+		t.Errorf("Expected edit format in args, got %v", args)
+	}
+	for _, arg := range args {
+		if strings.Contains(arg, "lm-studio") {
+			t.Errorf("API key must not be passed on the command line: %v", args)
+		}
+	}
+
+	env := resolved.ToEnv()
+	if len(env) != 1 || env[0] != "OPENAI_API_KEY=lm-studio" {
+		t.Errorf("Expected OPENAI_API_KEY in env, got %v", env)
+	}
+}
+
+func TestAiderConfigForAgentOverrides(t *testing.T) {
+	config := DefaultConfig()
+	autoCommit := true
+
+	resolved := config.AiderConfigFor(AgentConfig{
+		Name:       "Qwen-Big",
+		Model:      "openai/qwen2.5-coder-32b-instruct",
+		APIBase:    "http://gpu-box:1234/v1",
+		EditFormat: "whole",
+		MapTokens:  4096,
+		AutoCommit: &autoCommit,
+	})
+
+	if resolved.Model != "openai/qwen2.5-coder-32b-instruct" {
+		t.Errorf("Expected overridden model, got %s", resolved.Model)
+	}
+
+	args := resolved.ToArgs()
+	if !containsArgPair(args, "--openai-api-base", "http://gpu-box:1234/v1") {
+		t.Errorf("Expected overridden API base, got %v", args)
+	}
+	if !containsArgPair(args, "--edit-format", "whole") {
+		t.Errorf("Expected overridden edit format, got %v", args)
+	}
+	if !containsArgPair(args, "--map-tokens", "4096") {
+		t.Errorf("Expected overridden map tokens, got %v", args)
+	}
+	if args[len(args)-1] != "--auto-commits" {
+		t.Errorf("Expected --auto-commits, got %v", args)
+	}
+}
+
+func TestAiderConfigForOllamaFallback(t *testing.T) {
+	config := DefaultConfig()
+	config.Aider.Model = ""
+	config.Aider.APIBase = ""
+
+	resolved := config.AiderConfigFor(AgentConfig{})
+	if resolved.Model != "openai/"+config.Ollama.Model {
+		t.Errorf("Expected model from ollama section, got %s", resolved.Model)
+	}
+	if resolved.APIBase != config.Ollama.URL {
+		t.Errorf("Expected API base from ollama section, got %s", resolved.APIBase)
+	}
+}
+
+func TestLoadConfigOllamaAgent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agents.yaml")
+	yaml := `agents:
+  - name: Local
+    role: developer
+    model: ollama/qwen2.5-coder:7b
+  - name: Studio
+    role: developer
+`
+	if err := os.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	// The LM Studio defaults are not for an Ollama model
+	local := config.AiderConfigFor(config.Agents[0])
+	if local.APIBase != "" || local.APIKey != "" {
+		t.Errorf("Expected no OpenAI base or key for an ollama model, got %q and %q", local.APIBase, local.APIKey)
+	}
+	if args := local.ToArgs(); containsArgPair(args, "--openai-api-base", "http://localhost:1234/v1") {
+		t.Errorf("Expected no --openai-api-base, got %v", args)
+	}
+	if env := local.ToEnv(); len(env) != 1 || env[0] != "OLLAMA_API_BASE=http://localhost:11434" {
+		t.Errorf("Expected only OLLAMA_API_BASE in env, got %v", env)
+	}
+
+	studio := config.AiderConfigFor(config.Agents[1])
+	if studio.APIBase != "http://localhost:1234/v1" || studio.APIKey != "lm-studio" {
+		t.Errorf("Expected the LM Studio defaults for the default model, got %q and %q", studio.APIBase, studio.APIKey)
+	}
+}
+
+func TestLoadConfigShippedFile(t *testing.T) {
+	path := filepath.Join("..", "..", "configs", "agents.yaml")
+	if _, err := os.Stat(path); err != nil {
+		t.Skipf("shipped config not found: %v", err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if config.Aider.APIKey != "lm-studio" {
+		t.Errorf("Expected api_key from file, got %q", config.Aider.APIKey)
+	}
+	if len(config.Agents) != 2 {
+		t.Errorf("Expected 2 agents, got %d", len(config.Agents))
+	}
+}
+
+func TestRestartPolicy(t *testing.T) {
+	policy := RestartPolicy{Mode: RestartOnFailure, Backoff: 1, MaxBackoff: 5}
+
+	if !policy.ShouldRestart(true) || policy.ShouldRestart(false) {
+		t.Error("on-failure should restart only failed exits")
+	}
+	if (RestartPolicy{}).ShouldRestart(true) {
+		t.Error("empty policy should never restart")
+	}
+	if !(RestartPolicy{Mode: RestartAlways}).ShouldRestart(false) {
+		t.Error("always should restart clean exits")
+	}
+
+	expected := []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for attempt, want := range expected {
+		if got := policy.Delay(attempt); got != want {
+			t.Errorf("Delay(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+
+	if policy.Limit() != 3 {
+		t.Errorf("Expected default limit 3, got %d", policy.Limit())
+	}
+}
+
+func TestAgentConfigValidate(t *testing.T) {
+	valid := AgentConfig{
+		Name:         "Qwen-Dev-1",
+		InitialFiles: []string{"main.go"},
+		ReadFiles:    []string{"CONVENTIONS.md"},
+		Env:          map[string]string{"AIDER_DARK_MODE": "true"},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+
+	for _, agent := range []AgentConfig{
+		{Restart: RestartPolicy{Mode: "sometimes"}},
+		{InitialFiles: []string{"main.go\n/run rm -rf ."}},
+		{ReadFiles: []string{" "}},
+		{Env: map[string]string{"A=B": "c"}},
+	} {
+		if agent.Validate() == nil {
+			t.Errorf("Expected %+v to be rejected", agent)
+		}
+	}
+
+	config := DefaultConfig()
+	config.Agents = append(config.Agents, AgentConfig{Name: "Qwen-Dev-1"})
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Errorf("Expected a duplicate name error, got %v", err)
+	}
+
+	config.Agents = []AgentConfig{{ProjectPath: "/srv/app"}}
+	if err := config.Validate(); err == nil {
+		t.Error("Expected an autostarted agent without a name to be rejected")
+	}
+}
+
+func TestAgentTemplateIsACopy(t *testing.T) {
+	config := DefaultConfig()
+	config.Agents[0].InitialFiles = []string{"main.go"}
+	config.Agents[0].Env = map[string]string{"A": "1"}
+
+	template, ok := config.AgentTemplate("Qwen-Dev-1")
+	if !ok || template.Role != "developer" {
+		t.Fatalf("Expected the configured agent, got %+v", template)
+	}
+	template.InitialFiles[0] = "other.go"
+	template.Env["A"] = "2"
+	if config.Agents[0].InitialFiles[0] != "main.go" || config.Agents[0].Env["A"] != "1" {
+		t.Error("Changing a template must not change the config")
+	}
+
+	if _, ok := config.AgentTemplate("Nobody"); ok {
+		t.Error("Expected no template for an unknown name")
+	}
+	if env := (&AgentConfig{Env: map[string]string{"B": "2", "A": "1"}}).EnvList(); strings.Join(env, ",") != "A=1,B=2" {
+		t.Errorf("Expected sorted env, got %v", env)
+	}
+}
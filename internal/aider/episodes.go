@@ -0,0 +1,142 @@
+package aider
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/CLIAIRMONITOR/internal/memory"
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+)
+
+// Episode importance by kind of activity. Failures rank above routine
+// progress so they surface first when memory is retrieved.
+const (
+	importancePrompt       = 0.4
+	importancePromptFailed = 0.7
+	importanceEdit         = 0.5
+	importanceCommit       = 0.6
+	importanceCheckFailed  = 0.6
+	importanceError        = 0.7
+	importanceCrash        = 0.9
+)
+
+// maxEpisodePrompt caps how many characters of a prompt are kept in its episode
+const maxEpisodePrompt = 500
+
+// SetLearningDB records the agent's activity as episodes under its session
+// and project. Must be called before Start.
+func (b *Bridge) SetLearningDB(db memory.LearningDB, projectPath string) {
+	b.learning = db
+	b.projectPath = projectPath
+}
+
+// eventEpisode turns a parsed Aider event into an episode; nil for events
+// not worth remembering
+func eventEpisode(event natslib.EventMessage) *memory.Episode {
+	switch data := event.Data.(type) {
+	case natslib.EditAppliedEvent:
+		return &memory.Episode{
+			EventType:  "action",
+			Content:    fmt.Sprintf("Applied edit to %s", data.File),
+			Importance: importanceEdit,
+		}
+	case natslib.EditFailedEvent:
+		return &memory.Episode{
+			EventType:  "error",
+			Content:    fmt.Sprintf("Edit failed: %s", data.Message),
+			Importance: importanceError,
+		}
+	case natslib.CommitEvent:
+		return &memory.Episode{
+			EventType:  "action",
+			Content:    fmt.Sprintf("Committed %s: %s", data.Hash, data.Message),
+			Importance: importanceCommit,
+		}
+	case natslib.CheckFailedEvent:
+		check := "Lint"
+		if event.Type == natslib.EventTestFailed {
+			check = "Tests"
+		}
+		return &memory.Episode{
+			EventType:  "error",
+			Content:    fmt.Sprintf("%s failed: %s", check, data.Command),
+			Context:    strings.Join(data.Output, "\n"),
+			Importance: importanceCheckFailed,
+		}
+	case natslib.ErrorEvent:
+		return &memory.Episode{
+			EventType:  "error",
+			Content:    data.Message,
+			Importance: importanceError,
+		}
+	}
+	return nil
+}
+
+// promptEpisode summarises a finished prompt and what came of it
+func promptEpisode(response *natslib.PromptResponse) *memory.Episode {
+	prompt := response.Prompt
+	if runes := []rune(prompt); len(runes) > maxEpisodePrompt {
+		prompt = string(runes[:maxEpisodePrompt]) + "…"
+	}
+
+	var outcome []string
+	importance := importancePrompt
+	switch {
+	case response.Error != "":
+		outcome = append(outcome, response.Error)
+		importance = importancePromptFailed
+	case response.TimedOut:
+		outcome = append(outcome, "timed out")
+		importance = importancePromptFailed
+	}
+	if len(response.EditedFiles) > 0 {
+		outcome = append(outcome, "edited "+strings.Join(response.EditedFiles, ", "))
+	}
+	if len(response.Commits) > 0 {
+		outcome = append(outcome, fmt.Sprintf("%d commit(s)", len(response.Commits)))
+	}
+	if len(response.Errors) > 0 {
+		outcome = append(outcome, fmt.Sprintf("%d error(s)", len(response.Errors)))
+		importance = importancePromptFailed
+	}
+	if len(response.Warnings) > 0 {
+		outcome = append(outcome, fmt.Sprintf("%d warning(s)", len(response.Warnings)))
+	}
+	if len(outcome) == 0 {
+		outcome = append(outcome, "no changes")
+	}
+
+	return &memory.Episode{
+		EventType:  "action",
+		Content:    "Prompt: " + prompt,
+		Outcome:    strings.Join(outcome, "; "),
+		Importance: importance,
+	}
+}
+
+// recordEpisode stores an episode for this agent's session. Repeats of the
+// previous error are skipped so a noisy stderr does not flood memory.
+func (b *Bridge) recordEpisode(episode *memory.Episode) {
+	if b.learning == nil || episode == nil {
+		return
+	}
+
+	if episode.EventType == "error" {
+		b.mu.Lock()
+		repeat := episode.Content == b.lastError
+		b.lastError = episode.Content
+		b.mu.Unlock()
+		if repeat {
+			return
+		}
+	}
+
+	episode.SessionID = b.sessionID
+	episode.AgentID = b.agentID
+	episode.ProjectPath = b.projectPath
+	if err := b.learning.RecordEpisode(episode); err != nil {
+		log.Printf("[BRIDGE] Failed to record episode for agent %s: %v", b.agentID, err)
+	}
+}
@@ -0,0 +1,113 @@
+package aider
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/CLIAIRMONITOR/internal/memory"
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+)
+
+// recordingLearningDB keeps recorded episodes; other methods are unused
+type recordingLearningDB struct {
+	memory.LearningDB
+	episodes []*memory.Episode
+}
+
+func (r *recordingLearningDB) RecordEpisode(episode *memory.Episode) error {
+	r.episodes = append(r.episodes, episode)
+	return nil
+}
+
+func TestEventEpisodes(t *testing.T) {
+	tests := []struct {
+		event      natslib.EventMessage
+		eventType  string
+		content    string
+		importance float64
+	}{
+		{
+			natslib.EventMessage{Type: natslib.EventEditApplied, Data: natslib.EditAppliedEvent{File: "main.go"}},
+			"action", "Applied edit to main.go", importanceEdit,
+		},
+		{
+			natslib.EventMessage{Type: natslib.EventCommit, Data: natslib.CommitEvent{Hash: "abc1234", Message: "fix"}},
+			"action", "Committed abc1234: fix", importanceCommit,
+		},
+		{
+			natslib.EventMessage{Type: natslib.EventTestFailed, Data: natslib.CheckFailedEvent{Command: "go test ./..."}},
+			"error", "Tests failed: go test ./...", importanceCheckFailed,
+		},
+		{
+			natslib.EventMessage{Type: natslib.EventError, Data: natslib.ErrorEvent{Message: "API error"}},
+			"error", "API error", importanceError,
+		},
+	}
+
+	for _, tt := range tests {
+		episode := eventEpisode(tt.event)
+		if episode == nil {
+			t.Errorf("Expected episode for %s", tt.event.Type)
+			continue
+		}
+		if episode.EventType != tt.eventType || episode.Content != tt.content || episode.Importance != tt.importance {
+			t.Errorf("Unexpected episode for %s: %+v", tt.event.Type, episode)
+		}
+	}
+
+	if eventEpisode(natslib.EventMessage{Type: natslib.EventPrompt, Data: natslib.PromptEvent{}}) != nil {
+		t.Error("Prompt events should not become episodes")
+	}
+}
+
+func TestPromptEpisodeOutcome(t *testing.T) {
+	episode := promptEpisode(&natslib.PromptResponse{
+		Prompt:      "Fix login",
+		EditedFiles: []string{"auth.go", "auth_test.go"},
+		Commits:     []natslib.CommitEvent{{Hash: "abc1234"}},
+	})
+	if episode.Content != "Prompt: Fix login" || episode.Outcome != "edited auth.go, auth_test.go; 1 commit(s)" {
+		t.Errorf("Unexpected episode: %+v", episode)
+	}
+	if episode.Importance != importancePrompt {
+		t.Errorf("Expected routine importance, got %v", episode.Importance)
+	}
+
+	episode = promptEpisode(&natslib.PromptResponse{Prompt: "Fix login", TimedOut: true})
+	if episode.Outcome != "timed out" || episode.Importance != importancePromptFailed {
+		t.Errorf("Unexpected timed out episode: %+v", episode)
+	}
+}
+
+func TestPromptEpisodeTruncatesByCharacter(t *testing.T) {
+	// Each character takes three bytes, so a byte cut would split one
+	prompt := strings.Repeat("修", maxEpisodePrompt+1)
+	episode := promptEpisode(&natslib.PromptResponse{Prompt: prompt})
+
+	if !utf8.ValidString(episode.Content) {
+		t.Fatalf("Truncated prompt is not valid UTF-8: %q", episode.Content)
+	}
+	want := "Prompt: " + strings.Repeat("修", maxEpisodePrompt) + "…"
+	if episode.Content != want {
+		t.Errorf("Expected %d characters and an ellipsis, got %d", maxEpisodePrompt, utf8.RuneCountInString(episode.Content))
+	}
+}
+
+func TestRecordEpisodeSkipsRepeatedErrors(t *testing.T) {
+	db := &recordingLearningDB{}
+	b := NewBridge("aider-test", nil, nil, nil, nil)
+	b.SetSession("session-1")
+	b.SetLearningDB(db, "/src/app")
+
+	b.recordEpisode(&memory.Episode{EventType: "error", Content: "connection refused"})
+	b.recordEpisode(&memory.Episode{EventType: "error", Content: "connection refused"})
+	b.recordEpisode(&memory.Episode{EventType: "action", Content: "Applied edit to main.go"})
+
+	if len(db.episodes) != 2 {
+		t.Fatalf("Expected 2 episodes, got %d", len(db.episodes))
+	}
+	if db.episodes[0].SessionID != "session-1" || db.episodes[0].AgentID != "aider-test" || db.episodes[0].ProjectPath != "/src/app" {
+		t.Errorf("Episode not tied to the session: %+v", db.episodes[0])
+	}
+}
@@ -0,0 +1,134 @@
+package aider
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/CLIAIRMONITOR/internal/memory"
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+)
+
+// memoryInjector adds memory retrieved from the LearningDB to an agent's
+// prompts, either prepended to the prompt or through a --read file
+type memoryInjector struct {
+	builder     memory.ContextBuilder
+	learning    memory.LearningDB
+	config      MemoryConfig
+	projectPath string
+	readFile    string // read mode file, outside the project
+}
+
+// SetMemory enables memory injection for the bridge's prompts. Whether a
+// prompt gets memory follows config.Inject unless the prompt overrides it.
+// Must be called before Start.
+func (b *Bridge) SetMemory(builder memory.ContextBuilder, learning memory.LearningDB, config MemoryConfig, projectPath string) {
+	if builder == nil {
+		return
+	}
+	b.memory = &memoryInjector{
+		builder:     builder,
+		learning:    learning,
+		config:      config,
+		projectPath: projectPath,
+		readFile:    memoryReadFile(config, b.agentID),
+	}
+}
+
+// memoryReadFile returns where read mode writes an agent's memory. It lives
+// in the monitor's data directory rather than the project, so nothing is
+// left behind in the user's tree, and is absolute since Aider runs in the
+// project.
+func memoryReadFile(config MemoryConfig, agentID string) string {
+	path := filepath.Join(config.ReadDir, agentID+".md")
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// prepare returns the text to send to Aider for a prompt and the IDs of
+// the knowledge injected with it
+func (m *memoryInjector) prepare(prompt natslib.QueuedPrompt) (string, []string, error) {
+	inject := m.config.Inject
+	if prompt.InjectMemory != nil {
+		inject = *prompt.InjectMemory
+	}
+	readMode := m.config.Mode == MemoryModeRead
+
+	if !inject {
+		if readMode {
+			// Aider rereads the file every message; don't leave stale memory behind
+			return prompt.Text, nil, m.writeReadFile("")
+		}
+		return prompt.Text, nil, nil
+	}
+
+	ctx, err := m.builder.BuildContext(&memory.ContextRequest{
+		TaskDescription: prompt.Text,
+		TaskType:        prompt.TaskType,
+		ProjectPath:     m.projectPath,
+	})
+	if err != nil {
+		return prompt.Text, nil, fmt.Errorf("failed to build memory context: %w", err)
+	}
+
+	// Nothing was retrieved; the header alone is not worth the tokens
+	if len(ctx.RelevantKnowledge) == 0 && len(ctx.RecentEpisodes) == 0 && len(ctx.ApplicableProcedures) == 0 {
+		if readMode {
+			return prompt.Text, nil, m.writeReadFile("")
+		}
+		return prompt.Text, nil, nil
+	}
+
+	ids := make([]string, 0, len(ctx.RelevantKnowledge))
+	for _, k := range ctx.RelevantKnowledge {
+		ids = append(ids, k.ID)
+	}
+
+	if readMode {
+		if err := m.writeReadFile(ctx.SystemPrompt); err != nil {
+			return prompt.Text, nil, err
+		}
+		return prompt.Text, ids, nil
+	}
+	return ctx.SystemPrompt + "\n\n## Task\n" + prompt.Text, ids, nil
+}
+
+// writeReadFile replaces the contents of the read mode memory file
+func (m *memoryInjector) writeReadFile(content string) error {
+	if err := os.WriteFile(m.readFile, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write memory file: %w", err)
+	}
+	return nil
+}
+
+// withMemory prepares a prompt for sending. Failing to retrieve memory is
+// logged and the prompt goes out without it.
+func (b *Bridge) withMemory(prompt natslib.QueuedPrompt) (string, []string) {
+	if b.memory == nil {
+		return prompt.Text, nil
+	}
+
+	text, ids, err := b.memory.prepare(prompt)
+	if err != nil {
+		log.Printf("[BRIDGE] Memory injection failed for agent %s: %v", b.agentID, err)
+	}
+	return text, ids
+}
+
+// markKnowledgeUsed records that knowledge was handed to Aider
+func (b *Bridge) markKnowledgeUsed(promptID string, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+
+	log.Printf("[BRIDGE] Injected knowledge %v into prompt %s for agent %s", ids, promptID, b.agentID)
+	if b.memory.learning == nil {
+		return
+	}
+	if err := b.memory.learning.MarkKnowledgeUsed(ids); err != nil {
+		log.Printf("[BRIDGE] Failed to mark knowledge used: %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package aider
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CLIAIRMONITOR/internal/memory"
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+)
+
+// stubContextBuilder returns a fixed context and remembers the last request
+type stubContextBuilder struct {
+	context *memory.Context
+	request *memory.ContextRequest
+}
+
+func (s *stubContextBuilder) BuildContext(request *memory.ContextRequest) (*memory.Context, error) {
+	s.request = request
+	return s.context, nil
+}
+
+func (s *stubContextBuilder) EstimateTokens(text string) int { return len(text) }
+
+func (s *stubContextBuilder) SetBudget(budget *memory.ContextBudget) {}
+
+func newStubContextBuilder() *stubContextBuilder {
+	return &stubContextBuilder{context: &memory.Context{
+		SystemPrompt:      "You are a coding agent.\n\n## Relevant knowledge\n### [error_solution] Locked DB\nSet busy_timeout.",
+		RelevantKnowledge: []*memory.Knowledge{{ID: "k1", Title: "Locked DB"}},
+	}}
+}
+
+func TestMemoryInjectorPrependsToPrompt(t *testing.T) {
+	builder := newStubContextBuilder()
+	m := &memoryInjector{
+		builder:     builder,
+		config:      MemoryConfig{Inject: true, Mode: MemoryModePrompt},
+		projectPath: "/work/project",
+	}
+
+	text, ids, err := m.prepare(natslib.QueuedPrompt{Text: "Fix the lock", TaskType: "bugfix"})
+	if err != nil {
+		t.Fatalf("prepare failed: %v", err)
+	}
+	if !strings.HasPrefix(text, "You are a coding agent.") || !strings.HasSuffix(text, "## Task\nFix the lock") {
+		t.Errorf("Unexpected prompt:\n%s", text)
+	}
+	if len(ids) != 1 || ids[0] != "k1" {
+		t.Errorf("Expected knowledge k1, got %v", ids)
+	}
+	if builder.request.TaskType != "bugfix" || builder.request.ProjectPath != "/work/project" {
+		t.Errorf("Unexpected context request: %+v", builder.request)
+	}
+
+	// A prompt can opt out even when the agent injects by default
+	off := false
+	text, ids, _ = m.prepare(natslib.QueuedPrompt{Text: "Fix the lock", InjectMemory: &off})
+	if text != "Fix the lock" || len(ids) != 0 {
+		t.Errorf("Expected prompt unchanged, got %q %v", text, ids)
+	}
+}
+
+func TestMemoryInjectorWritesReadFile(t *testing.T) {
+	dir := t.TempDir()
+	m := &memoryInjector{
+		builder:     newStubContextBuilder(),
+		config:      MemoryConfig{Inject: false, Mode: MemoryModeRead, ReadDir: dir},
+		projectPath: "/work/project",
+		readFile:    memoryReadFile(MemoryConfig{ReadDir: dir}, "aider-test"),
+	}
+	path := filepath.Join(dir, "aider-test.md")
+
+	// A prompt can opt in when the agent does not inject by default
+	on := true
+	text, ids, err := m.prepare(natslib.QueuedPrompt{Text: "Fix the lock", InjectMemory: &on})
+	if err != nil {
+		t.Fatalf("prepare failed: %v", err)
+	}
+	if text != "Fix the lock" || len(ids) != 1 {
+		t.Errorf("Expected prompt unchanged with knowledge k1, got %q %v", text, ids)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "Set busy_timeout.") {
+		t.Errorf("Expected memory in read file, got %q", data)
+	}
+
+	// The next prompt without memory clears the file
+	m.prepare(natslib.QueuedPrompt{Text: "Next"})
+	data, _ = os.ReadFile(path)
+	if len(data) != 0 {
+		t.Errorf("Expected read file cleared, got %q", data)
+	}
+}
+
+func TestFormatPromptTagsClosingBrace(t *testing.T) {
+	if got := formatPrompt("one line"); got != "one line" {
+		t.Errorf("Unexpected single-line prompt %q", got)
+	}
+	if got := formatPrompt("a\nb"); got != "{\na\nb\n}" {
+		t.Errorf("Unexpected multi-line prompt %q", got)
+	}
+	if got := formatPrompt("func f() {\n}\n"); got != "{prompt\nfunc f() {\n}\nprompt}" {
+		t.Errorf("Unexpected tagged prompt %q", got)
+	}
+}
+
+func TestMemoryReadFileLivesOutsideProject(t *testing.T) {
+	dir := t.TempDir()
+	spawner := newTestSpawner(t)
+	spawner.context = memory.NewRAGContextBuilder(nil)
+	spawner.config.Memory = MemoryConfig{Mode: MemoryModeRead, ReadDir: filepath.Join(dir, "memory")}
+
+	args := spawner.memoryArgs("aider-test")
+	path := filepath.Join(dir, "memory", "aider-test.md")
+	if len(args) != 2 || args[0] != "--read" || args[1] != path {
+		t.Fatalf("Expected --read %s, got %v", path, args)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Expected the memory file to be created: %v", err)
+	}
+
+	spawner.removeMemoryFile("aider-test")
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the memory file removed, got %v", err)
+	}
+}
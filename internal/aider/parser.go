@@ -0,0 +1,275 @@
+package aider
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+)
+
+// recentLinesLimit bounds the plain output kept for lint/test failure reports
+const recentLinesLimit = 20
+
+// promptModes are the chat modes and edit formats Aider names in its input
+// prompt, e.g. "diff> ", "ask> " or "architect multi> "; the default is "> "
+const promptModes = `architect|ask|code|context|help|multi|patch|whole|diff|diff-fenced|udiff|udiff-simple|editor-diff|editor-whole|editor-diff-fenced`
+
+var (
+	// Aider prints its prompt with a trailing space and no newline; a quoted
+	// line in the model's reply ("> Note: ...") must not match
+	promptPattern      = regexp.MustCompile(`^((?:(?:` + promptModes + `) )*(?:` + promptModes + `))?> $`)
+	tokensSentPattern  = regexp.MustCompile(`([\d.,]+[kKmM]?) sent`)
+	tokensRecvPattern  = regexp.MustCompile(`([\d.,]+[kKmM]?) received`)
+	costPattern        = regexp.MustCompile(`Cost: \$([\d.,]+) message, \$([\d.,]+) session`)
+	commitPattern      = regexp.MustCompile(`^Commit ([0-9a-f]{7,40}) (.*)$`)
+	appliedPattern     = regexp.MustCompile(`^Applied edit to (.+)$`)
+	addedPattern       = regexp.MustCompile(`^Added (.+) to the chat\.?$`)
+	removedPattern     = regexp.MustCompile(`^Removed (.+) from the chat\.?$`)
+	failedEditsPattern = regexp.MustCompile(`^# (\d+) SEARCH/REPLACE blocks? failed to match`)
+)
+
+// parserState is where the parser is within Aider's output
+type parserState int
+
+const (
+	stateText    parserState = iota // ordinary output
+	stateSearch                     // inside the SEARCH half of an edit block
+	stateReplace                    // inside the REPLACE half of an edit block
+)
+
+// parsedLine is what one line of output means: a status change (empty when
+// unchanged) and any structured events
+type parsedLine struct {
+	status string
+	task   string
+	events []natslib.EventMessage
+}
+
+func (p *parsedLine) emit(eventType string, data interface{}) {
+	p.events = append(p.events, natslib.EventMessage{Type: eventType, Data: data})
+}
+
+// outputParser is a line-oriented state machine over Aider's stdout.
+// It tracks code fences and SEARCH/REPLACE blocks so that text inside
+// the model's reply is not mistaken for Aider's own messages.
+type outputParser struct {
+	state   parserState
+	inFence bool
+
+	lastText  string // last non-empty line outside fences, a candidate file name
+	fenceFile string // file name seen just before the current fence opened
+	editFile  string
+	search    []string
+	replace   []string
+
+	pendingHunks map[string]int // proposed blocks per file, awaiting "Applied edit"
+	lintCommand  string
+	recent       []string
+}
+
+func newOutputParser() *outputParser {
+	return &outputParser{pendingHunks: make(map[string]int)}
+}
+
+// parse consumes one line of output
+func (p *outputParser) parse(line string) parsedLine {
+	var result parsedLine
+	p.parseInto(line, &result)
+	return result
+}
+
+func (p *outputParser) parseInto(line string, result *parsedLine) {
+	// Aider's prompt ends the turn even when the model's reply left a fence
+	// or an edit block open, so it is matched before any block state
+	if m := promptPattern.FindStringSubmatch(strings.TrimRight(line, "\r")); m != nil {
+		p.reset()
+		result.status, result.task = "idle", "Awaiting prompt"
+		result.emit(natslib.EventPrompt, natslib.PromptEvent{Mode: m[1]})
+		return
+	}
+
+	trimmed := strings.TrimSpace(line)
+
+	switch p.state {
+	case stateSearch:
+		if trimmed == "=======" {
+			p.state = stateReplace
+			return
+		}
+		p.search = append(p.search, line)
+		return
+
+	case stateReplace:
+		if strings.HasPrefix(trimmed, ">>>>>>> REPLACE") {
+			p.finishEdit(result)
+			return
+		}
+		p.replace = append(p.replace, line)
+		return
+	}
+
+	if strings.HasPrefix(trimmed, "<<<<<<< SEARCH") {
+		p.editFile = p.lastText
+		if p.inFence {
+			p.editFile = p.fenceFile
+		}
+		p.search, p.replace = nil, nil
+		p.state = stateSearch
+		result.status, result.task = "working", "Editing "+p.editFile
+		return
+	}
+
+	if strings.HasPrefix(trimmed, "```") {
+		if !p.inFence {
+			p.fenceFile = p.lastText
+		}
+		p.inFence = !p.inFence
+		return
+	}
+
+	// The model's reply may contain anything; only edit blocks matter there
+	if p.inFence || trimmed == "" {
+		return
+	}
+
+	p.lastText = trimmed
+
+	switch {
+	case strings.HasPrefix(trimmed, "Tokens:"):
+		result.emit(natslib.EventTokensUsed, parseTokens(trimmed))
+
+	case appliedPattern.MatchString(trimmed):
+		file := appliedPattern.FindStringSubmatch(trimmed)[1]
+		hunks := p.pendingHunks[file]
+		if hunks == 0 {
+			hunks = 1
+		}
+		delete(p.pendingHunks, file)
+		result.status, result.task = "working", "Applied edit to "+file
+		result.emit(natslib.EventEditApplied, natslib.EditAppliedEvent{File: file, Hunks: hunks})
+
+	case failedEditsPattern.MatchString(trimmed):
+		blocks, _ := strconv.Atoi(failedEditsPattern.FindStringSubmatch(trimmed)[1])
+		result.emit(natslib.EventEditFailed, natslib.EditFailedEvent{Blocks: blocks, Message: trimmed})
+
+	case strings.HasPrefix(trimmed, "The LLM did not conform to the edit format"):
+		result.emit(natslib.EventEditFailed, natslib.EditFailedEvent{Message: trimmed})
+
+	case commitPattern.MatchString(trimmed):
+		m := commitPattern.FindStringSubmatch(trimmed)
+		result.status, result.task = "working", "Committed "+m[1]
+		result.emit(natslib.EventCommit, natslib.CommitEvent{Hash: m[1], Message: m[2]})
+
+	case addedPattern.MatchString(trimmed):
+		file := addedPattern.FindStringSubmatch(trimmed)[1]
+		result.status, result.task = "working", "Adding files to context"
+		result.emit(natslib.EventFileAdded, natslib.FileEvent{File: file})
+
+	case removedPattern.MatchString(trimmed):
+		file := removedPattern.FindStringSubmatch(trimmed)[1]
+		result.emit(natslib.EventFileDropped, natslib.FileEvent{File: file})
+
+	case strings.HasPrefix(trimmed, "## Running:"):
+		p.lintCommand = strings.TrimSpace(strings.TrimPrefix(trimmed, "## Running:"))
+		p.recent = nil
+		result.status, result.task = "working", "Linting"
+
+	case strings.HasPrefix(trimmed, "Attempt to fix lint errors?"):
+		result.status, result.task = "working", "Fixing lint errors"
+		result.emit(natslib.EventLintFailed, natslib.CheckFailedEvent{Command: p.lintCommand, Output: p.recent})
+		p.lintCommand, p.recent = "", nil
+
+	case strings.HasPrefix(trimmed, "Attempt to fix test errors?"):
+		result.status, result.task = "working", "Fixing test failures"
+		result.emit(natslib.EventTestFailed, natslib.CheckFailedEvent{Output: p.recent})
+		p.recent = nil
+
+	case isErrorLine(trimmed):
+		result.status, result.task = "error", trimmed
+		result.emit(natslib.EventError, natslib.ErrorEvent{Message: trimmed})
+
+	case strings.HasPrefix(trimmed, "►") && strings.Contains(strings.ToLower(trimmed), "thinking"):
+		result.status, result.task = "working", "Thinking..."
+
+	case strings.HasPrefix(trimmed, "Scanning repo"):
+		result.status, result.task = "working", "Searching codebase"
+
+	default:
+		p.remember(trimmed)
+	}
+}
+
+// finishEdit closes the current SEARCH/REPLACE block
+func (p *outputParser) finishEdit(result *parsedLine) {
+	p.pendingHunks[p.editFile]++
+	result.emit(natslib.EventEditProposed, natslib.EditProposedEvent{
+		File:    p.editFile,
+		Search:  strings.Join(p.search, "\n"),
+		Replace: strings.Join(p.replace, "\n"),
+	})
+	p.search, p.replace = nil, nil
+	p.state = stateText
+}
+
+// remember keeps recent plain output for lint/test failure reports
+func (p *outputParser) remember(line string) {
+	p.recent = append(p.recent, line)
+	if len(p.recent) > recentLinesLimit {
+		p.recent = p.recent[len(p.recent)-recentLinesLimit:]
+	}
+}
+
+// reset clears per-exchange state when Aider returns to its prompt
+func (p *outputParser) reset() {
+	p.state = stateText
+	p.inFence = false
+	p.pendingHunks = make(map[string]int)
+	p.lintCommand = ""
+	p.recent = nil
+}
+
+// isErrorLine reports whether a line is an error printed by Aider itself,
+// as opposed to ordinary text that merely mentions errors
+func isErrorLine(line string) bool {
+	lower := strings.ToLower(line)
+	return strings.HasPrefix(lower, "error") ||
+		strings.HasPrefix(lower, "litellm.") ||
+		strings.Contains(lower, "exception:")
+}
+
+// parseTokens reads a line like
+// "Tokens: 2.1k sent, 345 received. Cost: $0.01 message, $0.05 session."
+func parseTokens(line string) natslib.TokensUsedEvent {
+	var event natslib.TokensUsedEvent
+	if m := tokensSentPattern.FindStringSubmatch(line); m != nil {
+		event.Sent = parseTokenCount(m[1])
+	}
+	if m := tokensRecvPattern.FindStringSubmatch(line); m != nil {
+		event.Received = parseTokenCount(m[1])
+	}
+	if m := costPattern.FindStringSubmatch(line); m != nil {
+		event.Cost, _ = strconv.ParseFloat(strings.ReplaceAll(m[1], ",", ""), 64)
+		event.SessionCost, _ = strconv.ParseFloat(strings.ReplaceAll(m[2], ",", ""), 64)
+	}
+	return event
+}
+
+// parseTokenCount converts Aider's abbreviated counts ("345", "2.1k", "1,234", "1.2M")
+func parseTokenCount(s string) int {
+	s = strings.ReplaceAll(s, ",", "")
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(s, "k"), strings.HasSuffix(s, "K"):
+		multiplier = 1e3
+		s = s[:len(s)-1]
+	case strings.HasSuffix(s, "m"), strings.HasSuffix(s, "M"):
+		multiplier = 1e6
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return int(n*multiplier + 0.5)
+}
@@ -0,0 +1,206 @@
+package aider
+
+import (
+	"testing"
+
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+)
+
+// feed parses lines in order and collects every event and the last status
+func feed(p *outputParser, lines ...string) ([]natslib.EventMessage, string) {
+	var events []natslib.EventMessage
+	status := ""
+	for _, line := range lines {
+		result := p.parse(line)
+		events = append(events, result.events...)
+		if result.status != "" {
+			status = result.status
+		}
+	}
+	return events, status
+}
+
+func TestParserEditBlocks(t *testing.T) {
+	p := newOutputParser()
+
+	events, _ := feed(p,
+		"I'll fix the error handling in main.",
+		"",
+		"cmd/main.go",
+		"```go",
+		"<<<<<<< SEARCH",
+		"	return nil",
+		"=======",
+		"	return err",
+		">>>>>>> REPLACE",
+		"```",
+		"",
+		"cmd/main.go",
+		"```go",
+		"<<<<<<< SEARCH",
+		"// error: old",
+		"=======",
+		"// fixed",
+		">>>>>>> REPLACE",
+		"```",
+		"Applied edit to cmd/main.go",
+		"Commit 1a2b3c4 fix: return errors from main",
+	)
+
+	var proposed []natslib.EditProposedEvent
+	var applied []natslib.EditAppliedEvent
+	var commits []natslib.CommitEvent
+	for _, event := range events {
+		switch data := event.Data.(type) {
+		case natslib.EditProposedEvent:
+			proposed = append(proposed, data)
+		case natslib.EditAppliedEvent:
+			applied = append(applied, data)
+		case natslib.CommitEvent:
+			commits = append(commits, data)
+		case natslib.ErrorEvent:
+			t.Errorf("Text inside an edit block reported as error: %s", data.Message)
+		}
+	}
+
+	if len(proposed) != 2 {
+		t.Fatalf("Expected 2 proposed edits, got %d", len(proposed))
+	}
+	if proposed[0].File != "cmd/main.go" || proposed[0].Search != "\treturn nil" || proposed[0].Replace != "\treturn err" {
+		t.Errorf("Unexpected first edit: %+v", proposed[0])
+	}
+	if len(applied) != 1 || applied[0].File != "cmd/main.go" || applied[0].Hunks != 2 {
+		t.Errorf("Expected one applied edit with 2 hunks, got %+v", applied)
+	}
+	if len(commits) != 1 || commits[0].Hash != "1a2b3c4" || commits[0].Message != "fix: return errors from main" {
+		t.Errorf("Unexpected commits: %+v", commits)
+	}
+}
+
+func TestParserTokensAndPrompt(t *testing.T) {
+	p := newOutputParser()
+
+	events, status := feed(p,
+		"Tokens: 2.1k sent, 1,200 cache hit, 345 received. Cost: $0.01 message, $0.05 session.",
+		"diff> ",
+	)
+
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d: %+v", len(events), events)
+	}
+	tokens, ok := events[0].Data.(natslib.TokensUsedEvent)
+	if !ok {
+		t.Fatalf("Expected TokensUsedEvent, got %T", events[0].Data)
+	}
+	if tokens.Sent != 2100 || tokens.Received != 345 || tokens.Cost != 0.01 || tokens.SessionCost != 0.05 {
+		t.Errorf("Unexpected token report: %+v", tokens)
+	}
+
+	prompt, ok := events[1].Data.(natslib.PromptEvent)
+	if !ok || prompt.Mode != "diff" {
+		t.Errorf("Expected diff prompt, got %+v", events[1].Data)
+	}
+	if status != "idle" {
+		t.Errorf("Expected idle after prompt, got %s", status)
+	}
+}
+
+func TestParserQuotedReplyIsNotAPrompt(t *testing.T) {
+	p := newOutputParser()
+
+	events, status := feed(p,
+		"Here is what I found:",
+		"> Note: the config is loaded twice",
+		">",
+		"> diff",
+		"Let me fix that.",
+	)
+	if len(events) != 0 || status == "idle" {
+		t.Errorf("Expected a quoted reply to stay in the turn, got %+v / %q", events, status)
+	}
+
+	for _, prompt := range []string{"> ", "ask> ", "architect multi> ", "editor-diff> "} {
+		if _, status := feed(newOutputParser(), prompt); status != "idle" {
+			t.Errorf("Expected %q to be read as the prompt, got %q", prompt, status)
+		}
+	}
+	if !isPromptFragment("diff> ") || isPromptFragment("> Note: ") {
+		t.Error("Expected only the bare prompt to be yielded as a fragment")
+	}
+}
+
+func TestParserPromptEndsTruncatedReply(t *testing.T) {
+	truncated := map[string][]string{
+		"fence":      {"main.py", "```python", "def main():"},
+		"edit block": {"main.go", "```go", "<<<<<<< SEARCH", "old", "======="},
+	}
+	for name, reply := range truncated {
+		p := newOutputParser()
+		feed(p, reply...)
+
+		events, status := feed(p, "> ")
+		if status != "idle" || len(events) != 1 || events[0].Type != natslib.EventPrompt {
+			t.Errorf("%s: expected the prompt after a truncated reply, got %+v / %q", name, events, status)
+		}
+
+		// The next reply starts from a clean state
+		events, _ = feed(p, "Applied edit to main.go")
+		if len(events) != 1 || events[0].Type != natslib.EventEditApplied {
+			t.Errorf("%s: expected output after the prompt to be parsed, got %+v", name, events)
+		}
+	}
+}
+
+func TestParserIgnoresOrdinaryText(t *testing.T) {
+	p := newOutputParser()
+
+	events, status := feed(p,
+		"I added better error handling and the committed config stays as is.",
+		"```",
+		"Error: this is just code in the reply",
+		"```",
+	)
+	if len(events) != 0 || status != "" {
+		t.Errorf("Expected no events or status, got %+v / %q", events, status)
+	}
+
+	events, status = feed(p, "litellm.APIConnectionError: connection refused")
+	if status != "error" || len(events) != 1 || events[0].Type != natslib.EventError {
+		t.Errorf("Expected error event, got %+v / %q", events, status)
+	}
+}
+
+func TestParserFilesAndLint(t *testing.T) {
+	p := newOutputParser()
+
+	events, _ := feed(p,
+		"Added internal/aider/bridge.go to the chat",
+		"Removed README.md from the chat",
+		"## Running: flake8 app.py",
+		"app.py:3:1: F401 'os' imported but unused",
+		"Attempt to fix lint errors? (Y)es/(N)o [Yes]: y",
+	)
+
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d: %+v", len(events), events)
+	}
+	if events[0].Type != natslib.EventFileAdded || events[0].Data.(natslib.FileEvent).File != "internal/aider/bridge.go" {
+		t.Errorf("Unexpected add event: %+v", events[0])
+	}
+	if events[1].Type != natslib.EventFileDropped || events[1].Data.(natslib.FileEvent).File != "README.md" {
+		t.Errorf("Unexpected drop event: %+v", events[1])
+	}
+	lint, ok := events[2].Data.(natslib.CheckFailedEvent)
+	if !ok || lint.Command != "flake8 app.py" || len(lint.Output) != 1 {
+		t.Errorf("Unexpected lint event: %+v", events[2])
+	}
+}
+
+func TestParseTokenCount(t *testing.T) {
+	cases := map[string]int{"345": 345, "2.1k": 2100, "1,234": 1234, "1.2M": 1200000, "bogus": 0}
+	for input, want := range cases {
+		if got := parseTokenCount(input); got != want {
+			t.Errorf("parseTokenCount(%q) = %d, want %d", input, got, want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package aider
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CLIAIRMONITOR/internal/memory"
+)
+
+// staleAgentThreshold is how long an agent may go without a heartbeat
+// before CleanupStaleAgents marks it unreachable
+const staleAgentThreshold = 60 * time.Second
+
+// recordSpawn registers a freshly started agent and opens its session. Caller must hold s.mu.
+func (s *Spawner) recordSpawn(agent *Agent) {
+	if s.opDB == nil {
+		return
+	}
+
+	pid := agent.Process.Pid
+	now := time.Now()
+	state := &memory.AgentState{
+		AgentID:     agent.ID,
+		AgentType:   "aider",
+		Model:       agent.Model,
+		Status:      memory.AgentStatusStarting,
+		CurrentTask: "Starting Aider",
+		ProjectPath: agent.ProjectPath,
+		SessionID:   agent.SessionID,
+		PID:         &pid,
+		HeartbeatAt: &now,
+		Metadata: map[string]string{
+			"name":  agent.Config.Name,
+			"role":  agent.Config.Role,
+			"color": agent.Config.Color,
+		},
+	}
+	if name := s.managedName(agent.ID); name != "" {
+		state.Metadata["managed"] = name
+	}
+	if agent.supervised {
+		// Enough to rebuild the bridge after a monitor restart; the secret
+		// fields are kept in the supervisor dir instead
+		if err := saveSecrets(s.supervisorDir(agent.ID), agent.Config); err != nil {
+			log.Printf("[SPAWNER] Failed to save API key and env for agent %s: %v", agent.ID, err)
+		}
+		config, _ := json.Marshal(agent.Config.persisted())
+		state.Metadata["supervised"] = "true"
+		state.Metadata["supervisor_dir"] = s.supervisorDir(agent.ID)
+		state.Metadata["config"] = string(config)
+	}
+	if err := s.opDB.RegisterAgent(state); err != nil {
+		log.Printf("[SPAWNER] Failed to register agent %s: %v", agent.ID, err)
+		return
+	}
+
+	session := &memory.Session{
+		ID:          agent.SessionID,
+		AgentID:     agent.ID,
+		ProjectPath: agent.ProjectPath,
+	}
+	if err := s.opDB.CreateSession(session); err != nil {
+		log.Printf("[SPAWNER] Failed to create session for agent %s: %v", agent.ID, err)
+	}
+}
+
+// recordStopped marks an agent stopped and closes its session.
+// It does not take s.mu; opDB is set before any agent is spawned.
+func (s *Spawner) recordStopped(agent *Agent, reason string) {
+	opDB := s.opDB
+	if opDB == nil {
+		return
+	}
+
+	if err := opDB.MarkStopped(agent.ID, reason); err != nil {
+		log.Printf("[SPAWNER] Failed to mark agent %s stopped: %v", agent.ID, err)
+	}
+	if agent.supervised {
+		os.Remove(filepath.Join(s.supervisorDir(agent.ID), secretsFile))
+	}
+
+	session, err := opDB.GetSession(agent.SessionID)
+	if err != nil {
+		log.Printf("[SPAWNER] Failed to load session for agent %s: %v", agent.ID, err)
+		return
+	}
+	now := time.Now()
+	session.EndedAt = &now
+	if session.Summary == "" {
+		session.Summary = reason
+	}
+	if err := opDB.UpdateSession(session); err != nil {
+		log.Printf("[SPAWNER] Failed to close session for agent %s: %v", agent.ID, err)
+	}
+}
+
+// recordHeartbeats records a heartbeat for every live agent and marks agents
+// that stopped heartbeating (including leftovers from a previous run) unreachable.
+// Caller must hold s.mu.
+func (s *Spawner) recordHeartbeats() {
+	if s.opDB == nil {
+		return
+	}
+
+	for id := range s.agents {
+		if err := s.opDB.RecordHeartbeat(id); err != nil {
+			log.Printf("[SPAWNER] Failed to record heartbeat for agent %s: %v", id, err)
+		}
+	}
+
+	count, err := s.opDB.CleanupStaleAgents(staleAgentThreshold)
+	if err != nil {
+		log.Printf("[SPAWNER] Failed to clean up stale agents: %v", err)
+		return
+	}
+	if count > 0 {
+		log.Printf("[SPAWNER] Marked %d stale agents unreachable", count)
+	}
+}
+
+// crashReason describes how an agent's process ended
+func crashReason(agent *Agent) string {
+	if agent.exitState != nil {
+		return fmt.Sprintf("crashed: %s", agent.exitState)
+	}
+	return "crashed: process exited"
+}
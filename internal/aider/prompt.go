@@ -0,0 +1,634 @@
+package aider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+	"github.com/google/uuid"
+)
+
+// defaultPromptTimeout bounds a prompt, including time spent queued, when the caller sets none
+const defaultPromptTimeout = 5 * time.Minute
+
+// PromptErrorStopped is the error of a prompt cut short because the agent's
+// bridge stopped, when Aider exited or the agent was stopped
+const PromptErrorStopped = "bridge stopped"
+
+// idlePollInterval is how often the queue checks whether Aider is ready for input
+const idlePollInterval = 100 * time.Millisecond
+
+// turn collects Aider's output for the prompt currently running
+type turn struct {
+	response    natslib.PromptResponse
+	edited      map[string]bool
+	done        chan struct{}
+	finished    bool
+	interrupted bool
+}
+
+// finish marks the turn complete. Caller must hold b.mu.
+func (t *turn) finish() {
+	if !t.finished {
+		t.finished = true
+		close(t.done)
+	}
+}
+
+// record adds one parsed line of output to the turn. Caller must hold b.mu.
+func (t *turn) record(line string, result parsedLine) {
+	for _, event := range result.events {
+		switch data := event.Data.(type) {
+		case natslib.PromptEvent:
+			// Aider is back at its prompt; the prompt itself is not transcript
+			t.finish()
+			return
+		case natslib.EditAppliedEvent:
+			t.edited[data.File] = true
+		case natslib.TokensUsedEvent:
+			t.response.Tokens.Sent += data.Sent
+			t.response.Tokens.Received += data.Received
+			t.response.Tokens.Cost += data.Cost
+			t.response.Tokens.SessionCost = data.SessionCost
+		case natslib.CommitEvent:
+			t.response.Commits = append(t.response.Commits, data)
+		case natslib.ErrorEvent:
+			t.response.Errors = append(t.response.Errors, data.Message)
+		case natslib.EditFailedEvent:
+			t.response.Warnings = append(t.response.Warnings, data.Message)
+		}
+		if event.Type == natslib.EventLintFailed || event.Type == natslib.EventTestFailed {
+			t.response.Warnings = append(t.response.Warnings, event.Type)
+		}
+	}
+	t.response.Transcript = append(t.response.Transcript, line)
+}
+
+// errPromptCancelled ends a running prompt cancelled before it was sent
+var errPromptCancelled = errors.New("cancelled")
+
+// queuedPrompt is a prompt waiting in, or running from, the bridge's queue
+type queuedPrompt struct {
+	info     natslib.QueuedPrompt
+	deadline time.Time
+	result   *natslib.PromptResponse
+	done     chan struct{}
+	cancel   chan struct{} // closed when cancelled before it was sent to Aider
+	resumed  *turn         // the turn of a prompt sent before a monitor restart
+}
+
+// cancelled reports whether the prompt was cancelled before it was sent
+func (q *queuedPrompt) cancelled() bool {
+	select {
+	case <-q.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// complete records the outcome and wakes any caller waiting on the prompt
+func (q *queuedPrompt) complete(result *natslib.PromptResponse) {
+	result.PromptID = q.info.ID
+	q.result = result
+	close(q.done)
+}
+
+// failed builds the response for a prompt that never ran to completion
+func (q *queuedPrompt) failed(agentID, reason string) *natslib.PromptResponse {
+	return &natslib.PromptResponse{
+		AgentID:     agentID,
+		Prompt:      q.info.Text,
+		Transcript:  []string{},
+		EditedFiles: []string{},
+		Error:       reason,
+		CompletedAt: time.Now(),
+	}
+}
+
+// PromptOptions controls how a queued prompt is run
+type PromptOptions struct {
+	Timeout      time.Duration // covers time queued and running; 0 uses defaultPromptTimeout
+	InjectMemory *bool         // nil follows the agent's memory setting
+	TaskType     string        // selects procedures when memory is injected
+	TaskID       string        // the Sergeant task the prompt works on, see AwaitTask
+}
+
+// Enqueue adds a prompt to the agent's queue. Prompts are fed to Aider one at
+// a time, each only once Aider is back at its input prompt. The timeout covers
+// time spent queued as well as running.
+func (b *Bridge) Enqueue(text string, opts PromptOptions) (natslib.QueuedPrompt, error) {
+	item, err := b.enqueue(text, opts)
+	if err != nil {
+		return natslib.QueuedPrompt{}, err
+	}
+	return item.info, nil
+}
+
+func (b *Bridge) enqueue(text string, opts PromptOptions) (*queuedPrompt, error) {
+	if text == "" {
+		return nil, fmt.Errorf("prompt text is required")
+	}
+	return b.push(newQueuedPrompt(natslib.QueuedPrompt{
+		Text:         text,
+		InjectMemory: opts.InjectMemory,
+		TaskType:     opts.TaskType,
+		TaskID:       opts.TaskID,
+	}, opts.Timeout))
+}
+
+// enqueueCommand queues a slash command. It waits its turn like a prompt, so
+// the prompt Aider prints after it does not end another prompt's turn, but
+// nobody waits on its result.
+func (b *Bridge) enqueueCommand(command string) error {
+	_, err := b.push(newQueuedPrompt(natslib.QueuedPrompt{Text: command, Command: true}, 0))
+	return err
+}
+
+// newQueuedPrompt stamps a queue item with an ID and its deadline
+func newQueuedPrompt(info natslib.QueuedPrompt, timeout time.Duration) *queuedPrompt {
+	if timeout <= 0 {
+		timeout = defaultPromptTimeout
+	}
+	now := time.Now()
+	info.ID = uuid.New().String()[:8]
+	info.QueuedAt = now
+	info.Deadline = now.Add(timeout)
+	return &queuedPrompt{
+		info:     info,
+		deadline: info.Deadline,
+		done:     make(chan struct{}),
+		cancel:   make(chan struct{}),
+	}
+}
+
+// Resume restores the prompt queue a previous monitor persisted before it
+// detached. A prompt Aider was already running becomes the current turn, so
+// the prompt Aider prints when it is done ends that turn rather than the
+// next one; the rest are queued again. It reports whether such a turn was
+// resumed. Must be called before Start.
+func (b *Bridge) Resume(queue natslib.PromptQueueMessage) bool {
+	pending := queue.Pending
+	resumed := false
+	if running := queue.Running; running != nil {
+		if running.SentAt != nil {
+			item := restoredPrompt(*running)
+			item.info.SentAt = running.SentAt
+			item.resumed = newTurn(b.agentID, running.Text)
+			b.mu.Lock()
+			b.turn = item.resumed
+			b.mu.Unlock()
+			b.push(item)
+			resumed = true
+		} else {
+			pending = append([]natslib.QueuedPrompt{*running}, pending...)
+		}
+	}
+	for _, info := range pending {
+		b.push(restoredPrompt(info))
+	}
+	return resumed
+}
+
+// restoredPrompt queues a persisted prompt again under its own ID and deadline
+func restoredPrompt(info natslib.QueuedPrompt) *queuedPrompt {
+	id, deadline := info.ID, info.Deadline
+	info.StartedAt, info.SentAt = nil, nil
+	item := newQueuedPrompt(info, 0)
+	if id != "" {
+		item.info.ID = id
+	}
+	if !deadline.IsZero() {
+		item.info.Deadline, item.deadline = deadline, deadline
+	}
+	return item
+}
+
+// AwaitTask returns a channel delivering the outcome of the queued or running
+// prompt that works on a task, or false when there is none. It lets the
+// Sergeant follow tasks it dispatched before a monitor restart.
+func (b *Bridge) AwaitTask(taskID string) (<-chan *natslib.PromptResponse, bool) {
+	if taskID == "" {
+		return nil, false
+	}
+
+	b.mu.RLock()
+	var found *queuedPrompt
+	if b.running != nil && b.running.info.TaskID == taskID {
+		found = b.running
+	}
+	for _, item := range b.queue {
+		if found == nil && item.info.TaskID == taskID {
+			found = item
+		}
+	}
+	b.mu.RUnlock()
+	if found == nil {
+		return nil, false
+	}
+
+	result := make(chan *natslib.PromptResponse, 1)
+	go func() {
+		<-found.done
+		result <- found.result
+	}()
+	return result, true
+}
+
+// push appends an item to the queue and wakes the worker
+func (b *Bridge) push(item *queuedPrompt) (*queuedPrompt, error) {
+	b.mu.Lock()
+	select {
+	case <-b.stopCh:
+		b.mu.Unlock()
+		return nil, errors.New(PromptErrorStopped)
+	default:
+	}
+	b.queue = append(b.queue, item)
+	b.mu.Unlock()
+
+	// Wake the queue worker
+	select {
+	case b.queueCh <- struct{}{}:
+	default:
+	}
+
+	b.persistQueue()
+	return item, nil
+}
+
+// PromptOptionsFrom takes the options of a prompt request
+func PromptOptionsFrom(req natslib.PromptRequest) PromptOptions {
+	return PromptOptions{
+		Timeout:      time.Duration(req.TimeoutSeconds) * time.Second,
+		InjectMemory: req.InjectMemory,
+		TaskType:     req.TaskType,
+		TaskID:       req.TaskID,
+	}
+}
+
+// Prompt queues text and blocks until Aider has finished it, returning
+// everything Aider printed before returning to its input prompt
+func (b *Bridge) Prompt(text string, opts PromptOptions) (*natslib.PromptResponse, error) {
+	item, err := b.enqueue(text, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(time.Until(item.deadline))
+	defer timer.Stop()
+
+	select {
+	case <-item.done:
+		return item.result, nil
+	case <-timer.C:
+		if b.removeQueued(item.info.ID) {
+			return nil, fmt.Errorf("timed out waiting in queue")
+		}
+		// Already running; the worker reports the timeout itself
+		<-item.done
+		return item.result, nil
+	}
+}
+
+// Cancel removes a queued prompt, or interrupts it if it is already running.
+// A running prompt still waiting for Aider to become idle is dropped without
+// being sent.
+func (b *Bridge) Cancel(promptID string) error {
+	if b.removeQueued(promptID) {
+		return nil
+	}
+
+	b.mu.Lock()
+	item := b.running
+	if item == nil || item.info.ID != promptID {
+		b.mu.Unlock()
+		return fmt.Errorf("prompt %s not found", promptID)
+	}
+	if b.turn == nil {
+		if !item.cancelled() {
+			close(item.cancel)
+		}
+		b.mu.Unlock()
+		return nil
+	}
+	b.mu.Unlock()
+
+	return b.Interrupt()
+}
+
+// removeQueued takes a pending prompt off the queue, completing it as cancelled
+func (b *Bridge) removeQueued(promptID string) bool {
+	b.mu.Lock()
+	var removed *queuedPrompt
+	for i, item := range b.queue {
+		if item.info.ID == promptID {
+			removed = item
+			b.queue = append(b.queue[:i], b.queue[i+1:]...)
+			break
+		}
+	}
+	b.mu.Unlock()
+
+	if removed == nil {
+		return false
+	}
+	removed.complete(removed.failed(b.agentID, "cancelled"))
+	b.persistQueue()
+	return true
+}
+
+// Interrupt sends Ctrl-C to Aider, abandoning the running prompt. Aider
+// returns to its input prompt and the queue moves on to the next prompt.
+func (b *Bridge) Interrupt() error {
+	b.mu.Lock()
+	if b.turn == nil || b.interrupt == nil {
+		b.mu.Unlock()
+		return fmt.Errorf("no prompt is running")
+	}
+	b.turn.interrupted = true
+	interrupt := b.interrupt
+	b.mu.Unlock()
+
+	b.publishStatus("working", "Interrupting prompt")
+	if err := interrupt(); err != nil {
+		return fmt.Errorf("failed to interrupt aider: %w", err)
+	}
+	return nil
+}
+
+// Queue returns a snapshot of the running and pending prompts
+func (b *Bridge) Queue() natslib.PromptQueueMessage {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	snapshot := natslib.PromptQueueMessage{
+		AgentID:   b.agentID,
+		Pending:   make([]natslib.QueuedPrompt, 0, len(b.queue)),
+		Timestamp: time.Now(),
+	}
+	if b.running != nil {
+		running := b.running.info
+		snapshot.Running = &running
+	}
+	for _, item := range b.queue {
+		snapshot.Pending = append(snapshot.Pending, item.info)
+	}
+	return snapshot
+}
+
+// persistQueue mirrors the queue into the agent's metadata for the dashboard
+func (b *Bridge) persistQueue() {
+	b.mu.RLock()
+	connected := b.connected
+	b.mu.RUnlock()
+	if b.opDB == nil || !connected {
+		return
+	}
+
+	snapshot := b.Queue()
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		log.Printf("[BRIDGE] Failed to encode prompt queue: %v", err)
+		return
+	}
+
+	metadata := map[string]string{
+		"prompt_queue": string(data),
+		"queue_length": strconv.Itoa(len(snapshot.Pending)),
+	}
+	if err := b.opDB.UpdateAgentMetadata(b.agentID, metadata); err != nil {
+		log.Printf("[BRIDGE] Failed to persist prompt queue: %v", err)
+	}
+}
+
+// runQueue feeds queued prompts to Aider one at a time until the bridge stops
+func (b *Bridge) runQueue() {
+	for {
+		item := b.nextPrompt()
+		if item == nil {
+			b.drainQueue()
+			return
+		}
+
+		b.runPrompt(item)
+
+		b.mu.Lock()
+		b.running = nil
+		b.mu.Unlock()
+		b.persistQueue()
+	}
+}
+
+// nextPrompt blocks until a prompt is queued and marks it running; nil once stopped
+func (b *Bridge) nextPrompt() *queuedPrompt {
+	for {
+		b.mu.Lock()
+		if len(b.queue) > 0 {
+			item := b.queue[0]
+			b.queue = b.queue[1:]
+			now := time.Now()
+			item.info.StartedAt = &now
+			b.running = item
+			b.mu.Unlock()
+			b.persistQueue()
+			return item
+		}
+		b.mu.Unlock()
+
+		select {
+		case <-b.queueCh:
+		case <-b.stopCh:
+			return nil
+		}
+	}
+}
+
+// drainQueue fails every prompt still queued when the bridge stops
+func (b *Bridge) drainQueue() {
+	b.mu.Lock()
+	pending := b.queue
+	b.queue = nil
+	b.mu.Unlock()
+
+	for _, item := range pending {
+		item.complete(item.failed(b.agentID, PromptErrorStopped))
+	}
+}
+
+// runPrompt waits for Aider to be idle, sends the prompt and collects its
+// turn. Slash commands are sent as they are and leave no episode.
+func (b *Bridge) runPrompt(item *queuedPrompt) {
+	deadline := time.NewTimer(time.Until(item.deadline))
+	defer deadline.Stop()
+
+	t := item.resumed
+	if t == nil {
+		if t = b.startTurn(item, deadline.C); t == nil {
+			return
+		}
+	}
+
+	timedOut := false
+	select {
+	case <-t.done:
+	case <-deadline.C:
+		timedOut = true
+	case <-b.stopCh:
+		b.mu.Lock()
+		t.response.Error = PromptErrorStopped
+		b.mu.Unlock()
+	}
+
+	b.mu.Lock()
+	b.turn = nil
+	response := t.response
+	if t.interrupted {
+		response.Error = "interrupted"
+	}
+	b.mu.Unlock()
+
+	response.TimedOut = timedOut
+	response.CompletedAt = time.Now()
+	response.EditedFiles = make([]string, 0, len(t.edited))
+	for file := range t.edited {
+		response.EditedFiles = append(response.EditedFiles, file)
+	}
+	sort.Strings(response.EditedFiles)
+
+	if !item.info.Command {
+		b.recordEpisode(promptEpisode(&response))
+	}
+	item.complete(&response)
+}
+
+// newTurn starts collecting the output of a prompt
+func newTurn(agentID, prompt string) *turn {
+	return &turn{
+		response: natslib.PromptResponse{
+			AgentID:    agentID,
+			Prompt:     prompt,
+			Transcript: []string{},
+			StartedAt:  time.Now(),
+		},
+		edited: make(map[string]bool),
+		done:   make(chan struct{}),
+	}
+}
+
+// startTurn waits for Aider's prompt and sends the item, returning its turn;
+// nil when the item ended before it was sent
+func (b *Bridge) startTurn(item *queuedPrompt, deadline <-chan time.Time) *turn {
+	if err := b.waitIdle(deadline, item.cancel); err != nil {
+		item.complete(item.failed(b.agentID, err.Error()))
+		return nil
+	}
+
+	t := newTurn(b.agentID, item.info.Text)
+
+	text, knowledge := item.info.Text, []string(nil)
+	if !item.info.Command {
+		text, knowledge = b.withMemory(item.info)
+		t.response.Knowledge = knowledge
+	}
+
+	b.mu.Lock()
+	if item.cancelled() {
+		b.mu.Unlock()
+		item.complete(item.failed(b.agentID, errPromptCancelled.Error()))
+		return nil
+	}
+	b.turn = t
+	b.atPrompt = false
+	now := time.Now()
+	item.info.SentAt = &now
+	info := item.info
+	b.mu.Unlock()
+
+	if err := b.send(info, text); err != nil {
+		b.mu.Lock()
+		b.turn = nil
+		b.mu.Unlock()
+		item.complete(item.failed(b.agentID, fmt.Sprintf("failed to send prompt: %v", err)))
+		return nil
+	}
+	b.markKnowledgeUsed(item.info.ID, knowledge)
+
+	// Persisted so a monitor reattaching later knows the turn is under way
+	b.persistQueue()
+	return t
+}
+
+// send writes a queue item to Aider
+func (b *Bridge) send(info natslib.QueuedPrompt, text string) error {
+	if !info.Command {
+		return b.SendPrompt(text)
+	}
+	b.publishStatus("working", "Running "+text)
+	return b.writeInput(text)
+}
+
+// waitIdle blocks until Aider shows its input prompt or the prompt is cancelled
+func (b *Bridge) waitIdle(deadline <-chan time.Time, cancel <-chan struct{}) error {
+	ticker := time.NewTicker(idlePollInterval)
+	defer ticker.Stop()
+
+	for {
+		b.mu.RLock()
+		atPrompt, status := b.atPrompt, b.status
+		b.mu.RUnlock()
+		if atPrompt {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			return fmt.Errorf("timed out waiting for agent to become idle (status: %s)", status)
+		case <-cancel:
+			return errPromptCancelled
+		case <-b.stopCh:
+			return errors.New(PromptErrorStopped)
+		}
+	}
+}
+
+// handlePrompt serves request-reply prompts on agent.<id>.prompt
+func (b *Bridge) handlePrompt(msg *natslib.Message) {
+	var req natslib.PromptRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		log.Printf("[BRIDGE] Invalid prompt request JSON: %v", err)
+		b.reply(msg, &natslib.PromptResponse{AgentID: b.agentID, Error: "invalid prompt request"})
+		return
+	}
+
+	// Run off the subscription goroutine so status and commands keep flowing
+	go func() {
+		response, err := b.Prompt(req.Text, PromptOptionsFrom(req))
+		if err != nil {
+			response = &natslib.PromptResponse{AgentID: b.agentID, Prompt: req.Text, Error: err.Error()}
+		}
+		b.reply(msg, response)
+	}()
+}
+
+// handleQueue serves queue snapshots on agent.<id>.queue
+func (b *Bridge) handleQueue(msg *natslib.Message) {
+	snapshot := b.Queue()
+	b.reply(msg, &snapshot)
+}
+
+// reply sends a response to a NATS request
+func (b *Bridge) reply(msg *natslib.Message, v interface{}) {
+	if msg.Reply == "" {
+		return
+	}
+	if err := b.natsClient.PublishJSON(msg.Reply, v); err != nil {
+		log.Printf("[BRIDGE] Failed to reply on %s: %v", msg.Subject, err)
+	}
+}
@@ -0,0 +1,347 @@
+package aider
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+// newTestBridge returns a bridge running its queue against a fake Aider: the
+// lines written to its stdin arrive on the returned channel and its output is
+// fed with parseAiderLine
+func newTestBridge(t *testing.T) (*Bridge, <-chan string) {
+	t.Helper()
+
+	ns, err := server.NewServer(&server.Options{Port: server.RANDOM_PORT, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("Failed to create NATS server: %v", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	t.Cleanup(ns.Shutdown)
+
+	client, err := natslib.NewClient(ns.ClientURL(), "agent-aider-test")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	stdinR, stdinW := io.Pipe()
+	input := make(chan string, 10)
+	go func() {
+		scanner := bufio.NewScanner(stdinR)
+		for scanner.Scan() {
+			input <- scanner.Text()
+		}
+	}()
+
+	b := NewBridge("aider-test", client, stdinW, nil, nil)
+	go b.runQueue()
+	t.Cleanup(func() {
+		close(b.stopCh)
+		stdinW.Close()
+		client.Close()
+	})
+	return b, input
+}
+
+// expectInput waits for the next line written to Aider's stdin
+func expectInput(t *testing.T, input <-chan string, want string) {
+	t.Helper()
+	select {
+	case got := <-input:
+		if got != want {
+			t.Fatalf("Expected %q on stdin, got %q", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %q on stdin", want)
+	}
+}
+
+func TestScanOutputYieldsUnterminatedPrompt(t *testing.T) {
+	r, w := io.Pipe()
+	lines := make(chan string, 10)
+	go scanOutput(r, func(line string) { lines <- line })
+
+	// Aider prints its prompt without a newline and waits for input
+	io.WriteString(w, "Applied edit to main.go\r\n> ")
+
+	for _, want := range []string{"Applied edit to main.go", "> "} {
+		if got := <-lines; got != want {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	}
+	w.Close()
+}
+
+func TestTurnCollectsPromptOutcome(t *testing.T) {
+	p := newOutputParser()
+	tr := &turn{edited: make(map[string]bool), done: make(chan struct{})}
+
+	output := []string{
+		"main.go",
+		"```go",
+		"<<<<<<< SEARCH",
+		"old",
+		"=======",
+		"new",
+		">>>>>>> REPLACE",
+		"```",
+		"Tokens: 1.5k sent, 200 received. Cost: $0.00 message, $0.00 session.",
+		"Applied edit to main.go",
+		"Commit abc1234 refactor: use new",
+		"> ",
+	}
+	for _, line := range output {
+		tr.record(line, p.parse(line))
+	}
+
+	select {
+	case <-tr.done:
+	default:
+		t.Fatal("Expected turn to finish at the prompt")
+	}
+
+	if len(tr.response.Transcript) != len(output)-1 {
+		t.Errorf("Expected %d transcript lines, got %d", len(output)-1, len(tr.response.Transcript))
+	}
+	if strings.TrimSpace(tr.response.Transcript[len(tr.response.Transcript)-1]) == ">" {
+		t.Error("Prompt should not be part of the transcript")
+	}
+	if !tr.edited["main.go"] {
+		t.Errorf("Expected main.go to be edited, got %v", tr.edited)
+	}
+	if tr.response.Tokens.Sent != 1500 || tr.response.Tokens.Received != 200 {
+		t.Errorf("Unexpected tokens: %+v", tr.response.Tokens)
+	}
+	if len(tr.response.Commits) != 1 || tr.response.Commits[0] != (natslib.CommitEvent{Hash: "abc1234", Message: "refactor: use new"}) {
+		t.Errorf("Unexpected commits: %+v", tr.response.Commits)
+	}
+}
+
+func TestPromptQueueOrderAndCancel(t *testing.T) {
+	// No worker is started, so prompts stay queued
+	b := NewBridge("aider-test", nil, nil, nil, nil)
+
+	first, err := b.Enqueue("first", PromptOptions{})
+	if err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	second, _ := b.Enqueue("second", PromptOptions{})
+	b.Enqueue("third", PromptOptions{})
+
+	if err := b.Cancel(second.ID); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	if err := b.Cancel("missing"); err == nil {
+		t.Error("Expected error cancelling unknown prompt")
+	}
+
+	queue := b.Queue()
+	if queue.Running != nil {
+		t.Errorf("Expected nothing running, got %+v", queue.Running)
+	}
+	if len(queue.Pending) != 2 || queue.Pending[0].ID != first.ID || queue.Pending[1].Text != "third" {
+		t.Errorf("Unexpected queue: %+v", queue.Pending)
+	}
+
+	if err := b.Interrupt(); err == nil {
+		t.Error("Expected error interrupting with nothing running")
+	}
+}
+
+func TestPromptTimesOutWhileQueued(t *testing.T) {
+	b := NewBridge("aider-test", nil, nil, nil, nil)
+
+	_, err := b.Prompt("never runs", PromptOptions{Timeout: 50 * time.Millisecond})
+	if err == nil || !strings.Contains(err.Error(), "queue") {
+		t.Fatalf("Expected queue timeout, got %v", err)
+	}
+	if len(b.Queue().Pending) != 0 {
+		t.Error("Timed out prompt should leave the queue")
+	}
+}
+
+func TestCancelRunningPromptWhileAgentBusy(t *testing.T) {
+	// Aider never becomes idle, so the running prompt waits to be sent
+	b := NewBridge("aider-test", nil, nil, nil, nil)
+	go b.runQueue()
+	defer close(b.stopCh)
+
+	results := make(chan *natslib.PromptResponse, 1)
+	go func() {
+		response, _ := b.Prompt("busy", PromptOptions{})
+		results <- response
+	}()
+
+	var running *natslib.QueuedPrompt
+	for deadline := time.Now().Add(5 * time.Second); running == nil; {
+		if time.Now().After(deadline) {
+			t.Fatal("Prompt never started running")
+		}
+		time.Sleep(10 * time.Millisecond)
+		running = b.Queue().Running
+	}
+
+	if err := b.Cancel(running.ID); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+
+	select {
+	case response := <-results:
+		if response.Error != "cancelled" || response.PromptID != running.ID {
+			t.Errorf("Expected the prompt cancelled, got %+v", response)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cancelled prompt never completed")
+	}
+}
+
+func TestQueueIgnoresStatusNoiseAtPrompt(t *testing.T) {
+	b, input := newTestBridge(t)
+
+	// Aider reaches its prompt, then a stderr warning overwrites the status
+	b.parseAiderLine("> ")
+	b.publishStatus("error", "litellm: error fetching model info")
+
+	results := make(chan *natslib.PromptResponse, 1)
+	go func() {
+		response, _ := b.Prompt("Fix main", PromptOptions{})
+		results <- response
+	}()
+
+	expectInput(t, input, "Fix main")
+	b.parseAiderLine("Applied edit to main.go")
+	b.parseAiderLine("> ")
+
+	select {
+	case response := <-results:
+		if response.Error != "" || len(response.EditedFiles) != 1 {
+			t.Errorf("Unexpected response: %+v", response)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Prompt never completed")
+	}
+}
+
+func TestStderrNoiseIsOnlyAWarning(t *testing.T) {
+	b, input := newTestBridge(t)
+	b.parseAiderLine("> ")
+
+	results := make(chan *natslib.PromptResponse, 1)
+	go func() {
+		response, _ := b.Prompt("Fix main", PromptOptions{})
+		results <- response
+	}()
+
+	expectInput(t, input, "Fix main")
+	b.recordTurnWarning("litellm: error fetching model info")
+	b.parseAiderLine("litellm.APIError: model not loaded")
+	b.parseAiderLine("> ")
+
+	select {
+	case response := <-results:
+		if len(response.Warnings) != 1 || len(response.Errors) != 1 || !strings.Contains(response.Errors[0], "model not loaded") {
+			t.Errorf("Expected the stderr line as a warning and the parsed error as an error, got %+v", response)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Prompt never completed")
+	}
+}
+
+func TestSlashCommandsWaitTheirTurn(t *testing.T) {
+	b, input := newTestBridge(t)
+	b.parseAiderLine("> ")
+
+	if err := b.AddFile("main.go"); err != nil {
+		t.Fatalf("AddFile failed: %v", err)
+	}
+	results := make(chan *natslib.PromptResponse, 1)
+	go func() {
+		response, _ := b.Prompt("Fix main", PromptOptions{})
+		results <- response
+	}()
+
+	// The prompt Aider prints after /add ends the command, not the queued prompt
+	expectInput(t, input, "/add main.go")
+	b.parseAiderLine("Added main.go to the chat")
+	b.parseAiderLine("> ")
+
+	expectInput(t, input, "Fix main")
+	if err := b.AddFile("util.go"); err != nil {
+		t.Fatalf("AddFile failed: %v", err)
+	}
+	b.parseAiderLine("Applied edit to main.go")
+	b.parseAiderLine("> ")
+
+	select {
+	case response := <-results:
+		transcript := strings.Join(response.Transcript, "\n")
+		if strings.Contains(transcript, "Added main.go") || !strings.Contains(transcript, "Applied edit to main.go") {
+			t.Errorf("Expected only the prompt's own output, got %q", transcript)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Prompt never completed")
+	}
+
+	// A file added mid-turn goes in once the prompt is done
+	expectInput(t, input, "/add util.go")
+	if files := b.AddedFiles(); len(files) != 2 {
+		t.Errorf("Expected both files tracked, got %v", files)
+	}
+}
+
+func TestResumeWaitsForTheTurnInFlight(t *testing.T) {
+	b, input := newTestBridge(t)
+
+	// The previous monitor detached while Aider worked on p-1
+	sent := time.Now()
+	resumed := b.Resume(natslib.PromptQueueMessage{
+		Running: &natslib.QueuedPrompt{ID: "p-1", Text: "Fix main", SentAt: &sent},
+		Pending: []natslib.QueuedPrompt{{ID: "p-2", Text: "Fix util"}},
+	})
+	if !resumed {
+		t.Fatal("Expected the prompt in flight to be resumed")
+	}
+
+	select {
+	case line := <-input:
+		t.Fatalf("Nothing should be sent while the resumed turn runs, got %q", line)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if running := b.Queue().Running; running == nil || running.ID != "p-1" {
+		t.Fatalf("Expected p-1 running, got %+v", running)
+	}
+
+	b.parseAiderLine("Applied edit to main.go")
+	b.parseAiderLine("> ")
+
+	expectInput(t, input, "Fix util")
+	if running := b.Queue().Running; running == nil || running.ID != "p-2" || running.SentAt == nil {
+		t.Errorf("Expected p-2 sent, got %+v", running)
+	}
+}
+
+func TestResumeRequeuesUnsentPrompt(t *testing.T) {
+	b := NewBridge("aider-test", nil, nil, nil, nil)
+
+	resumed := b.Resume(natslib.PromptQueueMessage{
+		Running: &natslib.QueuedPrompt{ID: "p-1", Text: "/add main.go", Command: true},
+		Pending: []natslib.QueuedPrompt{{ID: "p-2", Text: "Fix main", TaskType: "bugfix"}},
+	})
+	if resumed {
+		t.Error("A prompt never sent should not be resumed")
+	}
+
+	queue := b.Queue()
+	if len(queue.Pending) != 2 || queue.Pending[0].ID != "p-1" || !queue.Pending[0].Command ||
+		queue.Pending[1].ID != "p-2" || queue.Pending[1].TaskType != "bugfix" {
+		t.Errorf("Unexpected queue: %+v", queue.Pending)
+	}
+}
@@ -0,0 +1,199 @@
+package aider
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+)
+
+// ReconcileResult lists what Reconcile did, by agents: entry name
+type ReconcileResult struct {
+	Started   []string          `json:"started"`
+	Restarted []string          `json:"restarted"` // settings changed, replaced by a new agent
+	Stopped   []string          `json:"stopped"`   // entry removed or no longer autostarted
+	Unchanged []string          `json:"unchanged"`
+	Pending   []string          `json:"pending"` // crashed, left to the restart policy
+	Failed    map[string]string `json:"failed,omitempty"`
+}
+
+// changed reports whether Reconcile started or stopped anything
+func (r *ReconcileResult) changed() bool {
+	return len(r.Started)+len(r.Restarted)+len(r.Stopped)+len(r.Failed) > 0
+}
+
+// String summarizes the result for logs
+func (r ReconcileResult) String() string {
+	return fmt.Sprintf("%d started, %d restarted, %d stopped, %d unchanged, %d pending, %d failed",
+		len(r.Started), len(r.Restarted), len(r.Stopped), len(r.Unchanged), len(r.Pending), len(r.Failed))
+}
+
+// plannedStart is an agent Reconcile has to start
+type plannedStart struct {
+	config  AgentConfig
+	replace bool // replaces a running agent whose settings changed
+}
+
+// reconcilePlan is what Reconcile has to do to match the declared agents
+type reconcilePlan struct {
+	start  []plannedStart
+	stop   []string // agent IDs
+	result ReconcileResult
+}
+
+// Reconcile brings the agents started for the agents: section in line with
+// the given entries, which also become the spawn templates. Every entry that
+// autostarts gets exactly one agent: missing agents are started, agents whose
+// settings changed are replaced and agents whose entry was removed or no
+// longer autostarts are stopped. Agents spawned through the API are left alone.
+//
+// It runs on boot, after Reattach so surviving agents are kept, and on each
+// reload of the configuration. Crashed agents waiting for a restart are left
+// to their restart policy; one whose circuit breaker tripped is started anew.
+func (s *Spawner) Reconcile(agents []AgentConfig) ReconcileResult {
+	s.reconcileMu.Lock()
+	defer s.reconcileMu.Unlock()
+
+	select {
+	case <-s.stopCh:
+		return ReconcileResult{}
+	default:
+	}
+
+	s.mu.Lock()
+	s.templates = agents
+	plan := s.planReconcile(agents)
+	s.mu.Unlock()
+
+	for _, agentID := range plan.stop {
+		if err := s.StopAgent(agentID); err != nil {
+			log.Printf("[SPAWNER] Failed to stop agent %s: %v", agentID, err)
+		}
+	}
+
+	result := plan.result
+	for _, start := range plan.start {
+		name := start.config.Name
+		agent, err := s.spawn(start.config, name)
+		if err != nil {
+			log.Printf("[SPAWNER] Failed to start configured agent %s: %v", name, err)
+			if result.Failed == nil {
+				result.Failed = make(map[string]string)
+			}
+			result.Failed[name] = err.Error()
+			continue
+		}
+		log.Printf("[SPAWNER] Configured agent %s running as %s", name, agent.ID)
+		if start.replace {
+			result.Restarted = append(result.Restarted, name)
+		} else {
+			result.Started = append(result.Started, name)
+		}
+	}
+
+	log.Printf("[SPAWNER] Reconciled configured agents: %s", result)
+
+	if result.changed() {
+		s.publish("spawner-reconcile", natslib.SubjectSystemBroadcast, natslib.SystemBroadcastMessage{
+			Type:    "config_change",
+			Message: "Configured agents reconciled",
+			Data: map[string]interface{}{
+				"started":   result.Started,
+				"restarted": result.Restarted,
+				"stopped":   result.Stopped,
+				"failed":    result.Failed,
+			},
+			Timestamp: time.Now(),
+		})
+	}
+
+	return result
+}
+
+// planReconcile compares the declared agents with the running ones. It
+// forgets removed entries and cancels their pending restarts. Caller must
+// hold s.mu.
+func (s *Spawner) planReconcile(declared []AgentConfig) reconcilePlan {
+	plan := reconcilePlan{result: ReconcileResult{
+		Started:   []string{},
+		Restarted: []string{},
+		Stopped:   []string{},
+		Unchanged: []string{},
+		Pending:   []string{},
+	}}
+
+	wanted := make(map[string]bool)
+	for _, entry := range declared {
+		if !entry.AutoStarts() {
+			continue
+		}
+		wanted[entry.Name] = true
+
+		agentID, known := s.managed[entry.Name]
+		agent := s.agents[agentID]
+		switch {
+		case agent != nil && sameSpec(agent.Config, entry):
+			plan.result.Unchanged = append(plan.result.Unchanged, entry.Name)
+		case agent != nil:
+			delete(s.restarts, agentID)
+			plan.stop = append(plan.stop, agentID)
+			plan.start = append(plan.start, plannedStart{config: entry, replace: true})
+		case known && s.restartPending(agentID):
+			plan.result.Pending = append(plan.result.Pending, entry.Name)
+		default:
+			if known {
+				delete(s.restarts, agentID)
+			}
+			plan.start = append(plan.start, plannedStart{config: entry})
+		}
+	}
+
+	for name, agentID := range s.managed {
+		if wanted[name] {
+			continue
+		}
+		delete(s.managed, name)
+		delete(s.restarts, agentID)
+		if _, running := s.agents[agentID]; running {
+			plan.stop = append(plan.stop, agentID)
+			plan.result.Stopped = append(plan.result.Stopped, name)
+		}
+	}
+
+	return plan
+}
+
+// restartPending reports whether a crashed agent is waiting to be restarted.
+// Caller must hold s.mu.
+func (s *Spawner) restartPending(agentID string) bool {
+	state, ok := s.restarts[agentID]
+	return ok && state.pending
+}
+
+// IsManaged reports whether an agent was started for an agents: entry, so
+// its lifecycle belongs to Reconcile
+func (s *Spawner) IsManaged(agentID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.managedName(agentID) != ""
+}
+
+// managedName returns the agents: entry an agent was started for, if any.
+// Caller must hold s.mu.
+func (s *Spawner) managedName(agentID string) string {
+	for name, id := range s.managed {
+		if id == agentID {
+			return name
+		}
+	}
+	return ""
+}
+
+// sameSpec reports whether a running agent matches its entry. Reattached
+// agents get their API key and env back from the supervisor dir, so those
+// count too.
+func sameSpec(running, declared AgentConfig) bool {
+	return reflect.DeepEqual(running.Clone(), declared.Clone())
+}
@@ -0,0 +1,119 @@
+package aider
+
+import (
+	"slices"
+	"sort"
+	"testing"
+)
+
+// newTestSpawner returns a spawner whose fake agents are dropped before it stops
+func newTestSpawner(t *testing.T) *Spawner {
+	spawner := NewSpawner("nats://127.0.0.1:0", DefaultConfig())
+	t.Cleanup(func() {
+		spawner.mu.Lock()
+		spawner.agents = make(map[string]*Agent)
+		spawner.mu.Unlock()
+		spawner.StopAll()
+	})
+	return spawner
+}
+
+// addManaged registers a fake running agent for an agents: entry
+func addManaged(s *Spawner, agentID string, config AgentConfig) {
+	s.agents[agentID] = &Agent{ID: agentID, Config: config.Clone()}
+	s.managed[config.Name] = agentID
+}
+
+func TestPlanReconcile(t *testing.T) {
+	spawner := newTestSpawner(t)
+	off := false
+
+	keep := AgentConfig{Name: "keep", ProjectPath: "/p", Env: map[string]string{"A": "1"}}
+	change := AgentConfig{Name: "change", ProjectPath: "/p", InitialFiles: []string{"a.go"}}
+	rekey := AgentConfig{Name: "rekey", ProjectPath: "/p", APIKey: "old", Env: map[string]string{"A": "1"}}
+	addManaged(spawner, "aider-keep", keep)
+	addManaged(spawner, "aider-change", change)
+	addManaged(spawner, "aider-rekey", rekey)
+	addManaged(spawner, "aider-removed", AgentConfig{Name: "removed", ProjectPath: "/p"})
+	addManaged(spawner, "aider-disabled", AgentConfig{Name: "disabled", ProjectPath: "/p"})
+	spawner.agents["aider-api"] = &Agent{ID: "aider-api", Config: AgentConfig{Name: "keep", ProjectPath: "/p"}}
+
+	// A crashed agent waiting for its restart, and one whose breaker tripped
+	spawner.managed["crashed"] = "aider-crashed"
+	spawner.restarts["aider-crashed"] = &restartState{count: 1, pending: true}
+	spawner.managed["tripped"] = "aider-tripped"
+	spawner.restarts["aider-tripped"] = &restartState{count: 3, tripped: true}
+
+	changed := change.Clone()
+	changed.InitialFiles = []string{"a.go", "b.go"}
+	rekeyed := rekey.Clone()
+	rekeyed.APIKey = "new" // secret fields count too
+
+	spawner.mu.Lock()
+	plan := spawner.planReconcile([]AgentConfig{
+		keep.Clone(),
+		changed,
+		rekeyed,
+		{Name: "disabled", ProjectPath: "/p", Autostart: &off},
+		{Name: "crashed", ProjectPath: "/p"},
+		{Name: "tripped", ProjectPath: "/p"},
+		{Name: "new", ProjectPath: "/p"},
+		{Name: "template"},
+	})
+	spawner.mu.Unlock()
+
+	if got := plan.result.Unchanged; len(got) != 1 || got[0] != "keep" {
+		t.Errorf("Expected keep unchanged, got %v", got)
+	}
+	if got := plan.result.Pending; len(got) != 1 || got[0] != "crashed" {
+		t.Errorf("Expected crashed pending, got %v", got)
+	}
+
+	var starts []string
+	for _, start := range plan.start {
+		name := start.config.Name
+		if start.replace {
+			name += " (replace)"
+		}
+		starts = append(starts, name)
+	}
+	sort.Strings(starts)
+	if want := []string{"change (replace)", "new", "rekey (replace)", "tripped"}; !slices.Equal(starts, want) {
+		t.Errorf("Expected starts %v, got %v", want, starts)
+	}
+
+	sort.Strings(plan.stop)
+	if want := []string{"aider-change", "aider-disabled", "aider-rekey", "aider-removed"}; !slices.Equal(plan.stop, want) {
+		t.Errorf("Expected stops %v, got %v", want, plan.stop)
+	}
+	sort.Strings(plan.result.Stopped)
+	if want := []string{"disabled", "removed"}; !slices.Equal(plan.result.Stopped, want) {
+		t.Errorf("Expected stopped entries %v, got %v", want, plan.result.Stopped)
+	}
+
+	// Removed entries are forgotten, tripped breakers reset, pending restarts kept
+	if _, ok := spawner.managed["removed"]; ok {
+		t.Error("Expected the removed entry to be forgotten")
+	}
+	if _, ok := spawner.restarts["aider-tripped"]; ok {
+		t.Error("Expected the tripped breaker to be cleared")
+	}
+	if !spawner.restartPending("aider-crashed") {
+		t.Error("Expected the pending restart to be kept")
+	}
+}
+
+func TestReconcileStartFailureIsReported(t *testing.T) {
+	spawner := newTestSpawner(t)
+
+	result := spawner.Reconcile([]AgentConfig{{Name: "missing", ProjectPath: t.TempDir() + "/missing"}})
+	if result.Failed["missing"] == "" || len(result.Started) != 0 {
+		t.Errorf("Expected the start to fail, got %+v", result)
+	}
+	if _, ok := spawner.managed["missing"]; ok {
+		t.Error("A failed start must not be tracked")
+	}
+	if _, ok := spawner.AgentTemplate("missing"); !ok {
+		t.Error("Expected the entries to become the spawn templates")
+	}
+}
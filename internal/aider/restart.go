@@ -0,0 +1,145 @@
+package aider
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+	"github.com/google/uuid"
+)
+
+// scheduleRestart applies the agent's restart policy after it exited. Caller must hold s.mu.
+func (s *Spawner) scheduleRestart(agent *Agent) {
+	policy := agent.Config.Restart
+	if !policy.ShouldRestart(agent.exitedWithFailure()) {
+		return
+	}
+
+	state, ok := s.restarts[agent.ID]
+	if !ok {
+		state = &restartState{}
+		s.restarts[agent.ID] = state
+	}
+	if state.tripped {
+		return
+	}
+
+	// A long enough run means the previous crashes were not a loop
+	if s.now().Sub(agent.StartedAt) >= policy.StableAfter() {
+		state.count = 0
+	}
+
+	s.retryRestart(agent, state)
+}
+
+// retryRestart schedules the next restart attempt or trips the circuit breaker.
+// Caller must hold s.mu.
+func (s *Spawner) retryRestart(agent *Agent, state *restartState) {
+	policy := agent.Config.Restart
+
+	if state.count >= policy.Limit() {
+		state.tripped = true
+		log.Printf("[SPAWNER] Agent %s crashed %d times in a row, circuit breaker tripped", agent.ID, state.count)
+		s.escalateCrashLoop(agent, state.count)
+		return
+	}
+
+	delay := policy.Delay(state.count)
+	state.count++
+	state.pending = true
+	attempt := state.count
+
+	log.Printf("[SPAWNER] Restarting agent %s in %s (attempt %d/%d)", agent.ID, delay, attempt, policy.Limit())
+	s.afterFunc(delay, func() {
+		s.restartAgent(agent, attempt)
+	})
+}
+
+// restartAgent respawns a crashed agent under the same ID and re-adds its files
+func (s *Spawner) restartAgent(old *Agent, attempt int) {
+	s.mu.Lock()
+
+	select {
+	case <-s.stopCh:
+		s.mu.Unlock()
+		return
+	default:
+	}
+
+	if _, exists := s.agents[old.ID]; exists {
+		s.mu.Unlock()
+		return
+	}
+
+	// Reconcile cancels restarts of agents it replaced or removed
+	state, ok := s.restarts[old.ID]
+	if !ok || !state.pending {
+		s.mu.Unlock()
+		return
+	}
+	state.pending = false
+
+	agent, err := s.startAgent(old.ID, old.Config)
+	if err != nil {
+		log.Printf("[SPAWNER] Restart of agent %s failed: %v", old.ID, err)
+		s.retryRestart(old, state)
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+
+	// Restore the chat context the agent had before it crashed
+	files := old.Bridge.AddedFiles()
+	for _, file := range files {
+		if err := agent.Bridge.AddFile(file); err != nil {
+			log.Printf("[SPAWNER] Failed to re-add %s to agent %s: %v", file, agent.ID, err)
+		}
+	}
+
+	log.Printf("[SPAWNER] Agent %s restarted (attempt %d, PID: %d, %d files re-added)",
+		agent.ID, attempt, agent.Process.Pid, len(files))
+
+	msg := natslib.SystemBroadcastMessage{
+		Type:    "agent_restarted",
+		Message: fmt.Sprintf("Aider agent %s restarted after crash (attempt %d)", agent.ID, attempt),
+		Data: map[string]interface{}{
+			"agent_id":     agent.ID,
+			"pid":          agent.Process.Pid,
+			"attempt":      attempt,
+			"project_path": agent.ProjectPath,
+			"files":        files,
+		},
+		Timestamp: time.Now(),
+	}
+	s.publish(fmt.Sprintf("spawner-restart-%s", agent.ID), natslib.SubjectSystemBroadcast, msg)
+}
+
+// escalateCrashLoop raises an escalation when the circuit breaker trips
+func (s *Spawner) escalateCrashLoop(agent *Agent, crashes int) {
+	context := map[string]interface{}{
+		"project_path": agent.ProjectPath,
+		"model":        agent.Model,
+		"crashes":      crashes,
+		"last_exit":    fmt.Sprintf("%v", agent.exitState),
+	}
+
+	escalation := natslib.EscalationCreateMessage{
+		ID:      uuid.New().String(),
+		AgentID: agent.ID,
+		Question: fmt.Sprintf("Agent %s crashed %d times in a row and will not be restarted. Investigate and respawn manually.",
+			agent.ID, crashes),
+		Context:   context,
+		Timestamp: time.Now(),
+	}
+	s.publish(fmt.Sprintf("spawner-escalation-%s", agent.ID), natslib.SubjectEscalationCreate, escalation)
+
+	broadcast := natslib.SystemBroadcastMessage{
+		Type:      "agent_crash_loop",
+		Message:   fmt.Sprintf("Restart circuit breaker tripped for agent %s after %d crashes", agent.ID, crashes),
+		Data:      context,
+		Timestamp: time.Now(),
+	}
+	broadcast.Data["agent_id"] = agent.ID
+	s.publish(fmt.Sprintf("spawner-crashloop-%s", agent.ID), natslib.SubjectSystemBroadcast, broadcast)
+}
@@ -0,0 +1,77 @@
+package aider
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestScheduleRestart(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RestartPolicy
+		runs    []time.Duration // how long the agent ran before each crash
+		delays  []time.Duration // restarts scheduled, in order
+		tripped bool
+	}{
+		{
+			name:   "backoff doubles up to the cap",
+			policy: RestartPolicy{Mode: RestartOnFailure, MaxRestarts: 5, Backoff: 1, MaxBackoff: 4},
+			runs:   []time.Duration{time.Second, time.Second, time.Second, time.Second, time.Second},
+			delays: []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second, 4 * time.Second},
+		},
+		{
+			name:   "stable run resets the count",
+			policy: RestartPolicy{Mode: RestartOnFailure, Backoff: 1, ResetAfter: 60},
+			runs:   []time.Duration{time.Second, time.Second, 2 * time.Minute, time.Second},
+			delays: []time.Duration{1 * time.Second, 2 * time.Second, 1 * time.Second, 2 * time.Second},
+		},
+		{
+			name:    "breaker trips after the limit",
+			policy:  RestartPolicy{Mode: RestartOnFailure, MaxRestarts: 2, Backoff: 1},
+			runs:    []time.Duration{time.Second, time.Second, time.Second},
+			delays:  []time.Duration{1 * time.Second, 2 * time.Second},
+			tripped: true,
+		},
+		{
+			name:    "tripped breaker ignores a later stable run",
+			policy:  RestartPolicy{Mode: RestartOnFailure, MaxRestarts: 1, Backoff: 1, ResetAfter: 60},
+			runs:    []time.Duration{time.Second, time.Second, time.Hour},
+			delays:  []time.Duration{1 * time.Second},
+			tripped: true,
+		},
+		{
+			name:   "never restarts",
+			policy: RestartPolicy{Mode: RestartNever},
+			runs:   []time.Duration{time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spawner := newTestSpawner(t)
+			now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+			var delays []time.Duration
+			spawner.now = func() time.Time { return now }
+			spawner.afterFunc = func(d time.Duration, f func()) { delays = append(delays, d) }
+
+			agent := &Agent{ID: "aider-crashy", Config: AgentConfig{Name: "crashy", Restart: tt.policy}}
+			for _, run := range tt.runs {
+				agent.StartedAt = now
+				now = now.Add(run)
+				spawner.mu.Lock()
+				spawner.scheduleRestart(agent)
+				spawner.mu.Unlock()
+			}
+
+			if !reflect.DeepEqual(delays, tt.delays) {
+				t.Errorf("Expected restarts after %v, got %v", tt.delays, delays)
+			}
+			spawner.mu.Lock()
+			defer spawner.mu.Unlock()
+			if state := spawner.restarts[agent.ID]; (state != nil && state.tripped) != tt.tripped {
+				t.Errorf("Expected tripped %v, got %+v", tt.tripped, state)
+			}
+		})
+	}
+}
@@ -1,14 +1,17 @@
 package aider
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/CLIAIRMONITOR/internal/memory"
 	natslib "github.com/CLIAIRMONITOR/internal/nats"
 	"github.com/google/uuid"
 )
@@ -18,29 +21,97 @@ type Agent struct {
 	ID          string
 	ProjectPath string
 	Model       string
+	SessionID   string
+	Config      AgentConfig
 	Bridge      *Bridge
 	Process     *os.Process
 	cmd         *exec.Cmd
 	StartedAt   time.Time
+
+	// Set once the process has exited and been reaped
+	exited    chan struct{}
+	exitState *os.ProcessState
+
+	// Running behind named pipes, detached from the monitor
+	supervised bool
+}
+
+// Supervised reports whether the agent runs behind named pipes and outlives
+// the monitor
+func (a *Agent) Supervised() bool {
+	return a.supervised
+}
+
+// wait reaps the Aider process and records its exit state
+func (a *Agent) wait() {
+	state, err := a.Process.Wait()
+	if err != nil {
+		log.Printf("[SPAWNER] Wait for agent %s failed: %v", a.ID, err)
+	}
+	a.exitState = state
+	close(a.exited)
+}
+
+// hasExited reports whether the Aider process has exited
+func (a *Agent) hasExited() bool {
+	select {
+	case <-a.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// exitedWithFailure reports whether the process exited with a non-zero status or signal
+func (a *Agent) exitedWithFailure() bool {
+	return a.exitState == nil || !a.exitState.Success()
+}
+
+// restartState tracks consecutive restarts of one agent for the circuit breaker
+type restartState struct {
+	count   int
+	tripped bool
+	pending bool // a restart is scheduled; cleared to cancel it
 }
 
 // Spawner manages Aider CLI processes
 type Spawner struct {
-	natsURL string
-	config  *Config
-	agents  map[string]*Agent
-	mu      sync.RWMutex
-	stopCh  chan struct{}
-	wg      sync.WaitGroup
+	natsURL  string
+	config   *Config
+	opDB     memory.OperationalDB
+	learning memory.LearningDB
+	context  *memory.RAGContextBuilder
+	agents   map[string]*Agent
+	restarts map[string]*restartState
+	mu       sync.RWMutex
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+
+	// Spawn templates, and the agents started for autostart entries by
+	// entry name; see Reconcile
+	templates   []AgentConfig
+	managed     map[string]string
+	reconcileMu sync.Mutex
+
+	// Clock and timer for restarts, replaced in tests
+	now       func() time.Time
+	afterFunc func(time.Duration, func())
 }
 
 // NewSpawner creates a new Aider process spawner
 func NewSpawner(natsURL string, config *Config) *Spawner {
 	s := &Spawner{
-		natsURL: natsURL,
-		config:  config,
-		agents:  make(map[string]*Agent),
-		stopCh:  make(chan struct{}),
+		natsURL:  natsURL,
+		config:   config,
+		agents:   make(map[string]*Agent),
+		restarts: make(map[string]*restartState),
+		stopCh:   make(chan struct{}),
+
+		templates: config.Agents,
+		managed:   make(map[string]string),
+
+		now:       time.Now,
+		afterFunc: func(d time.Duration, f func()) { time.AfterFunc(d, f) },
 	}
 
 	// Start agent monitor
@@ -50,14 +121,124 @@ func NewSpawner(natsURL string, config *Config) *Spawner {
 	return s
 }
 
-// SpawnAgent spawns a new Aider process with the given configuration
-func (s *Spawner) SpawnAgent(agentConfig AgentConfig) (*Agent, error) {
+// SetOperationalDB lets the spawner release tasks claimed by crashed agents
+func (s *Spawner) SetOperationalDB(db memory.OperationalDB) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opDB = db
+}
+
+// SetLearningDB enables injecting retrieved memory into agents' prompts
+// according to the memory section of the configuration
+func (s *Spawner) SetLearningDB(db memory.LearningDB) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.learning = db
+	s.context = memory.NewRAGContextBuilder(db)
+	if s.config.Memory.Budget > 0 {
+		budget := memory.DefaultContextBudget()
+		budget.MemoryBudget = s.config.Memory.Budget
+		s.context.SetBudget(budget)
+	}
+}
+
+// attachMemory sets up memory injection for an agent's bridge
+func (s *Spawner) attachMemory(bridge *Bridge, agentConfig AgentConfig) {
+	if s.context == nil {
+		return
+	}
+	bridge.SetMemory(s.context, s.learning, s.config.MemoryFor(agentConfig), agentConfig.ProjectPath)
+}
+
+// memoryArgs returns the extra Aider arguments for read mode memory
+// injection, creating the file Aider is told to read
+func (s *Spawner) memoryArgs(agentID string) []string {
+	if s.context == nil || s.config.Memory.Mode != MemoryModeRead {
+		return nil
+	}
+	path := memoryReadFile(s.config.Memory, agentID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Printf("[SPAWNER] Failed to create memory directory for %s: %v", path, err)
+		return nil
+	}
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		log.Printf("[SPAWNER] Failed to create memory file %s: %v", path, err)
+		return nil
+	}
+	return []string{"--read", path}
+}
+
+// removeMemoryFile deletes a stopped agent's read mode memory file
+func (s *Spawner) removeMemoryFile(agentID string) {
+	if s.context == nil || s.config.Memory.Mode != MemoryModeRead {
+		return
+	}
+	path := memoryReadFile(s.config.Memory, agentID)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("[SPAWNER] Failed to remove memory file %s: %v", path, err)
+	}
+}
+
+// ErrAgentLimit is returned by SpawnAgent when sergeant.max_concurrent_agents
+// agents are already running
+var ErrAgentLimit = errors.New("agent limit reached")
+
+// SpawnAgent spawns a new Aider process with the given configuration, then
+// adds its initial files and queues its initial prompt
+func (s *Spawner) SpawnAgent(agentConfig AgentConfig) (*Agent, error) {
+	return s.spawn(agentConfig, "")
+}
+
+// spawn starts an agent, recording it as the agent of an agents: entry when
+// managedName is set
+func (s *Spawner) spawn(agentConfig AgentConfig, managedName string) (*Agent, error) {
+	if err := agentConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid agent config: %w", err)
+	}
+	agentConfig = agentConfig.Clone()
+
+	s.mu.Lock()
+	if max := s.config.Sergeant.MaxConcurrentAgents; max > 0 && len(s.agents) >= max {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: %d of %d agents running", ErrAgentLimit, len(s.agents), max)
+	}
 
 	// Generate unique agent ID
 	agentID := fmt.Sprintf("aider-%s", uuid.New().String()[:8])
+	if managedName != "" {
+		s.managed[managedName] = agentID
+	}
 
+	agent, err := s.startAgent(agentID, agentConfig)
+	if err != nil && managedName != "" {
+		delete(s.managed, managedName)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	s.setupChat(agent)
+	return agent, nil
+}
+
+// setupChat adds an agent's initial files and queues its initial prompt.
+// Restarts skip this and re-add the files the agent had instead.
+func (s *Spawner) setupChat(agent *Agent) {
+	for _, file := range agent.Config.InitialFiles {
+		if err := agent.Bridge.AddFile(file); err != nil {
+			log.Printf("[SPAWNER] Failed to add %s to agent %s: %v", file, agent.ID, err)
+		}
+	}
+	if agent.Config.InitialPrompt != "" {
+		if _, err := agent.Bridge.Enqueue(agent.Config.InitialPrompt, PromptOptions{}); err != nil {
+			log.Printf("[SPAWNER] Failed to queue initial prompt for agent %s: %v", agent.ID, err)
+		}
+	}
+}
+
+// startAgent launches Aider under the given agent ID. Caller must hold s.mu.
+func (s *Spawner) startAgent(agentID string, agentConfig AgentConfig) (*Agent, error) {
 	// Validate project path
 	if agentConfig.ProjectPath == "" {
 		return nil, fmt.Errorf("project path is required")
@@ -67,40 +248,32 @@ func (s *Spawner) SpawnAgent(agentConfig AgentConfig) (*Agent, error) {
 		return nil, fmt.Errorf("project path does not exist: %s", agentConfig.ProjectPath)
 	}
 
-	// Build Aider command with LM Studio (OpenAI-compatible API)
-	cmd := exec.Command("aider",
-		"--model", "openai/qwen2.5-coder-7b-instruct",
-		"--openai-api-base", "http://localhost:1234/v1",
-		"--openai-api-key", "lm-studio",
-		"--no-auto-commits",
-		"--edit-format", "diff",
-	)
-
-	// Set working directory
-	cmd.Dir = agentConfig.ProjectPath
-
-	// Create pipes for stdin/stdout/stderr
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	// Build Aider command from configuration (global aider section + agent overrides)
+	aiderConfig := s.config.AiderConfigFor(agentConfig)
+	if aiderConfig.Model == "" {
+		return nil, fmt.Errorf("no model configured for agent %s", agentConfig.Name)
 	}
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	args := append(aiderConfig.ToArgs(), s.memoryArgs(agentID)...)
+	for _, file := range agentConfig.ReadFiles {
+		args = append(args, "--read", file)
 	}
+	cmd := exec.Command("aider", args...)
 
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
-	}
+	// Set working directory and environment; the agent's own variables win
+	cmd.Dir = agentConfig.ProjectPath
+	cmd.Env = append(os.Environ(), aiderConfig.ToEnv()...)
+	cmd.Env = append(cmd.Env, agentConfig.EnvList()...)
 
-	// Start the process
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("failed to start aider: %w", err)
+	// Start the process, behind named pipes when supervised
+	supervised := s.config.Supervisor.Enabled
+	pipes, err := s.startProcess(cmd, agentID, supervised)
+	if err != nil {
+		return nil, err
 	}
+	stdin, stdout, stderr := pipes.stdin, pipes.stdout, pipes.stderr
 
-	log.Printf("[SPAWNER] Started Aider process (PID: %d) for agent %s", cmd.Process.Pid, agentID)
+	log.Printf("[SPAWNER] Started Aider process (PID: %d) for agent %s (model: %s)", cmd.Process.Pid, agentID, aiderConfig.Model)
 
 	// Create NATS client for this agent
 	clientID := fmt.Sprintf("agent-%s", agentID)
@@ -110,24 +283,41 @@ func (s *Spawner) SpawnAgent(agentConfig AgentConfig) (*Agent, error) {
 		return nil, fmt.Errorf("failed to create NATS client for agent: %w", err)
 	}
 
-	// Create bridge
+	// Create bridge; status updates are persisted once the agent is registered
+	sessionID := uuid.New().String()
 	bridge := NewBridge(agentID, agentClient, stdin, stdout, stderr)
-	if err := bridge.Start(); err != nil {
-		// Kill the process if bridge fails
-		agentClient.Close()
-		cmd.Process.Kill()
-		return nil, fmt.Errorf("failed to start bridge: %w", err)
-	}
+	bridge.SetOperationalDB(s.opDB)
+	bridge.SetSession(sessionID)
+	bridge.SetInterrupt(func() error { return cmd.Process.Signal(os.Interrupt) })
+	bridge.SetLearningDB(s.learning, agentConfig.ProjectPath)
+	s.attachMemory(bridge, agentConfig)
 
 	// Create agent record
 	agent := &Agent{
 		ID:          agentID,
 		ProjectPath: agentConfig.ProjectPath,
-		Model:       "openai/qwen2.5-coder-7b-instruct",
+		Model:       aiderConfig.Model,
+		SessionID:   sessionID,
+		Config:      agentConfig,
 		Bridge:      bridge,
 		Process:     cmd.Process,
 		cmd:         cmd,
 		StartedAt:   time.Now(),
+		exited:      make(chan struct{}),
+		supervised:  supervised,
+	}
+	go agent.wait()
+
+	s.recordSpawn(agent)
+
+	if err := bridge.Start(); err != nil {
+		// Kill the process if bridge fails
+		agentClient.Close()
+		cmd.Process.Kill()
+		if s.opDB != nil {
+			s.opDB.MarkStopped(agentID, fmt.Sprintf("bridge failed: %v", err))
+		}
+		return nil, fmt.Errorf("failed to start bridge: %w", err)
 	}
 
 	// Track agent
@@ -138,6 +328,36 @@ func (s *Spawner) SpawnAgent(agentConfig AgentConfig) (*Agent, error) {
 	return agent, nil
 }
 
+// startProcess starts Aider with its stdio attached either to ordinary pipes
+// or, when supervised, to named pipes that outlive the monitor
+func (s *Spawner) startProcess(cmd *exec.Cmd, agentID string, supervised bool) (*agentIO, error) {
+	if supervised {
+		return startSupervised(cmd, s.supervisorDir(agentID))
+	}
+
+	// Create pipes for stdin/stdout/stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start aider: %w", err)
+	}
+
+	return &agentIO{stdin: stdin, stdout: stdout, stderr: stderr}, nil
+}
+
 // StopAgent gracefully stops an Aider agent
 func (s *Spawner) StopAgent(agentID string) error {
 	s.mu.Lock()
@@ -147,26 +367,23 @@ func (s *Spawner) StopAgent(agentID string) error {
 		return fmt.Errorf("agent %s not found", agentID)
 	}
 	delete(s.agents, agentID)
+	delete(s.restarts, agentID) // stopped on purpose, so no restart is owed
 	s.mu.Unlock()
 
 	log.Printf("[SPAWNER] Stopping agent %s (PID: %d)", agentID, agent.Process.Pid)
+	defer s.recordStopped(agent, "stopped")
+	defer s.removeMemoryFile(agentID)
 
-	// Stop bridge first
+	// Stopping the bridge sends Aider's /quit command
 	agent.Bridge.Stop()
 
-	// Send graceful shutdown via Aider's /quit command
-	agent.Bridge.SendCommand("/quit")
-
 	// Wait for process to exit gracefully (with timeout)
-	done := make(chan error, 1)
-	go func() {
-		done <- agent.cmd.Wait()
-	}()
+	done := agent.exited
 
 	select {
-	case err := <-done:
-		if err != nil {
-			log.Printf("[SPAWNER] Agent %s exited with error: %v", agentID, err)
+	case <-done:
+		if agent.exitedWithFailure() {
+			log.Printf("[SPAWNER] Agent %s exited with error: %v", agentID, agent.exitState)
 		} else {
 			log.Printf("[SPAWNER] Agent %s stopped gracefully", agentID)
 		}
@@ -197,6 +414,14 @@ func (s *Spawner) StopAgent(agentID string) error {
 	}
 }
 
+// AgentTemplate returns a copy of the agents: entry with the given name, as
+// of the last Reconcile
+func (s *Spawner) AgentTemplate(name string) (AgentConfig, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return findAgent(s.templates, name)
+}
+
 // GetAgent retrieves an agent by ID
 func (s *Spawner) GetAgent(agentID string) *Agent {
 	s.mu.RLock()
@@ -277,7 +502,7 @@ func (s *Spawner) checkAgents() {
 
 	for id, agent := range s.agents {
 		// Check if process is still running
-		if !s.isProcessRunning(agent.Process) {
+		if agent.hasExited() || !s.isProcessRunning(agent.Process) {
 			log.Printf("[SPAWNER] Agent %s (PID: %d) has crashed or exited unexpectedly", id, agent.Process.Pid)
 
 			// Stop bridge
@@ -288,8 +513,15 @@ func (s *Spawner) checkAgents() {
 
 			// Publish crash notification
 			s.publishCrash(id, agent)
+			s.recordCrash(agent)
+			s.recordStopped(agent, crashReason(agent))
+
+			// The Sergeant sees the disconnect and re-queues the agent's task
+			s.scheduleRestart(agent)
 		}
 	}
+
+	s.recordHeartbeats()
 }
 
 // isProcessRunning checks if a process is still running
@@ -313,17 +545,39 @@ func (s *Spawner) publishCrash(agentID string, agent *Agent) {
 		Timestamp: time.Now(),
 	}
 
-	// Create temporary client to publish crash message
-	clientID := fmt.Sprintf("spawner-crash-%s", agentID)
+	s.publish(fmt.Sprintf("spawner-crash-%s", agentID), natslib.SubjectSystemBroadcast, crashMsg)
+}
+
+// recordCrash remembers a crash as an episode of the agent's session
+func (s *Spawner) recordCrash(agent *Agent) {
+	if s.learning == nil {
+		return
+	}
+
+	episode := &memory.Episode{
+		SessionID:   agent.SessionID,
+		AgentID:     agent.ID,
+		EventType:   "error",
+		Content:     "Aider process " + crashReason(agent),
+		Outcome:     fmt.Sprintf("uptime %s", time.Since(agent.StartedAt).Round(time.Second)),
+		Importance:  importanceCrash,
+		ProjectPath: agent.ProjectPath,
+	}
+	if err := s.learning.RecordEpisode(episode); err != nil {
+		log.Printf("[SPAWNER] Failed to record crash episode for agent %s: %v", agent.ID, err)
+	}
+}
+
+// publish sends a one-off message using a temporary NATS client
+func (s *Spawner) publish(clientID, subject string, v interface{}) {
 	client, err := natslib.NewClient(s.natsURL, clientID)
 	if err != nil {
-		log.Printf("[SPAWNER] Failed to create NATS client for crash notification: %v", err)
+		log.Printf("[SPAWNER] Failed to create NATS client for %s: %v", subject, err)
 		return
 	}
 	defer client.Close()
 
-	subject := natslib.SubjectSystemBroadcast
-	if err := client.PublishJSON(subject, crashMsg); err != nil {
-		log.Printf("[SPAWNER] Failed to publish crash notification: %v", err)
+	if err := client.PublishJSON(subject, v); err != nil {
+		log.Printf("[SPAWNER] Failed to publish to %s: %v", subject, err)
 	}
 }
@@ -0,0 +1,252 @@
+package aider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/CLIAIRMONITOR/internal/memory"
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+)
+
+// supervisorPipes are the named pipes created per supervised agent, in stdio order
+var supervisorPipes = []string{"stdin", "stdout", "stderr"}
+
+// secretsFile holds the config fields kept out of the operational database,
+// in the agent's supervisor dir
+const secretsFile = "secrets.json"
+
+// reattachPollInterval is how often a reattached agent's PID is checked,
+// since only the original parent can wait on the process
+const reattachPollInterval = time.Second
+
+// agentIO holds the monitor's ends of an agent's stdin/stdout/stderr
+type agentIO struct {
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr io.ReadCloser
+}
+
+// supervisorDir returns the directory holding an agent's named pipes
+func (s *Spawner) supervisorDir(agentID string) string {
+	return filepath.Join(s.config.Supervisor.StateDir, agentID)
+}
+
+// agentSecrets are the parts of an agent's config that are not persisted
+// with it but that a restart after reattaching still needs
+type agentSecrets struct {
+	APIKey string            `json:"api_key,omitempty"`
+	Env    map[string]string `json:"env,omitempty"`
+}
+
+// saveSecrets writes an agent's API key and env next to its pipes, readable
+// only by the monitor's user
+func saveSecrets(dir string, config AgentConfig) error {
+	data, err := json.Marshal(agentSecrets{APIKey: config.APIKey, Env: config.Env})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, secretsFile), data, 0600)
+}
+
+// loadSecrets restores the API key and env saved by saveSecrets
+func loadSecrets(dir string, config *AgentConfig) error {
+	data, err := os.ReadFile(filepath.Join(dir, secretsFile))
+	if err != nil {
+		return err
+	}
+	var secrets agentSecrets
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return err
+	}
+	config.APIKey, config.Env = secrets.APIKey, secrets.Env
+	return nil
+}
+
+// pollExit watches a reattached process until its PID disappears
+func (a *Agent) pollExit() {
+	ticker := time.NewTicker(reattachPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if a.Process.Signal(syscall.Signal(0)) != nil {
+			close(a.exited)
+			return
+		}
+	}
+}
+
+// Reattach rebuilds bridges for supervised agents that survived a monitor
+// restart, resuming their sessions. Agents whose process is gone are marked
+// stopped. It returns the number of agents reattached.
+func (s *Spawner) Reattach() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.opDB == nil {
+		return 0, fmt.Errorf("operational database is required to reattach agents")
+	}
+
+	active := true
+	states, err := s.opDB.ListAgents(memory.AgentFilter{AgentType: "aider", Active: &active})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list agents: %w", err)
+	}
+
+	count := 0
+	for _, state := range states {
+		if state.Metadata["supervised"] != "true" || state.PID == nil {
+			continue
+		}
+		if _, tracked := s.agents[state.AgentID]; tracked {
+			continue
+		}
+
+		if !isAiderProcess(*state.PID) {
+			log.Printf("[SPAWNER] Agent %s (PID: %d) did not survive the restart", state.AgentID, *state.PID)
+			s.opDB.MarkStopped(state.AgentID, "process gone after monitor restart")
+			continue
+		}
+
+		if err := s.reattachAgent(state); err != nil {
+			log.Printf("[SPAWNER] Failed to reattach agent %s: %v", state.AgentID, err)
+			continue
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// reattachAgent reconnects to a running supervised Aider process. Caller must hold s.mu.
+func (s *Spawner) reattachAgent(state *memory.AgentState) error {
+	var agentConfig AgentConfig
+	if raw := state.Metadata["config"]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &agentConfig); err != nil {
+			return fmt.Errorf("failed to decode agent config: %w", err)
+		}
+	}
+	if agentConfig.ProjectPath == "" {
+		agentConfig.ProjectPath = state.ProjectPath
+	}
+
+	dir := state.Metadata["supervisor_dir"]
+	if dir == "" {
+		dir = s.supervisorDir(state.AgentID)
+	}
+	if err := loadSecrets(dir, &agentConfig); err != nil && !os.IsNotExist(err) {
+		log.Printf("[SPAWNER] Failed to load API key and env for agent %s: %v", state.AgentID, err)
+	}
+
+	pipes, err := openSupervised(dir)
+	if err != nil {
+		return err
+	}
+
+	process, err := os.FindProcess(*state.PID)
+	if err != nil {
+		pipes.stdin.Close()
+		pipes.stdout.Close()
+		pipes.stderr.Close()
+		return fmt.Errorf("failed to find process: %w", err)
+	}
+
+	clientID := fmt.Sprintf("agent-%s", state.AgentID)
+	agentClient, err := natslib.NewClient(s.natsURL, clientID)
+	if err != nil {
+		pipes.stdin.Close()
+		pipes.stdout.Close()
+		pipes.stderr.Close()
+		return fmt.Errorf("failed to create NATS client for agent: %w", err)
+	}
+
+	bridge := NewBridge(state.AgentID, agentClient, pipes.stdin, pipes.stdout, pipes.stderr)
+	bridge.SetOperationalDB(s.opDB)
+	bridge.SetSession(state.SessionID)
+	bridge.SetInterrupt(func() error { return process.Signal(os.Interrupt) })
+	bridge.SetLearningDB(s.learning, agentConfig.ProjectPath)
+	s.attachMemory(bridge, agentConfig)
+
+	agent := &Agent{
+		ID:          state.AgentID,
+		ProjectPath: agentConfig.ProjectPath,
+		Model:       state.Model,
+		SessionID:   state.SessionID,
+		Config:      agentConfig,
+		Bridge:      bridge,
+		Process:     process,
+		StartedAt:   state.CreatedAt,
+		exited:      make(chan struct{}),
+		supervised:  true,
+	}
+	go agent.pollExit()
+
+	// Pick up the queue the previous monitor left, including a prompt Aider
+	// may still be working on
+	resumed := false
+	if raw := state.Metadata["prompt_queue"]; raw != "" {
+		var queue natslib.PromptQueueMessage
+		if err := json.Unmarshal([]byte(raw), &queue); err == nil {
+			resumed = bridge.Resume(queue)
+		}
+	}
+
+	if err := bridge.Start(); err != nil {
+		agentClient.Close()
+		return fmt.Errorf("failed to start bridge: %w", err)
+	}
+
+	// The status stays unknown until the parser sees Aider's prompt. An idle
+	// Aider printed it before the restart and reprints it on an empty line;
+	// a busy one prints it when its turn ends.
+	if !resumed {
+		if err := bridge.writeInput(""); err != nil {
+			log.Printf("[SPAWNER] Failed to prompt reattached agent %s: %v", agent.ID, err)
+		}
+	}
+
+	s.agents[agent.ID] = agent
+	if name := state.Metadata["managed"]; name != "" {
+		s.managed[name] = agent.ID
+	}
+
+	log.Printf("[SPAWNER] Reattached agent %s (PID: %d, session: %s)", agent.ID, process.Pid, agent.SessionID)
+	return nil
+}
+
+// DetachAll disconnects from all agents without stopping them. Supervised
+// agents keep running and are picked up by Reattach on the next start;
+// unsupervised agents cannot outlive the monitor and are stopped.
+func (s *Spawner) DetachAll() {
+	log.Println("[SPAWNER] Detaching from all agents...")
+
+	close(s.stopCh)
+
+	s.mu.Lock()
+	var unsupervised []string
+	for id, agent := range s.agents {
+		if !agent.supervised {
+			unsupervised = append(unsupervised, id)
+			continue
+		}
+		agent.Bridge.Detach()
+		delete(s.agents, id)
+		log.Printf("[SPAWNER] Detached from agent %s (PID: %d)", id, agent.Process.Pid)
+	}
+	s.mu.Unlock()
+
+	for _, id := range unsupervised {
+		if err := s.StopAgent(id); err != nil {
+			log.Printf("[SPAWNER] Error stopping agent %s: %v", id, err)
+		}
+	}
+
+	s.wg.Wait()
+
+	log.Println("[SPAWNER] All agents detached")
+}
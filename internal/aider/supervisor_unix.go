@@ -0,0 +1,99 @@
+//go:build !windows
+
+package aider
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// startSupervised starts cmd with its stdio attached to named pipes in dir and
+// in its own session, so it outlives the monitor process.
+//
+// Aider gets read-write handles on every pipe: it holds a writer on its own
+// stdin, so it never sees EOF while the monitor is gone, and a reader on its
+// stdout/stderr, so it blocks instead of dying of SIGPIPE until reattached.
+func startSupervised(cmd *exec.Cmd, dir string) (*agentIO, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create supervisor dir: %w", err)
+	}
+
+	childFiles := make([]*os.File, 0, len(supervisorPipes))
+	defer func() {
+		for _, f := range childFiles {
+			f.Close()
+		}
+	}()
+
+	for _, name := range supervisorPipes {
+		path := filepath.Join(dir, name)
+		os.Remove(path)
+		if err := syscall.Mkfifo(path, 0600); err != nil {
+			return nil, fmt.Errorf("failed to create %s pipe: %w", name, err)
+		}
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s pipe: %w", name, err)
+		}
+		childFiles = append(childFiles, f)
+	}
+
+	cmd.Stdin = childFiles[0]
+	cmd.Stdout = childFiles[1]
+	cmd.Stderr = childFiles[2]
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start aider: %w", err)
+	}
+
+	pipes, err := openSupervised(dir)
+	if err != nil {
+		cmd.Process.Kill()
+		return nil, err
+	}
+	return pipes, nil
+}
+
+// openSupervised opens the monitor's ends of an agent's named pipes.
+// Opening is non-blocking so a pipe with nobody on the other end fails fast.
+func openSupervised(dir string) (*agentIO, error) {
+	stdin, err := os.OpenFile(filepath.Join(dir, "stdin"), os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdin pipe: %w", err)
+	}
+
+	stdout, err := os.OpenFile(filepath.Join(dir, "stdout"), os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		stdin.Close()
+		return nil, fmt.Errorf("failed to open stdout pipe: %w", err)
+	}
+
+	stderr, err := os.OpenFile(filepath.Join(dir, "stderr"), os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		stdin.Close()
+		stdout.Close()
+		return nil, fmt.Errorf("failed to open stderr pipe: %w", err)
+	}
+
+	return &agentIO{stdin: stdin, stdout: stdout, stderr: stderr}, nil
+}
+
+// isAiderProcess reports whether pid is alive and, where /proc is available,
+// still running aider rather than an unrelated process that reused the PID
+func isAiderProcess(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil || process.Signal(syscall.Signal(0)) != nil {
+		return false
+	}
+
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	return strings.Contains(string(cmdline), "aider")
+}
@@ -0,0 +1,94 @@
+//go:build !windows
+
+package aider
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSupervisedProcessSurvivesDetach(t *testing.T) {
+	if _, err := exec.LookPath("cat"); err != nil {
+		t.Skip("cat not available")
+	}
+
+	dir := filepath.Join(t.TempDir(), "agent")
+	cmd := exec.Command("cat")
+	pipes, err := startSupervised(cmd, dir)
+	if err != nil {
+		t.Fatalf("startSupervised failed: %v", err)
+	}
+	defer cmd.Process.Kill()
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	// Drop the monitor's ends, as a monitor restart would
+	pipes.stdin.Close()
+	pipes.stdout.Close()
+	pipes.stderr.Close()
+
+	select {
+	case <-exited:
+		t.Fatal("process exited after the monitor detached")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	pipes, err = openSupervised(dir)
+	if err != nil {
+		t.Fatalf("openSupervised failed: %v", err)
+	}
+	defer pipes.stdout.Close()
+
+	fmt.Fprintln(pipes.stdin, "still here")
+	pipes.stdin.Close()
+
+	line := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(pipes.stdout)
+		if scanner.Scan() {
+			line <- scanner.Text()
+		}
+	}()
+
+	select {
+	case got := <-line:
+		if got != "still here" {
+			t.Errorf("Expected echoed line, got %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no output after reattaching")
+	}
+}
+
+func TestSecretsSurviveReattach(t *testing.T) {
+	dir := t.TempDir()
+	config := AgentConfig{Name: "a", APIKey: "sk-test", Env: map[string]string{"TOKEN": "t"}}
+	if err := saveSecrets(dir, config); err != nil {
+		t.Fatalf("saveSecrets failed: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, secretsFile))
+	if err != nil {
+		t.Fatalf("Secrets file missing: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("Expected mode 0600, got %o", mode)
+	}
+
+	restored := config.persisted()
+	if err := loadSecrets(dir, &restored); err != nil {
+		t.Fatalf("loadSecrets failed: %v", err)
+	}
+	if !sameSpec(restored, config) {
+		t.Errorf("Expected %+v, got %+v", config, restored)
+	}
+}
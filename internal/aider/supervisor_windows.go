@@ -0,0 +1,25 @@
+//go:build windows
+
+package aider
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+var errSupervisorUnsupported = fmt.Errorf("supervised agents are not supported on Windows")
+
+// startSupervised is unavailable on Windows, which has no named FIFOs
+func startSupervised(cmd *exec.Cmd, dir string) (*agentIO, error) {
+	return nil, errSupervisorUnsupported
+}
+
+// openSupervised is unavailable on Windows, which has no named FIFOs
+func openSupervised(dir string) (*agentIO, error) {
+	return nil, errSupervisorUnsupported
+}
+
+// isAiderProcess always reports false on Windows; agents are never reattached
+func isAiderProcess(pid int) bool {
+	return false
+}
@@ -0,0 +1,127 @@
+package aider
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/CLIAIRMONITOR/internal/memory"
+)
+
+// transcriptFlushInterval is how often buffered transcript lines are written
+const transcriptFlushInterval = time.Second
+
+// transcriptBatchSize triggers an early flush when this many lines are buffered
+const transcriptBatchSize = 200
+
+// transcriptRecorder buffers a session's I/O and writes it to the
+// OperationalDB in batches, so chatty output does not hit SQLite per line
+type transcriptRecorder struct {
+	opDB      memory.OperationalDB
+	agentID   string
+	sessionID string
+
+	mu      sync.Mutex
+	pending []*memory.TranscriptLine
+	started bool
+
+	flushCh chan struct{}
+	stopCh  chan struct{}
+	done    chan struct{}
+}
+
+func newTranscriptRecorder(opDB memory.OperationalDB, agentID, sessionID string) *transcriptRecorder {
+	return &transcriptRecorder{
+		opDB:      opDB,
+		agentID:   agentID,
+		sessionID: sessionID,
+		flushCh:   make(chan struct{}, 1),
+		stopCh:    make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// record buffers one line; a nil recorder records nothing
+func (r *transcriptRecorder) record(stream, content string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	r.pending = append(r.pending, &memory.TranscriptLine{
+		SessionID: r.sessionID,
+		AgentID:   r.agentID,
+		Stream:    stream,
+		Content:   content,
+		Timestamp: time.Now(),
+	})
+	full := len(r.pending) >= transcriptBatchSize
+	r.mu.Unlock()
+
+	if full {
+		select {
+		case r.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// start begins flushing in the background; a nil recorder does nothing
+func (r *transcriptRecorder) start() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.started = true
+	r.mu.Unlock()
+	go r.run()
+}
+
+// run flushes periodically until closed
+func (r *transcriptRecorder) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(transcriptFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.flush()
+		case <-r.flushCh:
+			r.flush()
+		case <-r.stopCh:
+			r.flush()
+			return
+		}
+	}
+}
+
+// flush writes buffered lines
+func (r *transcriptRecorder) flush() {
+	r.mu.Lock()
+	lines := r.pending
+	r.pending = nil
+	r.mu.Unlock()
+
+	if err := r.opDB.AppendTranscript(lines); err != nil {
+		log.Printf("[BRIDGE] Failed to record %d transcript lines for agent %s: %v", len(lines), r.agentID, err)
+	}
+}
+
+// close stops the recorder after a final flush
+func (r *transcriptRecorder) close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	started := r.started
+	r.mu.Unlock()
+
+	if !started {
+		r.flush()
+		return
+	}
+	close(r.stopCh)
+	<-r.done
+}
@@ -0,0 +1,290 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/CLIAIRMONITOR/internal/aider"
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+)
+
+// Agents controls the running Aider agents; *aider.Spawner implements it
+type Agents interface {
+	ListAgents() []*aider.Agent
+	GetAgent(agentID string) *aider.Agent
+	AgentTemplate(name string) (aider.AgentConfig, bool)
+	SpawnAgent(config aider.AgentConfig) (*aider.Agent, error)
+	StopAgent(agentID string) error
+}
+
+// SetAgents enables the routes that control running agents. reload re-reads
+// the agents section of the config and reconciles the agents with it.
+func (s *Server) SetAgents(agents Agents, reload func() (aider.ReconcileResult, error)) {
+	s.agents = agents
+	s.reload = reload
+}
+
+// RunningAgent is an agent the spawner is running, with its live status
+type RunningAgent struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	Project   string    `json:"project"`
+	Model     string    `json:"model"`
+	Status    string    `json:"status"`
+	Task      string    `json:"task"`
+	StartedAt time.Time `json:"started_at"`
+	Uptime    string    `json:"uptime"`
+}
+
+func runningAgent(agent *aider.Agent) RunningAgent {
+	status, task := agent.Bridge.GetStatus()
+	return RunningAgent{
+		ID:        agent.ID,
+		Name:      agent.Config.Name,
+		Role:      agent.Config.Role,
+		Project:   agent.ProjectPath,
+		Model:     agent.Model,
+		Status:    status,
+		Task:      task,
+		StartedAt: agent.StartedAt,
+		Uptime:    time.Since(agent.StartedAt).Round(time.Second).String(),
+	}
+}
+
+// SpawnRequest is the body of a spawn: an agent config, optionally starting
+// from a named entry of the agents section
+type SpawnRequest struct {
+	Template string `json:"template,omitempty"`
+	*aider.AgentConfig
+}
+
+var errNoAgentControl = &Error{Status: http.StatusServiceUnavailable, Message: "agent control is not available"}
+
+// runningAgentFor looks up the agent named by the id path parameter,
+// replying with an error when there is none
+func (s *Server) runningAgentFor(w http.ResponseWriter, r *http.Request) *aider.Agent {
+	if s.agents == nil {
+		writeError(w, errNoAgentControl)
+		return nil
+	}
+	agentID := r.PathValue("id")
+	agent := s.agents.GetAgent(agentID)
+	if agent == nil {
+		writeError(w, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("agent %s is not running", agentID)})
+		return nil
+	}
+	return agent
+}
+
+func (s *Server) listRunningAgents(w http.ResponseWriter, r *http.Request) {
+	q := newQuery(r)
+	p := q.page()
+	if q.err != nil {
+		writeError(w, q.err)
+		return
+	}
+	if s.agents == nil {
+		writeError(w, errNoAgentControl)
+		return
+	}
+
+	agents := s.agents.ListAgents()
+	sort.Slice(agents, func(i, j int) bool { return agents[i].StartedAt.After(agents[j].StartedAt) })
+	agents = agents[min(p.offset, len(agents)):]
+	agents = agents[:min(p.fetch(), len(agents))]
+
+	running := make([]RunningAgent, 0, len(agents))
+	for _, agent := range agents {
+		running = append(running, runningAgent(agent))
+	}
+	writePage(w, p, running, nil)
+}
+
+func (s *Server) spawnAgent(w http.ResponseWriter, r *http.Request) {
+	q := newQuery(r)
+	template, project := q.str("template"), q.str("project")
+	if s.agents == nil {
+		writeError(w, errNoAgentControl)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		writeError(w, badRequest("failed to read body: %v", err))
+		return
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		body = []byte("{}")
+	}
+
+	// The template the body names comes first, then the body overrides it
+	var named struct {
+		Template string `json:"template"`
+	}
+	if err := json.Unmarshal(body, &named); err != nil {
+		writeError(w, badRequest("invalid JSON body: %v", err))
+		return
+	}
+	if named.Template != "" {
+		template = named.Template
+	}
+
+	config := aider.AgentConfig{Name: "Qwen-Agent", Role: "developer"}
+	if template != "" {
+		var ok bool
+		if config, ok = s.agents.AgentTemplate(template); !ok {
+			writeError(w, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("agent template %s not found", template)})
+			return
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&SpawnRequest{AgentConfig: &config}); err != nil {
+		writeError(w, badRequest("invalid JSON body: %v", err))
+		return
+	}
+	if project != "" {
+		config.ProjectPath = project
+	}
+	if err := required("project_path", config.ProjectPath); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := config.Validate(); err != nil {
+		writeError(w, badRequest("%v", err))
+		return
+	}
+
+	agent, err := s.agents.SpawnAgent(config)
+	if errors.Is(err, aider.ErrAgentLimit) {
+		writeError(w, &Error{Status: http.StatusConflict, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeCreated(w, r, agent.ID, runningAgent(agent))
+}
+
+func (s *Server) stopAgent(w http.ResponseWriter, r *http.Request) {
+	if s.agents == nil {
+		writeError(w, errNoAgentControl)
+		return
+	}
+	if err := s.agents.StopAgent(r.PathValue("id")); err != nil {
+		writeError(w, &Error{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) reloadAgents(w http.ResponseWriter, r *http.Request) {
+	if s.reload == nil {
+		writeError(w, errNoAgentControl)
+		return
+	}
+	result, err := s.reload()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// decodePrompt reads a prompt request body
+func decodePrompt(w http.ResponseWriter, r *http.Request) (natslib.PromptRequest, error) {
+	var req natslib.PromptRequest
+	if err := decode(w, r, &req); err != nil {
+		return req, err
+	}
+	if err := required("text", req.Text); err != nil {
+		return req, err
+	}
+	if req.TimeoutSeconds < 0 {
+		return req, badRequest("timeout_seconds must not be negative")
+	}
+	return req, nil
+}
+
+func (s *Server) promptAgent(w http.ResponseWriter, r *http.Request) {
+	agent := s.runningAgentFor(w, r)
+	if agent == nil {
+		return
+	}
+	req, err := decodePrompt(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response, err := agent.Bridge.Prompt(req.Text, aider.PromptOptionsFrom(req))
+	if err != nil {
+		writeError(w, &Error{Status: http.StatusConflict, Message: err.Error()})
+		return
+	}
+	status := http.StatusOK
+	if response.TimedOut {
+		status = http.StatusGatewayTimeout
+	}
+	writeJSON(w, status, response)
+}
+
+func (s *Server) getQueue(w http.ResponseWriter, r *http.Request) {
+	agent := s.runningAgentFor(w, r)
+	if agent == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, agent.Bridge.Queue())
+}
+
+func (s *Server) queuePrompt(w http.ResponseWriter, r *http.Request) {
+	agent := s.runningAgentFor(w, r)
+	if agent == nil {
+		return
+	}
+	req, err := decodePrompt(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	queued, err := agent.Bridge.Enqueue(req.Text, aider.PromptOptionsFrom(req))
+	if err != nil {
+		writeError(w, &Error{Status: http.StatusConflict, Message: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, queued)
+}
+
+func (s *Server) cancelPrompt(w http.ResponseWriter, r *http.Request) {
+	agent := s.runningAgentFor(w, r)
+	if agent == nil {
+		return
+	}
+	if err := agent.Bridge.Cancel(r.PathValue("prompt")); err != nil {
+		writeError(w, &Error{Status: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) interruptAgent(w http.ResponseWriter, r *http.Request) {
+	agent := s.runningAgentFor(w, r)
+	if agent == nil {
+		return
+	}
+	if err := agent.Bridge.Interrupt(); err != nil {
+		writeError(w, &Error{Status: http.StatusConflict, Message: err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
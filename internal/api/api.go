@@ -0,0 +1,289 @@
+// Package api serves the typed JSON REST API over the operational and
+// learning databases: agents, tasks, sessions, messages and metrics, and
+// episodes, knowledge and procedures; plus control of the running agents and
+// memory search. Every route is declared once in the route table, which
+// drives both the mux and the OpenAPI document.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CLIAIRMONITOR/internal/aider"
+	"github.com/CLIAIRMONITOR/internal/memory"
+)
+
+// Prefix is where the API is mounted
+const Prefix = "/api/v1"
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+	maxBodyBytes = 1 << 20
+)
+
+// Server handles API requests
+type Server struct {
+	ops      memory.OperationalDB
+	learning memory.LearningDB
+	agents   Agents // nil until SetAgents
+	reload   func() (aider.ReconcileResult, error)
+	mux      *http.ServeMux
+}
+
+// New creates an API server over both databases
+func New(ops memory.OperationalDB, learning memory.LearningDB) *Server {
+	s := &Server{ops: ops, learning: learning, mux: http.NewServeMux()}
+
+	// paths matches a path regardless of method, to tell 405 from 404
+	paths := http.NewServeMux()
+	allowed := map[string][]string{}
+	for _, rt := range s.routes() {
+		pattern := Prefix + rt.path
+		s.mux.HandleFunc(rt.method+" "+pattern, rt.handler)
+		if allowed[pattern] == nil {
+			paths.HandleFunc(pattern, http.NotFound)
+		}
+		allowed[pattern] = append(allowed[pattern], rt.method)
+	}
+
+	s.mux.HandleFunc(Prefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := paths.Handler(r); pattern != "" {
+			w.Header().Set("Allow", strings.Join(allowed[pattern], ", "))
+			writeError(w, &Error{Status: http.StatusMethodNotAllowed, Message: fmt.Sprintf("method %s not allowed on %s", r.Method, r.URL.Path)})
+			return
+		}
+		writeError(w, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("no route for %s %s", r.Method, r.URL.Path)})
+	})
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// route is one API operation
+type route struct {
+	method       string
+	path         string // below Prefix; {id} is a path parameter
+	tag          string
+	summary      string
+	params       []param     // query parameters, besides limit and offset on lists
+	list         bool        // paginated; reply is the item type
+	body         interface{} // request body type, nil for none
+	bodyOptional bool        // the body may be left out
+	reply        interface{} // response body type, nil for none
+	status       int
+	handler      http.HandlerFunc
+}
+
+// param is a query parameter; kind is string, integer, number, boolean or date-time
+type param struct {
+	name string
+	kind string
+	doc  string
+}
+
+// ================================================
+// Responses
+// ================================================
+
+// Error is the body of every error response
+type Error struct {
+	Status  int    `json:"status"`
+	Message string `json:"error"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+func badRequest(format string, args ...interface{}) *Error {
+	return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+// Page is the body of every list response
+type Page[T any] struct {
+	Items      []T  `json:"items"`
+	Limit      int  `json:"limit"`
+	Offset     int  `json:"offset"`
+	NextOffset *int `json:"next_offset,omitempty"` // set when more items follow
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError replies with err's status: its own for *Error, 404 for
+// memory.ErrNotFound, otherwise 500
+func writeError(w http.ResponseWriter, err error) {
+	var apiErr *Error
+	switch {
+	case errors.As(err, &apiErr):
+	case errors.Is(err, memory.ErrNotFound):
+		apiErr = &Error{Status: http.StatusNotFound, Message: err.Error()}
+	default:
+		log.Printf("[API] %v", err)
+		apiErr = &Error{Status: http.StatusInternalServerError, Message: err.Error()}
+	}
+	writeJSON(w, apiErr.Status, apiErr)
+}
+
+// writeCreated replies 201 with v and its location
+func writeCreated(w http.ResponseWriter, r *http.Request, id string, v interface{}) {
+	w.Header().Set("Location", r.URL.Path+"/"+url.PathEscape(id))
+	writeJSON(w, http.StatusCreated, v)
+}
+
+// writePage replies with one page of items, fetched with a limit one past
+// the page size so a following page can be detected
+func writePage[T any](w http.ResponseWriter, p page, items []T, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	result := Page[T]{Items: items, Limit: p.limit, Offset: p.offset}
+	if len(items) > p.limit {
+		result.Items = items[:p.limit]
+		next := p.offset + p.limit
+		result.NextOffset = &next
+	}
+	if result.Items == nil {
+		result.Items = []T{}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// ================================================
+// Requests
+// ================================================
+
+// decode reads a JSON body into v, rejecting unknown fields
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return badRequest("invalid JSON body: %v", err)
+	}
+	return nil
+}
+
+// query reads typed query parameters, keeping the first parse error
+type query struct {
+	values url.Values
+	err    error
+}
+
+func newQuery(r *http.Request) *query {
+	return &query{values: r.URL.Query()}
+}
+
+func (q *query) str(name string) string {
+	return q.values.Get(name)
+}
+
+func (q *query) int(name string) int {
+	v := q.values.Get(name)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		q.fail("%s must be a non-negative integer", name)
+	}
+	return n
+}
+
+func (q *query) intPtr(name string) *int {
+	if q.values.Get(name) == "" {
+		return nil
+	}
+	n := q.int(name)
+	return &n
+}
+
+func (q *query) float(name string) float64 {
+	v := q.values.Get(name)
+	if v == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		q.fail("%s must be a number", name)
+	}
+	return f
+}
+
+func (q *query) bool(name string) *bool {
+	v := q.values.Get(name)
+	if v == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		q.fail("%s must be true or false", name)
+	}
+	return &b
+}
+
+func (q *query) time(name string) time.Time {
+	v := q.values.Get(name)
+	if v == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		q.fail("%s must be an RFC 3339 time", name)
+	}
+	return t
+}
+
+func (q *query) fail(format string, args ...interface{}) {
+	if q.err == nil {
+		q.err = badRequest(format, args...)
+	}
+}
+
+// page is a requested window of a list
+type page struct {
+	limit  int
+	offset int
+}
+
+// page reads limit (default 50, at most 500) and offset
+func (q *query) page() page {
+	p := page{limit: q.int("limit"), offset: q.int("offset")}
+	if p.limit == 0 {
+		p.limit = defaultLimit
+	}
+	p.limit = min(p.limit, maxLimit)
+	return p
+}
+
+// fetch is the limit to pass to the database: one more than the page
+func (p page) fetch() int {
+	return p.limit + 1
+}
+
+// pageParams are the query parameters every list takes
+var pageParams = []param{
+	{"limit", "integer", fmt.Sprintf("Page size, default %d, at most %d", defaultLimit, maxLimit)},
+	{"offset", "integer", "Items to skip"},
+}
+
+// required returns a 400 naming the first empty field
+func required(fields ...string) error {
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i+1] == "" {
+			return badRequest("%s is required", fields[i])
+		}
+	}
+	return nil
+}
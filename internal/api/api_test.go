@@ -0,0 +1,333 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CLIAIRMONITOR/internal/aider"
+	"github.com/CLIAIRMONITOR/internal/memory"
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+)
+
+func setupTestServer(t *testing.T) (*httptest.Server, *memory.SQLiteOperationalDB, *memory.SQLiteLearningDB) {
+	t.Helper()
+	dir := t.TempDir()
+
+	ops, err := memory.NewSQLiteOperationalDB(filepath.Join(dir, "operational.db"))
+	if err != nil {
+		t.Fatalf("Failed to create operational DB: %v", err)
+	}
+	t.Cleanup(func() { ops.Close() })
+	learning, err := memory.NewSQLiteLearningDB(filepath.Join(dir, "learning.db"))
+	if err != nil {
+		t.Fatalf("Failed to create learning DB: %v", err)
+	}
+	t.Cleanup(func() { learning.Close() })
+
+	server := httptest.NewServer(New(ops, learning))
+	t.Cleanup(server.Close)
+	return server, ops, learning
+}
+
+// call sends a request with an optional JSON body and decodes the reply into out
+func call(t *testing.T, server *httptest.Server, method, path string, body interface{}, out interface{}) int {
+	t.Helper()
+	var reader *bytes.Reader
+	switch b := body.(type) {
+	case nil:
+		reader = bytes.NewReader(nil)
+	case string:
+		reader = bytes.NewReader([]byte(b))
+	default:
+		data, _ := json.Marshal(b)
+		reader = bytes.NewReader(data)
+	}
+
+	req, _ := http.NewRequest(method, server.URL+Prefix+path, reader)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: failed to decode reply: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestTaskCRUD(t *testing.T) {
+	server, _, _ := setupTestServer(t)
+
+	var task memory.Task
+	title := `Fix "quoted" \ title`
+	if status := call(t, server, "POST", "/tasks", map[string]interface{}{"title": title, "priority": 3}, &task); status != http.StatusCreated {
+		t.Fatalf("Create returned %d", status)
+	}
+	if task.ID == "" || task.Title != title || task.Status != memory.TaskStatusPending {
+		t.Fatalf("Unexpected task: %+v", task)
+	}
+
+	var got memory.Task
+	if status := call(t, server, "GET", "/tasks/"+task.ID, nil, &got); status != http.StatusOK || got.Title != title {
+		t.Fatalf("Get returned %d: %+v", status, got)
+	}
+
+	var updated memory.Task
+	status := call(t, server, "PATCH", "/tasks/"+task.ID, map[string]interface{}{"status": "completed", "summary": "done"}, &updated)
+	if status != http.StatusOK || updated.Status != memory.TaskStatusCompleted || updated.CompletedAt == nil || updated.Title != title || updated.Priority != 3 {
+		t.Fatalf("Patch returned %d: %+v", status, updated)
+	}
+
+	if status := call(t, server, "DELETE", "/tasks/"+task.ID, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("Delete returned %d", status)
+	}
+	var apiErr Error
+	if status := call(t, server, "GET", "/tasks/"+task.ID, nil, &apiErr); status != http.StatusNotFound || apiErr.Status != http.StatusNotFound || apiErr.Message == "" {
+		t.Errorf("Expected a 404 error body, got %d: %+v", status, apiErr)
+	}
+}
+
+func TestErrorBodies(t *testing.T) {
+	server, _, _ := setupTestServer(t)
+
+	cases := []struct {
+		method, path string
+		body         interface{}
+		status       int
+		contains     string
+	}{
+		{"POST", "/tasks", map[string]string{"description": "no title"}, http.StatusBadRequest, "title is required"},
+		{"POST", "/tasks", map[string]string{"title": "t", "status": "nope"}, http.StatusBadRequest, "unknown task status"},
+		{"POST", "/tasks", map[string]string{"title": "t", "colour": "red"}, http.StatusBadRequest, "unknown field"},
+		{"POST", "/tasks", `{"title": `, http.StatusBadRequest, "invalid JSON"},
+		{"POST", "/tasks", map[string]string{"id": "mine", "title": "t"}, http.StatusBadRequest, "assigned by the server"},
+		{"GET", "/tasks?limit=-1", nil, http.StatusBadRequest, "limit"},
+		{"GET", "/messages?since=yesterday", nil, http.StatusBadRequest, "RFC 3339"},
+		{"GET", "/knowledge/missing", nil, http.StatusNotFound, "not found"},
+		{"GET", "/knowledge/search?q=busy&limit=abc", nil, http.StatusBadRequest, "limit"},
+		{"GET", "/knowledge/search", nil, http.StatusBadRequest, "q is required"},
+		{"GET", "/episodes/search?q=busy&min_score=2", nil, http.StatusBadRequest, "min_score"},
+		{"GET", "/sessions/s1/transcript?after=x", nil, http.StatusBadRequest, "after"},
+		{"GET", "/agents/running", nil, http.StatusServiceUnavailable, "not available"},
+		{"PUT", "/tasks/x", nil, http.StatusMethodNotAllowed, "not allowed"},
+		{"GET", "/nothing", nil, http.StatusNotFound, "no route"},
+	}
+	for _, c := range cases {
+		var apiErr Error
+		status := call(t, server, c.method, c.path, c.body, &apiErr)
+		if status != c.status || apiErr.Status != c.status || !strings.Contains(apiErr.Message, c.contains) {
+			t.Errorf("%s %s: got %d %+v, want %d containing %q", c.method, c.path, status, apiErr, c.status, c.contains)
+		}
+	}
+}
+
+func TestPagination(t *testing.T) {
+	server, ops, _ := setupTestServer(t)
+	for i := 0; i < 5; i++ {
+		ops.SendMessage(&memory.Message{FromAgent: "sgt", ToAgent: "a1", MessageType: "task", Content: fmt.Sprint(i)})
+	}
+
+	var seen []string
+	offset := 0
+	for pages := 0; ; pages++ {
+		var page Page[memory.Message]
+		if status := call(t, server, "GET", fmt.Sprintf("/messages?to=a1&limit=2&offset=%d", offset), nil, &page); status != http.StatusOK {
+			t.Fatalf("List returned %d", status)
+		}
+		for _, msg := range page.Items {
+			seen = append(seen, msg.Content)
+		}
+		if page.NextOffset == nil {
+			break
+		}
+		if pages > 5 {
+			t.Fatal("Pagination did not end")
+		}
+		offset = *page.NextOffset
+	}
+	if strings.Join(seen, ",") != "0,1,2,3,4" {
+		t.Errorf("Expected every message once in order, got %v", seen)
+	}
+}
+
+func TestKnowledgeCRUD(t *testing.T) {
+	server, _, learning := setupTestServer(t)
+
+	var k memory.Knowledge
+	body := map[string]interface{}{"category": "error_solution", "title": "Busy", "content": "retry on SQLITE_BUSY", "tags": []string{"sqlite"}, "embedding": []float32{1, 0}}
+	if status := call(t, server, "POST", "/knowledge", body, &k); status != http.StatusCreated {
+		t.Fatalf("Create returned %d", status)
+	}
+	if len(k.Embedding) != 0 {
+		t.Errorf("Expected embeddings left out of the reply")
+	}
+
+	var page Page[memory.Knowledge]
+	call(t, server, "GET", "/knowledge?tag=sqlite&embeddings=true", nil, &page)
+	if len(page.Items) != 1 || len(page.Items[0].Embedding) != 2 {
+		t.Fatalf("Expected the item with its embedding, got %+v", page.Items)
+	}
+	call(t, server, "GET", "/knowledge?tag=other", nil, &page)
+	if len(page.Items) != 0 {
+		t.Errorf("Tag filter matched %+v", page.Items)
+	}
+
+	// Changing only the title keeps the embedding; changing content drops it
+	call(t, server, "PATCH", "/knowledge/"+k.ID, map[string]string{"title": "SQLite busy"}, nil)
+	if stored, _ := learning.GetKnowledge(k.ID); stored.Title != "SQLite busy" || len(stored.Embedding) != 2 {
+		t.Errorf("Unexpected item after title change: %+v", stored)
+	}
+	call(t, server, "PATCH", "/knowledge/"+k.ID, map[string]string{"content": "set busy_timeout"}, nil)
+	if stored, _ := learning.GetKnowledge(k.ID); stored.Content != "set busy_timeout" || len(stored.Embedding) != 0 {
+		t.Errorf("Unexpected item after content change: %+v", stored)
+	}
+
+	if status := call(t, server, "DELETE", "/knowledge/"+k.ID, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("Delete returned %d", status)
+	}
+	if status := call(t, server, "DELETE", "/knowledge/"+k.ID, nil, nil); status != http.StatusNotFound {
+		t.Errorf("Second delete returned %d", status)
+	}
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	server, _, _ := setupTestServer(t)
+
+	var doc struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if status := call(t, server, "GET", "/openapi.json", nil, &doc); status != http.StatusOK {
+		t.Fatalf("OpenAPI returned %d", status)
+	}
+
+	for _, rt := range (&Server{}).routes() {
+		if _, ok := doc.Paths[Prefix+rt.path][strings.ToLower(rt.method)]; !ok {
+			t.Errorf("%s %s missing from the document", rt.method, rt.path)
+		}
+	}
+	for _, name := range []string{"Task", "Knowledge", "Procedure", "Step", "Error"} {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("Schema %s missing", name)
+		}
+	}
+
+	// Every reference resolves
+	data, _ := json.Marshal(doc)
+	for _, m := range strings.Split(string(data), `"$ref":"#/components/schemas/`)[1:] {
+		name := m[:strings.IndexByte(m, '"')]
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("Dangling reference to %s", name)
+		}
+	}
+}
+
+// fakeAgents runs agents whose bridges are never started, so prompts stay queued
+type fakeAgents struct {
+	agents map[string]*aider.Agent
+}
+
+func (f *fakeAgents) ListAgents() []*aider.Agent {
+	var agents []*aider.Agent
+	for _, agent := range f.agents {
+		agents = append(agents, agent)
+	}
+	return agents
+}
+
+func (f *fakeAgents) GetAgent(agentID string) *aider.Agent { return f.agents[agentID] }
+
+func (f *fakeAgents) AgentTemplate(name string) (aider.AgentConfig, bool) {
+	return aider.AgentConfig{}, false
+}
+
+func (f *fakeAgents) SpawnAgent(config aider.AgentConfig) (*aider.Agent, error) {
+	return nil, aider.ErrAgentLimit
+}
+
+func (f *fakeAgents) StopAgent(agentID string) error {
+	if f.agents[agentID] == nil {
+		return fmt.Errorf("agent %s not found", agentID)
+	}
+	delete(f.agents, agentID)
+	return nil
+}
+
+func TestAgentControl(t *testing.T) {
+	dir := t.TempDir()
+	ops, _ := memory.NewSQLiteOperationalDB(filepath.Join(dir, "operational.db"))
+	t.Cleanup(func() { ops.Close() })
+	learning, _ := memory.NewSQLiteLearningDB(filepath.Join(dir, "learning.db"))
+	t.Cleanup(func() { learning.Close() })
+
+	agents := &fakeAgents{agents: map[string]*aider.Agent{
+		"a1": {ID: "a1", Model: "openai/qwen", Bridge: aider.NewBridge("a1", nil, nil, nil, nil)},
+	}}
+	api := New(ops, learning)
+	api.SetAgents(agents, func() (aider.ReconcileResult, error) {
+		return aider.ReconcileResult{Unchanged: []string{"a1"}}, nil
+	})
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	var running Page[RunningAgent]
+	if status := call(t, server, "GET", "/agents/running", nil, &running); status != http.StatusOK || len(running.Items) != 1 || running.Items[0].ID != "a1" {
+		t.Fatalf("List returned %d: %+v", status, running)
+	}
+
+	var queued natslib.QueuedPrompt
+	if status := call(t, server, "POST", "/agents/a1/queue", map[string]string{"text": "Fix main"}, &queued); status != http.StatusAccepted || queued.ID == "" {
+		t.Fatalf("Queue returned %d: %+v", status, queued)
+	}
+	var queue natslib.PromptQueueMessage
+	if call(t, server, "GET", "/agents/a1/queue", nil, &queue); len(queue.Pending) != 1 {
+		t.Fatalf("Expected the prompt queued, got %+v", queue)
+	}
+	if status := call(t, server, "DELETE", "/agents/a1/queue/"+queued.ID, nil, nil); status != http.StatusNoContent {
+		t.Errorf("Cancel returned %d", status)
+	}
+
+	var result aider.ReconcileResult
+	if status := call(t, server, "POST", "/agents/reload", nil, &result); status != http.StatusOK || len(result.Unchanged) != 1 {
+		t.Errorf("Reload returned %d: %+v", status, result)
+	}
+
+	cases := []struct {
+		method, path string
+		body         interface{}
+		status       int
+		contains     string
+	}{
+		{"POST", "/agents/a1/queue", map[string]string{}, http.StatusBadRequest, "text is required"},
+		{"POST", "/agents/a1/prompt", map[string]interface{}{"text": "x", "timeout_seconds": -1}, http.StatusBadRequest, "timeout_seconds"},
+		{"GET", "/agents/missing/queue", nil, http.StatusNotFound, "not running"},
+		{"DELETE", "/agents/a1/queue/missing", nil, http.StatusNotFound, "not found"},
+		{"POST", "/agents/a1/interrupt", nil, http.StatusConflict, ""},
+		{"POST", "/agents", map[string]string{"name": "dev"}, http.StatusBadRequest, "project_path is required"},
+		{"POST", "/agents", map[string]string{"name": "dev", "colour": "red"}, http.StatusBadRequest, "unknown field"},
+		{"POST", "/agents?template=missing", nil, http.StatusNotFound, "template missing not found"},
+		{"POST", "/agents?project=/work", nil, http.StatusConflict, "agent limit"},
+	}
+	for _, c := range cases {
+		var apiErr Error
+		status := call(t, server, c.method, c.path, c.body, &apiErr)
+		if status != c.status || apiErr.Status != c.status || !strings.Contains(apiErr.Message, c.contains) {
+			t.Errorf("%s %s: got %d %+v, want %d containing %q", c.method, c.path, status, apiErr, c.status, c.contains)
+		}
+	}
+
+	if status := call(t, server, "POST", "/agents/a1/stop", nil, nil); status != http.StatusNoContent || agents.agents["a1"] != nil {
+		t.Errorf("Stop returned %d", status)
+	}
+}
@@ -0,0 +1,442 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/CLIAIRMONITOR/internal/memory"
+)
+
+// withEmbeddings reports whether embeddings=true was asked for; vectors are
+// large and rarely wanted, so responses leave them out by default
+func withEmbeddings(q *query) bool {
+	b := q.bool("embeddings")
+	return b != nil && *b
+}
+
+// ================================================
+// Episodes
+// ================================================
+
+func (s *Server) listEpisodes(w http.ResponseWriter, r *http.Request) {
+	q := newQuery(r)
+	p := q.page()
+	filter := memory.EpisodeFilter{
+		SessionID:     q.str("session"),
+		AgentID:       q.str("agent"),
+		ProjectPath:   q.str("project"),
+		EventType:     q.str("type"),
+		Since:         q.time("since"),
+		Until:         q.time("until"),
+		MinImportance: q.float("min_importance"),
+		Limit:         p.fetch(),
+		Offset:        p.offset,
+	}
+	embeddings := withEmbeddings(q)
+	if q.err != nil {
+		writeError(w, q.err)
+		return
+	}
+
+	episodes, err := s.learning.GetEpisodes(filter)
+	if !embeddings {
+		for _, ep := range episodes {
+			ep.Embedding = nil
+		}
+	}
+	writePage(w, p, episodes, err)
+}
+
+func (s *Server) createEpisode(w http.ResponseWriter, r *http.Request) {
+	var ep memory.Episode
+	if err := decode(w, r, &ep); err != nil {
+		writeError(w, err)
+		return
+	}
+	if ep.ID != "" {
+		writeError(w, badRequest("id is assigned by the server"))
+		return
+	}
+	err := required("session_id", ep.SessionID, "agent_id", ep.AgentID,
+		"event_type", ep.EventType, "content", ep.Content)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if ep.Importance < 0 || ep.Importance > 1 {
+		writeError(w, badRequest("importance must be between 0 and 1"))
+		return
+	}
+
+	if err := s.learning.RecordEpisode(&ep); err != nil {
+		writeError(w, err)
+		return
+	}
+	ep.Embedding = nil
+	writeCreated(w, r, ep.ID, ep)
+}
+
+func (s *Server) getEpisode(w http.ResponseWriter, r *http.Request) {
+	q := newQuery(r)
+	embeddings := withEmbeddings(q)
+	if q.err != nil {
+		writeError(w, q.err)
+		return
+	}
+
+	ep, err := s.learning.GetEpisode(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !embeddings {
+		ep.Embedding = nil
+	}
+	writeJSON(w, http.StatusOK, ep)
+}
+
+func (s *Server) deleteEpisode(w http.ResponseWriter, r *http.Request) {
+	if err := s.learning.DeleteEpisode(r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// searchEpisodes ranks episodes across sessions by meaning and keyword
+func (s *Server) searchEpisodes(w http.ResponseWriter, r *http.Request) {
+	q := newQuery(r)
+	text := q.str("q")
+	opts := memory.EpisodeSearchOptions{
+		ProjectPath: q.str("project"),
+		AgentID:     q.str("agent"),
+		EventType:   q.str("type"),
+		Limit:       q.int("limit"),
+		MinScore:    minScore(q),
+	}
+	embeddings := withEmbeddings(q)
+	if q.err != nil {
+		writeError(w, q.err)
+		return
+	}
+	if err := required("q", text); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	hits, err := s.learning.SearchEpisodes(text, opts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	result := make([]memory.ScoredEpisode, 0, len(hits))
+	for _, hit := range hits {
+		if !embeddings {
+			ep := *hit.Episode
+			ep.Embedding = nil
+			hit.Episode = &ep
+		}
+		result = append(result, *hit)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// minScore reads min_score, a relevance cut-off between 0 and 1
+func minScore(q *query) float64 {
+	score := q.float("min_score")
+	if score < 0 || score > 1 {
+		q.fail("min_score must be between 0 and 1")
+	}
+	return score
+}
+
+// ================================================
+// Knowledge
+// ================================================
+
+func validateKnowledge(k *memory.Knowledge) error {
+	return required("category", k.Category, "title", k.Title, "content", k.Content)
+}
+
+func (s *Server) listKnowledge(w http.ResponseWriter, r *http.Request) {
+	q := newQuery(r)
+	p := q.page()
+	filter := memory.KnowledgeFilter{
+		Category: q.str("category"),
+		Source:   q.str("source"),
+		Tag:      q.str("tag"),
+		Limit:    p.fetch(),
+		Offset:   p.offset,
+	}
+	embeddings := withEmbeddings(q)
+	if q.err != nil {
+		writeError(w, q.err)
+		return
+	}
+
+	items, err := s.learning.ListKnowledge(filter)
+	if !embeddings {
+		for _, k := range items {
+			k.Embedding = nil
+		}
+	}
+	writePage(w, p, items, err)
+}
+
+func (s *Server) createKnowledge(w http.ResponseWriter, r *http.Request) {
+	var k memory.Knowledge
+	if err := decode(w, r, &k); err != nil {
+		writeError(w, err)
+		return
+	}
+	if k.ID != "" {
+		writeError(w, badRequest("id is assigned by the server"))
+		return
+	}
+	if err := validateKnowledge(&k); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.learning.StoreKnowledge(&k); err != nil {
+		writeError(w, err)
+		return
+	}
+	k.Embedding = nil
+	writeCreated(w, r, k.ID, k)
+}
+
+func (s *Server) getKnowledge(w http.ResponseWriter, r *http.Request) {
+	q := newQuery(r)
+	embeddings := withEmbeddings(q)
+	if q.err != nil {
+		writeError(w, q.err)
+		return
+	}
+
+	k, err := s.learning.GetKnowledge(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !embeddings {
+		k.Embedding = nil
+	}
+	writeJSON(w, http.StatusOK, k)
+}
+
+func (s *Server) updateKnowledge(w http.ResponseWriter, r *http.Request) {
+	k, err := s.learning.GetKnowledge(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Keep the stored embedding unless the body replaces it or the content
+	// changes, in which case the background job re-embeds it
+	before := *k
+	k.Embedding, k.EmbeddingModel = nil, ""
+	if err := decode(w, r, k); err != nil {
+		writeError(w, err)
+		return
+	}
+	k.ID, k.CreatedAt = before.ID, before.CreatedAt
+	if len(k.Embedding) == 0 && k.Content == before.Content {
+		k.Embedding, k.EmbeddingModel = before.Embedding, before.EmbeddingModel
+	}
+	if err := validateKnowledge(k); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.learning.UpdateKnowledge(k); err != nil {
+		writeError(w, err)
+		return
+	}
+	k.Embedding = nil
+	writeJSON(w, http.StatusOK, k)
+}
+
+func (s *Server) deleteKnowledge(w http.ResponseWriter, r *http.Request) {
+	if err := s.learning.DeleteKnowledge(r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// searchKnowledge fuses semantic and keyword rankings; used=true counts the
+// results as handed to an agent
+func (s *Server) searchKnowledge(w http.ResponseWriter, r *http.Request) {
+	q := newQuery(r)
+	text := q.str("q")
+	opts := memory.KnowledgeSearchOptions{
+		Category: q.str("category"),
+		Tags:     q.values["tag"],
+		Source:   q.str("source"),
+		Limit:    q.int("limit"),
+		MinScore: minScore(q),
+	}
+	if used := q.bool("used"); used != nil {
+		opts.MarkUsed = *used
+	}
+	embeddings := withEmbeddings(q)
+	if q.err != nil {
+		writeError(w, q.err)
+		return
+	}
+	if err := required("q", text); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	hits, err := s.learning.SearchKnowledgeScored(text, opts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	result := make([]memory.ScoredKnowledge, 0, len(hits))
+	for _, hit := range hits {
+		if !embeddings {
+			k := *hit.Knowledge
+			k.Embedding = nil
+			hit.Knowledge = &k
+		}
+		result = append(result, *hit)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// ================================================
+// Session summaries
+// ================================================
+
+// getSummary returns a session's stored summary, creating it on first request
+func (s *Server) getSummary(w http.ResponseWriter, r *http.Request) {
+	summary, err := s.learning.SummarizeEpisodes(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+func (s *Server) regenerateSummary(w http.ResponseWriter, r *http.Request) {
+	summary, err := s.learning.RegenerateSummary(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// promoteLessons stores a session's lessons learned as error_solution knowledge
+func (s *Server) promoteLessons(w http.ResponseWriter, r *http.Request) {
+	promoted, err := s.learning.PromoteLessons(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	result := make([]memory.Knowledge, 0, len(promoted))
+	for _, k := range promoted {
+		k.Embedding = nil
+		result = append(result, *k)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// ================================================
+// Embeddings
+// ================================================
+
+func (s *Server) embeddingStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.learning.EmbeddingStatus())
+}
+
+// ================================================
+// Procedures
+// ================================================
+
+func validateProcedure(p *memory.Procedure) error {
+	return required("name", p.Name, "task_type", p.TaskType)
+}
+
+func (s *Server) listProcedures(w http.ResponseWriter, r *http.Request) {
+	q := newQuery(r)
+	p := q.page()
+	filter := memory.ProcedureFilter{
+		TaskType: q.str("type"),
+		Limit:    p.fetch(),
+		Offset:   p.offset,
+	}
+	if q.err != nil {
+		writeError(w, q.err)
+		return
+	}
+
+	procedures, err := s.learning.ListProcedures(filter)
+	writePage(w, p, procedures, err)
+}
+
+func (s *Server) createProcedure(w http.ResponseWriter, r *http.Request) {
+	var p memory.Procedure
+	if err := decode(w, r, &p); err != nil {
+		writeError(w, err)
+		return
+	}
+	if p.ID != "" {
+		writeError(w, badRequest("id is assigned by the server"))
+		return
+	}
+	if err := validateProcedure(&p); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.learning.StoreProcedure(&p); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeCreated(w, r, p.ID, p)
+}
+
+func (s *Server) getProcedure(w http.ResponseWriter, r *http.Request) {
+	p, err := s.learning.GetProcedure(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (s *Server) updateProcedure(w http.ResponseWriter, r *http.Request) {
+	p, err := s.learning.GetProcedure(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	id, createdAt := p.ID, p.CreatedAt
+	if err := decode(w, r, p); err != nil {
+		writeError(w, err)
+		return
+	}
+	p.ID, p.CreatedAt = id, createdAt
+	if err := validateProcedure(p); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.learning.UpdateProcedure(p); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (s *Server) deleteProcedure(w http.ResponseWriter, r *http.Request) {
+	if err := s.learning.DeleteProcedure(r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenAPI returns the OpenAPI 3 document for the API, generated from the
+// route table and the Go types it names
+func OpenAPI() map[string]interface{} {
+	gen := &schemaGen{components: map[string]interface{}{}}
+	gen.schema(reflect.TypeOf(Error{}))
+
+	paths := map[string]interface{}{}
+	for _, rt := range (&Server{}).routes() {
+		ops, ok := paths[Prefix+rt.path].(map[string]interface{})
+		if !ok {
+			ops = map[string]interface{}{}
+			paths[Prefix+rt.path] = ops
+		}
+		ops[strings.ToLower(rt.method)] = gen.operation(rt)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "CLIAIRMONITOR API",
+			"version":     "1",
+			"description": "Agents, tasks, sessions, messages and metrics from the operational database; episodes, knowledge and procedures from the learning database; control of the running agents.",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": gen.components},
+	}
+}
+
+func (s *Server) openAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, OpenAPI())
+}
+
+var pathParam = regexp.MustCompile(`\{(\w+)\}`)
+
+// schemaGen builds JSON schemas for Go types, collecting named structs as
+// components
+type schemaGen struct {
+	components map[string]interface{}
+}
+
+// operation describes one route
+func (g *schemaGen) operation(rt route) map[string]interface{} {
+	op := map[string]interface{}{
+		"tags":        []string{rt.tag},
+		"summary":     rt.summary,
+		"operationId": operationID(rt),
+	}
+
+	var params []interface{}
+	for _, m := range pathParam.FindAllStringSubmatch(rt.path, -1) {
+		params = append(params, map[string]interface{}{
+			"name": m[1], "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+		})
+	}
+	query := rt.params
+	if rt.list {
+		query = append(append([]param{}, rt.params...), pageParams...)
+	}
+	for _, p := range query {
+		params = append(params, map[string]interface{}{
+			"name": p.name, "in": "query", "description": p.doc, "schema": paramSchema(p.kind),
+		})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if rt.body != nil {
+		op["requestBody"] = map[string]interface{}{
+			"required": !rt.bodyOptional,
+			"content":  jsonContent(g.schema(reflect.TypeOf(rt.body))),
+		}
+	}
+
+	success := map[string]interface{}{"description": http.StatusText(rt.status)}
+	switch {
+	case rt.list:
+		success["content"] = jsonContent(g.page(reflect.TypeOf(rt.reply)))
+	case rt.reply != nil:
+		success["content"] = jsonContent(g.schema(reflect.TypeOf(rt.reply)))
+	}
+	op["responses"] = map[string]interface{}{
+		strconv.Itoa(rt.status): success,
+		"default": map[string]interface{}{
+			"description": "Error",
+			"content":     jsonContent(ref("Error")),
+		},
+	}
+	return op
+}
+
+// operationID names a route after its method and path, e.g. getTasksById
+func operationID(rt route) string {
+	id := strings.ToLower(rt.method)
+	for _, part := range strings.Split(strings.Trim(rt.path, "/"), "/") {
+		if m := pathParam.FindStringSubmatch(part); m != nil {
+			part = "by_" + m[1]
+		}
+		for _, word := range strings.FieldsFunc(part, func(r rune) bool { return r == '_' || r == '.' }) {
+			id += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return id
+}
+
+// page is the schema of a Page of items of type t
+func (g *schemaGen) page(t reflect.Type) map[string]interface{} {
+	return map[string]interface{}{
+		"type":     "object",
+		"required": []string{"items", "limit", "offset"},
+		"properties": map[string]interface{}{
+			"items":       map[string]interface{}{"type": "array", "items": g.schema(t)},
+			"limit":       map[string]interface{}{"type": "integer"},
+			"offset":      map[string]interface{}{"type": "integer"},
+			"next_offset": map[string]interface{}{"type": "integer", "description": "Offset of the next page, when more items follow"},
+		},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema returns the schema of t, as a reference for named structs
+func (g *schemaGen) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		if _, ok := g.components[t.Name()]; !ok {
+			g.components[t.Name()] = nil // placeholder against recursion
+			g.components[t.Name()] = g.object(t)
+		}
+		return ref(t.Name())
+	case t.Kind() == reflect.Struct:
+		return g.object(t)
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// object describes a struct's JSON fields. None are marked required: the
+// same schemas describe create and PATCH bodies, where most are optional.
+func (g *schemaGen) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	g.fields(t, properties)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+// fields adds t's JSON fields to properties, promoting those of embedded
+// structs as encoding/json does
+func (g *schemaGen) fields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.Anonymous && name == "" {
+			embedded := f.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				g.fields(embedded, properties)
+				continue
+			}
+		}
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = g.schema(f.Type)
+	}
+}
+
+func paramSchema(kind string) map[string]interface{} {
+	if kind == "date-time" {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	return map[string]interface{}{"type": kind}
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
@@ -0,0 +1,402 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/CLIAIRMONITOR/internal/memory"
+)
+
+// ================================================
+// Agents
+// ================================================
+
+func (s *Server) listAgents(w http.ResponseWriter, r *http.Request) {
+	q := newQuery(r)
+	p := q.page()
+	filter := memory.AgentFilter{
+		Status:    memory.AgentStatus(q.str("status")),
+		AgentType: q.str("type"),
+		Active:    q.bool("active"),
+		Limit:     p.fetch(),
+		Offset:    p.offset,
+	}
+	if q.err != nil {
+		writeError(w, q.err)
+		return
+	}
+
+	agents, err := s.ops.ListAgents(filter)
+	writePage(w, p, agents, err)
+}
+
+func (s *Server) getAgent(w http.ResponseWriter, r *http.Request) {
+	agent, err := s.ops.GetAgent(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, agent)
+}
+
+// ================================================
+// Tasks
+// ================================================
+
+var taskStatuses = map[memory.TaskStatus]bool{
+	memory.TaskStatusPending:    true,
+	memory.TaskStatusClaimed:    true,
+	memory.TaskStatusInProgress: true,
+	memory.TaskStatusBlocked:    true,
+	memory.TaskStatusCompleted:  true,
+	memory.TaskStatusFailed:     true,
+}
+
+func validateTask(task *memory.Task) error {
+	if err := required("title", task.Title); err != nil {
+		return err
+	}
+	if !taskStatuses[task.Status] {
+		return badRequest("unknown task status %q", task.Status)
+	}
+	return nil
+}
+
+func (s *Server) listTasks(w http.ResponseWriter, r *http.Request) {
+	q := newQuery(r)
+	p := q.page()
+	filter := memory.TaskFilter{
+		Status:     memory.TaskStatus(q.str("status")),
+		AssignedTo: q.str("assigned_to"),
+		TaskType:   q.str("type"),
+		Priority:   q.intPtr("min_priority"),
+		Limit:      p.fetch(),
+		Offset:     p.offset,
+	}
+	if q.err != nil {
+		writeError(w, q.err)
+		return
+	}
+
+	tasks, err := s.ops.ListTasks(filter)
+	writePage(w, p, tasks, err)
+}
+
+func (s *Server) createTask(w http.ResponseWriter, r *http.Request) {
+	var task memory.Task
+	if err := decode(w, r, &task); err != nil {
+		writeError(w, err)
+		return
+	}
+	if task.ID != "" {
+		writeError(w, badRequest("id is assigned by the server"))
+		return
+	}
+	if task.Status == "" {
+		task.Status = memory.TaskStatusPending
+	}
+	if err := validateTask(&task); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.ops.CreateTask(&task); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeCreated(w, r, task.ID, task)
+}
+
+func (s *Server) getTask(w http.ResponseWriter, r *http.Request) {
+	task, err := s.ops.GetTask(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+func (s *Server) updateTask(w http.ResponseWriter, r *http.Request) {
+	task, err := s.ops.GetTask(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	id, createdAt := task.ID, task.CreatedAt
+	if err := decode(w, r, task); err != nil {
+		writeError(w, err)
+		return
+	}
+	task.ID, task.CreatedAt = id, createdAt
+	if err := validateTask(task); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.ops.UpdateTask(task); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+func (s *Server) deleteTask(w http.ResponseWriter, r *http.Request) {
+	if err := s.ops.DeleteTask(r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ================================================
+// Sessions
+// ================================================
+
+func (s *Server) listSessions(w http.ResponseWriter, r *http.Request) {
+	q := newQuery(r)
+	p := q.page()
+	filter := memory.SessionFilter{
+		AgentID:     q.str("agent"),
+		ProjectPath: q.str("project"),
+		Active:      q.bool("active"),
+		Limit:       p.fetch(),
+		Offset:      p.offset,
+	}
+	if q.err != nil {
+		writeError(w, q.err)
+		return
+	}
+
+	sessions, err := s.ops.ListSessions(filter)
+	writePage(w, p, sessions, err)
+}
+
+func (s *Server) createSession(w http.ResponseWriter, r *http.Request) {
+	var session memory.Session
+	if err := decode(w, r, &session); err != nil {
+		writeError(w, err)
+		return
+	}
+	if session.ID != "" {
+		writeError(w, badRequest("id is assigned by the server"))
+		return
+	}
+	if err := required("agent_id", session.AgentID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.ops.CreateSession(&session); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeCreated(w, r, session.ID, session)
+}
+
+func (s *Server) getSession(w http.ResponseWriter, r *http.Request) {
+	session, err := s.ops.GetSession(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+func (s *Server) updateSession(w http.ResponseWriter, r *http.Request) {
+	session, err := s.ops.GetSession(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Only the fields UpdateSession saves may change
+	before := *session
+	if err := decode(w, r, session); err != nil {
+		writeError(w, err)
+		return
+	}
+	if session.ID != before.ID || session.AgentID != before.AgentID ||
+		session.ProjectPath != before.ProjectPath || !session.StartedAt.Equal(before.StartedAt) {
+		writeError(w, badRequest("only ended_at, tokens_used, tasks_completed and summary can be updated"))
+		return
+	}
+
+	if err := s.ops.UpdateSession(session); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+func (s *Server) deleteSession(w http.ResponseWriter, r *http.Request) {
+	if err := s.ops.DeleteSession(r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listSessionTranscript(w http.ResponseWriter, r *http.Request) {
+	s.listTranscript(w, r, memory.TranscriptFilter{SessionID: r.PathValue("id")})
+}
+
+func (s *Server) listAgentTranscript(w http.ResponseWriter, r *http.Request) {
+	s.listTranscript(w, r, memory.TranscriptFilter{AgentID: r.PathValue("id")})
+}
+
+// listTranscript returns recorded I/O in order, after the query's filters
+func (s *Server) listTranscript(w http.ResponseWriter, r *http.Request, filter memory.TranscriptFilter) {
+	q := newQuery(r)
+	p := q.page()
+	filter.Stream = q.str("stream")
+	filter.Contains = q.str("q")
+	filter.AfterSeq = int64(q.int("after"))
+	filter.Limit, filter.Offset = p.fetch(), p.offset
+	if q.err != nil {
+		writeError(w, q.err)
+		return
+	}
+
+	lines, err := s.ops.GetTranscript(filter)
+	writePage(w, p, lines, err)
+}
+
+// ================================================
+// Messages
+// ================================================
+
+func (s *Server) listMessages(w http.ResponseWriter, r *http.Request) {
+	q := newQuery(r)
+	p := q.page()
+	unacked := q.bool("unacked")
+	filter := memory.MessageFilter{
+		ToAgent:     q.str("to"),
+		FromAgent:   q.str("from"),
+		MessageType: q.str("type"),
+		Unacked:     unacked != nil && *unacked,
+		Since:       q.time("since"),
+		Limit:       p.fetch(),
+		Offset:      p.offset,
+	}
+	if q.err != nil {
+		writeError(w, q.err)
+		return
+	}
+
+	messages, err := s.ops.ListMessages(filter)
+	writePage(w, p, messages, err)
+}
+
+func (s *Server) createMessage(w http.ResponseWriter, r *http.Request) {
+	var msg memory.Message
+	if err := decode(w, r, &msg); err != nil {
+		writeError(w, err)
+		return
+	}
+	if msg.ID != "" {
+		writeError(w, badRequest("id is assigned by the server"))
+		return
+	}
+	err := required("from_agent", msg.FromAgent, "to_agent", msg.ToAgent,
+		"message_type", msg.MessageType, "content", msg.Content)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.ops.SendMessage(&msg); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeCreated(w, r, msg.ID, msg)
+}
+
+func (s *Server) getMessage(w http.ResponseWriter, r *http.Request) {
+	msg, err := s.ops.GetMessage(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, msg)
+}
+
+func (s *Server) ackMessage(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, err := s.ops.GetMessage(id); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.ops.AcknowledgeMessage(id); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	msg, err := s.ops.GetMessage(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, msg)
+}
+
+func (s *Server) deleteMessage(w http.ResponseWriter, r *http.Request) {
+	if err := s.ops.DeleteMessage(r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ================================================
+// Metrics
+// ================================================
+
+func (s *Server) listMetrics(w http.ResponseWriter, r *http.Request) {
+	q := newQuery(r)
+	p := q.page()
+	filter := memory.MetricFilter{
+		AgentID:    q.str("agent"),
+		MetricType: q.str("type"),
+		Since:      q.time("since"),
+		Until:      q.time("until"),
+		Limit:      p.fetch(),
+		Offset:     p.offset,
+	}
+	if q.err != nil {
+		writeError(w, q.err)
+		return
+	}
+
+	metrics, err := s.ops.ListMetrics(filter)
+	writePage(w, p, metrics, err)
+}
+
+func (s *Server) createMetric(w http.ResponseWriter, r *http.Request) {
+	var metric memory.Metric
+	if err := decode(w, r, &metric); err != nil {
+		writeError(w, err)
+		return
+	}
+	if metric.ID != "" {
+		writeError(w, badRequest("id is assigned by the server"))
+		return
+	}
+	if err := required("agent_id", metric.AgentID, "metric_type", metric.MetricType); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.ops.RecordMetric(&metric); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeCreated(w, r, metric.ID, metric)
+}
+
+func (s *Server) deleteMetric(w http.ResponseWriter, r *http.Request) {
+	if err := s.ops.DeleteMetric(r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
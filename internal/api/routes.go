@@ -0,0 +1,221 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/CLIAIRMONITOR/internal/aider"
+	"github.com/CLIAIRMONITOR/internal/memory"
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+)
+
+// routes is the route table: the mux and the OpenAPI document are built from it
+func (s *Server) routes() []route {
+	return []route{
+		{method: "GET", path: "/openapi.json", tag: "meta", summary: "This API's OpenAPI document",
+			reply: map[string]interface{}{}, status: http.StatusOK, handler: s.openAPI},
+
+		// Agents are registered and stopped by the spawner; the API reads them
+		{method: "GET", path: "/agents", tag: "agents", summary: "List agents, newest first", list: true,
+			params: []param{
+				{"status", "string", "Only agents with this status"},
+				{"type", "string", "Only agents of this type"},
+				{"active", "boolean", "Only agents that are (true) or are not (false) stopped"},
+			},
+			reply: memory.AgentState{}, status: http.StatusOK, handler: s.listAgents},
+		{method: "GET", path: "/agents/{id}", tag: "agents", summary: "Get an agent",
+			reply: memory.AgentState{}, status: http.StatusOK, handler: s.getAgent},
+		{method: "GET", path: "/agents/{id}/transcript", tag: "agents", summary: "An agent's recorded I/O across sessions, in order", list: true,
+			params: transcriptParams,
+			reply:  memory.TranscriptLine{}, status: http.StatusOK, handler: s.listAgentTranscript},
+
+		// The running agents, controlled through the spawner
+		{method: "GET", path: "/agents/running", tag: "control", summary: "List running agents with their live status, newest first", list: true,
+			reply: RunningAgent{}, status: http.StatusOK, handler: s.listRunningAgents},
+		{method: "POST", path: "/agents", tag: "control", summary: "Spawn an agent from a config, optionally starting from a template of the agents section",
+			params: []param{
+				{"template", "string", "Start from this entry of the agents section; the body overrides it"},
+				{"project", "string", "Project path, overriding the body's"},
+			},
+			body: SpawnRequest{}, bodyOptional: true, reply: RunningAgent{}, status: http.StatusCreated, handler: s.spawnAgent},
+		{method: "POST", path: "/agents/{id}/stop", tag: "control", summary: "Stop a running agent",
+			status: http.StatusNoContent, handler: s.stopAgent},
+		{method: "POST", path: "/agents/reload", tag: "control", summary: "Re-read the agents section and start, replace or stop agents to match",
+			reply: aider.ReconcileResult{}, status: http.StatusOK, handler: s.reloadAgents},
+		{method: "POST", path: "/agents/{id}/prompt", tag: "control", summary: "Run a prompt and wait until Aider is back at its prompt (504 on timeout)",
+			body: natslib.PromptRequest{}, reply: natslib.PromptResponse{}, status: http.StatusOK, handler: s.promptAgent},
+		{method: "GET", path: "/agents/{id}/queue", tag: "control", summary: "The running and pending prompts",
+			reply: natslib.PromptQueueMessage{}, status: http.StatusOK, handler: s.getQueue},
+		{method: "POST", path: "/agents/{id}/queue", tag: "control", summary: "Queue a prompt without waiting for it",
+			body: natslib.PromptRequest{}, reply: natslib.QueuedPrompt{}, status: http.StatusAccepted, handler: s.queuePrompt},
+		{method: "DELETE", path: "/agents/{id}/queue/{prompt}", tag: "control", summary: "Cancel a prompt: drop it from the queue, or interrupt it if running",
+			status: http.StatusNoContent, handler: s.cancelPrompt},
+		{method: "POST", path: "/agents/{id}/interrupt", tag: "control", summary: "Send Ctrl-C to abandon the running prompt",
+			status: http.StatusNoContent, handler: s.interruptAgent},
+
+		{method: "GET", path: "/tasks", tag: "tasks", summary: "List tasks, highest priority then oldest first", list: true,
+			params: []param{
+				{"status", "string", "Only tasks with this status"},
+				{"assigned_to", "string", "Only tasks assigned to this agent"},
+				{"type", "string", "Only tasks of this type"},
+				{"min_priority", "integer", "Only tasks with at least this priority"},
+			},
+			reply: memory.Task{}, status: http.StatusOK, handler: s.listTasks},
+		{method: "POST", path: "/tasks", tag: "tasks", summary: "Create a task (pending unless a status is given)",
+			body: memory.Task{}, reply: memory.Task{}, status: http.StatusCreated, handler: s.createTask},
+		{method: "GET", path: "/tasks/{id}", tag: "tasks", summary: "Get a task",
+			reply: memory.Task{}, status: http.StatusOK, handler: s.getTask},
+		{method: "PATCH", path: "/tasks/{id}", tag: "tasks", summary: "Update the fields given in the body",
+			body: memory.Task{}, reply: memory.Task{}, status: http.StatusOK, handler: s.updateTask},
+		{method: "DELETE", path: "/tasks/{id}", tag: "tasks", summary: "Delete a task",
+			status: http.StatusNoContent, handler: s.deleteTask},
+
+		{method: "GET", path: "/sessions", tag: "sessions", summary: "List sessions, newest first", list: true,
+			params: []param{
+				{"agent", "string", "Only sessions of this agent"},
+				{"project", "string", "Only sessions in this project path"},
+				{"active", "boolean", "Only sessions that have not (true) or have (false) ended"},
+			},
+			reply: memory.Session{}, status: http.StatusOK, handler: s.listSessions},
+		{method: "POST", path: "/sessions", tag: "sessions", summary: "Start a session",
+			body: memory.Session{}, reply: memory.Session{}, status: http.StatusCreated, handler: s.createSession},
+		{method: "GET", path: "/sessions/{id}", tag: "sessions", summary: "Get a session",
+			reply: memory.Session{}, status: http.StatusOK, handler: s.getSession},
+		{method: "PATCH", path: "/sessions/{id}", tag: "sessions", summary: "Update ended_at, tokens_used, tasks_completed or summary",
+			body: memory.Session{}, reply: memory.Session{}, status: http.StatusOK, handler: s.updateSession},
+		{method: "DELETE", path: "/sessions/{id}", tag: "sessions", summary: "Delete a session and its transcript",
+			status: http.StatusNoContent, handler: s.deleteSession},
+		{method: "GET", path: "/sessions/{id}/transcript", tag: "sessions", summary: "A session's recorded I/O, in order", list: true,
+			params: transcriptParams,
+			reply:  memory.TranscriptLine{}, status: http.StatusOK, handler: s.listSessionTranscript},
+		{method: "GET", path: "/sessions/{id}/summary", tag: "sessions", summary: "The session's summary, created on first request",
+			reply: memory.EpisodeSummary{}, status: http.StatusOK, handler: s.getSummary},
+		{method: "POST", path: "/sessions/{id}/summary", tag: "sessions", summary: "Regenerate the session's summary",
+			reply: memory.EpisodeSummary{}, status: http.StatusOK, handler: s.regenerateSummary},
+		{method: "POST", path: "/sessions/{id}/lessons/promote", tag: "sessions", summary: "Store the session's lessons learned as error_solution knowledge",
+			reply: []memory.Knowledge{}, status: http.StatusOK, handler: s.promoteLessons},
+
+		{method: "GET", path: "/messages", tag: "messages", summary: "List messages, highest priority then oldest first", list: true,
+			params: []param{
+				{"to", "string", "Only messages to this agent"},
+				{"from", "string", "Only messages from this agent"},
+				{"type", "string", "Only messages of this type"},
+				{"unacked", "boolean", "Only messages not yet acknowledged"},
+				{"since", "date-time", "Only messages created at or after this time"},
+			},
+			reply: memory.Message{}, status: http.StatusOK, handler: s.listMessages},
+		{method: "POST", path: "/messages", tag: "messages", summary: "Send a message",
+			body: memory.Message{}, reply: memory.Message{}, status: http.StatusCreated, handler: s.createMessage},
+		{method: "GET", path: "/messages/{id}", tag: "messages", summary: "Get a message",
+			reply: memory.Message{}, status: http.StatusOK, handler: s.getMessage},
+		{method: "POST", path: "/messages/{id}/ack", tag: "messages", summary: "Acknowledge a message",
+			reply: memory.Message{}, status: http.StatusOK, handler: s.ackMessage},
+		{method: "DELETE", path: "/messages/{id}", tag: "messages", summary: "Delete a message",
+			status: http.StatusNoContent, handler: s.deleteMessage},
+
+		{method: "GET", path: "/metrics", tag: "metrics", summary: "List metric samples, oldest first", list: true,
+			params: []param{
+				{"agent", "string", "Only samples from this agent"},
+				{"type", "string", "Only samples of this metric type"},
+				{"since", "date-time", "Only samples at or after this time"},
+				{"until", "date-time", "Only samples at or before this time"},
+			},
+			reply: memory.Metric{}, status: http.StatusOK, handler: s.listMetrics},
+		{method: "POST", path: "/metrics", tag: "metrics", summary: "Record a metric sample",
+			body: memory.Metric{}, reply: memory.Metric{}, status: http.StatusCreated, handler: s.createMetric},
+		{method: "DELETE", path: "/metrics/{id}", tag: "metrics", summary: "Delete a metric sample",
+			status: http.StatusNoContent, handler: s.deleteMetric},
+
+		{method: "GET", path: "/episodes", tag: "episodes", summary: "List episodes, newest first", list: true,
+			params: []param{
+				{"session", "string", "Only episodes from this session"},
+				{"agent", "string", "Only episodes from this agent"},
+				{"project", "string", "Only episodes in this project path"},
+				{"type", "string", "Only episodes of this event type"},
+				{"since", "date-time", "Only episodes at or after this time"},
+				{"until", "date-time", "Only episodes at or before this time"},
+				{"min_importance", "number", "Only episodes at least this important (0-1)"},
+				embeddingsParam,
+			},
+			reply: memory.Episode{}, status: http.StatusOK, handler: s.listEpisodes},
+		{method: "POST", path: "/episodes", tag: "episodes", summary: "Record an episode (embedded in the background)",
+			body: memory.Episode{}, reply: memory.Episode{}, status: http.StatusCreated, handler: s.createEpisode},
+		{method: "GET", path: "/episodes/{id}", tag: "episodes", summary: "Get an episode",
+			params: []param{embeddingsParam},
+			reply:  memory.Episode{}, status: http.StatusOK, handler: s.getEpisode},
+		{method: "DELETE", path: "/episodes/{id}", tag: "episodes", summary: "Delete an episode",
+			status: http.StatusNoContent, handler: s.deleteEpisode},
+		{method: "GET", path: "/episodes/search", tag: "episodes", summary: "Search past episodes by meaning and keyword, across sessions",
+			params: []param{
+				{"q", "string", "Text to search for (required)"},
+				{"project", "string", "Only episodes in this project path"},
+				{"agent", "string", "Only episodes from this agent"},
+				{"type", "string", "Only episodes of this event type"},
+				searchLimitParam,
+				minScoreParam,
+				embeddingsParam,
+			},
+			reply: []memory.ScoredEpisode{}, status: http.StatusOK, handler: s.searchEpisodes},
+
+		{method: "GET", path: "/knowledge", tag: "knowledge", summary: "List knowledge, newest first", list: true,
+			params: []param{
+				{"category", "string", "Only knowledge in this category"},
+				{"source", "string", "Only knowledge from this source"},
+				{"tag", "string", "Only knowledge with this tag"},
+				embeddingsParam,
+			},
+			reply: memory.Knowledge{}, status: http.StatusOK, handler: s.listKnowledge},
+		{method: "POST", path: "/knowledge", tag: "knowledge", summary: "Store knowledge (embedded unless an embedding is given)",
+			body: memory.Knowledge{}, reply: memory.Knowledge{}, status: http.StatusCreated, handler: s.createKnowledge},
+		{method: "GET", path: "/knowledge/{id}", tag: "knowledge", summary: "Get a knowledge item",
+			params: []param{embeddingsParam},
+			reply:  memory.Knowledge{}, status: http.StatusOK, handler: s.getKnowledge},
+		{method: "PATCH", path: "/knowledge/{id}", tag: "knowledge", summary: "Update the fields given in the body; changed content is re-embedded",
+			body: memory.Knowledge{}, reply: memory.Knowledge{}, status: http.StatusOK, handler: s.updateKnowledge},
+		{method: "DELETE", path: "/knowledge/{id}", tag: "knowledge", summary: "Delete a knowledge item",
+			status: http.StatusNoContent, handler: s.deleteKnowledge},
+		{method: "GET", path: "/knowledge/search", tag: "knowledge", summary: "Search knowledge, fusing semantic and keyword rankings",
+			params: []param{
+				{"q", "string", "Text to search for (required)"},
+				{"category", "string", "Only knowledge in this category"},
+				{"tag", "string", "Only knowledge with this tag; repeatable, any matches"},
+				{"source", "string", "Only knowledge from this source"},
+				{"used", "boolean", "Count the results as handed to an agent"},
+				searchLimitParam,
+				minScoreParam,
+				embeddingsParam,
+			},
+			reply: []memory.ScoredKnowledge{}, status: http.StatusOK, handler: s.searchKnowledge},
+		{method: "GET", path: "/embeddings/status", tag: "knowledge", summary: "Progress of the background embedding job",
+			reply: memory.EmbeddingJobStatus{}, status: http.StatusOK, handler: s.embeddingStatus},
+
+		{method: "GET", path: "/procedures", tag: "procedures", summary: "List procedures, most successful first", list: true,
+			params: []param{
+				{"type", "string", "Only procedures for this task type"},
+			},
+			reply: memory.Procedure{}, status: http.StatusOK, handler: s.listProcedures},
+		{method: "POST", path: "/procedures", tag: "procedures", summary: "Store a procedure",
+			body: memory.Procedure{}, reply: memory.Procedure{}, status: http.StatusCreated, handler: s.createProcedure},
+		{method: "GET", path: "/procedures/{id}", tag: "procedures", summary: "Get a procedure",
+			reply: memory.Procedure{}, status: http.StatusOK, handler: s.getProcedure},
+		{method: "PATCH", path: "/procedures/{id}", tag: "procedures", summary: "Update the fields given in the body",
+			body: memory.Procedure{}, reply: memory.Procedure{}, status: http.StatusOK, handler: s.updateProcedure},
+		{method: "DELETE", path: "/procedures/{id}", tag: "procedures", summary: "Delete a procedure",
+			status: http.StatusNoContent, handler: s.deleteProcedure},
+	}
+}
+
+// embeddingsParam asks for embedding vectors, which are left out by default
+var embeddingsParam = param{"embeddings", "boolean", "Include embedding vectors"}
+
+// Parameters shared by the search routes
+var (
+	searchLimitParam = param{"limit", "integer", "Most results to return, default 10"}
+	minScoreParam    = param{"min_score", "number", "Drop results scoring below this (0-1)"}
+)
+
+// transcriptParams filter the transcript routes
+var transcriptParams = []param{
+	{"stream", "string", "Only lines from this stream: stdin, stdout or stderr"},
+	{"q", "string", "Only lines containing this text, case-insensitively"},
+	{"after", "integer", "Only lines after this sequence number"},
+}
@@ -0,0 +1,288 @@
+// Package dashboard serves the monitor's single-page web dashboard: agent
+// status and output, the task queue, escalations and memory statistics. The
+// page and its assets are embedded in the binary and load nothing from the
+// network, so the dashboard works on an airgapped machine. Live updates come
+// from NATS and are pushed to the browser as server-sent events.
+package dashboard
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CLIAIRMONITOR/internal/aider"
+	"github.com/CLIAIRMONITOR/internal/memory"
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+)
+
+//go:embed static
+var static embed.FS
+
+const (
+	// refreshInterval is how often the database-backed state is pushed
+	refreshInterval = 3 * time.Second
+
+	// heartbeatInterval keeps idle event streams open through proxies
+	heartbeatInterval = 15 * time.Second
+
+	// maxQueuedTasks bounds each task status shown in the queue
+	maxQueuedTasks = 100
+)
+
+// queueStatuses are the task states shown in the queue, in display order
+var queueStatuses = []memory.TaskStatus{
+	memory.TaskStatusInProgress,
+	memory.TaskStatusClaimed,
+	memory.TaskStatusBlocked,
+	memory.TaskStatusPending,
+}
+
+// Dashboard serves the web UI and its state and event endpoints
+type Dashboard struct {
+	spawner  *aider.Spawner
+	ops      memory.OperationalDB
+	learning memory.LearningDB
+	client   *natslib.Client
+	hub      *hub
+	mux      *http.ServeMux
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// New creates a dashboard over the running agents and both databases
+func New(spawner *aider.Spawner, ops memory.OperationalDB, learning memory.LearningDB) *Dashboard {
+	d := &Dashboard{
+		spawner:  spawner,
+		ops:      ops,
+		learning: learning,
+		hub:      newHub(),
+		mux:      http.NewServeMux(),
+		stopCh:   make(chan struct{}),
+	}
+
+	assets, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err) // the embedded directory is fixed at build time
+	}
+	d.mux.Handle("GET /", http.FileServer(http.FS(assets)))
+	d.mux.HandleFunc("GET /api/dashboard", d.handleState)
+	d.mux.HandleFunc("GET /api/dashboard/events", d.handleEvents)
+	return d
+}
+
+// Start connects to NATS and begins pushing updates
+func (d *Dashboard) Start(natsURL string) error {
+	client, err := natslib.NewClient(natsURL, "dashboard")
+	if err != nil {
+		return fmt.Errorf("failed to create NATS client for dashboard: %w", err)
+	}
+
+	subjects := []string{
+		natslib.SubjectAllStatus,
+		natslib.SubjectAllOutput,
+		natslib.SubjectSergeantStatus,
+		natslib.SubjectSystemBroadcast,
+		natslib.SubjectAllEscalations,
+	}
+	for _, subject := range subjects {
+		if _, err := client.Subscribe(subject, d.hub.handleMessage); err != nil {
+			client.Close()
+			return fmt.Errorf("failed to subscribe dashboard: %w", err)
+		}
+	}
+	d.client = client
+
+	d.wg.Add(1)
+	go d.refresh()
+
+	log.Println("[DASHBOARD] Started")
+	return nil
+}
+
+// Stop ends open event streams and disconnects from NATS. Call it before
+// shutting down the HTTP server, which otherwise waits for the streams.
+func (d *Dashboard) Stop() {
+	d.stopOnce.Do(func() { close(d.stopCh) })
+	d.wg.Wait()
+	if d.client != nil {
+		d.client.Close()
+	}
+}
+
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mux.ServeHTTP(w, r)
+}
+
+// refresh periodically pushes the database-backed state while anyone is
+// watching, and forgets output of agents that have gone
+func (d *Dashboard) refresh() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stopCh:
+			return
+		case <-ticker.C:
+			running := make(map[string]bool)
+			for _, agent := range d.spawner.ListAgents() {
+				running[agent.ID] = true
+			}
+			d.hub.pruneOutput(running)
+
+			if d.hub.subscriberCount() > 0 {
+				d.hub.publish(EventState, d.state(false))
+			}
+		}
+	}
+}
+
+// ================================================
+// State
+// ================================================
+
+// State is everything the dashboard shows
+type State struct {
+	Seq         uint64                           `json:"seq"` // last event included
+	Agents      []AgentView                      `json:"agents"`
+	Tasks       []*memory.Task                   `json:"tasks"` // active and queued
+	Escalations []Escalation                     `json:"escalations"`
+	Broadcasts  []natslib.SystemBroadcastMessage `json:"broadcasts"`
+	Sergeant    *natslib.SergeantStatusMessage   `json:"sergeant,omitempty"`
+	Memory      *MemoryView                      `json:"memory,omitempty"`
+	Errors      []string                         `json:"errors,omitempty"` // parts that could not be loaded
+	Timestamp   time.Time                        `json:"timestamp"`
+}
+
+// AgentView is one running agent
+type AgentView struct {
+	ID          string                  `json:"id"`
+	ProjectPath string                  `json:"project_path"`
+	Model       string                  `json:"model"`
+	SessionID   string                  `json:"session_id,omitempty"`
+	Status      string                  `json:"status"`
+	CurrentTask string                  `json:"current_task"`
+	StartedAt   time.Time               `json:"started_at"`
+	Output      []natslib.OutputMessage `json:"output,omitempty"` // most recent last
+}
+
+// MemoryView summarizes the learning database
+type MemoryView struct {
+	Stats      *memory.LearningStats     `json:"stats"`
+	Embeddings memory.EmbeddingJobStatus `json:"embeddings"`
+}
+
+// state gathers the current state; output tails are only needed on a full load
+func (d *Dashboard) state(withOutput bool) *State {
+	hist := d.hub.history()
+	state := &State{
+		Seq:         hist.seq,
+		Agents:      []AgentView{},
+		Tasks:       []*memory.Task{},
+		Escalations: hist.escalations,
+		Broadcasts:  hist.broadcasts,
+		Sergeant:    hist.sergeant,
+		Timestamp:   time.Now(),
+	}
+	if state.Escalations == nil {
+		state.Escalations = []Escalation{}
+	}
+
+	for _, agent := range d.spawner.ListAgents() {
+		view := AgentView{
+			ID:          agent.ID,
+			ProjectPath: agent.ProjectPath,
+			Model:       agent.Model,
+			SessionID:   agent.SessionID,
+			StartedAt:   agent.StartedAt,
+		}
+		if agent.Bridge != nil {
+			view.Status, view.CurrentTask = agent.Bridge.GetStatus()
+		}
+		if withOutput {
+			view.Output = hist.output[agent.ID]
+		}
+		state.Agents = append(state.Agents, view)
+	}
+	sort.Slice(state.Agents, func(i, j int) bool { return state.Agents[i].ID < state.Agents[j].ID })
+
+	for _, status := range queueStatuses {
+		tasks, err := d.ops.ListTasks(memory.TaskFilter{Status: status, Limit: maxQueuedTasks})
+		if err != nil {
+			state.Errors = append(state.Errors, fmt.Sprintf("tasks: %v", err))
+			break
+		}
+		state.Tasks = append(state.Tasks, tasks...)
+	}
+
+	if stats, err := d.learning.Stats(); err != nil {
+		state.Errors = append(state.Errors, fmt.Sprintf("memory: %v", err))
+	} else {
+		state.Memory = &MemoryView{Stats: stats, Embeddings: d.learning.EmbeddingStatus()}
+	}
+	return state
+}
+
+func (d *Dashboard) handleState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.state(true))
+}
+
+// ================================================
+// Events
+// ================================================
+
+// handleEvents streams events as server-sent events until the browser goes
+// away, falls too far behind, or the dashboard stops. The browser reconnects
+// on its own and reloads the state to catch up.
+func (d *Dashboard) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events := d.hub.subscribe()
+	defer d.hub.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprint(w, "retry: 2000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-d.stopCh:
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			writeEvent(w, event)
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes one event in text/event-stream format
+func writeEvent(w http.ResponseWriter, event Event) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, event.Data)
+}
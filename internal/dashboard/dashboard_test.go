@@ -0,0 +1,210 @@
+package dashboard
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CLIAIRMONITOR/internal/aider"
+	"github.com/CLIAIRMONITOR/internal/memory"
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+)
+
+func setupTestDashboard(t *testing.T) (*Dashboard, memory.OperationalDB) {
+	t.Helper()
+	dir := t.TempDir()
+
+	ops, err := memory.NewSQLiteOperationalDB(filepath.Join(dir, "operational.db"))
+	if err != nil {
+		t.Fatalf("Failed to create operational DB: %v", err)
+	}
+	t.Cleanup(func() { ops.Close() })
+	learning, err := memory.NewSQLiteLearningDB(filepath.Join(dir, "learning.db"))
+	if err != nil {
+		t.Fatalf("Failed to create learning DB: %v", err)
+	}
+	t.Cleanup(func() { learning.Close() })
+
+	spawner := aider.NewSpawner("nats://127.0.0.1:0", aider.DefaultConfig())
+	t.Cleanup(spawner.StopAll)
+
+	d := New(spawner, ops, learning)
+	t.Cleanup(d.Stop)
+	return d, ops
+}
+
+func natsMessage(subject string, v interface{}) *natslib.Message {
+	data, _ := json.Marshal(v)
+	return &natslib.Message{Subject: subject, Data: data}
+}
+
+func TestHubKeepsHistory(t *testing.T) {
+	h := newHub()
+
+	for i := 0; i < outputTailLines+50; i++ {
+		h.handleMessage(natsMessage("agent.a1.output", natslib.OutputMessage{AgentID: "a1", Stream: "stdout", Content: fmt.Sprint(i)}))
+	}
+	h.handleMessage(natsMessage(natslib.SubjectEscalationCreate, natslib.EscalationCreateMessage{ID: "e1", AgentID: "a1", Question: "Why?"}))
+	h.handleMessage(natsMessage("escalation.response.e1", natslib.EscalationResponseMessage{ID: "e1", Response: "Because", From: "operator"}))
+	h.handleMessage(natsMessage(natslib.SubjectSergeantStatus, natslib.SergeantStatusMessage{Status: "busy", PendingTasks: 2}))
+	h.handleMessage(&natslib.Message{Subject: "agent.a1.status", Data: []byte("not json")})
+	h.handleMessage(natsMessage("agent.a1.prompt", natslib.PromptRequest{Text: "ignored"}))
+
+	hist := h.history()
+	if lines := hist.output["a1"]; len(lines) != outputTailLines || lines[0].Content != "50" || lines[len(lines)-1].Content != fmt.Sprint(outputTailLines+49) {
+		t.Errorf("Expected the last %d lines, got %d starting at %q", outputTailLines, len(lines), lines[0].Content)
+	}
+	if len(hist.escalations) != 1 || hist.escalations[0].Response == nil || hist.escalations[0].Response.Response != "Because" {
+		t.Errorf("Expected the answered escalation, got %+v", hist.escalations)
+	}
+	if hist.sergeant == nil || hist.sergeant.PendingTasks != 2 {
+		t.Errorf("Expected the Sergeant status, got %+v", hist.sergeant)
+	}
+	// Output, escalation, response and Sergeant status; not the malformed or unrelated ones
+	if hist.seq != outputTailLines+50+3 {
+		t.Errorf("Expected %d events, got %d", outputTailLines+53, hist.seq)
+	}
+
+	h.pruneOutput(map[string]bool{"a2": true})
+	if _, ok := h.history().output["a1"]; ok {
+		t.Error("Expected output of a stopped agent to be dropped")
+	}
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	h := newHub()
+	slow := h.subscribe()
+	for i := 0; i <= subscriberBuffer; i++ {
+		h.publish(EventState, i)
+	}
+
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != subscriberBuffer {
+		t.Errorf("Expected %d buffered events before the drop, got %d", subscriberBuffer, received)
+	}
+	if h.subscriberCount() != 0 {
+		t.Errorf("Expected no subscribers, got %d", h.subscriberCount())
+	}
+	h.unsubscribe(slow) // already dropped: must not close twice
+}
+
+func TestAssetsAreSelfContained(t *testing.T) {
+	// Airgapped: nothing may be fetched from another host
+	external := regexp.MustCompile(`(?i)(src|href)\s*=\s*["']?(https?:)?//|@import|url\(\s*["']?(https?:)?//|fetch\(\s*["']https?:`)
+	err := fs.WalkDir(static, "static", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, _ := static.ReadFile(path)
+		if loc := external.FindIndex(data); loc != nil {
+			t.Errorf("%s references an external resource: %s", path, data[loc[0]:loc[1]])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServesPageAndState(t *testing.T) {
+	d, ops := setupTestDashboard(t)
+	ops.CreateTask(&memory.Task{Title: "Queued", Status: memory.TaskStatusPending})
+	ops.CreateTask(&memory.Task{Title: "Done", Status: memory.TaskStatusCompleted})
+	server := httptest.NewServer(d)
+	defer server.Close()
+
+	for path, contentType := range map[string]string{"/": "text/html", "/app.js": "javascript", "/style.css": "text/css"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("Content-Type"), contentType) {
+			t.Errorf("GET %s: %d %s", path, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+	}
+
+	d.hub.handleMessage(natsMessage(natslib.SubjectSystemBroadcast, natslib.SystemBroadcastMessage{Type: "agent_crashed", Message: "boom"}))
+
+	resp, err := http.Get(server.URL + "/api/dashboard")
+	if err != nil {
+		t.Fatalf("GET state failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var state State
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		t.Fatalf("Failed to decode state: %v", err)
+	}
+	if len(state.Tasks) != 1 || state.Tasks[0].Title != "Queued" {
+		t.Errorf("Expected only the queued task, got %+v", state.Tasks)
+	}
+	if len(state.Broadcasts) != 1 || state.Broadcasts[0].Type != "agent_crashed" {
+		t.Errorf("Expected the broadcast, got %+v", state.Broadcasts)
+	}
+	if state.Memory == nil || state.Agents == nil || len(state.Errors) != 0 {
+		t.Errorf("Incomplete state: %+v", state)
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	d, _ := setupTestDashboard(t)
+	server := httptest.NewServer(d)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/dashboard/events")
+	if err != nil {
+		t.Fatalf("GET events failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Unexpected content type %q", ct)
+	}
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Stream ended: %v", err)
+			}
+			if line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+	if first := readEvent(); first != "retry: 2000\n" {
+		t.Fatalf("Unexpected preamble %q", first)
+	}
+
+	d.hub.handleMessage(natsMessage("agent.a1.output", natslib.OutputMessage{AgentID: "a1", Stream: "stdout", Content: "Applied edit to main.go"}))
+	event := readEvent()
+	if !strings.HasPrefix(event, "id: 1\nevent: output\ndata: {") || !strings.Contains(event, "Applied edit to main.go") {
+		t.Errorf("Unexpected event %q", event)
+	}
+
+	// Stopping the dashboard ends the stream so the HTTP server can shut down
+	done := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, reader)
+		close(done)
+	}()
+	d.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream still open after Stop")
+	}
+}
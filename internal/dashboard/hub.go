@@ -0,0 +1,253 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+)
+
+const (
+	// outputTailLines is how many output lines are kept per agent
+	outputTailLines = 200
+
+	// maxEscalations and maxBroadcasts bound the recent history kept in memory
+	maxEscalations = 100
+	maxBroadcasts  = 50
+
+	// subscriberBuffer is how many events a browser may fall behind before it
+	// is disconnected; it reconnects and reloads the full state
+	subscriberBuffer = 512
+)
+
+// Event types sent to the browser
+const (
+	EventState              = "state"               // State, without output tails
+	EventStatus             = "status"              // nats.StatusMessage
+	EventOutput             = "output"              // nats.OutputMessage
+	EventSergeant           = "sergeant"            // nats.SergeantStatusMessage
+	EventBroadcast          = "broadcast"           // nats.SystemBroadcastMessage
+	EventEscalation         = "escalation"          // nats.EscalationCreateMessage
+	EventEscalationResponse = "escalation_response" // nats.EscalationResponseMessage
+)
+
+// Event is one real-time update; Data holds the message matching Type
+type Event struct {
+	Seq  uint64          `json:"seq"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Escalation is a question raised by an agent, with its answer once given
+type Escalation struct {
+	natslib.EscalationCreateMessage
+	Response *natslib.EscalationResponseMessage `json:"response,omitempty"`
+}
+
+// hub keeps what NATS does not store (output tails, escalations, broadcasts,
+// the last Sergeant status) and fans events out to connected browsers
+type hub struct {
+	mu          sync.Mutex
+	seq         uint64
+	output      map[string][]natslib.OutputMessage // keyed by agent ID
+	escalations []*Escalation
+	broadcasts  []natslib.SystemBroadcastMessage
+	sergeant    *natslib.SergeantStatusMessage
+	subscribers map[chan Event]struct{}
+}
+
+func newHub() *hub {
+	return &hub{
+		output:      make(map[string][]natslib.OutputMessage),
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// handleMessage records a NATS message and forwards it as an event
+func (h *hub) handleMessage(msg *natslib.Message) {
+	eventType := eventTypeFor(msg.Subject)
+	if eventType == "" {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.record(eventType, msg.Data); err != nil {
+		log.Printf("[DASHBOARD] Ignoring malformed %s message: %v", msg.Subject, err)
+		return
+	}
+	h.publishLocked(eventType, msg.Data)
+}
+
+// eventTypeFor maps a subject to the event type it is forwarded as
+func eventTypeFor(subject string) string {
+	parts := strings.Split(subject, ".")
+	switch {
+	case len(parts) == 3 && parts[0] == "agent" && parts[2] == "status":
+		return EventStatus
+	case len(parts) == 3 && parts[0] == "agent" && parts[2] == "output":
+		return EventOutput
+	case subject == natslib.SubjectSergeantStatus:
+		return EventSergeant
+	case subject == natslib.SubjectSystemBroadcast:
+		return EventBroadcast
+	case subject == natslib.SubjectEscalationCreate:
+		return EventEscalation
+	case len(parts) == 3 && parts[0] == "escalation" && parts[1] == "response":
+		return EventEscalationResponse
+	}
+	return ""
+}
+
+// record keeps the history an event type needs; h.mu must be held
+func (h *hub) record(eventType string, data []byte) error {
+	switch eventType {
+	case EventStatus:
+		var msg natslib.StatusMessage
+		return json.Unmarshal(data, &msg)
+
+	case EventOutput:
+		var msg natslib.OutputMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return err
+		}
+		h.output[msg.AgentID] = appendBounded(h.output[msg.AgentID], msg, outputTailLines)
+
+	case EventSergeant:
+		var msg natslib.SergeantStatusMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return err
+		}
+		h.sergeant = &msg
+
+	case EventBroadcast:
+		var msg natslib.SystemBroadcastMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return err
+		}
+		h.broadcasts = appendBounded(h.broadcasts, msg, maxBroadcasts)
+
+	case EventEscalation:
+		var msg natslib.EscalationCreateMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return err
+		}
+		h.escalations = appendBounded(h.escalations, &Escalation{EscalationCreateMessage: msg}, maxEscalations)
+
+	case EventEscalationResponse:
+		var msg natslib.EscalationResponseMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return err
+		}
+		for _, e := range h.escalations {
+			if e.ID == msg.ID {
+				e.Response = &msg
+			}
+		}
+	}
+	return nil
+}
+
+// appendBounded appends v, dropping the oldest items beyond max
+func appendBounded[T any](items []T, v T, max int) []T {
+	items = append(items, v)
+	if len(items) > max {
+		items = append(items[:0:0], items[len(items)-max:]...)
+	}
+	return items
+}
+
+// publish sends an event to every subscriber
+func (h *hub) publish(eventType string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("[DASHBOARD] Failed to encode %s event: %v", eventType, err)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.publishLocked(eventType, data)
+}
+
+// publishLocked numbers an event and sends it without blocking; a subscriber
+// whose buffer is full is dropped. h.mu must be held.
+func (h *hub) publishLocked(eventType string, data []byte) {
+	h.seq++
+	event := Event{Seq: h.seq, Type: eventType, Data: data}
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe registers a browser for events
+func (h *hub) subscribe() chan Event {
+	ch := make(chan Event, subscriberBuffer)
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+// unsubscribe removes a subscriber, unless it was already dropped
+func (h *hub) unsubscribe(ch chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[ch]; ok {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// subscriberCount reports how many browsers are connected
+func (h *hub) subscriberCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers)
+}
+
+// history is a copy of what the hub has recorded
+type history struct {
+	seq         uint64
+	output      map[string][]natslib.OutputMessage
+	escalations []Escalation
+	broadcasts  []natslib.SystemBroadcastMessage
+	sergeant    *natslib.SergeantStatusMessage
+}
+
+func (h *hub) history() history {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hist := history{
+		seq:        h.seq,
+		output:     make(map[string][]natslib.OutputMessage, len(h.output)),
+		broadcasts: append([]natslib.SystemBroadcastMessage{}, h.broadcasts...),
+		sergeant:   h.sergeant,
+	}
+	for agentID, lines := range h.output {
+		hist.output[agentID] = append([]natslib.OutputMessage{}, lines...)
+	}
+	for _, e := range h.escalations {
+		hist.escalations = append(hist.escalations, *e)
+	}
+	return hist
+}
+
+// pruneOutput forgets output of agents that are no longer running
+func (h *hub) pruneOutput(running map[string]bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for agentID := range h.output {
+		if !running[agentID] {
+			delete(h.output, agentID)
+		}
+	}
+}
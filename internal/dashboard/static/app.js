@@ -0,0 +1,294 @@
+// CLIAIRMONITOR dashboard: loads /api/dashboard, then applies server-sent
+// events from /api/dashboard/events. Everything is rendered with textContent,
+// never innerHTML, since agent output is untrusted.
+"use strict";
+
+const OUTPUT_LINES = 200;
+const MAX_BROADCASTS = 50;
+
+const state = {
+  agents: new Map(), // id -> agent view, with output
+  tasks: [],
+  escalations: [],
+  broadcasts: [],
+  sergeant: null,
+  memory: null,
+};
+
+const $ = (id) => document.getElementById(id);
+
+function el(tag, className, text) {
+  const node = document.createElement(tag);
+  if (className) node.className = className;
+  if (text !== undefined && text !== null) node.textContent = text;
+  return node;
+}
+
+function formatTime(value) {
+  if (!value) return "";
+  const date = new Date(value);
+  if (isNaN(date)) return "";
+  return date.toLocaleTimeString();
+}
+
+function formatUptime(startedAt) {
+  const seconds = Math.max(0, Math.floor((Date.now() - new Date(startedAt)) / 1000));
+  const h = Math.floor(seconds / 3600);
+  const m = Math.floor((seconds % 3600) / 60);
+  return h > 0 ? `${h}h${m}m` : `${m}m${seconds % 60}s`;
+}
+
+// ================================================
+// Loading
+// ================================================
+
+async function load() {
+  try {
+    const response = await fetch("api/dashboard", { cache: "no-store" });
+    if (!response.ok) throw new Error(response.statusText);
+    applyState(await response.json(), true);
+  } catch (err) {
+    console.error("Failed to load dashboard state", err);
+  }
+}
+
+// applyState replaces everything; output tails are only present on a full load
+function applyState(data, full) {
+  const agents = new Map();
+  for (const agent of data.agents || []) {
+    const previous = state.agents.get(agent.id);
+    agent.output = full ? agent.output || [] : previous ? previous.output : [];
+    agents.set(agent.id, agent);
+  }
+  state.agents = agents;
+  state.tasks = data.tasks || [];
+  state.escalations = data.escalations || [];
+  state.broadcasts = (data.broadcasts || []).slice().reverse();
+  state.sergeant = data.sergeant || null;
+  state.memory = data.memory || null;
+
+  renderAgents(full);
+  renderTasks();
+  renderEscalations();
+  renderBroadcasts();
+  renderSergeant();
+  renderMemory();
+}
+
+// ================================================
+// Events
+// ================================================
+
+function connect() {
+  const events = new EventSource("api/dashboard/events");
+  let dropped = false;
+
+  events.onopen = () => {
+    setConnection(true);
+    // Events were missed while disconnected: reload everything
+    if (dropped) load();
+    dropped = false;
+  };
+  events.onerror = () => {
+    setConnection(false);
+    dropped = true;
+  };
+
+  const on = (type, handler) =>
+    events.addEventListener(type, (e) => handler(JSON.parse(e.data)));
+
+  on("state", (data) => applyState(data, false));
+
+  on("status", (msg) => {
+    let agent = state.agents.get(msg.agent_id);
+    if (!agent) {
+      // A new agent; the next state event fills in the rest
+      agent = { id: msg.agent_id, project_path: "", model: "", output: [] };
+      state.agents.set(agent.id, agent);
+    }
+    agent.status = msg.status;
+    agent.current_task = msg.current_task;
+    renderAgents(false);
+  });
+
+  on("output", (msg) => {
+    const agent = state.agents.get(msg.agent_id);
+    if (!agent) return;
+    agent.output.push(msg);
+    if (agent.output.length > OUTPUT_LINES) agent.output.splice(0, agent.output.length - OUTPUT_LINES);
+    appendOutput(agent, msg);
+  });
+
+  on("sergeant", (msg) => {
+    state.sergeant = msg;
+    renderSergeant();
+  });
+
+  on("broadcast", (msg) => {
+    state.broadcasts.unshift(msg);
+    state.broadcasts.length = Math.min(state.broadcasts.length, MAX_BROADCASTS);
+    renderBroadcasts();
+  });
+
+  on("escalation", (msg) => {
+    state.escalations.push(msg);
+    renderEscalations();
+  });
+
+  on("escalation_response", (msg) => {
+    for (const escalation of state.escalations) {
+      if (escalation.id === msg.id) escalation.response = msg;
+    }
+    renderEscalations();
+  });
+}
+
+function setConnection(online) {
+  const pill = $("connection");
+  pill.textContent = online ? "live" : "reconnecting";
+  pill.className = "pill " + (online ? "online" : "offline");
+}
+
+// ================================================
+// Rendering
+// ================================================
+
+function renderAgents(withOutput) {
+  const container = $("agents");
+  const cards = new Map();
+  for (const card of container.querySelectorAll(".agent")) cards.set(card.dataset.id, card);
+
+  const ids = [...state.agents.keys()].sort();
+  $("agent-count").textContent = ids.length ? `(${ids.length})` : "";
+  container.querySelector(".empty")?.remove();
+  if (ids.length === 0) container.replaceChildren(el("p", "empty", "No agents running"));
+
+  for (const id of ids) {
+    const agent = state.agents.get(id);
+    let card = cards.get(id);
+    const isNew = !card;
+    cards.delete(id);
+    if (isNew) {
+      card = $("agent-template").content.firstElementChild.cloneNode(true);
+      card.dataset.id = id;
+    }
+    container.appendChild(card); // keeps cards in order
+
+    card.querySelector(".agent-id").textContent = id;
+    const status = card.querySelector(".status");
+    status.textContent = agent.status || "unknown";
+    status.className = "status " + (agent.status || "");
+
+    const meta = [agent.project_path, agent.model];
+    if (agent.started_at) meta.push("up " + formatUptime(agent.started_at));
+    card.querySelector(".agent-meta").textContent = meta.filter(Boolean).join(" · ");
+    card.querySelector(".agent-task").textContent = agent.current_task || "";
+
+    if (withOutput || isNew) {
+      const pre = card.querySelector(".output");
+      pre.replaceChildren(...agent.output.map(outputLine));
+      pre.scrollTop = pre.scrollHeight;
+    }
+  }
+  for (const card of cards.values()) card.remove();
+}
+
+function outputLine(msg) {
+  return el("div", msg.stream, msg.content);
+}
+
+function appendOutput(agent, msg) {
+  const card = $("agents").querySelector(`.agent[data-id="${CSS.escape(agent.id)}"]`);
+  if (!card) return;
+  const pre = card.querySelector(".output");
+  const atBottom = pre.scrollTop + pre.clientHeight >= pre.scrollHeight - 4;
+  pre.appendChild(outputLine(msg));
+  while (pre.childElementCount > OUTPUT_LINES) pre.firstElementChild.remove();
+  if (atBottom) pre.scrollTop = pre.scrollHeight;
+}
+
+function renderTasks() {
+  const body = $("tasks").querySelector("tbody");
+  body.replaceChildren(
+    ...state.tasks.map((task) => {
+      const row = el("tr");
+      row.append(
+        el("td", "mono", task.priority),
+        el("td", "", task.status),
+        el("td", "title", task.title),
+        el("td", "", task.task_type || ""),
+        el("td", "mono", task.assigned_to || ""),
+        el("td", "mono", task.project_path || ""),
+        el("td", "", formatTime(task.created_at)),
+      );
+      return row;
+    }),
+  );
+  $("task-count").textContent = state.tasks.length ? `(${state.tasks.length})` : "";
+  $("tasks").hidden = state.tasks.length === 0;
+  $("tasks-empty").hidden = state.tasks.length > 0;
+}
+
+function renderEscalations() {
+  const open = state.escalations.filter((e) => !e.response).length;
+  $("escalation-count").textContent = state.escalations.length ? `(${open} open)` : "";
+  $("escalations-empty").hidden = state.escalations.length > 0;
+  $("escalations").replaceChildren(
+    ...state.escalations
+      .slice()
+      .reverse()
+      .map((e) => {
+        const item = el("li", e.response ? "" : "open");
+        item.append(el("span", "when", formatTime(e.timestamp)), el("span", "kind", e.agent_id), el("span", "", e.question));
+        if (e.response) item.append(el("div", "answer", `${e.response.from}: ${e.response.response}`));
+        return item;
+      }),
+  );
+}
+
+function renderBroadcasts() {
+  $("broadcasts-empty").hidden = state.broadcasts.length > 0;
+  $("broadcasts").replaceChildren(
+    ...state.broadcasts.map((b) => {
+      const item = el("li");
+      item.append(el("span", "when", formatTime(b.timestamp)), el("span", "kind", b.type), el("span", "", b.message));
+      return item;
+    }),
+  );
+}
+
+function renderSergeant() {
+  const s = state.sergeant;
+  $("sergeant").textContent = s
+    ? `Sergeant: ${s.status} · ${s.busy_agents}/${s.active_agents} busy · ${s.pending_tasks} pending` +
+      (s.current_op ? ` · ${s.current_op}` : "")
+    : "Sergeant: unknown";
+}
+
+function renderMemory() {
+  const list = $("memory");
+  const m = state.memory;
+  if (!m) {
+    list.replaceChildren(el("dt", "", "Unavailable"));
+    return;
+  }
+  const rows = [
+    ["Episodes", m.stats.episodes],
+    ["Knowledge", `${m.stats.knowledge} (${m.stats.knowledge_embedded} embedded)`],
+  ];
+  for (const [category, count] of Object.entries(m.stats.knowledge_by_category || {}).sort()) {
+    rows.push(["  " + category, count]);
+  }
+  rows.push(["Procedures", m.stats.procedures], ["Cached embeddings", m.stats.cached_embeddings]);
+
+  const e = m.embeddings;
+  if (e.model) rows.push(["Embedding model", e.model]);
+  if (e.running) rows.push(["Embedding job", `${e.done}/${e.total}, ${e.remaining} remaining`]);
+  if (e.last_error) rows.push(["Embedding error", e.last_error]);
+
+  list.replaceChildren(...rows.flatMap(([name, value]) => [el("dt", "", name), el("dd", "", value)]));
+}
+
+load().then(connect);
+// Uptimes tick even when nothing else changes
+setInterval(() => renderAgents(false), 10000);
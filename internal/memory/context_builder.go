@@ -0,0 +1,474 @@
+package memory
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// charsPerToken approximates Qwen/GPT-style tokenizers on mixed code and prose;
+	// slightly low so estimates err on the side of more tokens
+	charsPerToken = 3.5
+
+	// knowledgeCandidates and episodeCandidates bound how much is fetched before ranking
+	knowledgeCandidates = 20
+	episodeCandidates   = 50
+
+	// minItemTokens is the smallest truncated item worth including
+	minItemTokens = 40
+
+	// maxQueryKeywords bounds the per-keyword fallback searches
+	maxQueryKeywords = 5
+
+	// episodeHalfLife controls how quickly old episodes lose relevance
+	episodeHalfLife = 48 * time.Hour
+)
+
+// stopWords are skipped when extracting query keywords
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "with": true, "that": true, "this": true,
+	"from": true, "into": true, "when": true, "then": true, "should": true, "would": true,
+	"make": true, "sure": true, "please": true, "file": true, "files": true, "code": true,
+	"add": true, "use": true, "are": true, "not": true, "all": true, "any": true,
+}
+
+// RAGContextBuilder implements ContextBuilder on top of a LearningDB. It
+// retrieves procedures, knowledge and episodes for a task, ranks them, and
+// renders a system prompt that fits the memory budget.
+type RAGContextBuilder struct {
+	learning LearningDB
+	budget   *ContextBudget
+	mu       sync.RWMutex
+}
+
+// NewRAGContextBuilder creates a context builder using DefaultContextBudget
+func NewRAGContextBuilder(learning LearningDB) *RAGContextBuilder {
+	return &RAGContextBuilder{
+		learning: learning,
+		budget:   DefaultContextBudget(),
+	}
+}
+
+// SetBudget replaces the budget; nil restores DefaultContextBudget
+func (b *RAGContextBuilder) SetBudget(budget *ContextBudget) {
+	if budget == nil {
+		budget = DefaultContextBudget()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.budget = budget
+}
+
+// EstimateTokens approximates the token count of text
+func (b *RAGContextBuilder) EstimateTokens(text string) int {
+	return estimateTokens(text)
+}
+
+func estimateTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	return int(math.Ceil(float64(utf8.RuneCountInString(text)) / charsPerToken))
+}
+
+// BuildContext assembles procedures, knowledge and episodes relevant to the
+// request into a system prompt within the memory budget. Sections are filled
+// procedural, semantic, then episodic; budget a section leaves unused carries
+// over to the next.
+func (b *RAGContextBuilder) BuildContext(request *ContextRequest) (*Context, error) {
+	if request == nil {
+		return nil, fmt.Errorf("context request is required")
+	}
+
+	b.mu.RLock()
+	budget := *b.budget
+	b.mu.RUnlock()
+
+	memoryBudget := budget.MemoryBudget
+	if memoryBudget <= 0 {
+		memoryBudget = budget.MaxTokens - budget.ReservedForTask
+	}
+
+	// The header and the blank lines between up to three sections
+	header := renderHeader(request)
+	remaining := memoryBudget - estimateTokens(header) - estimateTokens("\n\n\n\n\n\n")
+	if remaining <= 0 {
+		return &Context{SystemPrompt: header, TotalTokens: estimateTokens(header)}, nil
+	}
+
+	procedural, semantic, episodic := normalizeWeights(budget)
+	keywords := queryKeywords(strings.Join(append([]string{request.TaskDescription}, request.QueryHints...), " "))
+
+	result := &Context{}
+	var sections []string
+	carry := 0
+
+	// Procedures
+	share := int(float64(remaining)*procedural) + carry
+	section, used, err := b.buildProcedures(request, share, result)
+	if err != nil {
+		return nil, err
+	}
+	if section != "" {
+		sections = append(sections, section)
+	}
+	carry = share - used
+
+	// Knowledge
+	share = int(float64(remaining)*semantic) + carry
+	section, used, err = b.buildKnowledge(request, keywords, share, result)
+	if err != nil {
+		return nil, err
+	}
+	if section != "" {
+		sections = append(sections, section)
+	}
+	carry = share - used
+
+	// Episodes and recent actions
+	share = int(float64(remaining)*episodic) + carry
+	section, _, err = b.buildEpisodes(request, keywords, share, result)
+	if err != nil {
+		return nil, err
+	}
+	if section != "" {
+		sections = append(sections, section)
+	}
+
+	result.SystemPrompt = strings.Join(append([]string{header}, sections...), "\n\n")
+	result.TotalTokens = estimateTokens(result.SystemPrompt)
+	return result, nil
+}
+
+// renderHeader introduces the memory to the model
+func renderHeader(request *ContextRequest) string {
+	header := "You are a coding agent"
+	if request.ProjectPath != "" {
+		header += " working in " + request.ProjectPath
+	}
+	return header + ".\nThe notes below come from earlier work. Use them where they help and ignore them where they do not apply."
+}
+
+// normalizeWeights returns the section weights scaled to sum to 1
+func normalizeWeights(budget ContextBudget) (procedural, semantic, episodic float64) {
+	procedural = math.Max(budget.ProceduralWeight, 0)
+	semantic = math.Max(budget.SemanticWeight, 0)
+	episodic = math.Max(budget.EpisodicWeight, 0)
+
+	sum := procedural + semantic + episodic
+	if sum == 0 {
+		defaults := DefaultContextBudget()
+		return defaults.ProceduralWeight, defaults.SemanticWeight, defaults.EpisodicWeight
+	}
+	return procedural / sum, semantic / sum, episodic / sum
+}
+
+// buildProcedures renders the best procedures for the task type within tokens
+func (b *RAGContextBuilder) buildProcedures(request *ContextRequest, tokens int, result *Context) (string, int, error) {
+	if request.TaskType == "" {
+		return "", 0, nil
+	}
+
+	procedures, err := b.learning.FindProcedures(request.TaskType, "")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to find procedures: %w", err)
+	}
+
+	// Laplace-smoothed success rate, so untried procedures rank in the middle
+	sort.SliceStable(procedures, func(i, j int) bool {
+		return successRate(procedures[i]) > successRate(procedures[j])
+	})
+
+	items := make([]string, 0, len(procedures))
+	for _, p := range procedures {
+		items = append(items, renderProcedure(p))
+	}
+
+	section, used, kept := fillSection("## Procedures that worked before", items, tokens)
+	for _, i := range kept {
+		result.ApplicableProcedures = append(result.ApplicableProcedures, procedures[i])
+	}
+	return section, used, nil
+}
+
+// buildKnowledge renders the most relevant knowledge within tokens
+func (b *RAGContextBuilder) buildKnowledge(request *ContextRequest, keywords []string, tokens int, result *Context) (string, int, error) {
+	if request.TaskDescription == "" && len(request.QueryHints) == 0 {
+		return "", 0, nil
+	}
+
+	queries := append([]string{request.TaskDescription}, request.QueryHints...)
+	candidates, err := b.searchKnowledge(queries)
+	if err != nil {
+		return "", 0, err
+	}
+
+	// Text search matches whole phrases; fall back to individual keywords
+	if len(candidates) < knowledgeCandidates/2 {
+		more, err := b.searchKnowledge(keywords)
+		if err != nil {
+			return "", 0, err
+		}
+		candidates = mergeKnowledge(candidates, more)
+	}
+
+	// Blend retrieval rank with keyword overlap
+	scores := make(map[string]float64, len(candidates))
+	for rank, k := range candidates {
+		overlap := keywordOverlap(keywords, k.Title+" "+k.Content+" "+strings.Join(k.Tags, " "))
+		scores[k.ID] = 0.5/float64(rank+1) + 0.5*overlap
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return scores[candidates[i].ID] > scores[candidates[j].ID]
+	})
+
+	items := make([]string, 0, len(candidates))
+	for _, k := range candidates {
+		items = append(items, renderKnowledge(k))
+	}
+
+	section, used, kept := fillSection("## Relevant knowledge", items, tokens)
+	for _, i := range kept {
+		result.RelevantKnowledge = append(result.RelevantKnowledge, candidates[i])
+	}
+	return section, used, nil
+}
+
+// searchKnowledge runs each query and merges the results in order
+func (b *RAGContextBuilder) searchKnowledge(queries []string) ([]*Knowledge, error) {
+	var merged []*Knowledge
+	for _, query := range queries {
+		if strings.TrimSpace(query) == "" {
+			continue
+		}
+		found, err := b.learning.SearchKnowledge(query, knowledgeCandidates)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search knowledge: %w", err)
+		}
+		merged = mergeKnowledge(merged, found)
+	}
+	return merged, nil
+}
+
+// mergeKnowledge appends items not already present, keeping order
+func mergeKnowledge(existing, more []*Knowledge) []*Knowledge {
+	seen := make(map[string]bool, len(existing))
+	for _, k := range existing {
+		seen[k.ID] = true
+	}
+	for _, k := range more {
+		if !seen[k.ID] {
+			seen[k.ID] = true
+			existing = append(existing, k)
+		}
+	}
+	return existing
+}
+
+// buildEpisodes renders recent actions and the project's most relevant episodes within tokens
+func (b *RAGContextBuilder) buildEpisodes(request *ContextRequest, keywords []string, tokens int, result *Context) (string, int, error) {
+	episodes, relevance, err := b.episodeCandidates(request, keywords)
+	if err != nil {
+		return "", 0, err
+	}
+
+	// Importance, recency and relevance to the task
+	now := time.Now()
+	scores := make(map[string]float64, len(episodes))
+	for _, ep := range episodes {
+		age := now.Sub(ep.Timestamp)
+		recency := math.Pow(0.5, age.Hours()/episodeHalfLife.Hours())
+		scores[ep.ID] = 0.4*ep.Importance + 0.3*recency + 0.3*relevance[ep.ID]
+	}
+	sort.SliceStable(episodes, func(i, j int) bool {
+		return scores[episodes[i].ID] > scores[episodes[j].ID]
+	})
+
+	// Recent actions come first: they are what the agent was just doing
+	items := make([]string, 0, len(request.RecentActions)+len(episodes))
+	for _, action := range request.RecentActions {
+		items = append(items, "- Recent action: "+action)
+	}
+	for _, ep := range episodes {
+		items = append(items, renderEpisode(ep))
+	}
+
+	section, used, kept := fillSection("## Recent history", items, tokens)
+	for _, i := range kept {
+		if i >= len(request.RecentActions) {
+			result.RecentEpisodes = append(result.RecentEpisodes, episodes[i-len(request.RecentActions)])
+		}
+	}
+	return section, used, nil
+}
+
+// episodeCandidates fetches the project's episodes with their relevance to
+// the task, from an episode search for the task description, or when there is
+// none or it finds nothing, the most recent episodes by keyword overlap
+func (b *RAGContextBuilder) episodeCandidates(request *ContextRequest, keywords []string) ([]*Episode, map[string]float64, error) {
+	relevance := make(map[string]float64)
+
+	if query := strings.TrimSpace(request.TaskDescription); query != "" {
+		hits, err := b.learning.SearchEpisodes(query, EpisodeSearchOptions{
+			ProjectPath: request.ProjectPath,
+			Limit:       episodeCandidates,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to search episodes: %w", err)
+		}
+		if len(hits) > 0 {
+			episodes := make([]*Episode, len(hits))
+			for i, hit := range hits {
+				episodes[i] = hit.Episode
+				relevance[hit.Episode.ID] = hit.Score
+			}
+			return episodes, relevance, nil
+		}
+	}
+
+	episodes, err := b.learning.GetEpisodes(EpisodeFilter{ProjectPath: request.ProjectPath, Limit: episodeCandidates})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get episodes: %w", err)
+	}
+	for _, ep := range episodes {
+		relevance[ep.ID] = keywordOverlap(keywords, ep.Content+" "+ep.Context+" "+ep.Outcome)
+	}
+	return episodes, relevance, nil
+}
+
+// fillSection greedily packs items under a heading within tokens, truncating
+// an item that does not fit when enough room is left. It returns the section,
+// the tokens it uses and the indexes of the items included.
+func fillSection(heading string, items []string, tokens int) (string, int, []int) {
+	headingTokens := estimateTokens(heading) + 1
+	remaining := tokens - headingTokens
+	if len(items) == 0 || remaining < minItemTokens {
+		return "", 0, nil
+	}
+
+	var parts []string
+	var kept []int
+	for i, item := range items {
+		cost := estimateTokens(item) + 1
+		if cost > remaining {
+			if remaining < minItemTokens {
+				continue
+			}
+			item = truncateToTokens(item, remaining-1)
+			cost = estimateTokens(item) + 1
+		}
+		parts = append(parts, item)
+		kept = append(kept, i)
+		remaining -= cost
+	}
+
+	if len(parts) == 0 {
+		return "", 0, nil
+	}
+	section := heading + "\n" + strings.Join(parts, "\n")
+	return section, estimateTokens(section), kept
+}
+
+// truncateToTokens shortens text to roughly tokens, cutting at a line or word boundary
+func truncateToTokens(text string, tokens int) string {
+	const ellipsis = " …"
+	maxRunes := int(float64(tokens)*charsPerToken) - utf8.RuneCountInString(ellipsis)
+	runes := []rune(text)
+	if maxRunes <= 0 {
+		return ""
+	}
+	if len(runes) <= maxRunes {
+		return text
+	}
+
+	cut := string(runes[:maxRunes])
+	if i := strings.LastIndexAny(cut, "\n "); i > len(cut)/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " \n") + ellipsis
+}
+
+func renderProcedure(p *Procedure) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "### %s (succeeded %d of %d)", p.Name, p.SuccessCount, p.SuccessCount+p.FailureCount)
+	if p.Description != "" {
+		sb.WriteString("\n" + p.Description)
+	}
+	for _, pre := range p.Preconditions {
+		sb.WriteString("\n- Requires: " + pre)
+	}
+	for _, step := range p.Steps {
+		fmt.Fprintf(&sb, "\n%d. %s", step.Order, step.Action)
+		if step.Tool != "" {
+			fmt.Fprintf(&sb, " (%s)", step.Tool)
+		}
+		if step.Expected != "" {
+			sb.WriteString(" - expect: " + step.Expected)
+		}
+		if step.OnFailure != "" {
+			sb.WriteString(" - on failure: " + step.OnFailure)
+		}
+	}
+	return sb.String()
+}
+
+func renderKnowledge(k *Knowledge) string {
+	return fmt.Sprintf("### [%s] %s\n%s", k.Category, k.Title, k.Content)
+}
+
+func renderEpisode(ep *Episode) string {
+	line := fmt.Sprintf("- [%s %s] %s", ep.Timestamp.Format("2006-01-02"), ep.EventType, ep.Content)
+	if ep.Outcome != "" {
+		line += " -> " + ep.Outcome
+	}
+	return line
+}
+
+// successRate is the Laplace-smoothed success rate of a procedure
+func successRate(p *Procedure) float64 {
+	return float64(p.SuccessCount+1) / float64(p.SuccessCount+p.FailureCount+2)
+}
+
+// queryKeywords extracts distinct significant words, longest first
+func queryKeywords(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+
+	seen := make(map[string]bool)
+	var keywords []string
+	for _, w := range words {
+		if len(w) < 3 || stopWords[w] || seen[w] {
+			continue
+		}
+		seen[w] = true
+		keywords = append(keywords, w)
+	}
+
+	sort.SliceStable(keywords, func(i, j int) bool { return len(keywords[i]) > len(keywords[j]) })
+	if len(keywords) > maxQueryKeywords {
+		keywords = keywords[:maxQueryKeywords]
+	}
+	return keywords
+}
+
+// keywordOverlap is the fraction of keywords found in text
+func keywordOverlap(keywords []string, text string) float64 {
+	if len(keywords) == 0 {
+		return 0
+	}
+	lower := strings.ToLower(text)
+	matched := 0
+	for _, kw := range keywords {
+		if strings.Contains(lower, kw) {
+			matched++
+		}
+	}
+	return float64(matched) / float64(len(keywords))
+}
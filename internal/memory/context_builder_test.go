@@ -0,0 +1,185 @@
+package memory
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupTestLearningDB(t *testing.T) *SQLiteLearningDB {
+	t.Helper()
+
+	db, err := NewSQLiteLearningDB(filepath.Join(t.TempDir(), "learning.db"))
+	if err != nil {
+		t.Fatalf("Failed to create learning DB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestBuildContextSelectsRelevantMemory(t *testing.T) {
+	db := setupTestLearningDB(t)
+
+	db.StoreKnowledge(&Knowledge{
+		Category: "error_solution",
+		Title:    "SQLite database is locked",
+		Content:  "Set PRAGMA busy_timeout and use a single connection for writes.",
+	})
+	db.StoreKnowledge(&Knowledge{
+		Category: "domain",
+		Title:    "Dashboard colours",
+		Content:  "Agents use the colour from agents.yaml.",
+	})
+	db.StoreProcedure(&Procedure{
+		Name:         "Fix failing Go test",
+		TaskType:     "bugfix",
+		SuccessCount: 4,
+		Steps:        []Step{{Order: 1, Action: "Run go test ./...", Tool: "shell"}},
+	})
+	db.RecordEpisode(&Episode{
+		SessionID:   "s1",
+		AgentID:     "aider-1",
+		EventType:   "error",
+		Content:     "database is locked while writing transcript",
+		Outcome:     "added busy_timeout",
+		Importance:  0.9,
+		ProjectPath: "/work/cliairmonitor",
+	})
+
+	builder := NewRAGContextBuilder(db)
+	ctx, err := builder.BuildContext(&ContextRequest{
+		TaskDescription: "Fix the database locked error when recording transcripts",
+		TaskType:        "bugfix",
+		ProjectPath:     "/work/cliairmonitor",
+		RecentActions:   []string{"Opened internal/memory/operational.go"},
+	})
+	if err != nil {
+		t.Fatalf("BuildContext failed: %v", err)
+	}
+
+	if len(ctx.ApplicableProcedures) != 1 {
+		t.Errorf("Expected 1 procedure, got %d", len(ctx.ApplicableProcedures))
+	}
+	if len(ctx.RelevantKnowledge) == 0 || ctx.RelevantKnowledge[0].Title != "SQLite database is locked" {
+		t.Errorf("Expected the locked-database knowledge first, got %+v", ctx.RelevantKnowledge)
+	}
+	if len(ctx.RecentEpisodes) != 1 {
+		t.Errorf("Expected 1 episode, got %d", len(ctx.RecentEpisodes))
+	}
+
+	for _, want := range []string{"/work/cliairmonitor", "Fix failing Go test", "busy_timeout", "Opened internal/memory/operational.go"} {
+		if !strings.Contains(ctx.SystemPrompt, want) {
+			t.Errorf("Expected system prompt to contain %q:\n%s", want, ctx.SystemPrompt)
+		}
+	}
+	if ctx.TotalTokens != builder.EstimateTokens(ctx.SystemPrompt) {
+		t.Errorf("TotalTokens %d does not match the prompt estimate", ctx.TotalTokens)
+	}
+}
+
+func TestBuildContextEpisodesStayInProject(t *testing.T) {
+	db := setupTestLearningDB(t)
+
+	for _, project := range []string{"/work/alpha", "/work/beta"} {
+		db.RecordEpisode(&Episode{
+			SessionID:   "s1",
+			AgentID:     "aider-1",
+			EventType:   "error",
+			Content:     "migration failed on " + project,
+			Importance:  0.8,
+			ProjectPath: project,
+		})
+		db.RecordEpisode(&Episode{
+			SessionID:   "s1",
+			AgentID:     "aider-1",
+			EventType:   "action",
+			Content:     "edited the readme in " + project,
+			Importance:  0.3,
+			ProjectPath: project,
+		})
+	}
+
+	builder := NewRAGContextBuilder(db)
+	for _, request := range []*ContextRequest{
+		{TaskDescription: "Fix the failed migration", ProjectPath: "/work/alpha"},
+		{ProjectPath: "/work/alpha"}, // no query: most recent episodes
+	} {
+		ctx, err := builder.BuildContext(request)
+		if err != nil {
+			t.Fatalf("BuildContext failed: %v", err)
+		}
+		if len(ctx.RecentEpisodes) == 0 {
+			t.Fatalf("Expected episodes for %q", request.TaskDescription)
+		}
+		for _, ep := range ctx.RecentEpisodes {
+			if ep.ProjectPath != "/work/alpha" {
+				t.Errorf("Episode from another project in context: %q", ep.Content)
+			}
+		}
+		if request.TaskDescription != "" && !strings.Contains(ctx.RecentEpisodes[0].Content, "migration") {
+			t.Errorf("Expected the migration episode first, got %q", ctx.RecentEpisodes[0].Content)
+		}
+	}
+}
+
+func TestBuildContextRespectsBudget(t *testing.T) {
+	db := setupTestLearningDB(t)
+
+	for i := 0; i < 30; i++ {
+		db.StoreKnowledge(&Knowledge{
+			Category: "code_pattern",
+			Title:    fmt.Sprintf("Retry pattern %d", i),
+			Content:  strings.Repeat("Wrap NATS requests in a retry loop with backoff. ", 20),
+		})
+		db.RecordEpisode(&Episode{
+			SessionID:  "s1",
+			AgentID:    "aider-1",
+			EventType:  "action",
+			Content:    strings.Repeat("retried a NATS request ", 10),
+			Timestamp:  time.Now().Add(-time.Duration(i) * time.Hour),
+			Importance: 0.5,
+		})
+	}
+
+	builder := NewRAGContextBuilder(db)
+	builder.SetBudget(&ContextBudget{
+		MaxTokens:        4000,
+		ReservedForTask:  3000,
+		MemoryBudget:     1000,
+		EpisodicWeight:   0.3,
+		SemanticWeight:   0.5,
+		ProceduralWeight: 0.2,
+	})
+
+	ctx, err := builder.BuildContext(&ContextRequest{TaskDescription: "Add retry to NATS requests"})
+	if err != nil {
+		t.Fatalf("BuildContext failed: %v", err)
+	}
+
+	if ctx.TotalTokens > 1000 {
+		t.Errorf("Context uses %d tokens, over the 1000 token budget", ctx.TotalTokens)
+	}
+	if len(ctx.RelevantKnowledge) == 0 || len(ctx.RecentEpisodes) == 0 {
+		t.Errorf("Expected both knowledge and episodes, got %d and %d", len(ctx.RelevantKnowledge), len(ctx.RecentEpisodes))
+	}
+	if len(ctx.RelevantKnowledge) == 30 {
+		t.Error("Expected knowledge to be truncated to the budget")
+	}
+}
+
+func TestTruncateToTokens(t *testing.T) {
+	text := strings.Repeat("word ", 100)
+	truncated := truncateToTokens(text, 20)
+
+	if estimateTokens(truncated) > 20 {
+		t.Errorf("Truncated text is %d tokens, want at most 20", estimateTokens(truncated))
+	}
+	if !strings.HasSuffix(truncated, "…") {
+		t.Errorf("Expected ellipsis, got %q", truncated)
+	}
+	if truncateToTokens("short", 20) != "short" {
+		t.Error("Short text should be unchanged")
+	}
+}
@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sqliteEmbeddingCache persists embeddings in learning.db so restarts and
+// re-embedding unchanged text do not call the model server again
+type sqliteEmbeddingCache struct {
+	s *SQLiteLearningDB
+}
+
+// EmbeddingCache returns a cache backed by the database, for NewCachingEmbedder
+func (s *SQLiteLearningDB) EmbeddingCache() EmbeddingCache {
+	return &sqliteEmbeddingCache{s: s}
+}
+
+// GetEmbeddings returns the cached embeddings found for keys
+func (c *sqliteEmbeddingCache) GetEmbeddings(keys []string) (map[string][]float32, error) {
+	found := make(map[string][]float32, len(keys))
+	// Stay well under SQLite's bound parameter limit
+	for start := 0; start < len(keys); start += 500 {
+		chunk := keys[start:min(start+500, len(keys))]
+		args := make([]interface{}, len(chunk))
+		for i, key := range chunk {
+			args[i] = key
+		}
+
+		query := "SELECT key, embedding FROM embedding_cache WHERE key IN (?" + strings.Repeat(", ?", len(chunk)-1) + ")"
+		rows, err := c.s.db.Query(query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read embedding cache: %w", err)
+		}
+		for rows.Next() {
+			var key string
+			var blob []byte
+			if err := rows.Scan(&key, &blob); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan cached embedding: %w", err)
+			}
+			if embedding := decodeEmbedding(blob); len(embedding) > 0 {
+				found[key] = embedding
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error iterating embedding cache: %w", err)
+		}
+	}
+	return found, nil
+}
+
+// PutEmbeddings stores embeddings from model under their keys
+func (c *sqliteEmbeddingCache) PutEmbeddings(model string, entries map[string][]float32) error {
+	tx, err := c.s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("INSERT OR REPLACE INTO embedding_cache (key, model, embedding, dims) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare embedding cache insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for key, embedding := range entries {
+		if len(embedding) == 0 {
+			continue
+		}
+		if _, err := stmt.Exec(key, model, encodeEmbedding(embedding), len(embedding)); err != nil {
+			return fmt.Errorf("failed to cache embedding: %w", err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,232 @@
+package memory
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Decorators wrap any EmbeddingProvider and can be stacked, e.g. a cache in
+// front of retries in front of LM Studio:
+//
+//	NewCachingEmbedder(NewRetryingEmbedder(NewLMStudioEmbedding(url, model), DefaultRetryPolicy), cache)
+
+// embedContext calls the provider's cancellable Embed when it has one
+func embedContext(ctx context.Context, provider EmbeddingProvider, text string) ([]float32, error) {
+	if p, ok := provider.(ContextEmbeddingProvider); ok {
+		return p.EmbedContext(ctx, text)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return provider.Embed(text)
+}
+
+// embedBatchContext calls the provider's cancellable EmbedBatch when it has one
+func embedBatchContext(ctx context.Context, provider EmbeddingProvider, texts []string) ([][]float32, error) {
+	if p, ok := provider.(ContextEmbeddingProvider); ok {
+		return p.EmbedBatchContext(ctx, texts)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return provider.EmbedBatch(texts)
+}
+
+// ================================================
+// Retries
+// ================================================
+
+// RetryPolicy controls how RetryingEmbedder retries failed calls
+type RetryPolicy struct {
+	Attempts       int           // total tries, including the first
+	InitialBackoff time.Duration // wait before the first retry, doubled after each
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy rides out a model server that is loading or briefly busy
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:       4,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+}
+
+// RetryingEmbedder retries calls to the wrapped provider with exponential
+// backoff. Errors that report Temporary() == false, such as a 400 from the
+// API, and cancellation are returned at once.
+type RetryingEmbedder struct {
+	inner  EmbeddingProvider
+	policy RetryPolicy
+}
+
+// NewRetryingEmbedder wraps inner with retries
+func NewRetryingEmbedder(inner EmbeddingProvider, policy RetryPolicy) *RetryingEmbedder {
+	if policy.Attempts < 1 {
+		policy.Attempts = 1
+	}
+	return &RetryingEmbedder{inner: inner, policy: policy}
+}
+
+func (r *RetryingEmbedder) Embed(text string) ([]float32, error) {
+	return r.EmbedContext(context.Background(), text)
+}
+
+func (r *RetryingEmbedder) EmbedContext(ctx context.Context, text string) ([]float32, error) {
+	var embedding []float32
+	err := r.retry(ctx, func() (err error) {
+		embedding, err = embedContext(ctx, r.inner, text)
+		return err
+	})
+	return embedding, err
+}
+
+func (r *RetryingEmbedder) EmbedBatch(texts []string) ([][]float32, error) {
+	return r.EmbedBatchContext(context.Background(), texts)
+}
+
+func (r *RetryingEmbedder) EmbedBatchContext(ctx context.Context, texts []string) ([][]float32, error) {
+	var embeddings [][]float32
+	err := r.retry(ctx, func() (err error) {
+		embeddings, err = embedBatchContext(ctx, r.inner, texts)
+		return err
+	})
+	return embeddings, err
+}
+
+func (r *RetryingEmbedder) Dimensions() int { return r.inner.Dimensions() }
+func (r *RetryingEmbedder) Model() string   { return r.inner.Model() }
+
+// retry runs call until it succeeds, fails permanently or runs out of attempts
+func (r *RetryingEmbedder) retry(ctx context.Context, call func() error) error {
+	backoff := r.policy.InitialBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = call(); err == nil || attempt == r.policy.Attempts || !retryable(ctx, err) {
+			break
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, r.policy.MaxBackoff)
+	}
+	if err != nil && r.policy.Attempts > 1 && retryable(ctx, err) {
+		return fmt.Errorf("embedding failed after %d attempts: %w", r.policy.Attempts, err)
+	}
+	return err
+}
+
+// retryable reports whether err may go away on another try
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) {
+		return temporary.Temporary()
+	}
+	return true
+}
+
+// ================================================
+// Caching
+// ================================================
+
+// CachingEmbedder serves embeddings from a cache keyed by a hash of the
+// model and text, calling the wrapped provider only for misses. Cache
+// errors are not fatal; the provider is asked instead.
+type CachingEmbedder struct {
+	inner EmbeddingProvider
+	cache EmbeddingCache
+}
+
+// NewCachingEmbedder wraps inner with cache, e.g. SQLiteLearningDB.EmbeddingCache()
+func NewCachingEmbedder(inner EmbeddingProvider, cache EmbeddingCache) *CachingEmbedder {
+	return &CachingEmbedder{inner: inner, cache: cache}
+}
+
+// EmbeddingCacheKey identifies the embedding of text by model
+func EmbeddingCacheKey(model, text string) string {
+	sum := sha256.Sum256([]byte(model + "\x00" + text))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *CachingEmbedder) Embed(text string) ([]float32, error) {
+	return c.EmbedContext(context.Background(), text)
+}
+
+func (c *CachingEmbedder) EmbedContext(ctx context.Context, text string) ([]float32, error) {
+	embeddings, err := c.EmbedBatchContext(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return embeddings[0], nil
+}
+
+func (c *CachingEmbedder) EmbedBatch(texts []string) ([][]float32, error) {
+	return c.EmbedBatchContext(context.Background(), texts)
+}
+
+func (c *CachingEmbedder) EmbedBatchContext(ctx context.Context, texts []string) ([][]float32, error) {
+	model := c.inner.Model()
+	keys := make([]string, len(texts))
+	for i, text := range texts {
+		keys[i] = EmbeddingCacheKey(model, text)
+	}
+
+	cached, err := c.cache.GetEmbeddings(keys)
+	if err != nil {
+		cached = nil
+	}
+
+	// Embed each distinct missing text once
+	var missTexts, missKeys []string
+	seen := make(map[string]bool)
+	for i, key := range keys {
+		if _, ok := cached[key]; ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+		missTexts = append(missTexts, texts[i])
+		missKeys = append(missKeys, key)
+	}
+
+	if len(missTexts) > 0 {
+		embedded, err := embedBatchContext(ctx, c.inner, missTexts)
+		if err != nil {
+			return nil, err
+		}
+		if len(embedded) != len(missTexts) {
+			return nil, fmt.Errorf("provider returned %d embeddings for %d texts", len(embedded), len(missTexts))
+		}
+
+		fresh := make(map[string][]float32, len(missKeys))
+		for i, key := range missKeys {
+			fresh[key] = embedded[i]
+		}
+		c.cache.PutEmbeddings(model, fresh)
+
+		if cached == nil {
+			cached = make(map[string][]float32, len(fresh))
+		}
+		for key, embedding := range fresh {
+			cached[key] = embedding
+		}
+	}
+
+	results := make([][]float32, len(texts))
+	for i, key := range keys {
+		results[i] = cached[key]
+	}
+	return results, nil
+}
+
+func (c *CachingEmbedder) Dimensions() int { return c.inner.Dimensions() }
+func (c *CachingEmbedder) Model() string   { return c.inner.Model() }
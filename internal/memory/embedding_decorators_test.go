@@ -0,0 +1,129 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakyEmbedder fails with errs in turn before embedding like inner
+type flakyEmbedder struct {
+	inner EmbeddingProvider
+	errs  []error
+	calls int
+	texts []string // every text asked for
+}
+
+func (e *flakyEmbedder) Embed(text string) ([]float32, error) {
+	embeddings, err := e.EmbedBatch([]string{text})
+	if err != nil {
+		return nil, err
+	}
+	return embeddings[0], nil
+}
+
+func (e *flakyEmbedder) EmbedBatch(texts []string) ([][]float32, error) {
+	e.calls++
+	if len(e.errs) > 0 {
+		err := e.errs[0]
+		e.errs = e.errs[1:]
+		return nil, err
+	}
+	e.texts = append(e.texts, texts...)
+	return e.inner.EmbedBatch(texts)
+}
+
+func (e *flakyEmbedder) Dimensions() int { return e.inner.Dimensions() }
+func (e *flakyEmbedder) Model() string   { return e.inner.Model() }
+
+var fastRetries = RetryPolicy{Attempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+func TestRetryingEmbedder(t *testing.T) {
+	busy := &EmbeddingAPIError{StatusCode: 503, Status: "503 Service Unavailable"}
+	bad := &EmbeddingAPIError{StatusCode: 400, Status: "400 Bad Request"}
+
+	flaky := &flakyEmbedder{inner: &vocabEmbedder{vocab: []string{"lock"}}, errs: []error{busy, busy}}
+	if _, err := NewRetryingEmbedder(flaky, fastRetries).Embed("lock"); err != nil || flaky.calls != 3 {
+		t.Errorf("Expected success on the third try, got %v after %d calls", err, flaky.calls)
+	}
+
+	flaky = &flakyEmbedder{inner: &vocabEmbedder{}, errs: []error{busy, busy, busy}}
+	if _, err := NewRetryingEmbedder(flaky, fastRetries).Embed("lock"); !errors.Is(err, busy) || flaky.calls != 3 {
+		t.Errorf("Expected to give up after 3 tries, got %v after %d calls", err, flaky.calls)
+	}
+
+	flaky = &flakyEmbedder{inner: &vocabEmbedder{}, errs: []error{bad}}
+	if _, err := NewRetryingEmbedder(flaky, fastRetries).Embed("lock"); !errors.Is(err, bad) || flaky.calls != 1 {
+		t.Errorf("Expected no retry of a bad request, got %v after %d calls", err, flaky.calls)
+	}
+}
+
+func TestRetryingEmbedderCancel(t *testing.T) {
+	flaky := &flakyEmbedder{inner: &vocabEmbedder{}, errs: []error{errors.New("connection refused")}}
+	retrying := NewRetryingEmbedder(flaky, RetryPolicy{Attempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := retrying.EmbedBatchContext(ctx, []string{"lock"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to end the backoff, got %v", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("Cancelled backoff took %v", took)
+	}
+}
+
+func TestCachingEmbedder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "learning.db")
+	db, err := NewSQLiteLearningDB(path)
+	if err != nil {
+		t.Fatalf("Failed to create learning DB: %v", err)
+	}
+
+	inner := &flakyEmbedder{inner: &vocabEmbedder{vocab: []string{"lock", "edit"}}}
+	caching := NewCachingEmbedder(inner, db.EmbeddingCache())
+
+	// Duplicates within a batch are embedded once
+	embeddings, err := caching.EmbedBatch([]string{"lock", "edit", "lock"})
+	if err != nil {
+		t.Fatalf("EmbedBatch failed: %v", err)
+	}
+	if len(embeddings) != 3 || embeddings[0][0] != 1 || embeddings[1][1] != 1 || embeddings[2][0] != 1 {
+		t.Errorf("Unexpected embeddings: %v", embeddings)
+	}
+	if got := strings.Join(inner.texts, ","); got != "lock,edit" {
+		t.Errorf("Expected lock and edit to be embedded once, got %s", got)
+	}
+
+	// Survives a reopen; only the new text reaches the provider
+	db.Close()
+	db, err = NewSQLiteLearningDB(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen learning DB: %v", err)
+	}
+	defer db.Close()
+
+	inner.texts = nil
+	caching = NewCachingEmbedder(inner, db.EmbeddingCache())
+	if _, err := caching.EmbedBatch([]string{"edit", "lock edit"}); err != nil {
+		t.Fatalf("EmbedBatch failed: %v", err)
+	}
+	if got := strings.Join(inner.texts, ","); got != "lock edit" {
+		t.Errorf("Expected only the uncached text to be embedded, got %s", got)
+	}
+
+	// Another model does not share entries
+	inner.texts = nil
+	caching = NewCachingEmbedder(&flakyEmbedder{inner: &vocabEmbedder{vocab: []string{"lock"}, model: "test/other"}}, db.EmbeddingCache())
+	if _, err := caching.Embed("lock"); err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	var count int
+	db.db.QueryRow("SELECT COUNT(*) FROM embedding_cache").Scan(&count)
+	if count != 4 {
+		t.Errorf("Expected 4 cached embeddings, got %d", count)
+	}
+}
@@ -0,0 +1,432 @@
+package memory
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// embedBatch is how many texts are embedded per provider call
+const embedBatch = 32
+
+// maxEmbedText caps the text embedded per item: a knowledge item longer than
+// the model's context fails to embed, and long check output in an episode's
+// context adds little beyond its first lines
+const maxEmbedText = 2000
+
+// maxEmbedFailureStreak is how many items in a row may fail to embed on their
+// own, with none succeeding, before the job takes the provider to be down
+const maxEmbedFailureStreak = 3
+
+// SetEmbedRate limits background embedding to perSecond texts, so that
+// re-embedding a large corpus does not starve agents of the model server.
+// 0 removes the limit. Searches and StoreKnowledge are not limited.
+func (s *SQLiteLearningDB) SetEmbedRate(perSecond float64) {
+	s.jobMu.Lock()
+	defer s.jobMu.Unlock()
+	s.embedRate = max(perSecond, 0)
+}
+
+// EmbeddingStatus reports progress of the background embedding job
+func (s *SQLiteLearningDB) EmbeddingStatus() EmbeddingJobStatus {
+	s.jobMu.Lock()
+	status := s.job
+	s.jobMu.Unlock()
+
+	if status.Model == "" {
+		status.Model = s.activeModel()
+	}
+	status.Remaining = max(status.Total-status.Done-status.Failed, 0)
+	return status
+}
+
+// runEmbedder embeds knowledge and episodes lacking an embedding from the
+// active model each time it is woken, until the database is closed
+func (s *SQLiteLearningDB) runEmbedder() {
+	defer close(s.embedderDone)
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.embedWake:
+			s.embedPending()
+		}
+	}
+}
+
+// wakeEmbedder schedules an embedding run without blocking
+func (s *SQLiteLearningDB) wakeEmbedder() {
+	select {
+	case s.embedWake <- struct{}{}:
+	default:
+	}
+}
+
+// embedPending embeds everything stale for the active model, knowledge
+// first, a batch at a time within the rate limit. A batch the provider
+// rejects is embedded one item at a time, so a single item it cannot embed
+// does not hold up the rest; such items are skipped until the model changes.
+// Stops when nothing is left, when items keep failing with none succeeding
+// (the provider is taken to be down and the next wake retries), when the
+// model changes (the change wakes a new run) or when the database is closing.
+func (s *SQLiteLearningDB) embedPending() {
+	provider := s.provider()
+	if provider == nil {
+		return
+	}
+	model := provider.Model()
+
+	total, err := s.countStaleEmbeddings(model)
+	if err != nil {
+		log.Printf("[MEMORY] Embedding job failed: %v", err)
+		return
+	}
+	// Items earlier runs failed on are left out, but still reported
+	skipped := len(s.embedFailures(model))
+	if total <= skipped {
+		return
+	}
+
+	now := time.Now()
+	s.jobMu.Lock()
+	s.job = EmbeddingJobStatus{Model: model, Running: true, Total: total, Failed: skipped, StartedAt: &now}
+	s.jobMu.Unlock()
+	log.Printf("[MEMORY] Embedding %d items with %s", total, model)
+
+	// Items that failed since the last success; they only count against
+	// the items once the provider is seen to work
+	var unconfirmed []string
+	var itemErr error
+	for {
+		if s.activeModel() != model {
+			s.finishJob(nil)
+			return
+		}
+
+		n, failed, err := s.embedNextBatch(provider, model, unconfirmed)
+		if err != nil && s.ctx.Err() != nil {
+			s.finishJob(nil)
+			return
+		}
+		if err != nil && len(failed) == 0 {
+			log.Printf("[MEMORY] Embedding job paused: %v", err)
+			s.finishJob(err)
+			return
+		}
+		if n == 0 && len(failed) == 0 {
+			status := s.finishJob(itemErr)
+			log.Printf("[MEMORY] Embedding job finished: %d items with %s, %d failed", status.Done, model, status.Failed)
+			return
+		}
+
+		unconfirmed = append(unconfirmed, failed...)
+		if len(failed) > 0 {
+			itemErr = err
+			log.Printf("[MEMORY] Failed to embed %d items, skipping them: %v", len(failed), err)
+		}
+		if n > 0 {
+			s.recordEmbedFailures(model, unconfirmed)
+			unconfirmed = nil
+		}
+
+		s.jobMu.Lock()
+		s.job.Done += n
+		s.job.Failed += len(failed)
+		s.job.Total = max(s.job.Total, s.job.Done+s.job.Failed)
+		s.jobMu.Unlock()
+
+		if len(unconfirmed) >= maxEmbedFailureStreak {
+			log.Printf("[MEMORY] Embedding job paused: %v", err)
+			s.jobMu.Lock()
+			s.job.Failed -= len(unconfirmed)
+			s.jobMu.Unlock()
+			s.finishJob(err)
+			return
+		}
+		if !s.throttle(n + len(failed)) {
+			s.finishJob(nil)
+			return
+		}
+	}
+}
+
+// finishJob marks the running job stopped and returns its final status
+func (s *SQLiteLearningDB) finishJob(err error) EmbeddingJobStatus {
+	now := time.Now()
+	s.jobMu.Lock()
+	defer s.jobMu.Unlock()
+	s.job.Running = false
+	s.job.FinishedAt = &now
+	if err != nil {
+		s.job.LastError = err.Error()
+	}
+	return s.job
+}
+
+// throttle waits long enough for n texts to respect the rate limit.
+// Returns false when the database is closing.
+func (s *SQLiteLearningDB) throttle(n int) bool {
+	s.jobMu.Lock()
+	rate := s.embedRate
+	s.jobMu.Unlock()
+
+	if rate <= 0 {
+		select {
+		case <-s.ctx.Done():
+			return false
+		default:
+			return true
+		}
+	}
+
+	timer := time.NewTimer(time.Duration(float64(n) / rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-s.ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// countStaleEmbeddings counts knowledge and episodes without an embedding
+// from model
+func (s *SQLiteLearningDB) countStaleEmbeddings(model string) (int, error) {
+	var total int
+	for _, table := range []string{"knowledge", "episodes"} {
+		var count int
+		query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE COALESCE(embedding_model, '') != ?", table)
+		if err := s.db.QueryRow(query, model).Scan(&count); err != nil {
+			return 0, fmt.Errorf("failed to count stale %s embeddings: %w", table, err)
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// embedNextBatch embeds one batch of stale knowledge, or of episodes once
+// knowledge is done, leaving out recorded failures and skip. It returns how
+// many items it embedded and which failed on their own, with the last of
+// their errors; an error with no failed items stops the job.
+func (s *SQLiteLearningDB) embedNextBatch(provider EmbeddingProvider, model string, skip []string) (int, []string, error) {
+	skip = append(s.embedFailures(model), skip...)
+	table := "knowledge"
+	ids, texts, err := s.staleKnowledge(model, embedBatch, skip)
+	if err == nil && len(ids) == 0 {
+		table = "episodes"
+		ids, texts, err = s.staleEpisodes(model, embedBatch, skip)
+	}
+	if err != nil || len(ids) == 0 {
+		return 0, nil, err
+	}
+
+	embeddings, err := embedBatchContext(s.ctx, provider, texts)
+	if err == nil && len(embeddings) != len(ids) {
+		err = fmt.Errorf("provider returned %d embeddings for %d texts", len(embeddings), len(ids))
+	}
+	if err != nil {
+		if s.ctx.Err() != nil {
+			return 0, nil, err
+		}
+		return s.embedEach(provider, model, table, ids, texts)
+	}
+	if err := s.storeEmbeddings(table, model, ids, embeddings); err != nil {
+		return 0, nil, err
+	}
+	return len(ids), nil, nil
+}
+
+// embedEach embeds a batch the provider rejected one item at a time, giving
+// up once maxEmbedFailureStreak items fail before any succeeds
+func (s *SQLiteLearningDB) embedEach(provider EmbeddingProvider, model, table string, ids, texts []string) (int, []string, error) {
+	var embedded int
+	var failed []string
+	var itemErr error
+	for i, id := range ids {
+		embeddings, err := embedBatchContext(s.ctx, provider, texts[i:i+1])
+		if err == nil && len(embeddings) != 1 {
+			err = fmt.Errorf("provider returned %d embeddings for 1 text", len(embeddings))
+		}
+		if err == nil && len(embeddings[0]) == 0 {
+			err = fmt.Errorf("provider returned an empty embedding for %s %s", table, id)
+		}
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return embedded, nil, err
+			}
+			failed = append(failed, id)
+			itemErr = err
+			if embedded == 0 && len(failed) == maxEmbedFailureStreak {
+				break
+			}
+			continue
+		}
+		if err := s.storeEmbeddings(table, model, ids[i:i+1], embeddings); err != nil {
+			return embedded, nil, err
+		}
+		embedded++
+	}
+	return embedded, failed, itemErr
+}
+
+// embedFailures returns the items recorded as failing to embed with model
+func (s *SQLiteLearningDB) embedFailures(model string) []string {
+	s.jobMu.Lock()
+	defer s.jobMu.Unlock()
+	var ids []string
+	for id, failedModel := range s.embedFailed {
+		if failedModel == model {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// recordEmbedFailures records items that failed to embed with model while
+// the provider worked, so later runs skip them
+func (s *SQLiteLearningDB) recordEmbedFailures(model string, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	s.jobMu.Lock()
+	defer s.jobMu.Unlock()
+	if s.embedFailed == nil {
+		s.embedFailed = make(map[string]string)
+	}
+	for id, failedModel := range s.embedFailed {
+		if failedModel != model {
+			delete(s.embedFailed, id)
+		}
+	}
+	for _, id := range ids {
+		s.embedFailed[id] = model
+	}
+}
+
+// staleKnowledge returns up to limit knowledge items to embed with model,
+// most used first, leaving out skip
+func (s *SQLiteLearningDB) staleKnowledge(model string, limit int, skip []string) ([]string, []string, error) {
+	notIn, args := notInIDs(skip)
+	rows, err := s.db.Query(`
+		SELECT id, content FROM knowledge
+		WHERE COALESCE(embedding_model, '') != ?`+notIn+`
+		ORDER BY use_count DESC, created_at DESC
+		LIMIT ?
+	`, append(append([]interface{}{model}, args...), limit)...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query stale knowledge: %w", err)
+	}
+	defer rows.Close()
+
+	var ids, texts []string
+	for rows.Next() {
+		var id, content string
+		if err := rows.Scan(&id, &content); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan knowledge: %w", err)
+		}
+		ids = append(ids, id)
+		texts = append(texts, embedText(content))
+	}
+	return ids, texts, rows.Err()
+}
+
+// staleEpisodes returns up to limit episodes to embed with model, newest
+// first, leaving out skip
+func (s *SQLiteLearningDB) staleEpisodes(model string, limit int, skip []string) ([]string, []string, error) {
+	notIn, args := notInIDs(skip)
+	rows, err := s.db.Query(`
+		SELECT id, content, context, outcome FROM episodes
+		WHERE COALESCE(embedding_model, '') != ?`+notIn+`
+		ORDER BY timestamp DESC
+		LIMIT ?
+	`, append(append([]interface{}{model}, args...), limit)...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query stale episodes: %w", err)
+	}
+	defer rows.Close()
+
+	var ids, texts []string
+	for rows.Next() {
+		ep := &Episode{}
+		var context, outcome sql.NullString
+		if err := rows.Scan(&ep.ID, &ep.Content, &context, &outcome); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan episode: %w", err)
+		}
+		ep.Context = context.String
+		ep.Outcome = outcome.String
+		ids = append(ids, ep.ID)
+		texts = append(texts, episodeEmbedText(ep))
+	}
+	return ids, texts, rows.Err()
+}
+
+// notInIDs renders a condition leaving out ids, with its arguments
+func notInIDs(ids []string) (string, []interface{}) {
+	if len(ids) == 0 {
+		return "", nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return " AND id NOT IN (?" + strings.Repeat(", ?", len(ids)-1) + ")", args
+}
+
+// storeEmbeddings saves a batch of embeddings from model into table and
+// indexes them
+func (s *SQLiteLearningDB) storeEmbeddings(table, model string, ids []string, embeddings [][]float32) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(fmt.Sprintf("UPDATE %s SET embedding = ?, embedding_model = ?, embedding_dims = ? WHERE id = ?", table))
+	if err != nil {
+		return fmt.Errorf("failed to prepare %s embedding update: %w", table, err)
+	}
+	defer stmt.Close()
+
+	for i, id := range ids {
+		if len(embeddings[i]) == 0 {
+			return fmt.Errorf("provider returned an empty embedding for %s %s", table, id)
+		}
+		if _, err := stmt.Exec(encodeEmbedding(embeddings[i]), model, len(embeddings[i]), id); err != nil {
+			return fmt.Errorf("failed to store embedding for %s %s: %w", table, id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit %s embeddings: %w", table, err)
+	}
+
+	for i, id := range ids {
+		if table == "knowledge" {
+			s.indexKnowledge(&Knowledge{ID: id, Embedding: embeddings[i], EmbeddingModel: model})
+		} else {
+			s.indexEpisode(&Episode{ID: id, Embedding: embeddings[i], EmbeddingModel: model})
+		}
+	}
+	return nil
+}
+
+// episodeEmbedText is the text an episode is embedded from
+func episodeEmbedText(ep *Episode) string {
+	parts := []string{ep.Content}
+	if ep.Outcome != "" {
+		parts = append(parts, ep.Outcome)
+	}
+	if ep.Context != "" {
+		parts = append(parts, ep.Context)
+	}
+	return embedText(strings.Join(parts, "\n"))
+}
+
+// embedText caps text at maxEmbedText bytes without splitting a character
+func embedText(text string) string {
+	if len(text) > maxEmbedText {
+		text = strings.ToValidUTF8(text[:maxEmbedText], "")
+	}
+	return text
+}
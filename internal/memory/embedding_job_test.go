@@ -0,0 +1,198 @@
+package memory
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForEmbeddingJob blocks until nothing is left to embed with the active
+// model and the job has stopped
+func waitForEmbeddingJob(t *testing.T, db *SQLiteLearningDB) EmbeddingJobStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stale, err := db.countStaleEmbeddings(db.activeModel())
+		if err != nil {
+			t.Fatalf("Failed to count stale embeddings: %v", err)
+		}
+		status := db.EmbeddingStatus()
+		if stale == 0 && !status.Running {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Embedding job did not finish: %d stale, %+v", stale, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// embeddingModelOf reads how a row's embedding is tagged
+func embeddingModelOf(t *testing.T, db *SQLiteLearningDB, table, id string) (string, int) {
+	t.Helper()
+	var model string
+	var dims int
+	err := db.db.QueryRow("SELECT COALESCE(embedding_model, ''), COALESCE(embedding_dims, 0) FROM "+table+" WHERE id = ?", id).Scan(&model, &dims)
+	if err != nil {
+		t.Fatalf("Failed to read %s %s: %v", table, id, err)
+	}
+	return model, dims
+}
+
+func TestModelChangeReembedsCorpus(t *testing.T) {
+	db := setupTestLearningDB(t)
+
+	// Stored before any provider: untagged
+	k := &Knowledge{Category: "error_solution", Title: "Busy", Content: "lock timeout", Embedding: []float32{1, 0, 0}}
+	db.StoreKnowledge(k)
+	ep := &Episode{SessionID: "s1", AgentID: "a1", EventType: "error", Content: "edit failed"}
+	db.RecordEpisode(ep)
+	if results, _ := db.SearchByEmbedding([]float32{1, 0, 0}, 1); len(results) != 1 {
+		t.Fatalf("Expected the untagged embedding to be searchable without a provider, got %d", len(results))
+	}
+
+	a := &vocabEmbedder{vocab: []string{"lock", "edit"}, model: "test/a"}
+	db.SetEmbeddingProvider(a)
+	status := waitForEmbeddingJob(t, db)
+	if status.Model != "test/a" || status.Total != 2 || status.Done != 2 || status.Remaining != 0 || status.LastError != "" {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+	if model, dims := embeddingModelOf(t, db, "knowledge", k.ID); model != "test/a" || dims != 3 {
+		t.Errorf("Knowledge tagged %q/%d, want test/a/3", model, dims)
+	}
+	if model, _ := embeddingModelOf(t, db, "episodes", ep.ID); model != "test/a" {
+		t.Errorf("Episode tagged %q, want test/a", model)
+	}
+	if results, _ := db.SearchKnowledge("lock", 1); len(results) != 1 || results[0].EmbeddingModel != "test/a" {
+		t.Errorf("Expected the re-embedded item, got %+v", results)
+	}
+
+	// A new model that cannot embed yet: old vectors are no longer compared
+	b := &vocabEmbedder{vocab: []string{"lock", "edit", "cache"}, model: "test/b", fail: true}
+	db.SetEmbeddingProvider(b)
+	status = db.EmbeddingStatus()
+	for deadline := time.Now().Add(5 * time.Second); status.LastError == "" && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		status = db.EmbeddingStatus()
+	}
+	if status.LastError == "" || status.Running {
+		t.Errorf("Expected the job to pause on the provider error, got %+v", status)
+	}
+	if results, _ := db.SearchByEmbedding([]float32{1, 0, 0.1}, 1); len(results) != 0 {
+		t.Errorf("Searched vectors from another model: %+v", results)
+	}
+	hits, _ := db.SearchEpisodes("edit", EpisodeSearchOptions{})
+	if len(hits) != 1 || len(hits[0].MatchedBy) != 1 || hits[0].MatchedBy[0] != MatchedByKeyword {
+		t.Errorf("Expected a keyword-only episode hit, got %+v", hits)
+	}
+
+	// Once the provider recovers, setting it again resumes the job
+	b.fail = false
+	db.SetEmbeddingProvider(b)
+	waitForEmbeddingJob(t, db)
+	if model, dims := embeddingModelOf(t, db, "knowledge", k.ID); model != "test/b" || dims != 4 {
+		t.Errorf("Knowledge tagged %q/%d, want test/b/4", model, dims)
+	}
+	if results, _ := db.SearchByEmbedding([]float32{1, 0, 0, 0.1}, 1); len(results) != 1 || results[0].ID != k.ID {
+		t.Errorf("Expected the item under the new model, got %+v", results)
+	}
+}
+
+// waitForJobRun blocks until an embedding job has run and stopped
+func waitForJobRun(t *testing.T, db *SQLiteLearningDB) EmbeddingJobStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := db.EmbeddingStatus()
+		if status.FinishedAt != nil && !status.Running {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Embedding job did not finish: %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEmbeddingJobSkipsPoisonItem(t *testing.T) {
+	db := setupTestLearningDB(t)
+
+	// Most used, so first in every batch of knowledge
+	poison := &Knowledge{Category: "domain", Title: "Poison", Content: "poison pill", UseCount: 10}
+	db.StoreKnowledge(poison)
+	long := &Knowledge{Category: "domain", Title: "Long", Content: strings.Repeat("lock wait ", 1000)}
+	db.StoreKnowledge(long)
+	db.StoreKnowledge(&Knowledge{Category: "domain", Title: "Short", Content: "lock"})
+	db.RecordEpisode(&Episode{SessionID: "s1", AgentID: "a1", EventType: "error", Content: "lock wait exceeded"})
+
+	db.SetEmbeddingProvider(&vocabEmbedder{vocab: []string{"lock"}, poison: "poison", maxText: maxEmbedText})
+	status := waitForJobRun(t, db)
+	if status.Done != 3 || status.Failed != 1 || status.Remaining != 0 || !strings.Contains(status.LastError, "rejected") {
+		t.Fatalf("Expected all but the poison item embedded, got %+v", status)
+	}
+	if model, _ := embeddingModelOf(t, db, "knowledge", poison.ID); model != "" {
+		t.Errorf("Poison item tagged %q", model)
+	}
+	if model, _ := embeddingModelOf(t, db, "knowledge", long.ID); model != "test/vocab" {
+		t.Errorf("Expected the long item embedded from its truncated text, tagged %q", model)
+	}
+
+	// Later runs leave the poison item out
+	ep := &Episode{SessionID: "s1", AgentID: "a1", EventType: "action", Content: "released lock"}
+	db.RecordEpisode(ep)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if model, _ := embeddingModelOf(t, db, "episodes", ep.ID); model != "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("New episode never embedded")
+		}
+	}
+	status = waitForJobRun(t, db)
+	if status.Done != 1 || status.Failed != 1 || status.LastError != "" {
+		t.Errorf("Expected only the new episode embedded, got %+v", status)
+	}
+}
+
+func TestEmbeddingJobRateLimit(t *testing.T) {
+	db := setupTestLearningDB(t)
+	for _, content := range []string{"one", "two", "three"} {
+		db.StoreKnowledge(&Knowledge{Category: "domain", Title: content, Content: content})
+	}
+
+	// 3 texts at 20 per second take at least 150ms
+	db.SetEmbedRate(20)
+	db.SetEmbeddingProvider(&vocabEmbedder{vocab: []string{"one"}})
+	status := waitForEmbeddingJob(t, db)
+
+	if status.Done != 3 || status.StartedAt == nil || status.FinishedAt == nil {
+		t.Fatalf("Unexpected status: %+v", status)
+	}
+	if took := status.FinishedAt.Sub(*status.StartedAt); took < 100*time.Millisecond {
+		t.Errorf("Job took %v, expected the rate limit to slow it down", took)
+	}
+}
+
+func TestLearningStats(t *testing.T) {
+	db := setupTestLearningDB(t)
+	db.StoreKnowledge(&Knowledge{Category: "error_solution", Title: "Busy", Content: "lock timeout", Embedding: []float32{1, 0}})
+	db.StoreKnowledge(&Knowledge{Category: "pattern", Title: "Retry", Content: "retry edits"})
+	db.RecordEpisode(&Episode{SessionID: "s1", AgentID: "a1", EventType: "error", Content: "edit failed"})
+
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.Episodes != 1 || stats.Knowledge != 2 || stats.KnowledgeEmbedded != 1 || stats.Procedures != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if stats.KnowledgeByCategory["error_solution"] != 1 || stats.KnowledgeByCategory["pattern"] != 1 {
+		t.Errorf("Unexpected categories: %v", stats.KnowledgeByCategory)
+	}
+
+	// Only vectors from the active model count once one is set
+	db.SetEmbeddingProvider(&vocabEmbedder{vocab: []string{"lock"}, model: "test/a", fail: true})
+	if stats, _ := db.Stats(); stats.KnowledgeEmbedded != 0 {
+		t.Errorf("Counted vectors from another model: %+v", stats)
+	}
+}
@@ -2,18 +2,25 @@ package memory
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
+	"sync"
 	"time"
 )
 
+// maxEmbeddingRequestInputs caps the texts sent in one /embeddings request
+const maxEmbeddingRequestInputs = 64
+
 // LMStudioEmbedding implements EmbeddingProvider using LM Studio's API
 type LMStudioEmbedding struct {
 	baseURL    string
 	model      string
 	client     *http.Client
+	mu         sync.Mutex
 	dimensions int
 }
 
@@ -30,8 +37,8 @@ func NewLMStudioEmbedding(baseURL, model string) *LMStudioEmbedding {
 }
 
 type embeddingRequest struct {
-	Input string `json:"input"`
-	Model string `json:"model"`
+	Input []string `json:"input"`
+	Model string   `json:"model"`
 }
 
 type embeddingResponse struct {
@@ -48,18 +55,67 @@ type embeddingResponse struct {
 	} `json:"usage"`
 }
 
+// EmbeddingAPIError is a non-200 reply from the embedding API
+type EmbeddingAPIError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *EmbeddingAPIError) Error() string {
+	return fmt.Sprintf("embedding API error: %s - %s", e.Status, e.Body)
+}
+
+// Temporary reports whether retrying may help: rate limiting and server errors
+func (e *EmbeddingAPIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
 func (l *LMStudioEmbedding) Embed(text string) ([]float32, error) {
-	req := embeddingRequest{
-		Input: text,
-		Model: l.model,
+	return l.EmbedContext(context.Background(), text)
+}
+
+func (l *LMStudioEmbedding) EmbedContext(ctx context.Context, text string) ([]float32, error) {
+	embeddings, err := l.EmbedBatchContext(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return embeddings[0], nil
+}
+
+func (l *LMStudioEmbedding) EmbedBatch(texts []string) ([][]float32, error) {
+	return l.EmbedBatchContext(context.Background(), texts)
+}
+
+// EmbedBatchContext embeds texts with as few requests as possible, keeping
+// their order
+func (l *LMStudioEmbedding) EmbedBatchContext(ctx context.Context, texts []string) ([][]float32, error) {
+	results := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += maxEmbeddingRequestInputs {
+		end := min(start+maxEmbeddingRequestInputs, len(texts))
+		embeddings, err := l.request(ctx, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, embeddings...)
 	}
+	return results, nil
+}
 
-	body, err := json.Marshal(req)
+// request sends one /embeddings call for texts
+func (l *LMStudioEmbedding) request(ctx context.Context, texts []string) ([][]float32, error) {
+	body, err := json.Marshal(embeddingRequest{Input: texts, Model: l.model})
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	resp, err := l.client.Post(l.baseURL+"/embeddings", "application/json", bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.baseURL+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := l.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to call embedding API: %w", err)
 	}
@@ -67,7 +123,7 @@ func (l *LMStudioEmbedding) Embed(text string) ([]float32, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		respBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("embedding API error: %s - %s", resp.Status, string(respBody))
+		return nil, &EmbeddingAPIError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(respBody)}
 	}
 
 	var embResp embeddingResponse
@@ -75,28 +131,33 @@ func (l *LMStudioEmbedding) Embed(text string) ([]float32, error) {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	if len(embResp.Data) == 0 {
-		return nil, fmt.Errorf("no embedding returned")
+	if len(embResp.Data) != len(texts) {
+		return nil, fmt.Errorf("embedding API returned %d embeddings for %d inputs", len(embResp.Data), len(texts))
 	}
 
-	embedding := embResp.Data[0].Embedding
-	l.dimensions = len(embedding)
-
-	return embedding, nil
-}
-
-func (l *LMStudioEmbedding) EmbedBatch(texts []string) ([][]float32, error) {
-	results := make([][]float32, len(texts))
-	for i, text := range texts {
-		emb, err := l.Embed(text)
-		if err != nil {
-			return nil, fmt.Errorf("failed to embed text %d: %w", i, err)
+	// Data is usually in input order, but index is authoritative
+	sort.SliceStable(embResp.Data, func(i, j int) bool { return embResp.Data[i].Index < embResp.Data[j].Index })
+	embeddings := make([][]float32, len(texts))
+	for i, d := range embResp.Data {
+		if len(d.Embedding) == 0 {
+			return nil, fmt.Errorf("no embedding returned for input %d", i)
 		}
-		results[i] = emb
+		embeddings[i] = d.Embedding
 	}
-	return results, nil
+
+	l.mu.Lock()
+	l.dimensions = len(embeddings[0])
+	l.mu.Unlock()
+
+	return embeddings, nil
 }
 
 func (l *LMStudioEmbedding) Dimensions() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	return l.dimensions
 }
+
+func (l *LMStudioEmbedding) Model() string {
+	return "lmstudio/" + l.model
+}
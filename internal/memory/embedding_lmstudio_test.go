@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLMStudioEmbedBatch(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path != "/embeddings" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		var req embeddingRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		// Reply out of order; index says which input each belongs to
+		var data []string
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			data = append(data, fmt.Sprintf(`{"index": %d, "embedding": [%d, 1]}`, i, len(req.Input[i])))
+		}
+		fmt.Fprintf(w, `{"data": [%s], "model": %q}`, strings.Join(data, ", "), req.Model)
+	}))
+	defer server.Close()
+
+	texts := make([]string, maxEmbeddingRequestInputs+1)
+	for i := range texts {
+		texts[i] = strings.Repeat("x", i+1)
+	}
+
+	embedder := NewLMStudioEmbedding(server.URL, "nomic")
+	embeddings, err := embedder.EmbedBatch(texts)
+	if err != nil {
+		t.Fatalf("EmbedBatch failed: %v", err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("Expected 2 requests for %d texts, got %d", len(texts), n)
+	}
+	for i, embedding := range embeddings {
+		if int(embedding[0]) != i+1 {
+			t.Fatalf("Embedding %d belongs to input %d", i, int(embedding[0])-1)
+		}
+	}
+	if embedder.Dimensions() != 2 {
+		t.Errorf("Expected dimensions 2, got %d", embedder.Dimensions())
+	}
+}
+
+func TestLMStudioEmbedAPIError(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model loading", status)
+	}))
+	defer server.Close()
+
+	embedder := NewLMStudioEmbedding(server.URL, "nomic")
+	_, err := embedder.Embed("text")
+	var apiErr *EmbeddingAPIError
+	if !errors.As(err, &apiErr) || !apiErr.Temporary() {
+		t.Errorf("Expected a temporary API error, got %v", err)
+	}
+
+	status = http.StatusBadRequest
+	if _, err := embedder.Embed("text"); !errors.As(err, &apiErr) || apiErr.Temporary() {
+		t.Errorf("Expected a permanent API error, got %v", err)
+	}
+}
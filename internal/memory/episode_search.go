@@ -0,0 +1,207 @@
+package memory
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// SearchEpisodes finds past episodes similar to a natural language query,
+// such as an error message, across sessions. Vector similarity over
+// embedded episodes and BM25 keyword relevance are fused with reciprocal
+// rank fusion, so episodes not yet embedded are still found by keyword.
+func (s *SQLiteLearningDB) SearchEpisodes(query string, opts EpisodeSearchOptions) ([]*ScoredEpisode, error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = 10
+	}
+	depth := max(limit*5, minHybridCandidates)
+
+	fused := make(map[string]*ScoredEpisode)
+	var order []string
+	rankings := 0
+	add := func(ep *Episode, rank int, matchedBy string) {
+		hit, ok := fused[ep.ID]
+		if !ok {
+			hit = &ScoredEpisode{Episode: ep}
+			fused[ep.ID] = hit
+			order = append(order, ep.ID)
+		}
+		hit.Score += rrfScore(rank)
+		hit.MatchedBy = append(hit.MatchedBy, matchedBy)
+	}
+
+	if provider := s.provider(); provider != nil {
+		embedding, err := provider.Embed(query)
+		if err != nil {
+			log.Printf("[MEMORY] Embedding failed, searching episodes by keyword only: %v", err)
+		} else {
+			rankings++
+			semantic, err := s.searchEpisodesByEmbedding(embedding, opts, depth)
+			if err != nil {
+				return nil, err
+			}
+			for rank, ep := range semantic {
+				add(ep, rank, MatchedBySemantic)
+			}
+		}
+	}
+
+	keywordOpts := opts
+	keywordOpts.Limit = depth
+	keyword, err := s.searchEpisodesKeyword(query, keywordOpts)
+	if err != nil {
+		return nil, err
+	}
+	rankings++
+	for rank, hit := range keyword {
+		add(hit.Episode, rank, MatchedByKeyword)
+	}
+
+	best := rrfBest(rankings)
+	results := make([]*ScoredEpisode, 0, len(fused))
+	for _, id := range order {
+		hit := fused[id]
+		hit.Score /= best
+		if hit.Score >= opts.MinScore {
+			results = append(results, hit)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Episode.Importance > results[j].Episode.Importance
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// episodeIndexWidenings is how many times searchEpisodesByEmbedding widens
+// its index search, fourfold each time, before scoring the filtered rows
+// directly
+const episodeIndexWidenings = 3
+
+// searchEpisodesByEmbedding ranks episodes embedded by the active model and
+// matching the options' filters by similarity, answered from the HNSW index.
+// Filters are applied to the index's hits, widening the search until enough
+// match; filters so narrow that they still do not fall back to an exact scan
+// of the few rows they match.
+func (s *SQLiteLearningDB) searchEpisodesByEmbedding(embedding []float32, opts EpisodeSearchOptions, limit int) ([]*Episode, error) {
+	where, args := opts.where("")
+	k := limit
+	for i := 0; i <= episodeIndexWidenings; i++ {
+		hits := s.episodeIndex.Search(embedding, k)
+		results, err := s.episodesForHits(hits, where, args)
+		if err != nil {
+			return nil, err
+		}
+		// Fewer hits than asked for means the whole index was searched
+		if len(results) >= limit || len(hits) < k {
+			return results[:min(len(results), limit)], nil
+		}
+		k *= 4
+	}
+	return s.scanEpisodesByEmbedding(embedding, where, args, limit)
+}
+
+// episodesForHits loads the episodes for index hits that match the filter
+// conditions, in hit order
+func (s *SQLiteLearningDB) episodesForHits(hits []hnswResult, where string, args []interface{}) ([]*Episode, error) {
+	if len(hits) == 0 {
+		return nil, nil
+	}
+	ids := make([]interface{}, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+	query := "SELECT " + episodeColumns + " FROM episodes WHERE id IN (?" + strings.Repeat(", ?", len(ids)-1) + ")" + where
+	rows, err := s.db.Query(query, append(ids, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query episodes: %w", err)
+	}
+	defer rows.Close()
+
+	byID := make(map[string]*Episode, len(hits))
+	for rows.Next() {
+		ep, err := scanEpisode(rows)
+		if err != nil {
+			return nil, err
+		}
+		byID[ep.ID] = ep
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating episodes: %w", err)
+	}
+
+	// Hits deleted since they were indexed, or filtered out, are skipped
+	results := make([]*Episode, 0, len(byID))
+	for _, hit := range hits {
+		if ep, ok := byID[hit.ID]; ok {
+			results = append(results, ep)
+		}
+	}
+	return results, nil
+}
+
+// scanEpisodesByEmbedding scores every episode embedded by the active model
+// and matching the filter conditions
+func (s *SQLiteLearningDB) scanEpisodesByEmbedding(embedding []float32, where string, args []interface{}, limit int) ([]*Episode, error) {
+	args = append([]interface{}{s.activeModel()}, args...)
+	query := "SELECT " + episodeColumns + " FROM episodes WHERE length(embedding) > 0 AND COALESCE(embedding_model, '') = ?" + where
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query episode embeddings: %w", err)
+	}
+	defer rows.Close()
+
+	type hit struct {
+		episode *Episode
+		score   float64
+	}
+	var hits []hit
+	for rows.Next() {
+		ep, err := scanEpisode(rows)
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, hit{episode: ep, score: cosineSimilarity(embedding, ep.Embedding)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating episode embeddings: %w", err)
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].score > hits[j].score })
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	results := make([]*Episode, len(hits))
+	for i, h := range hits {
+		results[i] = h.episode
+	}
+	return results, nil
+}
+
+// where renders the project, agent and event type filters as SQL conditions
+// on columns qualified by prefix
+func (o EpisodeSearchOptions) where(prefix string) (string, []interface{}) {
+	var clause strings.Builder
+	var args []interface{}
+	for _, f := range []struct{ column, value string }{
+		{"project_path", o.ProjectPath},
+		{"agent_id", o.AgentID},
+		{"event_type", o.EventType},
+	} {
+		if f.value == "" {
+			continue
+		}
+		fmt.Fprintf(&clause, " AND %s%s = ?", prefix, f.column)
+		args = append(args, f.value)
+	}
+	return clause.String(), args
+}
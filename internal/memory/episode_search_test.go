@@ -0,0 +1,157 @@
+package memory
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitForEpisodeEmbeddings blocks until the background embedder has caught up
+func waitForEpisodeEmbeddings(t *testing.T, db *SQLiteLearningDB) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var pending int
+		if err := db.db.QueryRow("SELECT COUNT(*) FROM episodes WHERE embedding IS NULL").Scan(&pending); err != nil {
+			t.Fatalf("Failed to count pending episodes: %v", err)
+		}
+		if pending == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d episodes still not embedded", pending)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSearchEpisodes(t *testing.T) {
+	db := setupTestLearningDB(t)
+
+	episodes := []*Episode{
+		{SessionID: "s1", AgentID: "a1", ProjectPath: "/src/api", EventType: "error", Content: "Lock wait exceeded on users table", Importance: 0.7},
+		{SessionID: "s2", AgentID: "a2", ProjectPath: "/src/api", EventType: "action", Content: "Applied edit to handlers.go", Importance: 0.5},
+		{SessionID: "s3", AgentID: "a3", ProjectPath: "/src/web", EventType: "error", Content: "Lock wait exceeded in the cache", Importance: 0.7},
+	}
+	for _, ep := range episodes {
+		if err := db.RecordEpisode(ep); err != nil {
+			t.Fatalf("RecordEpisode failed: %v", err)
+		}
+	}
+
+	// Recorded before a provider was set: found by keyword only
+	hits, err := db.SearchEpisodes("lock wait", EpisodeSearchOptions{ProjectPath: "/src/api"})
+	if err != nil {
+		t.Fatalf("SearchEpisodes failed: %v", err)
+	}
+	if len(hits) != 1 || hits[0].Episode.ID != episodes[0].ID || hits[0].MatchedBy[0] != MatchedByKeyword {
+		t.Fatalf("Expected the api lock episode by keyword, got %+v", hits)
+	}
+	if hits[0].Episode.ProjectPath != "/src/api" {
+		t.Errorf("Project path not stored: %+v", hits[0].Episode)
+	}
+
+	// Setting a provider embeds the backlog in the background
+	db.SetEmbeddingProvider(&vocabEmbedder{vocab: []string{"lock|busy", "edit"}})
+	waitForEpisodeEmbeddings(t, db)
+
+	// "busy" shares no word with the episodes, only meaning
+	hits, err = db.SearchEpisodes("database busy", EpisodeSearchOptions{EventType: "error"})
+	if err != nil {
+		t.Fatalf("SearchEpisodes failed: %v", err)
+	}
+	if len(hits) != 2 {
+		t.Fatalf("Expected both lock errors, got %d hits", len(hits))
+	}
+	for _, hit := range hits {
+		if hit.Episode.EventType != "error" || len(hit.MatchedBy) != 1 || hit.MatchedBy[0] != MatchedBySemantic {
+			t.Errorf("Unexpected hit: %+v", hit)
+		}
+	}
+
+	hits, _ = db.SearchEpisodes("database busy", EpisodeSearchOptions{AgentID: "a3"})
+	if len(hits) != 1 || hits[0].Episode.ID != episodes[2].ID {
+		t.Errorf("Expected only agent a3's episode, got %+v", hits)
+	}
+
+	// Episodes recorded later are embedded too
+	db.RecordEpisode(&Episode{SessionID: "s4", AgentID: "a1", ProjectPath: "/src/web", EventType: "error", Content: "SQLITE_BUSY on write"})
+	waitForEpisodeEmbeddings(t, db)
+	hits, _ = db.SearchEpisodes("lock", EpisodeSearchOptions{ProjectPath: "/src/web", Limit: 1})
+	if len(hits) != 1 || hits[0].Score != 1 {
+		t.Errorf("Expected one full-score hit, got %+v", hits)
+	}
+}
+
+func TestMigrateAddsEpisodeColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "learning.db")
+	db, err := NewSQLiteLearningDB(path)
+	if err != nil {
+		t.Fatalf("Failed to create learning DB: %v", err)
+	}
+
+	// Recreate an episodes table from before project paths and embeddings
+	for _, stmt := range []string{
+		"DROP INDEX idx_episodes_project",
+		"ALTER TABLE episodes DROP COLUMN project_path",
+		"ALTER TABLE episodes DROP COLUMN embedding",
+		"PRAGMA user_version = 1",
+	} {
+		if _, err := db.db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	db.Close()
+
+	db, err = NewSQLiteLearningDB(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen learning DB: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.RecordEpisode(&Episode{SessionID: "s1", AgentID: "a1", ProjectPath: "/src/api", EventType: "action", Content: "Ran tests"}); err != nil {
+		t.Fatalf("RecordEpisode failed after migration: %v", err)
+	}
+	episodes, err := db.GetEpisodes(EpisodeFilter{ProjectPath: "/src/api"})
+	if err != nil || len(episodes) != 1 {
+		t.Errorf("Expected 1 episode for the project, got %d (%v)", len(episodes), err)
+	}
+}
+
+func TestEpisodeIndexFollowsEpisodes(t *testing.T) {
+	db := setupTestLearningDB(t)
+
+	// One web episode among many api ones that rank as high
+	old := time.Now().Add(-48 * time.Hour)
+	for i := 0; i < 300; i++ {
+		db.RecordEpisode(&Episode{SessionID: "s1", AgentID: "a1", ProjectPath: "/src/api", EventType: "error", Content: "Lock wait exceeded", Timestamp: old})
+	}
+	web := &Episode{SessionID: "s2", AgentID: "a2", ProjectPath: "/src/web", EventType: "error", Content: "Lock wait exceeded"}
+	db.RecordEpisode(web)
+	db.SetEmbeddingProvider(&vocabEmbedder{vocab: []string{"lock|busy", "edit"}})
+	waitForEmbeddingJob(t, db)
+
+	if n := db.episodeIndex.Len(); n != 301 {
+		t.Fatalf("Expected 301 indexed episodes, got %d", n)
+	}
+	hits, err := db.SearchEpisodes("busy", EpisodeSearchOptions{ProjectPath: "/src/web", Limit: 1})
+	if err != nil {
+		t.Fatalf("SearchEpisodes failed: %v", err)
+	}
+	if len(hits) != 1 || hits[0].Episode.ID != web.ID {
+		t.Fatalf("Expected the web episode past the api ones, got %+v", hits)
+	}
+
+	if pruned, err := db.PruneOldEpisodes(time.Now().Add(-time.Hour)); err != nil || pruned != 300 {
+		t.Fatalf("Expected 300 episodes pruned, got %d (%v)", pruned, err)
+	}
+	if n := db.episodeIndex.Len(); n != 1 {
+		t.Errorf("Expected pruned episodes out of the index, %d left", n)
+	}
+	if err := db.DeleteEpisode(web.ID); err != nil {
+		t.Fatalf("DeleteEpisode failed: %v", err)
+	}
+	if n := db.episodeIndex.Len(); n != 0 {
+		t.Errorf("Expected the deleted episode out of the index, %d left", n)
+	}
+}
@@ -0,0 +1,379 @@
+package memory
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// HNSW parameters. M links per node per layer (2*M on layer 0), and the
+// candidate list sizes used while building and searching. Larger values
+// trade speed for recall.
+const (
+	hnswM              = 16
+	hnswEfConstruction = 200
+	hnswEfSearch       = 128
+)
+
+// hnswIndex is an in-process Hierarchical Navigable Small World graph for
+// approximate nearest-neighbour search by cosine similarity. Vectors are
+// stored normalized so similarity is a dot product. Removed or replaced
+// items are tombstoned and skipped in results; rebuild to reclaim them.
+type hnswIndex struct {
+	mu sync.RWMutex
+
+	nodes    []*hnswNode
+	ids      map[string]int // live node per knowledge ID
+	entry    int            // entry point node, -1 when empty
+	maxLevel int
+	dims     int // dimension of indexed vectors; others are not indexed
+	deleted  int
+
+	levelMult float64
+	rng       *rand.Rand
+}
+
+type hnswNode struct {
+	id      string
+	vector  []float32
+	links   [][]int // neighbours per layer
+	deleted bool
+}
+
+// hnswResult is a search hit
+type hnswResult struct {
+	ID    string
+	Score float64 // cosine similarity
+}
+
+func newHNSWIndex() *hnswIndex {
+	return &hnswIndex{
+		ids:       make(map[string]int),
+		entry:     -1,
+		levelMult: 1 / math.Log(hnswM),
+		rng:       rand.New(rand.NewSource(1)),
+	}
+}
+
+// Len returns the number of live items
+func (h *hnswIndex) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.ids)
+}
+
+// Add indexes a vector under id, replacing any previous vector for it
+func (h *hnswIndex) Add(id string, vector []float32) {
+	normalized := normalizeVector(vector)
+	if normalized == nil {
+		h.Remove(id)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Updates that keep the embedding leave the graph alone
+	if idx, ok := h.ids[id]; ok && equalVectors(h.nodes[idx].vector, normalized) {
+		return
+	}
+
+	h.removeLocked(id)
+	if h.dims == 0 {
+		h.dims = len(normalized)
+	}
+	if len(normalized) != h.dims {
+		return
+	}
+
+	level := int(math.Floor(-math.Log(1-h.rng.Float64()) * h.levelMult))
+	node := &hnswNode{id: id, vector: normalized, links: make([][]int, level+1)}
+	idx := len(h.nodes)
+	h.nodes = append(h.nodes, node)
+	h.ids[id] = idx
+
+	if h.entry < 0 {
+		h.entry = idx
+		h.maxLevel = level
+		return
+	}
+
+	// Descend greedily through the layers above the new node's level
+	ep := h.entry
+	for l := h.maxLevel; l > level; l-- {
+		ep = h.greedy(normalized, ep, l)
+	}
+
+	// Link into each layer the node lives on
+	entries := []int{ep}
+	for l := min(level, h.maxLevel); l >= 0; l-- {
+		candidates := h.searchLayer(normalized, entries, hnswEfConstruction, l)
+		neighbours := h.selectNeighbours(candidates, hnswM)
+		node.links[l] = neighbours
+
+		limit := hnswM
+		if l == 0 {
+			limit = 2 * hnswM
+		}
+		for _, n := range neighbours {
+			h.nodes[n].links[l] = append(h.nodes[n].links[l], idx)
+			if len(h.nodes[n].links[l]) > limit {
+				h.pruneLinks(n, l, limit)
+			}
+		}
+
+		entries = entries[:0]
+		for _, c := range candidates {
+			entries = append(entries, c.node)
+		}
+	}
+
+	if level > h.maxLevel {
+		h.maxLevel = level
+		h.entry = idx
+	}
+}
+
+// replaceWith takes over the contents of a freshly built index
+func (h *hnswIndex) replaceWith(other *hnswIndex) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nodes = other.nodes
+	h.ids = other.ids
+	h.entry = other.entry
+	h.maxLevel = other.maxLevel
+	h.dims = other.dims
+	h.deleted = other.deleted
+}
+
+// Remove drops id from the index
+func (h *hnswIndex) Remove(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeLocked(id)
+}
+
+func (h *hnswIndex) removeLocked(id string) {
+	idx, ok := h.ids[id]
+	if !ok {
+		return
+	}
+	h.nodes[idx].deleted = true
+	delete(h.ids, id)
+	h.deleted++
+}
+
+// Search returns up to k items most similar to vector, best first
+func (h *hnswIndex) Search(vector []float32, k int) []hnswResult {
+	query := normalizeVector(vector)
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.entry < 0 || query == nil || len(query) != h.dims || k <= 0 {
+		return nil
+	}
+
+	ep := h.entry
+	for l := h.maxLevel; l > 0; l-- {
+		ep = h.greedy(query, ep, l)
+	}
+
+	// Widen the search to make up for tombstones that will be filtered out
+	ef := max(hnswEfSearch, k)
+	if h.deleted > 0 {
+		ef += k * h.deleted / max(len(h.ids), 1)
+	}
+
+	results := make([]hnswResult, 0, k)
+	for _, c := range h.searchLayer(query, []int{ep}, ef, 0) {
+		node := h.nodes[c.node]
+		if node.deleted {
+			continue
+		}
+		results = append(results, hnswResult{ID: node.id, Score: 1 - c.dist})
+		if len(results) == k {
+			break
+		}
+	}
+	return results
+}
+
+// greedy walks layer l towards query from ep, returning the closest node found
+func (h *hnswIndex) greedy(query []float32, ep, l int) int {
+	best := ep
+	bestDist := cosineDistance(query, h.nodes[ep].vector)
+	for changed := true; changed; {
+		changed = false
+		for _, n := range h.nodes[best].links[l] {
+			if d := cosineDistance(query, h.nodes[n].vector); d < bestDist {
+				best, bestDist = n, d
+				changed = true
+			}
+		}
+	}
+	return best
+}
+
+// searchLayer is the HNSW beam search: returns up to ef nodes on layer l
+// closest to query, nearest first
+func (h *hnswIndex) searchLayer(query []float32, entries []int, ef, l int) []hnswCandidate {
+	visited := make([]bool, len(h.nodes))
+	candidates := &minHeap{}
+	results := &maxHeap{}
+
+	for _, ep := range entries {
+		if visited[ep] {
+			continue
+		}
+		visited[ep] = true
+		c := hnswCandidate{node: ep, dist: cosineDistance(query, h.nodes[ep].vector)}
+		heap.Push(candidates, c)
+		heap.Push(results, c)
+	}
+
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(hnswCandidate)
+		if results.Len() >= ef && c.dist > (*results)[0].dist {
+			break
+		}
+		for _, n := range h.nodes[c.node].links[l] {
+			if visited[n] {
+				continue
+			}
+			visited[n] = true
+			d := cosineDistance(query, h.nodes[n].vector)
+			if results.Len() < ef || d < (*results)[0].dist {
+				heap.Push(candidates, hnswCandidate{node: n, dist: d})
+				heap.Push(results, hnswCandidate{node: n, dist: d})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	found := []hnswCandidate(*results)
+	sort.Slice(found, func(i, j int) bool { return found[i].dist < found[j].dist })
+	return found
+}
+
+// selectNeighbours picks up to m neighbours from candidates (nearest first)
+// with the HNSW heuristic: a candidate is skipped when it is closer to an
+// already chosen neighbour than to the new node, keeping links spread out
+func (h *hnswIndex) selectNeighbours(candidates []hnswCandidate, m int) []int {
+	selected := make([]int, 0, m)
+	var skipped []int
+	for _, c := range candidates {
+		if len(selected) == m {
+			break
+		}
+		keep := true
+		for _, s := range selected {
+			if cosineDistance(h.nodes[c.node].vector, h.nodes[s].vector) < c.dist {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			selected = append(selected, c.node)
+		} else {
+			skipped = append(skipped, c.node)
+		}
+	}
+
+	// Fill up with the nearest skipped candidates
+	for _, n := range skipped {
+		if len(selected) == m {
+			break
+		}
+		selected = append(selected, n)
+	}
+	return selected
+}
+
+// pruneLinks trims node n's links on layer l to the limit closest
+func (h *hnswIndex) pruneLinks(n, l, limit int) {
+	vector := h.nodes[n].vector
+	links := h.nodes[n].links[l]
+	candidates := make([]hnswCandidate, len(links))
+	for i, link := range links {
+		candidates[i] = hnswCandidate{node: link, dist: cosineDistance(vector, h.nodes[link].vector)}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].dist < candidates[j].dist })
+	h.nodes[n].links[l] = h.selectNeighbours(candidates, limit)
+}
+
+// normalizeVector returns a unit-length copy; nil for empty or zero vectors
+func normalizeVector(v []float32) []float32 {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		return nil
+	}
+	norm = math.Sqrt(norm)
+
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = float32(float64(x) / norm)
+	}
+	return out
+}
+
+func equalVectors(a, b []float32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// cosineDistance is 1 - cosine similarity of two unit vectors
+func cosineDistance(a, b []float32) float64 {
+	var dot float32
+	for i := range a {
+		dot += a[i] * b[i]
+	}
+	return 1 - float64(dot)
+}
+
+type hnswCandidate struct {
+	node int
+	dist float64
+}
+
+// minHeap pops the nearest candidate first
+type minHeap []hnswCandidate
+
+func (h minHeap) Len() int            { return len(h) }
+func (h minHeap) Less(i, j int) bool  { return h[i].dist < h[j].dist }
+func (h minHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *minHeap) Push(x interface{}) { *h = append(*h, x.(hnswCandidate)) }
+func (h *minHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// maxHeap keeps the farthest result on top so it can be evicted
+type maxHeap []hnswCandidate
+
+func (h maxHeap) Len() int            { return len(h) }
+func (h maxHeap) Less(i, j int) bool  { return h[i].dist > h[j].dist }
+func (h maxHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *maxHeap) Push(x interface{}) { *h = append(*h, x.(hnswCandidate)) }
+func (h *maxHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
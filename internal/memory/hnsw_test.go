@@ -0,0 +1,223 @@
+package memory
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// randomVectors returns n vectors grouped around a few centres, which is
+// closer to real embeddings than uniform noise
+func randomVectors(rng *rand.Rand, n, dims int) [][]float32 {
+	centres := make([][]float32, 32)
+	for i := range centres {
+		centres[i] = make([]float32, dims)
+		for d := range centres[i] {
+			centres[i][d] = float32(rng.NormFloat64())
+		}
+	}
+
+	vectors := make([][]float32, n)
+	for i := range vectors {
+		centre := centres[rng.Intn(len(centres))]
+		vectors[i] = make([]float32, dims)
+		for d := range vectors[i] {
+			vectors[i][d] = centre[d] + float32(rng.NormFloat64()*0.5)
+		}
+	}
+	return vectors
+}
+
+// exactTopK is the brute-force answer for a query
+func exactTopK(vectors [][]float32, query []float32, k int) []string {
+	type hit struct {
+		id    string
+		score float64
+	}
+	hits := make([]hit, len(vectors))
+	for i, v := range vectors {
+		hits[i] = hit{id: fmt.Sprintf("k%d", i), score: cosineSimilarity(query, v)}
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].score > hits[j].score })
+
+	ids := make([]string, k)
+	for i := range ids {
+		ids[i] = hits[i].id
+	}
+	return ids
+}
+
+// recallAt measures how many of the exact top k the index found
+func recallAt(index *hnswIndex, vectors, queries [][]float32, k int) float64 {
+	found, total := 0, 0
+	for _, q := range queries {
+		want := make(map[string]bool)
+		for _, id := range exactTopK(vectors, q, k) {
+			want[id] = true
+		}
+		for _, hit := range index.Search(q, k) {
+			if want[hit.ID] {
+				found++
+			}
+		}
+		total += k
+	}
+	return float64(found) / float64(total)
+}
+
+func buildIndex(vectors [][]float32) *hnswIndex {
+	index := newHNSWIndex()
+	for i, v := range vectors {
+		index.Add(fmt.Sprintf("k%d", i), v)
+	}
+	return index
+}
+
+func TestHNSWRecall(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	vectors := randomVectors(rng, 2000, 64)
+	queries := randomVectors(rng, 50, 64)
+
+	index := buildIndex(vectors)
+	if index.Len() != 2000 {
+		t.Fatalf("Expected 2000 items, got %d", index.Len())
+	}
+
+	if recall := recallAt(index, vectors, queries, 10); recall < 0.95 {
+		t.Errorf("Recall@10 is %.3f, want at least 0.95", recall)
+	}
+}
+
+func TestHNSWUpdateAndRemove(t *testing.T) {
+	index := newHNSWIndex()
+	index.Add("a", []float32{1, 0, 0})
+	index.Add("b", []float32{0, 1, 0})
+	index.Add("c", []float32{0, 0, 1})
+
+	hits := index.Search([]float32{0.9, 0.1, 0}, 1)
+	if len(hits) != 1 || hits[0].ID != "a" {
+		t.Fatalf("Expected a, got %+v", hits)
+	}
+
+	// Moving a away makes b the nearest
+	index.Add("a", []float32{0, 0, -1})
+	hits = index.Search([]float32{0.9, 0.1, 0}, 1)
+	if len(hits) != 1 || hits[0].ID != "b" {
+		t.Errorf("Expected b after update, got %+v", hits)
+	}
+
+	index.Remove("b")
+	for _, hit := range index.Search([]float32{0, 1, 0}, 3) {
+		if hit.ID == "b" {
+			t.Error("Removed item returned")
+		}
+	}
+	if index.Len() != 2 {
+		t.Errorf("Expected 2 items, got %d", index.Len())
+	}
+
+	// Vectors of another dimension are not indexed or searched
+	index.Add("d", []float32{1, 0})
+	if index.Len() != 2 || index.Search([]float32{1, 0}, 1) != nil {
+		t.Error("Mismatched dimensions should be ignored")
+	}
+}
+
+func TestSearchByEmbeddingUsesIndex(t *testing.T) {
+	path := t.TempDir() + "/learning.db"
+	db, err := NewSQLiteLearningDB(path)
+	if err != nil {
+		t.Fatalf("Failed to create learning DB: %v", err)
+	}
+
+	db.StoreKnowledge(&Knowledge{Category: "domain", Title: "x", Content: "x", Embedding: []float32{1, 0, 0}})
+	db.StoreKnowledge(&Knowledge{Category: "domain", Title: "y", Content: "y", Embedding: []float32{0, 1, 0}})
+	db.Close()
+
+	// Reopening rebuilds the index from disk
+	db, err = NewSQLiteLearningDB(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen learning DB: %v", err)
+	}
+	defer db.Close()
+
+	results, err := db.SearchByEmbedding([]float32{0.1, 0.9, 0}, 1)
+	if err != nil {
+		t.Fatalf("SearchByEmbedding failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Title != "y" {
+		t.Fatalf("Expected y, got %+v", results)
+	}
+
+	// Updates are picked up without a rebuild
+	k := results[0]
+	k.Embedding = []float32{0, 0, 1}
+	if err := db.UpdateKnowledge(k); err != nil {
+		t.Fatalf("UpdateKnowledge failed: %v", err)
+	}
+	results, _ = db.SearchByEmbedding([]float32{0, 0.1, 0.9}, 1)
+	if len(results) != 1 || results[0].ID != k.ID {
+		t.Errorf("Expected updated item, got %+v", results)
+	}
+}
+
+// Benchmarks compare the HNSW index with the brute-force scan, in memory and
+// through SQLite. Recall@10 is reported alongside latency for the index.
+
+const benchDims = 384
+
+func benchmarkVectors(n int) ([][]float32, [][]float32) {
+	rng := rand.New(rand.NewSource(42))
+	return randomVectors(rng, n, benchDims), randomVectors(rng, 100, benchDims)
+}
+
+func BenchmarkSearchBruteForce(b *testing.B) {
+	for _, n := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("n=%d", n), func(b *testing.B) {
+			vectors, queries := benchmarkVectors(n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				exactTopK(vectors, queries[i%len(queries)], 10)
+			}
+		})
+	}
+}
+
+func BenchmarkSearchHNSW(b *testing.B) {
+	for _, n := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("n=%d", n), func(b *testing.B) {
+			vectors, queries := benchmarkVectors(n)
+			index := buildIndex(vectors)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				index.Search(queries[i%len(queries)], 10)
+			}
+			b.StopTimer()
+			b.ReportMetric(recallAt(index, vectors, queries, 10), "recall@10")
+		})
+	}
+}
+
+func BenchmarkSearchByEmbeddingDB(b *testing.B) {
+	vectors, queries := benchmarkVectors(2000)
+	db, err := NewSQLiteLearningDB(b.TempDir() + "/learning.db")
+	if err != nil {
+		b.Fatalf("Failed to create learning DB: %v", err)
+	}
+	defer db.Close()
+	for i, v := range vectors {
+		db.StoreKnowledge(&Knowledge{Category: "domain", Title: fmt.Sprintf("k%d", i), Content: "bench", Embedding: v})
+	}
+
+	b.Run("exact", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			db.searchByEmbeddingExact(queries[i%len(queries)], 10)
+		}
+	})
+	b.Run("hnsw", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			db.SearchByEmbedding(queries[i%len(queries)], 10)
+		}
+	})
+}
@@ -3,9 +3,15 @@
 package memory
 
 import (
+	"context"
+	"errors"
 	"time"
 )
 
+// ErrNotFound is wrapped by errors for records that do not exist; test with
+// errors.Is.
+var ErrNotFound = errors.New("not found")
+
 // OperationalDB handles fast, focused operational state.
 // This DB is small and optimized for current session management.
 type OperationalDB interface {
@@ -17,29 +23,44 @@ type OperationalDB interface {
 	SetShutdownFlag(agentID string, reason string) error
 	RecordHeartbeat(agentID string) error
 	MarkStopped(agentID string, reason string) error
+	UpdateAgentMetadata(agentID string, metadata map[string]string) error
 
 	// Task queue
 	CreateTask(task *Task) error
 	ClaimTask(taskID, agentID string) error
 	UpdateTaskProgress(taskID string, status TaskStatus, note string) error
+	ReleaseTask(taskID, note string) error
 	CompleteTask(taskID, summary string) error
 	GetTask(taskID string) (*Task, error)
 	ListTasks(filter TaskFilter) ([]*Task, error)
+	UpdateTask(task *Task) error
+	DeleteTask(taskID string) error
 
 	// Session management
 	CreateSession(session *Session) error
 	GetSession(sessionID string) (*Session, error)
+	ListSessions(filter SessionFilter) ([]*Session, error)
 	UpdateSession(session *Session) error
 	GetActiveSession(agentID string) (*Session, error)
+	DeleteSession(sessionID string) error
+
+	// Session transcripts
+	AppendTranscript(lines []*TranscriptLine) error
+	GetTranscript(filter TranscriptFilter) ([]*TranscriptLine, error)
 
 	// Communication channels
 	SendMessage(msg *Message) error
 	GetMessages(agentID string, since time.Time) ([]*Message, error)
+	GetMessage(msgID string) (*Message, error)
+	ListMessages(filter MessageFilter) ([]*Message, error)
 	AcknowledgeMessage(msgID string) error
+	DeleteMessage(msgID string) error
 
 	// Health and metrics
 	RecordMetric(metric *Metric) error
 	GetMetrics(agentID string, since time.Time) ([]*Metric, error)
+	ListMetrics(filter MetricFilter) ([]*Metric, error)
+	DeleteMetric(metricID string) error
 
 	// Cleanup
 	CleanupStaleAgents(threshold time.Duration) (int, error)
@@ -52,26 +73,43 @@ type LearningDB interface {
 	// Episodic memory - what happened, timestamped
 	RecordEpisode(episode *Episode) error
 	GetEpisodes(filter EpisodeFilter) ([]*Episode, error)
+	GetEpisode(id string) (*Episode, error)
+	DeleteEpisode(id string) error
+	SearchEpisodesKeyword(query string, limit int) ([]*ScoredEpisode, error)
+	SearchEpisodes(query string, opts EpisodeSearchOptions) ([]*ScoredEpisode, error)
 	SummarizeEpisodes(sessionID string) (*EpisodeSummary, error)
+	RegenerateSummary(sessionID string) (*EpisodeSummary, error)
+	PromoteLessons(sessionID string) ([]*Knowledge, error)
+	SetSummarizer(summarizer Summarizer)
 
 	// Semantic memory - searchable knowledge with embeddings
 	StoreKnowledge(knowledge *Knowledge) error
 	SearchKnowledge(query string, limit int) ([]*Knowledge, error)
 	SearchByEmbedding(embedding []float32, limit int) ([]*Knowledge, error)
+	SearchKnowledgeKeyword(query string, limit int) ([]*ScoredKnowledge, error)
+	SearchKnowledgeScored(query string, opts KnowledgeSearchOptions) ([]*ScoredKnowledge, error)
 	GetKnowledge(id string) (*Knowledge, error)
+	ListKnowledge(filter KnowledgeFilter) ([]*Knowledge, error)
 	UpdateKnowledge(knowledge *Knowledge) error
+	DeleteKnowledge(id string) error
+	MarkKnowledgeUsed(ids []string) error
 
 	// Procedural memory - learned workflows
 	StoreProcedure(procedure *Procedure) error
 	GetProcedure(id string) (*Procedure, error)
 	FindProcedures(taskType string, context string) ([]*Procedure, error)
+	ListProcedures(filter ProcedureFilter) ([]*Procedure, error)
+	UpdateProcedure(procedure *Procedure) error
 	UpdateProcedureSuccess(id string, success bool) error
+	DeleteProcedure(id string) error
 
 	// Embedding management
 	ComputeEmbedding(text string) ([]float32, error)
 	SetEmbeddingProvider(provider EmbeddingProvider) error
+	EmbeddingStatus() EmbeddingJobStatus
 
 	// Maintenance
+	Stats() (*LearningStats, error)
 	PruneOldEpisodes(before time.Time) (int, error)
 	CompactKnowledge() error
 	Close() error
@@ -83,6 +121,32 @@ type EmbeddingProvider interface {
 	Embed(text string) ([]float32, error)
 	EmbedBatch(texts []string) ([][]float32, error)
 	Dimensions() int
+
+	// Model identifies the provider and model, e.g. "lmstudio/nomic-embed-text".
+	// Embeddings are only compared with others from the same model.
+	Model() string
+}
+
+// ContextEmbeddingProvider is an EmbeddingProvider whose calls can be
+// cancelled. The providers and decorators in this package implement it.
+type ContextEmbeddingProvider interface {
+	EmbeddingProvider
+	EmbedContext(ctx context.Context, text string) ([]float32, error)
+	EmbedBatchContext(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// EmbeddingCache stores embeddings by key; see NewCachingEmbedder.
+type EmbeddingCache interface {
+	// GetEmbeddings returns the cached embeddings for the keys it has
+	GetEmbeddings(keys []string) (map[string][]float32, error)
+	PutEmbeddings(model string, entries map[string][]float32) error
+}
+
+// Summarizer turns a session's episodes (oldest first) into a narrative
+// summary, key events and lessons learned.
+// Can be implemented by local models or external services.
+type Summarizer interface {
+	Summarize(sessionID string, episodes []*Episode) (*EpisodeSummary, error)
 }
 
 // ContextBuilder assembles focused context for the LLM.
@@ -111,6 +175,7 @@ const (
 	AgentStatusWorking     AgentStatus = "working"
 	AgentStatusIdle        AgentStatus = "idle"
 	AgentStatusBlocked     AgentStatus = "blocked"
+	AgentStatusError       AgentStatus = "error"
 	AgentStatusStopping    AgentStatus = "stopping"
 	AgentStatusStopped     AgentStatus = "stopped"
 	AgentStatusUnreachable AgentStatus = "unreachable"
@@ -138,7 +203,7 @@ type AgentState struct {
 type AgentFilter struct {
 	Status    AgentStatus
 	AgentType string
-	Active    *bool // non-stopped agents
+	Active    *bool // non-stopped (true) or stopped (false) agents
 	Limit     int
 	Offset    int
 }
@@ -195,6 +260,43 @@ type Session struct {
 	Summary     string    `json:"summary,omitempty"`
 }
 
+// SessionFilter filters session queries; newest first
+type SessionFilter struct {
+	AgentID     string
+	ProjectPath string
+	Active      *bool // not yet ended
+	Limit       int
+	Offset      int
+}
+
+// Transcript streams
+const (
+	StreamStdin  = "stdin"  // prompts and commands sent to the agent
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// TranscriptLine is one line of agent I/O within a session
+type TranscriptLine struct {
+	Seq       int64     `json:"seq"` // increasing in recording order
+	SessionID string    `json:"session_id"`
+	AgentID   string    `json:"agent_id"`
+	Stream    string    `json:"stream"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// TranscriptFilter filters transcript queries; results are in recording order
+type TranscriptFilter struct {
+	SessionID string
+	AgentID   string
+	Stream    string
+	Contains  string // case-insensitive substring search
+	AfterSeq  int64
+	Limit     int
+	Offset    int
+}
+
 // Message for inter-agent communication
 type Message struct {
 	ID          string    `json:"id"`
@@ -207,6 +309,17 @@ type Message struct {
 	AckedAt     *time.Time `json:"acked_at,omitempty"`
 }
 
+// MessageFilter filters message queries; highest priority, then oldest, first
+type MessageFilter struct {
+	ToAgent     string
+	FromAgent   string
+	MessageType string
+	Unacked     bool
+	Since       time.Time
+	Limit       int
+	Offset      int
+}
+
 // Metric for tracking agent performance
 type Metric struct {
 	ID        string    `json:"id"`
@@ -216,6 +329,16 @@ type Metric struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// MetricFilter filters metric queries; oldest first
+type MetricFilter struct {
+	AgentID    string
+	MetricType string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+	Offset     int
+}
+
 // ================================================
 // Learning Types
 // ================================================
@@ -231,17 +354,22 @@ type Episode struct {
 	Outcome     string    `json:"outcome,omitempty"`
 	Timestamp   time.Time `json:"timestamp"`
 	Importance  float64   `json:"importance"` // 0-1, for retrieval weighting
+	ProjectPath string    `json:"project_path,omitempty"`
+	Embedding   []float32 `json:"embedding,omitempty"` // computed in the background when empty
+	EmbeddingModel string `json:"embedding_model,omitempty"`
 }
 
 // EpisodeFilter filters episode queries
 type EpisodeFilter struct {
 	SessionID string
 	AgentID   string
+	ProjectPath string
 	EventType string
 	Since     time.Time
 	Until     time.Time
 	MinImportance float64
 	Limit     int
+	Offset    int
 }
 
 // EpisodeSummary is a compressed summary of episodes
@@ -261,6 +389,7 @@ type Knowledge struct {
 	Content     string    `json:"content"`
 	Source      string    `json:"source,omitempty"` // where this knowledge came from
 	Embedding   []float32 `json:"embedding,omitempty"`
+	EmbeddingModel string `json:"embedding_model,omitempty"` // provider/model that produced Embedding
 	Tags        []string  `json:"tags,omitempty"`
 	UseCount    int       `json:"use_count"`
 	LastUsed    *time.Time `json:"last_used,omitempty"`
@@ -268,6 +397,73 @@ type Knowledge struct {
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
+// KnowledgeFilter filters knowledge listings; newest first
+type KnowledgeFilter struct {
+	Category string
+	Source   string
+	Tag      string
+	Limit    int
+	Offset   int
+}
+
+// ScoredKnowledge is a knowledge search hit with its relevance score
+type ScoredKnowledge struct {
+	Knowledge *Knowledge `json:"knowledge"`
+	Score     float64    `json:"score"`
+	MatchedBy []string   `json:"matched_by,omitempty"` // semantic, keyword
+}
+
+// KnowledgeSearchOptions filters and limits a hybrid knowledge search
+type KnowledgeSearchOptions struct {
+	Limit    int      // default 10
+	Category string   // exact category
+	Tags     []string // any of these tags
+	Source   string   // exact source
+	MinScore float64  // 0-1, drops weaker hits
+	MarkUsed bool     // bump use_count and last_used on returned items
+}
+
+// ScoredEpisode is an episode search hit with its relevance score
+type ScoredEpisode struct {
+	Episode   *Episode `json:"episode"`
+	Score     float64  `json:"score"`
+	MatchedBy []string `json:"matched_by,omitempty"` // semantic, keyword
+}
+
+// EpisodeSearchOptions filters and limits a natural language episode search
+type EpisodeSearchOptions struct {
+	Limit       int     // default 10
+	ProjectPath string  // exact project path
+	AgentID     string
+	EventType   string  // action, observation, error, decision
+	MinScore    float64 // 0-1, drops weaker hits
+}
+
+// EmbeddingJobStatus reports the background job that embeds knowledge and
+// episodes lacking an embedding from the active model, including the whole
+// corpus after a model change
+type EmbeddingJobStatus struct {
+	Model      string     `json:"model"`
+	Running    bool       `json:"running"`
+	Total      int        `json:"total"` // items pending when the current or last run started
+	Done       int        `json:"done"`
+	Failed     int        `json:"failed"` // items the provider could not embed, skipped until the model changes
+	Remaining  int        `json:"remaining"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+}
+
+// LearningStats counts what the learning database holds
+type LearningStats struct {
+	Episodes            int            `json:"episodes"`
+	Knowledge           int            `json:"knowledge"`
+	KnowledgeEmbedded   int            `json:"knowledge_embedded"` // by the active model, or any without one
+	KnowledgeByCategory map[string]int `json:"knowledge_by_category"`
+	Procedures          int            `json:"procedures"`
+	CachedEmbeddings    int            `json:"cached_embeddings"`
+}
+
 // Procedure represents a learned workflow pattern
 type Procedure struct {
 	ID           string    `json:"id"`
@@ -292,6 +488,13 @@ type Step struct {
 	OnFailure   string `json:"on_failure,omitempty"`
 }
 
+// ProcedureFilter filters procedure listings; most successful first
+type ProcedureFilter struct {
+	TaskType string
+	Limit    int
+	Offset   int
+}
+
 // ================================================
 // Context Building Types
 // ================================================
@@ -299,6 +502,7 @@ type Step struct {
 // ContextRequest specifies what context is needed
 type ContextRequest struct {
 	TaskDescription string   // What the agent needs to do
+	TaskType        string   // Procedure lookup key (Task.TaskType); empty skips procedures
 	ProjectPath     string   // Current project context
 	RecentActions   []string // Recent actions for continuity
 	QueryHints      []string // Hints for knowledge retrieval
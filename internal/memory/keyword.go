@@ -0,0 +1,155 @@
+package memory
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// BM25 column weights: a match in a knowledge title or tag counts for more
+// than one in the body; an episode's content more than its context
+const (
+	knowledgeBM25Weights = "10.0, 1.0, 5.0" // title, content, tags
+	episodeBM25Weights   = "4.0, 1.0, 2.0"  // content, context, outcome
+)
+
+// maxKeywordTerms caps the terms taken from a query
+const maxKeywordTerms = 32
+
+// ftsQuery turns free text into an FTS5 query that matches any of its words.
+// Each word is quoted so punctuation and FTS5 operators in the text are
+// taken literally. Returns "" when the text has no words.
+func ftsQuery(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+
+	seen := make(map[string]bool)
+	terms := make([]string, 0, len(words))
+	for _, w := range words {
+		if seen[w] {
+			continue
+		}
+		seen[w] = true
+		terms = append(terms, `"`+w+`"`)
+		if len(terms) == maxKeywordTerms {
+			break
+		}
+	}
+	return strings.Join(terms, " OR ")
+}
+
+// SearchKnowledgeKeyword ranks knowledge by BM25 over title, content and
+// tags. Works without an embedding provider; best match first.
+func (s *SQLiteLearningDB) SearchKnowledgeKeyword(query string, limit int) ([]*ScoredKnowledge, error) {
+	match := ftsQuery(query)
+	if match == "" || limit <= 0 {
+		return []*ScoredKnowledge{}, nil
+	}
+
+	sqlQuery := fmt.Sprintf(`
+		SELECT k.id, -bm25(knowledge_fts, %s) AS score
+		FROM knowledge_fts
+		JOIN knowledge k ON k.rowid = knowledge_fts.rowid
+		WHERE knowledge_fts MATCH ?
+		ORDER BY score DESC
+		LIMIT ?
+	`, knowledgeBM25Weights)
+
+	rows, err := s.db.Query(sqlQuery, match, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search knowledge: %w", err)
+	}
+
+	type hit struct {
+		id    string
+		score float64
+	}
+	var hits []hit
+	for rows.Next() {
+		var h hit
+		if err := rows.Scan(&h.id, &h.score); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan knowledge hit: %w", err)
+		}
+		hits = append(hits, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating knowledge hits: %w", err)
+	}
+
+	results := make([]*ScoredKnowledge, 0, len(hits))
+	for _, h := range hits {
+		k, err := s.GetKnowledge(h.id)
+		if err != nil {
+			continue
+		}
+		results = append(results, &ScoredKnowledge{Knowledge: k, Score: h.score})
+	}
+	return results, nil
+}
+
+// SearchEpisodesKeyword ranks episodes by BM25 over content, context and
+// outcome; best match first.
+func (s *SQLiteLearningDB) SearchEpisodesKeyword(query string, limit int) ([]*ScoredEpisode, error) {
+	return s.searchEpisodesKeyword(query, EpisodeSearchOptions{Limit: limit})
+}
+
+// searchEpisodesKeyword is SearchEpisodesKeyword restricted by the options'
+// project, agent and event type
+func (s *SQLiteLearningDB) searchEpisodesKeyword(query string, opts EpisodeSearchOptions) ([]*ScoredEpisode, error) {
+	match := ftsQuery(query)
+	if match == "" || opts.Limit <= 0 {
+		return []*ScoredEpisode{}, nil
+	}
+
+	where, args := opts.where("e.")
+	sqlQuery := fmt.Sprintf(`
+		SELECT %s, -bm25(episodes_fts, %s) AS score
+		FROM episodes_fts
+		JOIN episodes e ON e.rowid = episodes_fts.rowid
+		WHERE episodes_fts MATCH ?%s
+		ORDER BY score DESC
+		LIMIT ?
+	`, prefixColumns(episodeColumns, "e."), episodeBM25Weights, where)
+	args = append([]interface{}{match}, append(args, opts.Limit)...)
+
+	rows, err := s.db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search episodes: %w", err)
+	}
+	defer rows.Close()
+
+	results := []*ScoredEpisode{}
+	for rows.Next() {
+		scored := &ScoredEpisode{}
+		scored.Episode, err = scanEpisode(rows, &scored.Score)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, scored)
+	}
+
+	return results, rows.Err()
+}
+
+// prefixColumns qualifies each column in a comma-separated list
+func prefixColumns(columns, prefix string) string {
+	fields := strings.Split(columns, ",")
+	for i, f := range fields {
+		fields[i] = prefix + strings.TrimSpace(f)
+	}
+	return strings.Join(fields, ", ")
+}
+
+// rebuildKeywordIndexes reindexes every knowledge and episode row, for rows
+// written before the full-text tables existed
+func (s *SQLiteLearningDB) rebuildKeywordIndexes() error {
+	for _, table := range []string{"knowledge_fts", "episodes_fts"} {
+		if _, err := s.db.Exec(fmt.Sprintf("INSERT INTO %[1]s(%[1]s) VALUES('rebuild')", table)); err != nil {
+			return fmt.Errorf("failed to rebuild %s: %w", table, err)
+		}
+	}
+	return nil
+}
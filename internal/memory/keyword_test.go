@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFTSQuery(t *testing.T) {
+	tests := map[string]string{
+		"Fix the login":         `"fix" OR "the" OR "login"`,
+		`database "locked" OR*`: `"database" OR "locked" OR "or"`,
+		"go_test go_test":       `"go_test"`,
+		"   ?!  ":               "",
+	}
+	for text, want := range tests {
+		if got := ftsQuery(text); got != want {
+			t.Errorf("ftsQuery(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestSearchKnowledgeKeyword(t *testing.T) {
+	db := setupTestLearningDB(t)
+
+	db.StoreKnowledge(&Knowledge{Category: "error_solution", Title: "SQLite database is locked", Content: "Set busy_timeout on the connection."})
+	db.StoreKnowledge(&Knowledge{Category: "domain", Title: "Dashboard", Content: "The dashboard reads from the database every second."})
+	db.StoreKnowledge(&Knowledge{Category: "code_pattern", Title: "Retries", Content: "Retry NATS requests.", Tags: []string{"locking"}})
+
+	hits, err := db.SearchKnowledgeKeyword("database locked", 10)
+	if err != nil {
+		t.Fatalf("SearchKnowledgeKeyword failed: %v", err)
+	}
+	if len(hits) != 3 {
+		t.Fatalf("Expected 3 hits, got %d", len(hits))
+	}
+	// The title match on both words ranks first; stemming matches "locking"
+	if hits[0].Knowledge.Title != "SQLite database is locked" {
+		t.Errorf("Expected the locked database first, got %q", hits[0].Knowledge.Title)
+	}
+	for i := 1; i < len(hits); i++ {
+		if hits[i].Score > hits[i-1].Score || hits[i].Score <= 0 {
+			t.Errorf("Scores not positive and descending: %v then %v", hits[i-1].Score, hits[i].Score)
+		}
+	}
+
+	// Updates are reindexed
+	k := hits[0].Knowledge
+	k.Title = "Connection pool"
+	k.Content = "Limit open connections."
+	db.UpdateKnowledge(k)
+	hits, _ = db.SearchKnowledgeKeyword("busy_timeout", 10)
+	if len(hits) != 0 {
+		t.Errorf("Expected stale content to be gone, got %d hits", len(hits))
+	}
+}
+
+func TestSearchEpisodesKeyword(t *testing.T) {
+	db := setupTestLearningDB(t)
+
+	db.RecordEpisode(&Episode{SessionID: "s1", AgentID: "a1", EventType: "error", Content: "Tests failed: go test ./...", Outcome: "ran go generate"})
+	db.RecordEpisode(&Episode{SessionID: "s1", AgentID: "a1", EventType: "action", Content: "Applied edit to main.go"})
+
+	hits, err := db.SearchEpisodesKeyword("generate", 10)
+	if err != nil {
+		t.Fatalf("SearchEpisodesKeyword failed: %v", err)
+	}
+	if len(hits) != 1 || hits[0].Episode.EventType != "error" || hits[0].Score <= 0 {
+		t.Errorf("Unexpected hits: %+v", hits)
+	}
+}
+
+func TestMigrateIndexesExistingRows(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "learning.db")
+	db, err := NewSQLiteLearningDB(path)
+	if err != nil {
+		t.Fatalf("Failed to create learning DB: %v", err)
+	}
+
+	// Recreate a database from before the full-text tables
+	for _, stmt := range []string{
+		"DROP TRIGGER knowledge_fts_insert",
+		"DROP TRIGGER knowledge_fts_update",
+		"DROP TRIGGER knowledge_fts_delete",
+		"DROP TABLE knowledge_fts",
+		"DROP TABLE episodes_fts",
+		"PRAGMA user_version = 0",
+	} {
+		if _, err := db.db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	if _, err := db.db.Exec("INSERT INTO knowledge (id, category, title, content) VALUES ('k1', 'domain', 'Legacy note', 'written before search')"); err != nil {
+		t.Fatalf("Failed to insert legacy row: %v", err)
+	}
+	db.Close()
+
+	db, err = NewSQLiteLearningDB(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen learning DB: %v", err)
+	}
+	defer db.Close()
+
+	hits, _ := db.SearchKnowledgeKeyword("legacy", 10)
+	if len(hits) != 1 || hits[0].Knowledge.ID != "k1" {
+		t.Errorf("Expected existing row to be indexed, got %+v", hits)
+	}
+}
@@ -1,13 +1,17 @@
 package memory
 
 import (
+	"context"
 	"database/sql"
 	_ "embed"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"log"
 	"math"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -20,7 +24,22 @@ var schemaLearning string
 // SQLiteLearningDB implements LearningDB using SQLite with embedded schema.
 type SQLiteLearningDB struct {
 	db               *sql.DB
+	providerMu       sync.RWMutex
 	embeddingProvider EmbeddingProvider
+	summarizer       Summarizer
+	index            *hnswIndex // ANN index over knowledge embeddings
+	episodeIndex     *hnswIndex // ANN index over episode embeddings
+
+	// Background embedding of knowledge and episodes
+	embedWake    chan struct{}
+	embedRate    float64 // texts per second, 0 for no limit
+	embedFailed  map[string]string // item ID to the model that could not embed it
+	jobMu        sync.Mutex
+	job          EmbeddingJobStatus
+	ctx          context.Context // cancelled on Close
+	cancel       context.CancelFunc
+	embedderDone chan struct{}
+	closeOnce    sync.Once
 }
 
 // NewSQLiteLearningDB creates a new learning database.
@@ -52,9 +71,161 @@ func NewSQLiteLearningDB(dbPath string) (*SQLiteLearningDB, error) {
 		return nil, fmt.Errorf("failed to initialize schema: %w", err)
 	}
 
-	return &SQLiteLearningDB{
-		db: db,
-	}, nil
+	s := &SQLiteLearningDB{
+		db:           db,
+		index:        newHNSWIndex(),
+		episodeIndex: newHNSWIndex(),
+		embedWake:    make(chan struct{}, 1),
+		embedderDone: make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	if err := s.migrate(); err != nil {
+		s.cancel()
+		db.Close()
+		return nil, err
+	}
+	if err := s.loadIndex(); err != nil {
+		s.cancel()
+		db.Close()
+		return nil, err
+	}
+	go s.runEmbedder()
+	return s, nil
+}
+
+// learningSchemaVersion is stored in PRAGMA user_version; bump it when an
+// upgrade needs more than the CREATE IF NOT EXISTS statements in the schema
+const learningSchemaVersion = 3
+
+// migrate brings databases created by older versions up to date
+func (s *SQLiteLearningDB) migrate() error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version >= learningSchemaVersion {
+		return nil
+	}
+
+	// 1: full-text indexes, filled by triggers from now on
+	if version < 1 {
+		if err := s.rebuildKeywordIndexes(); err != nil {
+			return err
+		}
+	}
+
+	// 2: episode project paths and embeddings
+	if version < 2 {
+		if err := s.addColumns("episodes", "project_path TEXT", "embedding BLOB"); err != nil {
+			return err
+		}
+		if _, err := s.db.Exec("CREATE INDEX IF NOT EXISTS idx_episodes_project ON episodes(project_path)"); err != nil {
+			return fmt.Errorf("failed to index episode projects: %w", err)
+		}
+	}
+
+	// 3: embeddings tagged with their model; untagged ones are re-embedded
+	if version < 3 {
+		for _, table := range []string{"knowledge", "episodes"} {
+			if err := s.addColumns(table, "embedding_model TEXT", "embedding_dims INTEGER"); err != nil {
+				return err
+			}
+			index := fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%[1]s_embedding_model ON %[1]s(embedding_model)", table)
+			if _, err := s.db.Exec(index); err != nil {
+				return fmt.Errorf("failed to index %s embedding models: %w", table, err)
+			}
+		}
+	}
+
+	if _, err := s.db.Exec(fmt.Sprintf("PRAGMA user_version = %d", learningSchemaVersion)); err != nil {
+		return fmt.Errorf("failed to set schema version: %w", err)
+	}
+	return nil
+}
+
+// addColumns adds columns (given as "name TYPE") missing from a table
+// created before they were part of the schema
+func (s *SQLiteLearningDB) addColumns(table string, columns ...string) error {
+	rows, err := s.db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return fmt.Errorf("failed to read %s columns: %w", table, err)
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan %s column: %w", table, err)
+		}
+		existing[name] = true
+	}
+	rows.Close()
+
+	for _, column := range columns {
+		name := strings.Fields(column)[0]
+		if existing[name] {
+			continue
+		}
+		if _, err := s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column)); err != nil {
+			return fmt.Errorf("failed to add %s.%s: %w", table, name, err)
+		}
+	}
+	return nil
+}
+
+// loadIndex builds the ANN indexes from the knowledge and episode embeddings
+// on disk that came from the active model
+func (s *SQLiteLearningDB) loadIndex() error {
+	if err := s.loadTableIndex("knowledge", s.index); err != nil {
+		return err
+	}
+	return s.loadTableIndex("episodes", s.episodeIndex)
+}
+
+// loadTableIndex replaces target with an index of table's embeddings from
+// the active model
+func (s *SQLiteLearningDB) loadTableIndex(table string, target *hnswIndex) error {
+	rows, err := s.db.Query("SELECT id, embedding FROM "+table+" WHERE embedding IS NOT NULL AND COALESCE(embedding_model, '') = ?", s.activeModel())
+	if err != nil {
+		return fmt.Errorf("failed to load %s embedding index: %w", table, err)
+	}
+	defer rows.Close()
+
+	index := newHNSWIndex()
+	for rows.Next() {
+		var id string
+		var blob []byte
+		if err := rows.Scan(&id, &blob); err != nil {
+			return fmt.Errorf("failed to scan embedding: %w", err)
+		}
+		if embedding := decodeEmbedding(blob); len(embedding) > 0 {
+			index.Add(id, embedding)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating embeddings: %w", err)
+	}
+
+	target.replaceWith(index)
+	return nil
+}
+
+// indexKnowledge keeps the ANN index in step with a stored knowledge row
+func (s *SQLiteLearningDB) indexKnowledge(knowledge *Knowledge) {
+	if len(knowledge.Embedding) > 0 && knowledge.EmbeddingModel == s.activeModel() {
+		s.index.Add(knowledge.ID, knowledge.Embedding)
+	} else {
+		s.index.Remove(knowledge.ID)
+	}
+}
+
+// indexEpisode keeps the episode ANN index in step with a stored episode
+func (s *SQLiteLearningDB) indexEpisode(episode *Episode) {
+	if len(episode.Embedding) > 0 && episode.EmbeddingModel == s.activeModel() {
+		s.episodeIndex.Add(episode.ID, episode.Embedding)
+	} else {
+		s.episodeIndex.Remove(episode.ID)
+	}
 }
 
 // ================================================
@@ -70,9 +241,12 @@ func (s *SQLiteLearningDB) RecordEpisode(episode *Episode) error {
 		episode.Timestamp = time.Now()
 	}
 
+	embeddingBlob, embeddingModel, embeddingDims := s.embeddingColumns(episode.Embedding, &episode.EmbeddingModel)
+
 	query := `
-		INSERT INTO episodes (id, session_id, agent_id, event_type, content, context, outcome, timestamp, importance)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO episodes (id, session_id, agent_id, event_type, content, context, outcome, timestamp, importance,
+		                      project_path, embedding, embedding_model, embedding_dims)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
 	_, err := s.db.Exec(query,
@@ -85,17 +259,27 @@ func (s *SQLiteLearningDB) RecordEpisode(episode *Episode) error {
 		episode.Outcome,
 		episode.Timestamp,
 		episode.Importance,
+		episode.ProjectPath,
+		embeddingBlob,
+		embeddingModel,
+		embeddingDims,
 	)
 
 	if err != nil {
 		return fmt.Errorf("failed to record episode: %w", err)
 	}
+	s.indexEpisode(episode)
+
+	// Embedding is slow; leave it to the background embedder
+	if embeddingBlob == nil {
+		s.wakeEmbedder()
+	}
 	return nil
 }
 
 // GetEpisodes retrieves episodes based on filter criteria.
 func (s *SQLiteLearningDB) GetEpisodes(filter EpisodeFilter) ([]*Episode, error) {
-	query := "SELECT id, session_id, agent_id, event_type, content, context, outcome, timestamp, importance FROM episodes WHERE 1=1"
+	query := "SELECT " + episodeColumns + " FROM episodes WHERE 1=1"
 	args := []interface{}{}
 
 	if filter.SessionID != "" {
@@ -106,6 +290,10 @@ func (s *SQLiteLearningDB) GetEpisodes(filter EpisodeFilter) ([]*Episode, error)
 		query += " AND agent_id = ?"
 		args = append(args, filter.AgentID)
 	}
+	if filter.ProjectPath != "" {
+		query += " AND project_path = ?"
+		args = append(args, filter.ProjectPath)
+	}
 	if filter.EventType != "" {
 		query += " AND event_type = ?"
 		args = append(args, filter.EventType)
@@ -124,11 +312,7 @@ func (s *SQLiteLearningDB) GetEpisodes(filter EpisodeFilter) ([]*Episode, error)
 	}
 
 	query += " ORDER BY timestamp DESC"
-
-	if filter.Limit > 0 {
-		query += " LIMIT ?"
-		args = append(args, filter.Limit)
-	}
+	query, args = paginate(query, args, filter.Limit, filter.Offset)
 
 	rows, err := s.db.Query(query, args...)
 	if err != nil {
@@ -138,27 +322,9 @@ func (s *SQLiteLearningDB) GetEpisodes(filter EpisodeFilter) ([]*Episode, error)
 
 	episodes := []*Episode{}
 	for rows.Next() {
-		ep := &Episode{}
-		var context, outcome sql.NullString
-		err := rows.Scan(
-			&ep.ID,
-			&ep.SessionID,
-			&ep.AgentID,
-			&ep.EventType,
-			&ep.Content,
-			&context,
-			&outcome,
-			&ep.Timestamp,
-			&ep.Importance,
-		)
+		ep, err := scanEpisode(rows)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan episode: %w", err)
-		}
-		if context.Valid {
-			ep.Context = context.String
-		}
-		if outcome.Valid {
-			ep.Outcome = outcome.String
+			return nil, err
 		}
 		episodes = append(episodes, ep)
 	}
@@ -166,7 +332,70 @@ func (s *SQLiteLearningDB) GetEpisodes(filter EpisodeFilter) ([]*Episode, error)
 	return episodes, rows.Err()
 }
 
-// SummarizeEpisodes creates a summary of episodes for a session.
+// GetEpisode retrieves an episode by ID.
+func (s *SQLiteLearningDB) GetEpisode(id string) (*Episode, error) {
+	rows, err := s.db.Query("SELECT "+episodeColumns+" FROM episodes WHERE id = ?", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get episode: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to get episode: %w", err)
+		}
+		return nil, fmt.Errorf("episode %s %w", id, ErrNotFound)
+	}
+	return scanEpisode(rows)
+}
+
+// DeleteEpisode removes an episode.
+func (s *SQLiteLearningDB) DeleteEpisode(id string) error {
+	result, err := s.db.Exec("DELETE FROM episodes WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete episode: %w", err)
+	}
+	s.episodeIndex.Remove(id)
+	return requireRow(result, "episode", id)
+}
+
+// episodeColumns are the columns scanEpisode reads, in order
+const episodeColumns = "id, session_id, agent_id, event_type, content, context, outcome, timestamp, importance, project_path, embedding, embedding_model"
+
+// scanEpisode reads episodeColumns, then any extra destinations
+func scanEpisode(rows *sql.Rows, extra ...interface{}) (*Episode, error) {
+	ep := &Episode{}
+	var context, outcome, projectPath, embeddingModel sql.NullString
+	var embeddingBlob []byte
+	dest := append([]interface{}{
+		&ep.ID,
+		&ep.SessionID,
+		&ep.AgentID,
+		&ep.EventType,
+		&ep.Content,
+		&context,
+		&outcome,
+		&ep.Timestamp,
+		&ep.Importance,
+		&projectPath,
+		&embeddingBlob,
+		&embeddingModel,
+	}, extra...)
+	if err := rows.Scan(dest...); err != nil {
+		return nil, fmt.Errorf("failed to scan episode: %w", err)
+	}
+	ep.Context = context.String
+	ep.Outcome = outcome.String
+	ep.ProjectPath = projectPath.String
+	ep.EmbeddingModel = embeddingModel.String
+	if len(embeddingBlob) > 0 {
+		ep.Embedding = decodeEmbedding(embeddingBlob)
+	}
+	return ep, nil
+}
+
+// SummarizeEpisodes returns the stored summary for a session, creating one
+// from its episodes if none exists yet.
 func (s *SQLiteLearningDB) SummarizeEpisodes(sessionID string) (*EpisodeSummary, error) {
 	// Check if summary already exists
 	var summary EpisodeSummary
@@ -196,7 +425,13 @@ func (s *SQLiteLearningDB) SummarizeEpisodes(sessionID string) (*EpisodeSummary,
 		return nil, fmt.Errorf("failed to check for existing summary: %w", err)
 	}
 
-	// No summary exists, create a basic one from episodes
+	return s.RegenerateSummary(sessionID)
+}
+
+// RegenerateSummary summarizes a session's episodes afresh, replacing any
+// stored summary. The configured Summarizer is used when set; if it fails
+// the summary falls back to BasicSummary.
+func (s *SQLiteLearningDB) RegenerateSummary(sessionID string) (*EpisodeSummary, error) {
 	episodes, err := s.GetEpisodes(EpisodeFilter{
 		SessionID: sessionID,
 		Limit:     100,
@@ -213,31 +448,37 @@ func (s *SQLiteLearningDB) SummarizeEpisodes(sessionID string) (*EpisodeSummary,
 		}, nil
 	}
 
-	// Build basic summary
-	summary = EpisodeSummary{
-		SessionID: sessionID,
-		Summary:   fmt.Sprintf("Session with %d episodes", len(episodes)),
-		KeyEvents: []string{},
-		LessonsLearned: []string{},
-		CreatedAt: time.Now(),
-	}
+	// Oldest first, so the summary reads in order
+	sort.Slice(episodes, func(i, j int) bool {
+		return episodes[i].Timestamp.Before(episodes[j].Timestamp)
+	})
 
-	// Extract high-importance episodes as key events
-	for _, ep := range episodes {
-		if ep.Importance > 0.7 {
-			summary.KeyEvents = append(summary.KeyEvents, ep.Content)
-		}
-		if ep.EventType == "error" && ep.Outcome != "" {
-			summary.LessonsLearned = append(summary.LessonsLearned, ep.Outcome)
+	var summary *EpisodeSummary
+	if s.summarizer != nil {
+		summary, err = s.summarizer.Summarize(sessionID, episodes)
+		if err != nil {
+			log.Printf("[MEMORY] Summarizer failed for session %s, using basic summary: %v", sessionID, err)
+			summary = nil
 		}
 	}
+	if summary == nil {
+		summary = BasicSummary(sessionID, episodes)
+	}
+	summary.SessionID = sessionID
+	summary.CreatedAt = time.Now()
+	if summary.KeyEvents == nil {
+		summary.KeyEvents = []string{}
+	}
+	if summary.LessonsLearned == nil {
+		summary.LessonsLearned = []string{}
+	}
 
 	// Store the summary
 	keyEventsBytes, _ := json.Marshal(summary.KeyEvents)
 	lessonsBytes, _ := json.Marshal(summary.LessonsLearned)
 
 	insertQuery := `
-		INSERT INTO episode_summaries (session_id, summary, key_events, lessons_learned, created_at)
+		INSERT OR REPLACE INTO episode_summaries (session_id, summary, key_events, lessons_learned, created_at)
 		VALUES (?, ?, ?, ?, ?)
 	`
 	_, err = s.db.Exec(insertQuery, summary.SessionID, summary.Summary, keyEventsBytes, lessonsBytes, summary.CreatedAt)
@@ -245,7 +486,118 @@ func (s *SQLiteLearningDB) SummarizeEpisodes(sessionID string) (*EpisodeSummary,
 		return nil, fmt.Errorf("failed to store summary: %w", err)
 	}
 
-	return &summary, nil
+	return summary, nil
+}
+
+// BasicSummary builds a summary from episodes without a model: counts by
+// event type, high-importance episodes as key events and the outcomes of
+// errors as lessons.
+func BasicSummary(sessionID string, episodes []*Episode) *EpisodeSummary {
+	summary := &EpisodeSummary{
+		SessionID:      sessionID,
+		KeyEvents:      []string{},
+		LessonsLearned: []string{},
+		CreatedAt:      time.Now(),
+	}
+
+	counts := make(map[string]int)
+	var types []string
+	first, last := episodes[0].Timestamp, episodes[0].Timestamp
+	for _, ep := range episodes {
+		if counts[ep.EventType] == 0 {
+			types = append(types, ep.EventType)
+		}
+		counts[ep.EventType]++
+		if ep.Timestamp.Before(first) {
+			first = ep.Timestamp
+		}
+		if ep.Timestamp.After(last) {
+			last = ep.Timestamp
+		}
+
+		// Extract high-importance episodes as key events
+		if ep.Importance >= 0.7 {
+			summary.KeyEvents = append(summary.KeyEvents, ep.Content)
+		}
+		if ep.EventType == "error" && ep.Outcome != "" {
+			summary.LessonsLearned = append(summary.LessonsLearned, ep.Outcome)
+		}
+	}
+
+	sort.Strings(types)
+	parts := make([]string, 0, len(types))
+	for _, t := range types {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[t], t))
+	}
+	summary.Summary = fmt.Sprintf("Session with %d episodes (%s) over %s",
+		len(episodes), strings.Join(parts, ", "), last.Sub(first).Round(time.Second))
+
+	return summary
+}
+
+// PromoteLessons stores a session's lessons learned as error_solution
+// knowledge so they are retrieved for future tasks. Lessons already stored
+// from the same session are skipped.
+func (s *SQLiteLearningDB) PromoteLessons(sessionID string) ([]*Knowledge, error) {
+	summary, err := s.SummarizeEpisodes(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	source := "session:" + sessionID
+	promoted := []*Knowledge{}
+	for _, lesson := range summary.LessonsLearned {
+		lesson = strings.TrimSpace(lesson)
+		if lesson == "" {
+			continue
+		}
+
+		var exists int
+		err := s.db.QueryRow(
+			"SELECT COUNT(*) FROM knowledge WHERE category = 'error_solution' AND source = ? AND content = ?",
+			source, lesson,
+		).Scan(&exists)
+		if err != nil {
+			return promoted, fmt.Errorf("failed to check for existing lesson: %w", err)
+		}
+		if exists > 0 {
+			continue
+		}
+
+		knowledge := &Knowledge{
+			Category: "error_solution",
+			Title:    lessonTitle(lesson),
+			Content:  lesson,
+			Source:   source,
+			Tags:     []string{"lesson"},
+		}
+		if err := s.StoreKnowledge(knowledge); err != nil {
+			return promoted, err
+		}
+		promoted = append(promoted, knowledge)
+	}
+
+	return promoted, nil
+}
+
+// lessonTitle uses the first sentence of a lesson, capped at 80 characters
+func lessonTitle(lesson string) string {
+	title := lesson
+	if i := strings.Index(title, "\n"); i > 0 {
+		title = title[:i]
+	}
+	if i := strings.Index(title, ". "); i > 0 {
+		title = title[:i]
+	}
+	if runes := []rune(title); len(runes) > 80 {
+		title = string(runes[:77]) + "..."
+	}
+	return title
+}
+
+// SetSummarizer configures the model used to summarize sessions.
+func (s *SQLiteLearningDB) SetSummarizer(summarizer Summarizer) {
+	s.summarizer = summarizer
 }
 
 // PruneOldEpisodes removes episodes older than the specified time.
@@ -260,6 +612,13 @@ func (s *SQLiteLearningDB) PruneOldEpisodes(before time.Time) (int, error) {
 		return 0, fmt.Errorf("failed to get affected rows: %w", err)
 	}
 
+	// Rebuild the episode index without the pruned rows
+	if count > 0 {
+		if err := s.loadTableIndex("episodes", s.episodeIndex); err != nil {
+			return int(count), err
+		}
+	}
+
 	return int(count), nil
 }
 
@@ -279,30 +638,33 @@ func (s *SQLiteLearningDB) StoreKnowledge(knowledge *Knowledge) error {
 		knowledge.UpdatedAt = time.Now()
 	}
 
-	// Compute embedding if provider is set and embedding is empty
-	if s.embeddingProvider != nil && len(knowledge.Embedding) == 0 {
-		embedding, err := s.embeddingProvider.Embed(knowledge.Content)
+	// Compute embedding if provider is set and embedding is empty; the
+	// background embedder retries if this fails
+	if provider := s.provider(); provider != nil && len(knowledge.Embedding) == 0 {
+		embedding, err := provider.Embed(embedText(knowledge.Content))
 		if err == nil {
 			knowledge.Embedding = embedding
+			knowledge.EmbeddingModel = provider.Model()
+		} else {
+			s.wakeEmbedder()
 		}
 	}
 
-	var embeddingBlob []byte
-	if len(knowledge.Embedding) > 0 {
-		embeddingBlob = encodeEmbedding(knowledge.Embedding)
-	}
-
+	embeddingBlob, embeddingModel, embeddingDims := s.embeddingColumns(knowledge.Embedding, &knowledge.EmbeddingModel)
 	tagsJSON, _ := json.Marshal(knowledge.Tags)
 
 	query := `
-		INSERT INTO knowledge (id, category, title, content, source, embedding, tags, use_count, last_used, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO knowledge (id, category, title, content, source, embedding, embedding_model, embedding_dims,
+		                       tags, use_count, last_used, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(id) DO UPDATE SET
 			category = excluded.category,
 			title = excluded.title,
 			content = excluded.content,
 			source = excluded.source,
 			embedding = excluded.embedding,
+			embedding_model = excluded.embedding_model,
+			embedding_dims = excluded.embedding_dims,
 			tags = excluded.tags,
 			updated_at = excluded.updated_at
 	`
@@ -314,6 +676,8 @@ func (s *SQLiteLearningDB) StoreKnowledge(knowledge *Knowledge) error {
 		knowledge.Content,
 		knowledge.Source,
 		embeddingBlob,
+		embeddingModel,
+		embeddingDims,
 		tagsJSON,
 		knowledge.UseCount,
 		knowledge.LastUsed,
@@ -324,47 +688,56 @@ func (s *SQLiteLearningDB) StoreKnowledge(knowledge *Knowledge) error {
 	if err != nil {
 		return fmt.Errorf("failed to store knowledge: %w", err)
 	}
+
+	s.indexKnowledge(knowledge)
 	return nil
 }
 
 // GetKnowledge retrieves a knowledge item by ID.
 func (s *SQLiteLearningDB) GetKnowledge(id string) (*Knowledge, error) {
-	query := `
-		SELECT id, category, title, content, source, embedding, tags, use_count, last_used, created_at, updated_at
-		FROM knowledge WHERE id = ?
-	`
+	row := s.db.QueryRow("SELECT "+knowledgeColumns+" FROM knowledge WHERE id = ?", id)
+	k, err := scanKnowledge(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("knowledge %s %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get knowledge: %w", err)
+	}
+	return k, nil
+}
 
+// knowledgeColumns are the columns scanKnowledge reads, in order
+const knowledgeColumns = "id, category, title, content, source, embedding, embedding_model, tags, use_count, last_used, created_at, updated_at"
+
+// scanKnowledge reads knowledgeColumns from a row
+func scanKnowledge(row interface{ Scan(...interface{}) error }) (*Knowledge, error) {
 	k := &Knowledge{}
 	var embeddingBlob []byte
-	var tagsJSON, source sql.NullString
+	var tagsJSON, source, embeddingModel sql.NullString
 	var lastUsed sql.NullTime
 
-	err := s.db.QueryRow(query, id).Scan(
+	err := row.Scan(
 		&k.ID,
 		&k.Category,
 		&k.Title,
 		&k.Content,
 		&source,
 		&embeddingBlob,
+		&embeddingModel,
 		&tagsJSON,
 		&k.UseCount,
 		&lastUsed,
 		&k.CreatedAt,
 		&k.UpdatedAt,
 	)
-
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("knowledge not found: %s", id)
-	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get knowledge: %w", err)
+		return nil, err
 	}
 
-	if source.Valid {
-		k.Source = source.String
-	}
+	k.Source = source.String
 	if len(embeddingBlob) > 0 {
 		k.Embedding = decodeEmbedding(embeddingBlob)
+		k.EmbeddingModel = embeddingModel.String
 	}
 	if tagsJSON.Valid {
 		json.Unmarshal([]byte(tagsJSON.String), &k.Tags)
@@ -372,7 +745,6 @@ func (s *SQLiteLearningDB) GetKnowledge(id string) (*Knowledge, error) {
 	if lastUsed.Valid {
 		k.LastUsed = &lastUsed.Time
 	}
-
 	return k, nil
 }
 
@@ -380,17 +752,13 @@ func (s *SQLiteLearningDB) GetKnowledge(id string) (*Knowledge, error) {
 func (s *SQLiteLearningDB) UpdateKnowledge(knowledge *Knowledge) error {
 	knowledge.UpdatedAt = time.Now()
 
-	var embeddingBlob []byte
-	if len(knowledge.Embedding) > 0 {
-		embeddingBlob = encodeEmbedding(knowledge.Embedding)
-	}
-
+	embeddingBlob, embeddingModel, embeddingDims := s.embeddingColumns(knowledge.Embedding, &knowledge.EmbeddingModel)
 	tagsJSON, _ := json.Marshal(knowledge.Tags)
 
 	query := `
 		UPDATE knowledge
-		SET category = ?, title = ?, content = ?, source = ?, embedding = ?, tags = ?,
-		    use_count = ?, last_used = ?, updated_at = ?
+		SET category = ?, title = ?, content = ?, source = ?, embedding = ?, embedding_model = ?, embedding_dims = ?,
+		    tags = ?, use_count = ?, last_used = ?, updated_at = ?
 		WHERE id = ?
 	`
 
@@ -400,6 +768,8 @@ func (s *SQLiteLearningDB) UpdateKnowledge(knowledge *Knowledge) error {
 		knowledge.Content,
 		knowledge.Source,
 		embeddingBlob,
+		embeddingModel,
+		embeddingDims,
 		tagsJSON,
 		knowledge.UseCount,
 		knowledge.LastUsed,
@@ -413,51 +783,128 @@ func (s *SQLiteLearningDB) UpdateKnowledge(knowledge *Knowledge) error {
 
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("knowledge not found: %s", knowledge.ID)
+		return fmt.Errorf("knowledge %s %w", knowledge.ID, ErrNotFound)
 	}
 
+	s.indexKnowledge(knowledge)
+	if embeddingBlob == nil {
+		s.wakeEmbedder()
+	}
 	return nil
 }
 
-// SearchKnowledge searches by text query (falls back to simple text search if no embeddings).
-func (s *SQLiteLearningDB) SearchKnowledge(query string, limit int) ([]*Knowledge, error) {
-	// If embedding provider is available, compute embedding and use semantic search
-	if s.embeddingProvider != nil {
-		embedding, err := s.embeddingProvider.Embed(query)
-		if err == nil {
-			return s.SearchByEmbedding(embedding, limit)
-		}
+// ListKnowledge lists knowledge items matching the filter, newest first.
+func (s *SQLiteLearningDB) ListKnowledge(filter KnowledgeFilter) ([]*Knowledge, error) {
+	query := "SELECT " + knowledgeColumns + " FROM knowledge WHERE 1=1"
+	args := []interface{}{}
+
+	if filter.Category != "" {
+		query += " AND category = ?"
+		args = append(args, filter.Category)
+	}
+	if filter.Source != "" {
+		query += " AND source = ?"
+		args = append(args, filter.Source)
+	}
+	if filter.Tag != "" {
+		query += " AND EXISTS (SELECT 1 FROM json_each(knowledge.tags) WHERE value = ?)"
+		args = append(args, filter.Tag)
 	}
 
-	// Fall back to text search
-	sqlQuery := `
-		SELECT id, category, title, content, source, embedding, tags, use_count, last_used, created_at, updated_at
-		FROM knowledge
-		WHERE title LIKE ? OR content LIKE ? OR tags LIKE ?
-		ORDER BY use_count DESC
-		LIMIT ?
-	`
+	query += " ORDER BY created_at DESC"
+	query, args = paginate(query, args, filter.Limit, filter.Offset)
 
-	pattern := "%" + query + "%"
-	rows, err := s.db.Query(sqlQuery, pattern, pattern, pattern, limit)
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to search knowledge: %w", err)
+		return nil, fmt.Errorf("failed to list knowledge: %w", err)
 	}
 	defer rows.Close()
 
 	return s.scanKnowledgeRows(rows)
 }
 
-// SearchByEmbedding performs semantic search using cosine similarity.
+// DeleteKnowledge removes a knowledge item.
+func (s *SQLiteLearningDB) DeleteKnowledge(id string) error {
+	result, err := s.db.Exec("DELETE FROM knowledge WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete knowledge: %w", err)
+	}
+	if err := requireRow(result, "knowledge", id); err != nil {
+		return err
+	}
+
+	s.index.Remove(id)
+	return nil
+}
+
+// MarkKnowledgeUsed increments use_count and sets last_used for knowledge
+// items that were handed to an agent. Unknown IDs are ignored.
+func (s *SQLiteLearningDB) MarkKnowledgeUsed(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`UPDATE knowledge SET use_count = use_count + 1, last_used = ? WHERE id = ?`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare knowledge use update: %w", err)
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	for _, id := range ids {
+		if _, err := stmt.Exec(now, id); err != nil {
+			return fmt.Errorf("failed to mark knowledge %s used: %w", id, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SearchKnowledge searches by text query, fusing semantic and keyword
+// rankings (keyword only when no embeddings are available).
+func (s *SQLiteLearningDB) SearchKnowledge(query string, limit int) ([]*Knowledge, error) {
+	hits, err := s.SearchKnowledgeScored(query, KnowledgeSearchOptions{Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*Knowledge, len(hits))
+	for i, hit := range hits {
+		results[i] = hit.Knowledge
+	}
+	return results, nil
+}
+
+// SearchByEmbedding performs semantic search using cosine similarity,
+// answered from the in-process HNSW index.
 func (s *SQLiteLearningDB) SearchByEmbedding(embedding []float32, limit int) ([]*Knowledge, error) {
-	// Get all knowledge items with embeddings
-	query := `
-		SELECT id, category, title, content, source, embedding, tags, use_count, last_used, created_at, updated_at
-		FROM knowledge
-		WHERE embedding IS NOT NULL
-	`
+	hits := s.index.Search(embedding, limit)
+
+	results := make([]*Knowledge, 0, len(hits))
+	for _, hit := range hits {
+		k, err := s.GetKnowledge(hit.ID)
+		if err != nil {
+			// Deleted since it was indexed
+			continue
+		}
+		results = append(results, k)
+	}
+
+	return results, nil
+}
+
+// searchByEmbeddingExact scores every stored embedding from the active
+// model. It is the brute-force baseline the HNSW index is benchmarked against.
+func (s *SQLiteLearningDB) searchByEmbeddingExact(embedding []float32, limit int) ([]*Knowledge, error) {
+	query := "SELECT " + knowledgeColumns + " FROM knowledge WHERE embedding IS NOT NULL AND COALESCE(embedding_model, '') = ?"
 
-	rows, err := s.db.Query(query)
+	rows, err := s.db.Query(query, s.activeModel())
 	if err != nil {
 		return nil, fmt.Errorf("failed to query knowledge: %w", err)
 	}
@@ -471,41 +918,11 @@ func (s *SQLiteLearningDB) SearchByEmbedding(embedding []float32, limit int) ([]
 	scored := []scoredKnowledge{}
 
 	for rows.Next() {
-		k := &Knowledge{}
-		var embeddingBlob []byte
-		var tagsJSON, source sql.NullString
-		var lastUsed sql.NullTime
-
-		err := rows.Scan(
-			&k.ID,
-			&k.Category,
-			&k.Title,
-			&k.Content,
-			&source,
-			&embeddingBlob,
-			&tagsJSON,
-			&k.UseCount,
-			&lastUsed,
-			&k.CreatedAt,
-			&k.UpdatedAt,
-		)
+		k, err := scanKnowledge(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan knowledge: %w", err)
 		}
 
-		if source.Valid {
-			k.Source = source.String
-		}
-		if len(embeddingBlob) > 0 {
-			k.Embedding = decodeEmbedding(embeddingBlob)
-		}
-		if tagsJSON.Valid {
-			json.Unmarshal([]byte(tagsJSON.String), &k.Tags)
-		}
-		if lastUsed.Valid {
-			k.LastUsed = &lastUsed.Time
-		}
-
 		// Compute similarity
 		if len(k.Embedding) > 0 {
 			similarity := cosineSimilarity(embedding, k.Embedding)
@@ -518,13 +935,9 @@ func (s *SQLiteLearningDB) SearchByEmbedding(embedding []float32, limit int) ([]
 	}
 
 	// Sort by score descending
-	for i := 0; i < len(scored); i++ {
-		for j := i + 1; j < len(scored); j++ {
-			if scored[j].score > scored[i].score {
-				scored[i], scored[j] = scored[j], scored[i]
-			}
-		}
-	}
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].score > scored[j].score
+	})
 
 	// Return top N
 	if limit > len(scored) {
@@ -544,41 +957,10 @@ func (s *SQLiteLearningDB) scanKnowledgeRows(rows *sql.Rows) ([]*Knowledge, erro
 	results := []*Knowledge{}
 
 	for rows.Next() {
-		k := &Knowledge{}
-		var embeddingBlob []byte
-		var tagsJSON, source sql.NullString
-		var lastUsed sql.NullTime
-
-		err := rows.Scan(
-			&k.ID,
-			&k.Category,
-			&k.Title,
-			&k.Content,
-			&source,
-			&embeddingBlob,
-			&tagsJSON,
-			&k.UseCount,
-			&lastUsed,
-			&k.CreatedAt,
-			&k.UpdatedAt,
-		)
+		k, err := scanKnowledge(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan knowledge: %w", err)
 		}
-
-		if source.Valid {
-			k.Source = source.String
-		}
-		if len(embeddingBlob) > 0 {
-			k.Embedding = decodeEmbedding(embeddingBlob)
-		}
-		if tagsJSON.Valid {
-			json.Unmarshal([]byte(tagsJSON.String), &k.Tags)
-		}
-		if lastUsed.Valid {
-			k.LastUsed = &lastUsed.Time
-		}
-
 		results = append(results, k)
 	}
 
@@ -641,16 +1023,27 @@ func (s *SQLiteLearningDB) StoreProcedure(procedure *Procedure) error {
 
 // GetProcedure retrieves a procedure by ID.
 func (s *SQLiteLearningDB) GetProcedure(id string) (*Procedure, error) {
-	query := `
-		SELECT id, name, description, task_type, steps, preconditions, success_count, failure_count, last_used, created_at, updated_at
-		FROM procedures WHERE id = ?
-	`
+	p, err := scanProcedure(s.db.QueryRow("SELECT "+procedureColumns+" FROM procedures WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("procedure %s %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get procedure: %w", err)
+	}
 
+	return p, nil
+}
+
+// procedureColumns are the columns scanProcedure reads, in order
+const procedureColumns = "id, name, description, task_type, steps, preconditions, success_count, failure_count, last_used, created_at, updated_at"
+
+// scanProcedure reads procedureColumns from a row
+func scanProcedure(row interface{ Scan(...interface{}) error }) (*Procedure, error) {
 	p := &Procedure{}
 	var stepsJSON, preconditionsJSON, description sql.NullString
 	var lastUsed sql.NullTime
 
-	err := s.db.QueryRow(query, id).Scan(
+	err := row.Scan(
 		&p.ID,
 		&p.Name,
 		&description,
@@ -663,12 +1056,8 @@ func (s *SQLiteLearningDB) GetProcedure(id string) (*Procedure, error) {
 		&p.CreatedAt,
 		&p.UpdatedAt,
 	)
-
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("procedure not found: %s", id)
-	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get procedure: %w", err)
+		return nil, err
 	}
 
 	if description.Valid {
@@ -689,8 +1078,7 @@ func (s *SQLiteLearningDB) GetProcedure(id string) (*Procedure, error) {
 
 // FindProcedures searches for procedures by task type and optional context.
 func (s *SQLiteLearningDB) FindProcedures(taskType string, context string) ([]*Procedure, error) {
-	query := `
-		SELECT id, name, description, task_type, steps, preconditions, success_count, failure_count, last_used, created_at, updated_at
+	query := "SELECT " + procedureColumns + `
 		FROM procedures
 		WHERE task_type = ?
 		ORDER BY success_count DESC, last_used DESC
@@ -704,59 +1092,97 @@ func (s *SQLiteLearningDB) FindProcedures(taskType string, context string) ([]*P
 
 	procedures := []*Procedure{}
 
+	context = strings.ToLower(context)
 	for rows.Next() {
-		p := &Procedure{}
-		var stepsJSON, preconditionsJSON, description sql.NullString
-		var lastUsed sql.NullTime
-
-		err := rows.Scan(
-			&p.ID,
-			&p.Name,
-			&description,
-			&p.TaskType,
-			&stepsJSON,
-			&preconditionsJSON,
-			&p.SuccessCount,
-			&p.FailureCount,
-			&lastUsed,
-			&p.CreatedAt,
-			&p.UpdatedAt,
-		)
+		p, err := scanProcedure(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan procedure: %w", err)
 		}
 
-		if description.Valid {
-			p.Description = description.String
-		}
-		if stepsJSON.Valid {
-			json.Unmarshal([]byte(stepsJSON.String), &p.Steps)
-		}
-		if preconditionsJSON.Valid {
-			json.Unmarshal([]byte(preconditionsJSON.String), &p.Preconditions)
-		}
-		if lastUsed.Valid {
-			p.LastUsed = &lastUsed.Time
+		// If context is provided, filter by matching preconditions or description
+		if context != "" && !strings.Contains(strings.ToLower(p.Description), context) &&
+			!strings.Contains(strings.ToLower(strings.Join(p.Preconditions, "\n")), context) {
+			continue
 		}
+		procedures = append(procedures, p)
+	}
 
-		// If context is provided, filter by matching preconditions or description
-		if context != "" {
-			if description.Valid && strings.Contains(strings.ToLower(description.String), strings.ToLower(context)) {
-				procedures = append(procedures, p)
-				continue
-			}
-			if preconditionsJSON.Valid && strings.Contains(strings.ToLower(preconditionsJSON.String), strings.ToLower(context)) {
-				procedures = append(procedures, p)
-				continue
-			}
-		} else {
-			procedures = append(procedures, p)
+	return procedures, rows.Err()
+}
+
+// ListProcedures lists procedures matching the filter, most successful first.
+func (s *SQLiteLearningDB) ListProcedures(filter ProcedureFilter) ([]*Procedure, error) {
+	query := "SELECT " + procedureColumns + " FROM procedures WHERE 1=1"
+	args := []interface{}{}
+
+	if filter.TaskType != "" {
+		query += " AND task_type = ?"
+		args = append(args, filter.TaskType)
+	}
+
+	query += " ORDER BY success_count DESC, created_at DESC"
+	query, args = paginate(query, args, filter.Limit, filter.Offset)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list procedures: %w", err)
+	}
+	defer rows.Close()
+
+	procedures := []*Procedure{}
+	for rows.Next() {
+		p, err := scanProcedure(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan procedure: %w", err)
 		}
+		procedures = append(procedures, p)
 	}
 
 	return procedures, rows.Err()
 }
 
+// UpdateProcedure saves all fields of an existing procedure.
+func (s *SQLiteLearningDB) UpdateProcedure(procedure *Procedure) error {
+	procedure.UpdatedAt = time.Now()
+
+	stepsJSON, _ := json.Marshal(procedure.Steps)
+	preconditionsJSON, _ := json.Marshal(procedure.Preconditions)
+
+	query := `
+		UPDATE procedures
+		SET name = ?, description = ?, task_type = ?, steps = ?, preconditions = ?,
+		    success_count = ?, failure_count = ?, last_used = ?, updated_at = ?
+		WHERE id = ?
+	`
+
+	result, err := s.db.Exec(query,
+		procedure.Name,
+		procedure.Description,
+		procedure.TaskType,
+		stepsJSON,
+		preconditionsJSON,
+		procedure.SuccessCount,
+		procedure.FailureCount,
+		procedure.LastUsed,
+		procedure.UpdatedAt,
+		procedure.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update procedure: %w", err)
+	}
+
+	return requireRow(result, "procedure", procedure.ID)
+}
+
+// DeleteProcedure removes a procedure.
+func (s *SQLiteLearningDB) DeleteProcedure(id string) error {
+	result, err := s.db.Exec("DELETE FROM procedures WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete procedure: %w", err)
+	}
+	return requireRow(result, "procedure", id)
+}
+
 // UpdateProcedureSuccess updates the success/failure count of a procedure.
 func (s *SQLiteLearningDB) UpdateProcedureSuccess(id string, success bool) error {
 	now := time.Now()
@@ -783,7 +1209,7 @@ func (s *SQLiteLearningDB) UpdateProcedureSuccess(id string, success bool) error
 
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("procedure not found: %s", id)
+		return fmt.Errorf("procedure %s %w", id, ErrNotFound)
 	}
 
 	return nil
@@ -795,22 +1221,101 @@ func (s *SQLiteLearningDB) UpdateProcedureSuccess(id string, success bool) error
 
 // ComputeEmbedding generates an embedding for text.
 func (s *SQLiteLearningDB) ComputeEmbedding(text string) ([]float32, error) {
-	if s.embeddingProvider == nil {
+	provider := s.provider()
+	if provider == nil {
 		return nil, fmt.Errorf("no embedding provider configured")
 	}
-	return s.embeddingProvider.Embed(text)
+	return provider.Embed(text)
 }
 
-// SetEmbeddingProvider configures the embedding provider.
+// SetEmbeddingProvider configures the embedding provider. Searches only
+// compare embeddings from its model; knowledge and episodes embedded by
+// another model (or not at all) are re-embedded in the background.
 func (s *SQLiteLearningDB) SetEmbeddingProvider(provider EmbeddingProvider) error {
+	previous := s.activeModel()
+	s.providerMu.Lock()
 	s.embeddingProvider = provider
+	s.providerMu.Unlock()
+
+	if s.activeModel() != previous {
+		if err := s.loadIndex(); err != nil {
+			return err
+		}
+	}
+	s.wakeEmbedder()
 	return nil
 }
 
+// provider returns the embedding provider, nil when none is set
+func (s *SQLiteLearningDB) provider() EmbeddingProvider {
+	s.providerMu.RLock()
+	defer s.providerMu.RUnlock()
+	return s.embeddingProvider
+}
+
+// activeModel identifies the provider's model; "" when none is set, which
+// matches embeddings stored without a provider
+func (s *SQLiteLearningDB) activeModel() string {
+	if provider := s.provider(); provider != nil {
+		return provider.Model()
+	}
+	return ""
+}
+
+// embeddingColumns renders an embedding for storage, tagging it with the
+// active model when the caller did not say which model produced it
+func (s *SQLiteLearningDB) embeddingColumns(embedding []float32, model *string) ([]byte, sql.NullString, sql.NullInt64) {
+	if len(embedding) == 0 {
+		*model = ""
+		return nil, sql.NullString{}, sql.NullInt64{}
+	}
+	if *model == "" {
+		*model = s.activeModel()
+	}
+	return encodeEmbedding(embedding), nullString(*model), sql.NullInt64{Int64: int64(len(embedding)), Valid: true}
+}
+
+// nullString stores "" as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
 // ================================================
 // Maintenance
 // ================================================
 
+// Stats counts episodes, knowledge, procedures and cached embeddings.
+func (s *SQLiteLearningDB) Stats() (*LearningStats, error) {
+	stats := &LearningStats{KnowledgeByCategory: make(map[string]int)}
+
+	err := s.db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM episodes),
+			(SELECT COUNT(*) FROM knowledge WHERE embedding IS NOT NULL AND (?1 = '' OR embedding_model = ?1)),
+			(SELECT COUNT(*) FROM procedures),
+			(SELECT COUNT(*) FROM embedding_cache)
+	`, s.activeModel()).Scan(&stats.Episodes, &stats.KnowledgeEmbedded, &stats.Procedures, &stats.CachedEmbeddings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count learning data: %w", err)
+	}
+
+	rows, err := s.db.Query("SELECT category, COUNT(*) FROM knowledge GROUP BY category")
+	if err != nil {
+		return nil, fmt.Errorf("failed to count knowledge: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var category string
+		var count int
+		if err := rows.Scan(&category, &count); err != nil {
+			return nil, err
+		}
+		stats.KnowledgeByCategory[category] = count
+		stats.Knowledge += count
+	}
+	return stats, rows.Err()
+}
+
 // CompactKnowledge removes duplicate or low-value knowledge.
 func (s *SQLiteLearningDB) CompactKnowledge() error {
 	// Remove knowledge that has never been used and is older than 30 days
@@ -823,6 +1328,18 @@ func (s *SQLiteLearningDB) CompactKnowledge() error {
 		return fmt.Errorf("failed to compact knowledge: %w", err)
 	}
 
+	// Cached embeddings from models no longer in use will not be asked for
+	if model := s.activeModel(); model != "" {
+		if _, err := s.db.Exec("DELETE FROM embedding_cache WHERE model != ?", model); err != nil {
+			return fmt.Errorf("failed to compact embedding cache: %w", err)
+		}
+	}
+
+	// Rebuild the index without the removed rows and their tombstones
+	if err := s.loadIndex(); err != nil {
+		return err
+	}
+
 	// Vacuum to reclaim space
 	_, err = s.db.Exec("VACUUM")
 	if err != nil {
@@ -834,6 +1351,10 @@ func (s *SQLiteLearningDB) CompactKnowledge() error {
 
 // Close closes the database connection.
 func (s *SQLiteLearningDB) Close() error {
+	s.closeOnce.Do(func() {
+		s.cancel()
+		<-s.embedderDone
+	})
 	return s.db.Close()
 }
 
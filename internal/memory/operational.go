@@ -5,6 +5,7 @@ import (
 	_ "embed"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -59,7 +60,8 @@ func (s *SQLiteOperationalDB) Close() error {
 // Agent Lifecycle
 // ================================================
 
-// RegisterAgent registers a new agent
+// RegisterAgent registers a new agent, or re-registers an existing agent ID
+// (e.g. after a restart) keeping its original creation time
 func (s *SQLiteOperationalDB) RegisterAgent(agent *AgentState) error {
 	if agent.AgentID == "" {
 		agent.AgentID = uuid.New().String()
@@ -80,6 +82,19 @@ func (s *SQLiteOperationalDB) RegisterAgent(agent *AgentState) error {
 			session_id, pid, heartbeat_at, shutdown_flag, shutdown_reason,
 			created_at, updated_at, metadata
 		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(agent_id) DO UPDATE SET
+			agent_type = excluded.agent_type,
+			model = excluded.model,
+			status = excluded.status,
+			current_task = excluded.current_task,
+			project_path = excluded.project_path,
+			session_id = excluded.session_id,
+			pid = excluded.pid,
+			heartbeat_at = excluded.heartbeat_at,
+			shutdown_flag = excluded.shutdown_flag,
+			shutdown_reason = excluded.shutdown_reason,
+			updated_at = excluded.updated_at,
+			metadata = excluded.metadata
 	`
 
 	_, err = s.db.Exec(query,
@@ -126,7 +141,7 @@ func (s *SQLiteOperationalDB) GetAgent(agentID string) (*AgentState, error) {
 		&metadata)
 
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("agent not found: %s", agentID)
+		return nil, fmt.Errorf("agent %s %w", agentID, ErrNotFound)
 	}
 	if err != nil {
 		return nil, err
@@ -174,20 +189,16 @@ func (s *SQLiteOperationalDB) ListAgents(filter AgentFilter) ([]*AgentState, err
 		query += " AND agent_type = ?"
 		args = append(args, filter.AgentType)
 	}
-	if filter.Active != nil && *filter.Active {
-		query += " AND status != 'stopped'"
+	if filter.Active != nil {
+		if *filter.Active {
+			query += " AND status != 'stopped'"
+		} else {
+			query += " AND status = 'stopped'"
+		}
 	}
 
 	query += " ORDER BY created_at DESC"
-
-	if filter.Limit > 0 {
-		query += " LIMIT ?"
-		args = append(args, filter.Limit)
-	}
-	if filter.Offset > 0 {
-		query += " OFFSET ?"
-		args = append(args, filter.Offset)
-	}
+	query, args = paginate(query, args, filter.Limit, filter.Offset)
 
 	rows, err := s.db.Query(query, args...)
 	if err != nil {
@@ -273,6 +284,53 @@ func (s *SQLiteOperationalDB) MarkStopped(agentID string, reason string) error {
 	return err
 }
 
+// UpdateAgentMetadata merges keys into an agent's metadata; an empty value removes the key
+func (s *SQLiteOperationalDB) UpdateAgentMetadata(agentID string, metadata map[string]string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var raw sql.NullString
+	err = tx.QueryRow(`SELECT metadata FROM agents WHERE agent_id = ?`, agentID).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("agent %s %w", agentID, ErrNotFound)
+	}
+	if err != nil {
+		return err
+	}
+
+	merged := make(map[string]string)
+	if raw.Valid && raw.String != "" && raw.String != "null" {
+		if err := json.Unmarshal([]byte(raw.String), &merged); err != nil {
+			return fmt.Errorf("failed to unmarshal metadata: %w", err)
+		}
+	}
+	for key, value := range metadata {
+		if value == "" {
+			delete(merged, key)
+		} else {
+			merged[key] = value
+		}
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	query := `
+		UPDATE agents
+		SET metadata = ?, updated_at = ?
+		WHERE agent_id = ?
+	`
+	if _, err := tx.Exec(query, string(data), time.Now(), agentID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 // CleanupStaleAgents marks agents as unreachable if they haven't heartbeated
 func (s *SQLiteOperationalDB) CleanupStaleAgents(threshold time.Duration) (int, error) {
 	cutoff := time.Now().Add(-threshold)
@@ -361,6 +419,18 @@ func (s *SQLiteOperationalDB) UpdateTaskProgress(taskID string, status TaskStatu
 	return err
 }
 
+// ReleaseTask returns a claimed task to the queue, unassigning it so any
+// agent can claim it again
+func (s *SQLiteOperationalDB) ReleaseTask(taskID, note string) error {
+	query := `
+		UPDATE tasks
+		SET status = 'pending', assigned_to = NULL, progress = ?, updated_at = ?
+		WHERE id = ?
+	`
+	_, err := s.db.Exec(query, note, time.Now(), taskID)
+	return err
+}
+
 // CompleteTask marks a task as completed
 func (s *SQLiteOperationalDB) CompleteTask(taskID, summary string) error {
 	now := time.Now()
@@ -395,7 +465,7 @@ func (s *SQLiteOperationalDB) GetTask(taskID string) (*Task, error) {
 		&task.CreatedAt, &task.UpdatedAt, &completedAt, &metadata)
 
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("task not found: %s", taskID)
+		return nil, fmt.Errorf("task %s %w", taskID, ErrNotFound)
 	}
 	if err != nil {
 		return nil, err
@@ -450,15 +520,7 @@ func (s *SQLiteOperationalDB) ListTasks(filter TaskFilter) ([]*Task, error) {
 	}
 
 	query += " ORDER BY priority DESC, created_at ASC"
-
-	if filter.Limit > 0 {
-		query += " LIMIT ?"
-		args = append(args, filter.Limit)
-	}
-	if filter.Offset > 0 {
-		query += " OFFSET ?"
-		args = append(args, filter.Offset)
-	}
+	query, args = paginate(query, args, filter.Limit, filter.Offset)
 
 	rows, err := s.db.Query(query, args...)
 	if err != nil {
@@ -505,6 +567,48 @@ func (s *SQLiteOperationalDB) ListTasks(filter TaskFilter) ([]*Task, error) {
 	return tasks, rows.Err()
 }
 
+// UpdateTask saves all of a task's fields. Completing a task without a
+// completion time stamps it now.
+func (s *SQLiteOperationalDB) UpdateTask(task *Task) error {
+	task.UpdatedAt = time.Now()
+	if task.Status == TaskStatusCompleted && task.CompletedAt == nil {
+		now := task.UpdatedAt
+		task.CompletedAt = &now
+	}
+
+	metadata, err := json.Marshal(task.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	query := `
+		UPDATE tasks
+		SET title = ?, description = ?, task_type = ?, priority = ?, status = ?,
+			assigned_to = ?, project_path = ?, progress = ?, summary = ?,
+			updated_at = ?, completed_at = ?, metadata = ?
+		WHERE id = ?
+	`
+	result, err := s.db.Exec(query,
+		task.Title, task.Description, task.TaskType, task.Priority,
+		task.Status, task.AssignedTo, task.ProjectPath, task.Progress,
+		task.Summary, task.UpdatedAt, task.CompletedAt, string(metadata),
+		task.ID)
+	if err != nil {
+		return err
+	}
+
+	return requireRow(result, "task", task.ID)
+}
+
+// DeleteTask removes a task
+func (s *SQLiteOperationalDB) DeleteTask(taskID string) error {
+	result, err := s.db.Exec("DELETE FROM tasks WHERE id = ?", taskID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result, "task", taskID)
+}
+
 // ================================================
 // Session Management
 // ================================================
@@ -541,17 +645,73 @@ func (s *SQLiteOperationalDB) GetSession(sessionID string) (*Session, error) {
 		WHERE id = ?
 	`
 
+	session, err := scanSession(s.db.QueryRow(query, sessionID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("session %s %w", sessionID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// ListSessions lists sessions matching the filter, newest first
+func (s *SQLiteOperationalDB) ListSessions(filter SessionFilter) ([]*Session, error) {
+	query := `
+		SELECT id, agent_id, project_path, started_at, ended_at, tokens_used,
+			   tasks_completed, summary
+		FROM sessions
+		WHERE 1=1
+	`
+	args := []interface{}{}
+
+	if filter.AgentID != "" {
+		query += " AND agent_id = ?"
+		args = append(args, filter.AgentID)
+	}
+	if filter.ProjectPath != "" {
+		query += " AND project_path = ?"
+		args = append(args, filter.ProjectPath)
+	}
+	if filter.Active != nil {
+		if *filter.Active {
+			query += " AND ended_at IS NULL"
+		} else {
+			query += " AND ended_at IS NOT NULL"
+		}
+	}
+
+	query += " ORDER BY started_at DESC"
+	query, args = paginate(query, args, filter.Limit, filter.Offset)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// scanSession reads a session row selected in the column order GetSession uses
+func scanSession(row interface{ Scan(...interface{}) error }) (*Session, error) {
 	var session Session
 	var projectPath, summary sql.NullString
 	var endedAt sql.NullTime
 
-	err := s.db.QueryRow(query, sessionID).Scan(
+	err := row.Scan(
 		&session.ID, &session.AgentID, &projectPath, &session.StartedAt,
 		&endedAt, &session.TokensUsed, &session.TasksCompleted, &summary)
-
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("session not found: %s", sessionID)
-	}
 	if err != nil {
 		return nil, err
 	}
@@ -589,14 +749,7 @@ func (s *SQLiteOperationalDB) GetActiveSession(agentID string) (*Session, error)
 		LIMIT 1
 	`
 
-	var session Session
-	var projectPath, summary sql.NullString
-	var endedAt sql.NullTime
-
-	err := s.db.QueryRow(query, agentID).Scan(
-		&session.ID, &session.AgentID, &projectPath, &session.StartedAt,
-		&endedAt, &session.TokensUsed, &session.TasksCompleted, &summary)
-
+	session, err := scanSession(s.db.QueryRow(query, agentID))
 	if err == sql.ErrNoRows {
 		return nil, nil // No active session
 	}
@@ -604,13 +757,130 @@ func (s *SQLiteOperationalDB) GetActiveSession(agentID string) (*Session, error)
 		return nil, err
 	}
 
-	session.ProjectPath = projectPath.String
-	session.Summary = summary.String
-	if endedAt.Valid {
-		session.EndedAt = &endedAt.Time
+	return session, nil
+}
+
+// DeleteSession removes a session and its transcript
+func (s *SQLiteOperationalDB) DeleteSession(sessionID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	return &session, nil
+	if _, err := tx.Exec("DELETE FROM transcript_lines WHERE session_id = ?", sessionID); err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM sessions WHERE id = ?", sessionID)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(result, "session", sessionID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ================================================
+// Session Transcripts
+// ================================================
+
+// AppendTranscript records transcript lines in order, in one transaction
+func (s *SQLiteOperationalDB) AppendTranscript(lines []*TranscriptLine) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO transcript_lines (session_id, agent_id, stream, content, timestamp)
+		VALUES (?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, line := range lines {
+		if line.Timestamp.IsZero() {
+			line.Timestamp = time.Now()
+		}
+		result, err := stmt.Exec(line.SessionID, line.AgentID, line.Stream, line.Content, line.Timestamp)
+		if err != nil {
+			return err
+		}
+		if line.Seq, err = result.LastInsertId(); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetTranscript retrieves transcript lines matching the filter in recording order
+func (s *SQLiteOperationalDB) GetTranscript(filter TranscriptFilter) ([]*TranscriptLine, error) {
+	query := `
+		SELECT id, session_id, agent_id, stream, content, timestamp
+		FROM transcript_lines
+		WHERE id > ?
+	`
+	args := []interface{}{filter.AfterSeq}
+
+	if filter.SessionID != "" {
+		query += " AND session_id = ?"
+		args = append(args, filter.SessionID)
+	}
+	if filter.AgentID != "" {
+		query += " AND agent_id = ?"
+		args = append(args, filter.AgentID)
+	}
+	if filter.Stream != "" {
+		query += " AND stream = ?"
+		args = append(args, filter.Stream)
+	}
+	if filter.Contains != "" {
+		query += ` AND content LIKE ? ESCAPE '\'`
+		args = append(args, "%"+escapeLike(filter.Contains)+"%")
+	}
+
+	query += " ORDER BY id ASC"
+
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+		if filter.Offset > 0 {
+			query += " OFFSET ?"
+			args = append(args, filter.Offset)
+		}
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []*TranscriptLine
+	for rows.Next() {
+		var line TranscriptLine
+
+		err := rows.Scan(
+			&line.Seq, &line.SessionID, &line.AgentID, &line.Stream,
+			&line.Content, &line.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+
+		lines = append(lines, &line)
+	}
+
+	return lines, rows.Err()
 }
 
 // ================================================
@@ -655,26 +925,101 @@ func (s *SQLiteOperationalDB) GetMessages(agentID string, since time.Time) ([]*M
 	}
 	defer rows.Close()
 
+	return scanMessages(rows)
+}
+
+// GetMessage retrieves a message by ID
+func (s *SQLiteOperationalDB) GetMessage(msgID string) (*Message, error) {
+	query := `
+		SELECT id, from_agent, to_agent, message_type, content, priority,
+			   created_at, acked_at
+		FROM messages
+		WHERE id = ?
+	`
+
+	msg, err := scanMessage(s.db.QueryRow(query, msgID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("message %s %w", msgID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// ListMessages lists messages matching the filter
+func (s *SQLiteOperationalDB) ListMessages(filter MessageFilter) ([]*Message, error) {
+	query := `
+		SELECT id, from_agent, to_agent, message_type, content, priority,
+			   created_at, acked_at
+		FROM messages
+		WHERE 1=1
+	`
+	args := []interface{}{}
+
+	if filter.ToAgent != "" {
+		query += " AND to_agent = ?"
+		args = append(args, filter.ToAgent)
+	}
+	if filter.FromAgent != "" {
+		query += " AND from_agent = ?"
+		args = append(args, filter.FromAgent)
+	}
+	if filter.MessageType != "" {
+		query += " AND message_type = ?"
+		args = append(args, filter.MessageType)
+	}
+	if filter.Unacked {
+		query += " AND acked_at IS NULL"
+	}
+	if !filter.Since.IsZero() {
+		query += " AND created_at >= ?"
+		args = append(args, filter.Since)
+	}
+
+	query += " ORDER BY priority DESC, created_at ASC"
+	query, args = paginate(query, args, filter.Limit, filter.Offset)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMessages(rows)
+}
+
+// scanMessages reads message rows selected in the column order GetMessage uses
+func scanMessages(rows *sql.Rows) ([]*Message, error) {
 	var messages []*Message
 	for rows.Next() {
-		var msg Message
-		var ackedAt sql.NullTime
-
-		err := rows.Scan(
-			&msg.ID, &msg.FromAgent, &msg.ToAgent, &msg.MessageType,
-			&msg.Content, &msg.Priority, &msg.CreatedAt, &ackedAt)
+		msg, err := scanMessage(rows)
 		if err != nil {
 			return nil, err
 		}
+		messages = append(messages, msg)
+	}
 
-		if ackedAt.Valid {
-			msg.AckedAt = &ackedAt.Time
-		}
+	return messages, rows.Err()
+}
+
+func scanMessage(row interface{ Scan(...interface{}) error }) (*Message, error) {
+	var msg Message
+	var ackedAt sql.NullTime
+
+	err := row.Scan(
+		&msg.ID, &msg.FromAgent, &msg.ToAgent, &msg.MessageType,
+		&msg.Content, &msg.Priority, &msg.CreatedAt, &ackedAt)
+	if err != nil {
+		return nil, err
+	}
 
-		messages = append(messages, &msg)
+	if ackedAt.Valid {
+		msg.AckedAt = &ackedAt.Time
 	}
 
-	return messages, rows.Err()
+	return &msg, nil
 }
 
 // AcknowledgeMessage marks a message as acknowledged
@@ -688,6 +1033,15 @@ func (s *SQLiteOperationalDB) AcknowledgeMessage(msgID string) error {
 	return err
 }
 
+// DeleteMessage removes a message
+func (s *SQLiteOperationalDB) DeleteMessage(msgID string) error {
+	result, err := s.db.Exec("DELETE FROM messages WHERE id = ?", msgID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result, "message", msgID)
+}
+
 // ================================================
 // Health and Metrics
 // ================================================
@@ -727,6 +1081,58 @@ func (s *SQLiteOperationalDB) GetMetrics(agentID string, since time.Time) ([]*Me
 	}
 	defer rows.Close()
 
+	return scanMetrics(rows)
+}
+
+// ListMetrics lists metrics matching the filter, oldest first
+func (s *SQLiteOperationalDB) ListMetrics(filter MetricFilter) ([]*Metric, error) {
+	query := `
+		SELECT id, agent_id, metric_type, value, timestamp
+		FROM metrics
+		WHERE 1=1
+	`
+	args := []interface{}{}
+
+	if filter.AgentID != "" {
+		query += " AND agent_id = ?"
+		args = append(args, filter.AgentID)
+	}
+	if filter.MetricType != "" {
+		query += " AND metric_type = ?"
+		args = append(args, filter.MetricType)
+	}
+	if !filter.Since.IsZero() {
+		query += " AND timestamp >= ?"
+		args = append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query += " AND timestamp <= ?"
+		args = append(args, filter.Until)
+	}
+
+	query += " ORDER BY timestamp ASC"
+	query, args = paginate(query, args, filter.Limit, filter.Offset)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMetrics(rows)
+}
+
+// DeleteMetric removes a metric sample
+func (s *SQLiteOperationalDB) DeleteMetric(metricID string) error {
+	result, err := s.db.Exec("DELETE FROM metrics WHERE id = ?", metricID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result, "metric", metricID)
+}
+
+// scanMetrics reads metric rows selected in the column order GetMetrics uses
+func scanMetrics(rows *sql.Rows) ([]*Metric, error) {
 	var metrics []*Metric
 	for rows.Next() {
 		var metric Metric
@@ -748,6 +1154,41 @@ func (s *SQLiteOperationalDB) GetMetrics(agentID string, since time.Time) ([]*Me
 // Utility Functions
 // ================================================
 
+// paginate appends LIMIT and OFFSET clauses; an offset needs a limit in
+// SQLite, so an offset alone is paired with LIMIT -1 (no limit)
+func paginate(query string, args []interface{}, limit, offset int) (string, []interface{}) {
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	} else if offset > 0 {
+		query += " LIMIT -1"
+	}
+	if offset > 0 {
+		query += " OFFSET ?"
+		args = append(args, offset)
+	}
+	return query, args
+}
+
+// requireRow returns ErrNotFound if result changed no rows
+func requireRow(result sql.Result, kind, id string) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("%s %s %w", kind, id, ErrNotFound)
+	}
+	return nil
+}
+
+// escapeLike escapes LIKE wildcards so text is matched literally
+func escapeLike(text string) string {
+	text = strings.ReplaceAll(text, `\`, `\\`)
+	text = strings.ReplaceAll(text, "%", `\%`)
+	return strings.ReplaceAll(text, "_", `\_`)
+}
+
 func boolToInt(b bool) int {
 	if b {
 		return 1
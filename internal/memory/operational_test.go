@@ -1,6 +1,7 @@
 package memory
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -174,6 +175,36 @@ func TestTaskLifecycle(t *testing.T) {
 	}
 }
 
+func TestReleaseTaskUnassigns(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	task := &Task{Title: "Test Task", Status: TaskStatusPending}
+	if err := db.CreateTask(task); err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	if err := db.ClaimTask(task.ID, "task-agent"); err != nil {
+		t.Fatalf("ClaimTask failed: %v", err)
+	}
+
+	if err := db.ReleaseTask(task.ID, "Returned to queue"); err != nil {
+		t.Fatalf("ReleaseTask failed: %v", err)
+	}
+
+	retrieved, _ := db.GetTask(task.ID)
+	if retrieved.Status != TaskStatusPending || retrieved.AssignedTo != "" {
+		t.Errorf("Expected an unassigned pending task, got %s assigned to %q", retrieved.Status, retrieved.AssignedTo)
+	}
+	if retrieved.Progress != "Returned to queue" {
+		t.Errorf("Expected the release note, got %q", retrieved.Progress)
+	}
+
+	// Released tasks can be claimed again
+	if err := db.ClaimTask(task.ID, "other-agent"); err != nil {
+		t.Errorf("ClaimTask after release failed: %v", err)
+	}
+}
+
 func TestSessionManagement(t *testing.T) {
 	db, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -208,6 +239,43 @@ func TestSessionManagement(t *testing.T) {
 	}
 }
 
+func TestDeleteSessionRemovesTranscript(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	kept := &Session{AgentID: "agent-1", ProjectPath: "/a"}
+	deleted := &Session{AgentID: "agent-1", ProjectPath: "/b"}
+	db.CreateSession(kept)
+	db.CreateSession(deleted)
+	db.AppendTranscript([]*TranscriptLine{
+		{SessionID: kept.ID, AgentID: "agent-1", Stream: StreamStdout, Content: "kept"},
+		{SessionID: deleted.ID, AgentID: "agent-1", Stream: StreamStdout, Content: "deleted"},
+	})
+
+	if err := db.DeleteSession(deleted.ID); err != nil {
+		t.Fatalf("DeleteSession failed: %v", err)
+	}
+	if err := db.DeleteSession(deleted.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting twice, got %v", err)
+	}
+	if _, err := db.GetSession(deleted.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	sessions, err := db.ListSessions(SessionFilter{AgentID: "agent-1"})
+	if err != nil {
+		t.Fatalf("ListSessions failed: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != kept.ID {
+		t.Errorf("Expected only the kept session, got %d", len(sessions))
+	}
+
+	lines, _ := db.GetTranscript(TranscriptFilter{AgentID: "agent-1"})
+	if len(lines) != 1 || lines[0].Content != "kept" {
+		t.Errorf("Expected only the kept transcript line, got %d", len(lines))
+	}
+}
+
 func TestMessaging(t *testing.T) {
 	db, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -277,3 +345,122 @@ func TestMetrics(t *testing.T) {
 		t.Errorf("Expected value 1500.0, got %f", metrics[0].Value)
 	}
 }
+
+func TestRegisterAgentTwiceKeepsCreatedAt(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	agent := &AgentState{
+		AgentID:   "restart-agent",
+		AgentType: "aider",
+		Model:     "qwen2.5-coder-7b",
+		Status:    AgentStatusStarting,
+	}
+	if err := db.RegisterAgent(agent); err != nil {
+		t.Fatalf("RegisterAgent failed: %v", err)
+	}
+	first, _ := db.GetAgent("restart-agent")
+
+	db.MarkStopped("restart-agent", "crashed")
+
+	again := &AgentState{
+		AgentID:   "restart-agent",
+		AgentType: "aider",
+		Model:     "qwen2.5-coder-14b",
+		Status:    AgentStatusStarting,
+	}
+	if err := db.RegisterAgent(again); err != nil {
+		t.Fatalf("Re-registering agent failed: %v", err)
+	}
+
+	retrieved, err := db.GetAgent("restart-agent")
+	if err != nil {
+		t.Fatalf("GetAgent failed: %v", err)
+	}
+	if retrieved.Status != AgentStatusStarting {
+		t.Errorf("Expected status %s, got %s", AgentStatusStarting, retrieved.Status)
+	}
+	if retrieved.Model != "qwen2.5-coder-14b" {
+		t.Errorf("Expected updated model, got %s", retrieved.Model)
+	}
+	if !retrieved.CreatedAt.Equal(first.CreatedAt) {
+		t.Errorf("Expected created_at %v to be kept, got %v", first.CreatedAt, retrieved.CreatedAt)
+	}
+}
+
+func TestUpdateAgentMetadataMerges(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	agent := &AgentState{
+		AgentID:   "meta-agent",
+		AgentType: "aider",
+		Model:     "qwen2.5-coder-7b",
+		Status:    AgentStatusIdle,
+		Metadata:  map[string]string{"name": "Qwen-Dev-1", "queue_length": "2"},
+	}
+	db.RegisterAgent(agent)
+
+	err := db.UpdateAgentMetadata("meta-agent", map[string]string{"queue_length": "", "queue": "[]"})
+	if err != nil {
+		t.Fatalf("UpdateAgentMetadata failed: %v", err)
+	}
+
+	retrieved, _ := db.GetAgent("meta-agent")
+	if retrieved.Metadata["name"] != "Qwen-Dev-1" {
+		t.Errorf("Expected existing key to be kept, got %v", retrieved.Metadata)
+	}
+	if _, ok := retrieved.Metadata["queue_length"]; ok {
+		t.Errorf("Expected empty value to remove key, got %v", retrieved.Metadata)
+	}
+	if retrieved.Metadata["queue"] != "[]" {
+		t.Errorf("Expected new key to be set, got %v", retrieved.Metadata)
+	}
+
+	if err := db.UpdateAgentMetadata("missing-agent", map[string]string{"a": "b"}); err == nil {
+		t.Error("Expected error for unknown agent")
+	}
+}
+
+func TestTranscriptAppendAndSearch(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	start := time.Now()
+	lines := []*TranscriptLine{
+		{SessionID: "session-1", AgentID: "agent-1", Stream: StreamStdin, Content: "fix 100% of the bugs", Timestamp: start},
+		{SessionID: "session-1", AgentID: "agent-1", Stream: StreamStdout, Content: "Applied edit to main.go", Timestamp: start.Add(time.Second)},
+		{SessionID: "session-2", AgentID: "agent-2", Stream: StreamStdout, Content: "Applied edit to other.go", Timestamp: start},
+		{SessionID: "session-1", AgentID: "agent-1", Stream: StreamStderr, Content: "Warning: slow", Timestamp: start.Add(2 * time.Second)},
+	}
+	if err := db.AppendTranscript(lines); err != nil {
+		t.Fatalf("AppendTranscript failed: %v", err)
+	}
+	if lines[0].Seq == 0 || lines[1].Seq <= lines[0].Seq {
+		t.Errorf("Expected increasing sequence numbers, got %d, %d", lines[0].Seq, lines[1].Seq)
+	}
+
+	session, err := db.GetTranscript(TranscriptFilter{SessionID: "session-1"})
+	if err != nil {
+		t.Fatalf("GetTranscript failed: %v", err)
+	}
+	if len(session) != 3 || session[0].Stream != StreamStdin || session[2].Stream != StreamStderr {
+		t.Errorf("Expected session-1 lines in order, got %+v", session)
+	}
+
+	found, _ := db.GetTranscript(TranscriptFilter{SessionID: "session-1", Contains: "applied EDIT"})
+	if len(found) != 1 || found[0].Content != "Applied edit to main.go" {
+		t.Errorf("Expected case-insensitive match, got %+v", found)
+	}
+
+	// Wildcards are matched literally
+	literal, _ := db.GetTranscript(TranscriptFilter{AgentID: "agent-1", Contains: "100%"})
+	if len(literal) != 1 {
+		t.Errorf("Expected literal %% match, got %d lines", len(literal))
+	}
+
+	after, _ := db.GetTranscript(TranscriptFilter{SessionID: "session-1", AfterSeq: session[0].Seq, Limit: 1})
+	if len(after) != 1 || after[0].Seq != session[1].Seq {
+		t.Errorf("Expected the line after seq %d, got %+v", session[0].Seq, after)
+	}
+}
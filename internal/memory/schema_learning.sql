@@ -8,7 +8,11 @@ CREATE TABLE IF NOT EXISTS episodes (
     context TEXT,
     outcome TEXT,
     timestamp DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    importance REAL NOT NULL DEFAULT 0.5
+    importance REAL NOT NULL DEFAULT 0.5,
+    project_path TEXT,
+    embedding BLOB, -- filled in the background once an embedding provider is set
+    embedding_model TEXT, -- provider/model that produced the embedding
+    embedding_dims INTEGER
 );
 
 CREATE INDEX IF NOT EXISTS idx_episodes_session ON episodes(session_id);
@@ -33,6 +37,8 @@ CREATE TABLE IF NOT EXISTS knowledge (
     content TEXT NOT NULL,
     source TEXT,
     embedding BLOB,
+    embedding_model TEXT, -- provider/model that produced the embedding
+    embedding_dims INTEGER,
     tags TEXT,
     use_count INTEGER NOT NULL DEFAULT 0,
     last_used DATETIME,
@@ -60,3 +66,59 @@ CREATE TABLE IF NOT EXISTS procedures (
 
 CREATE INDEX IF NOT EXISTS idx_procedures_task_type ON procedures(task_type);
 CREATE INDEX IF NOT EXISTS idx_procedures_success ON procedures(success_count DESC);
+
+-- Embedding cache, keyed by a hash of model and text
+CREATE TABLE IF NOT EXISTS embedding_cache (
+    key TEXT PRIMARY KEY,
+    model TEXT NOT NULL,
+    embedding BLOB NOT NULL,
+    dims INTEGER NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_embedding_cache_model ON embedding_cache(model);
+
+-- Full-text indexes (BM25 keyword search), kept in sync by triggers
+CREATE VIRTUAL TABLE IF NOT EXISTS knowledge_fts USING fts5(
+    title,
+    content,
+    tags,
+    content='knowledge',
+    content_rowid='rowid',
+    tokenize='porter unicode61'
+);
+
+CREATE TRIGGER IF NOT EXISTS knowledge_fts_insert AFTER INSERT ON knowledge BEGIN
+    INSERT INTO knowledge_fts(rowid, title, content, tags) VALUES (new.rowid, new.title, new.content, new.tags);
+END;
+
+CREATE TRIGGER IF NOT EXISTS knowledge_fts_delete AFTER DELETE ON knowledge BEGIN
+    INSERT INTO knowledge_fts(knowledge_fts, rowid, title, content, tags) VALUES ('delete', old.rowid, old.title, old.content, old.tags);
+END;
+
+CREATE TRIGGER IF NOT EXISTS knowledge_fts_update AFTER UPDATE OF title, content, tags ON knowledge BEGIN
+    INSERT INTO knowledge_fts(knowledge_fts, rowid, title, content, tags) VALUES ('delete', old.rowid, old.title, old.content, old.tags);
+    INSERT INTO knowledge_fts(rowid, title, content, tags) VALUES (new.rowid, new.title, new.content, new.tags);
+END;
+
+CREATE VIRTUAL TABLE IF NOT EXISTS episodes_fts USING fts5(
+    content,
+    context,
+    outcome,
+    content='episodes',
+    content_rowid='rowid',
+    tokenize='porter unicode61'
+);
+
+CREATE TRIGGER IF NOT EXISTS episodes_fts_insert AFTER INSERT ON episodes BEGIN
+    INSERT INTO episodes_fts(rowid, content, context, outcome) VALUES (new.rowid, new.content, new.context, new.outcome);
+END;
+
+CREATE TRIGGER IF NOT EXISTS episodes_fts_delete AFTER DELETE ON episodes BEGIN
+    INSERT INTO episodes_fts(episodes_fts, rowid, content, context, outcome) VALUES ('delete', old.rowid, old.content, old.context, old.outcome);
+END;
+
+CREATE TRIGGER IF NOT EXISTS episodes_fts_update AFTER UPDATE OF content, context, outcome ON episodes BEGIN
+    INSERT INTO episodes_fts(episodes_fts, rowid, content, context, outcome) VALUES ('delete', old.rowid, old.content, old.context, old.outcome);
+    INSERT INTO episodes_fts(rowid, content, context, outcome) VALUES (new.rowid, new.content, new.context, new.outcome);
+END;
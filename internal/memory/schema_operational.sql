@@ -83,3 +83,17 @@ CREATE TABLE IF NOT EXISTS metrics (
 );
 
 CREATE INDEX IF NOT EXISTS idx_metrics_agent ON metrics(agent_id, timestamp);
+
+-- Transcript lines table: every prompt in and output line out, per session
+CREATE TABLE IF NOT EXISTS transcript_lines (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    session_id TEXT NOT NULL,
+    agent_id TEXT NOT NULL,
+    stream TEXT NOT NULL,
+    content TEXT NOT NULL,
+    timestamp DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (session_id) REFERENCES sessions(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_transcript_session ON transcript_lines(session_id, id);
+CREATE INDEX IF NOT EXISTS idx_transcript_agent ON transcript_lines(agent_id, id);
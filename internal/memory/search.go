@@ -0,0 +1,137 @@
+package memory
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// rrfK damps the weight of top ranks in reciprocal rank fusion; 60 is the
+// value from the original RRF paper and works well without tuning
+const rrfK = 60
+
+// minHybridCandidates is the least each ranking contributes before fusion,
+// so filters still leave enough to fill the limit
+const minHybridCandidates = 100
+
+// Match sources reported in ScoredKnowledge.MatchedBy
+const (
+	MatchedBySemantic = "semantic"
+	MatchedByKeyword  = "keyword"
+)
+
+// rrfScore is what a hit at rank (0 for the best) adds to its fused score
+func rrfScore(rank int) float64 {
+	return 1.0 / float64(rrfK+rank+1)
+}
+
+// rrfBest is the highest fused score possible across the given number of
+// rankings, used to normalize scores to 0-1
+func rrfBest(rankings int) float64 {
+	return float64(rankings) / float64(rrfK+1)
+}
+
+// SearchKnowledgeScored ranks knowledge by fusing vector similarity (when an
+// embedding provider is set and answers) with BM25 keyword relevance using
+// reciprocal rank fusion. Scores are normalized so 1.0 means ranked first
+// by every ranking that ran.
+func (s *SQLiteLearningDB) SearchKnowledgeScored(query string, opts KnowledgeSearchOptions) ([]*ScoredKnowledge, error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = 10
+	}
+	depth := max(limit*5, minHybridCandidates)
+
+	fused := make(map[string]*ScoredKnowledge)
+	rankings := 0
+	add := func(k *Knowledge, rank int, matchedBy string) {
+		hit, ok := fused[k.ID]
+		if !ok {
+			hit = &ScoredKnowledge{Knowledge: k}
+			fused[k.ID] = hit
+		}
+		hit.Score += rrfScore(rank)
+		hit.MatchedBy = append(hit.MatchedBy, matchedBy)
+	}
+
+	if provider := s.provider(); provider != nil {
+		embedding, err := provider.Embed(query)
+		if err != nil {
+			log.Printf("[MEMORY] Embedding failed, searching by keyword only: %v", err)
+		} else {
+			rankings++
+			semantic, err := s.SearchByEmbedding(embedding, depth)
+			if err != nil {
+				return nil, err
+			}
+			for rank, k := range semantic {
+				add(k, rank, MatchedBySemantic)
+			}
+		}
+	}
+
+	keyword, err := s.SearchKnowledgeKeyword(query, depth)
+	if err != nil {
+		return nil, err
+	}
+	rankings++
+	for rank, hit := range keyword {
+		add(hit.Knowledge, rank, MatchedByKeyword)
+	}
+
+	best := rrfBest(rankings)
+	results := make([]*ScoredKnowledge, 0, len(fused))
+	for _, hit := range fused {
+		hit.Score /= best
+		if hit.Score < opts.MinScore || !opts.matches(hit.Knowledge) {
+			continue
+		}
+		results = append(results, hit)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Knowledge.UseCount > results[j].Knowledge.UseCount
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	if opts.MarkUsed && len(results) > 0 {
+		ids := make([]string, len(results))
+		now := time.Now()
+		for i, hit := range results {
+			ids[i] = hit.Knowledge.ID
+			hit.Knowledge.UseCount++
+			hit.Knowledge.LastUsed = &now
+		}
+		if err := s.MarkKnowledgeUsed(ids); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// matches applies the category, source and tag filters
+func (o KnowledgeSearchOptions) matches(k *Knowledge) bool {
+	if o.Category != "" && k.Category != o.Category {
+		return false
+	}
+	if o.Source != "" && k.Source != o.Source {
+		return false
+	}
+	if len(o.Tags) == 0 {
+		return true
+	}
+	for _, want := range o.Tags {
+		for _, tag := range k.Tags {
+			if tag == want {
+				return true
+			}
+		}
+	}
+	return false
+}
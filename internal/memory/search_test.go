@@ -0,0 +1,149 @@
+package memory
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// vocabEmbedder embeds text as which of a fixed vocabulary it mentions, so
+// texts sharing vocabulary words are similar. An entry like "lock|busy"
+// treats its words as synonyms.
+type vocabEmbedder struct {
+	vocab   []string
+	model   string
+	fail    bool
+	poison  string // texts containing it fail to embed
+	maxText int    // longer texts fail to embed, 0 for no limit
+}
+
+func (e *vocabEmbedder) Embed(text string) ([]float32, error) {
+	if e.fail {
+		return nil, errors.New("embedding server down")
+	}
+	if e.poison != "" && strings.Contains(text, e.poison) {
+		return nil, errors.New("input rejected")
+	}
+	if e.maxText > 0 && len(text) > e.maxText {
+		return nil, errors.New("input exceeds the context length")
+	}
+	text = strings.ToLower(text)
+	v := make([]float32, len(e.vocab)+1)
+	for i, entry := range e.vocab {
+		for _, w := range strings.Split(entry, "|") {
+			if strings.Contains(text, w) {
+				v[i] = 1
+			}
+		}
+	}
+	v[len(e.vocab)] = 0.1 // never a zero vector
+	return v, nil
+}
+
+func (e *vocabEmbedder) EmbedBatch(texts []string) ([][]float32, error) {
+	out := make([][]float32, len(texts))
+	for i, t := range texts {
+		v, err := e.Embed(t)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func (e *vocabEmbedder) Dimensions() int { return len(e.vocab) + 1 }
+
+func (e *vocabEmbedder) Model() string {
+	if e.model == "" {
+		return "test/vocab"
+	}
+	return e.model
+}
+
+func TestSearchKnowledgeScored(t *testing.T) {
+	db := setupTestLearningDB(t)
+	embedder := &vocabEmbedder{vocab: []string{"lock", "timeout", "retry"}}
+	db.SetEmbeddingProvider(embedder)
+
+	busy := &Knowledge{Category: "error_solution", Title: "SQLite busy", Content: "database lock timeout: set busy_timeout", Source: "session:s1"}
+	wal := &Knowledge{Category: "error_solution", Title: "Database locked", Content: "Switch the journal to WAL mode.", Tags: []string{"sqlite"}}
+	retry := &Knowledge{Category: "code_pattern", Title: "Retries", Content: "Retry with a timeout and backoff."}
+	for _, k := range []*Knowledge{busy, wal, retry} {
+		if err := db.StoreKnowledge(k); err != nil {
+			t.Fatalf("StoreKnowledge failed: %v", err)
+		}
+	}
+
+	hits, err := db.SearchKnowledgeScored("database lock timeout", KnowledgeSearchOptions{})
+	if err != nil {
+		t.Fatalf("SearchKnowledgeScored failed: %v", err)
+	}
+	if len(hits) != 3 {
+		t.Fatalf("Expected 3 hits, got %d", len(hits))
+	}
+	// Found by both rankings, so it outranks items only one of them found
+	if hits[0].Knowledge.ID != busy.ID {
+		t.Errorf("Expected %q first, got %q", busy.Title, hits[0].Knowledge.Title)
+	}
+	if got := strings.Join(hits[0].MatchedBy, ","); got != "semantic,keyword" {
+		t.Errorf("Expected match by both, got %q", got)
+	}
+	for i, hit := range hits {
+		if hit.Score <= 0 || hit.Score > 1 {
+			t.Errorf("Score %v outside (0, 1]", hit.Score)
+		}
+		if i > 0 && hit.Score > hits[i-1].Score {
+			t.Errorf("Scores not descending: %v then %v", hits[i-1].Score, hit.Score)
+		}
+	}
+
+	// Filters
+	filtered := map[string]KnowledgeSearchOptions{
+		retry.ID: {Category: "code_pattern"},
+		wal.ID:   {Tags: []string{"sqlite", "postgres"}},
+		busy.ID:  {Source: "session:s1"},
+	}
+	for want, opts := range filtered {
+		hits, _ := db.SearchKnowledgeScored("database lock timeout", opts)
+		if len(hits) != 1 || hits[0].Knowledge.ID != want {
+			t.Errorf("Filter %+v: expected only %s, got %d hits", opts, want, len(hits))
+		}
+	}
+
+	hits, _ = db.SearchKnowledgeScored("database lock timeout", KnowledgeSearchOptions{MinScore: 0.99})
+	if len(hits) != 1 || hits[0].Knowledge.ID != busy.ID {
+		t.Errorf("Expected only the top hit above the minimum score, got %d hits", len(hits))
+	}
+
+	// Without embeddings only the keyword ranking runs
+	embedder.fail = true
+	hits, _ = db.SearchKnowledgeScored("database", KnowledgeSearchOptions{Limit: 1})
+	if len(hits) != 1 || len(hits[0].MatchedBy) != 1 || hits[0].MatchedBy[0] != MatchedByKeyword || hits[0].Score != 1 {
+		t.Errorf("Expected a full-score keyword hit, got %+v", hits)
+	}
+}
+
+func TestSearchKnowledgeScoredMarksUsed(t *testing.T) {
+	db := setupTestLearningDB(t)
+	k := &Knowledge{Category: "domain", Title: "Ports", Content: "The dashboard listens on 8080."}
+	db.StoreKnowledge(k)
+
+	db.SearchKnowledgeScored("dashboard", KnowledgeSearchOptions{})
+	stored, _ := db.GetKnowledge(k.ID)
+	if stored.UseCount != 0 || stored.LastUsed != nil {
+		t.Errorf("Plain search should not count as use, got %d", stored.UseCount)
+	}
+
+	hits, err := db.SearchKnowledgeScored("dashboard", KnowledgeSearchOptions{MarkUsed: true})
+	if err != nil || len(hits) != 1 {
+		t.Fatalf("Expected 1 hit, got %d (%v)", len(hits), err)
+	}
+	if hits[0].Knowledge.UseCount != 1 || hits[0].Knowledge.LastUsed == nil {
+		t.Error("Returned item should reflect the use")
+	}
+	stored, _ = db.GetKnowledge(k.ID)
+	if stored.UseCount != 1 || stored.LastUsed == nil {
+		t.Errorf("Expected use_count 1 with last_used set, got %d", stored.UseCount)
+	}
+}
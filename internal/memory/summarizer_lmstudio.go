@@ -0,0 +1,138 @@
+package memory
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// summarizerSystemPrompt asks for the summary as a JSON object so the reply
+// can be parsed without guessing at prose
+const summarizerSystemPrompt = `You summarize the work log of an AI coding agent.
+Reply with a single JSON object and nothing else:
+{"summary": "...", "key_events": ["..."], "lessons_learned": ["..."]}
+- summary: 2-4 sentences on what the agent set out to do and what it achieved
+- key_events: up to 8 short entries for the events that mattered most
+- lessons_learned: up to 5 reusable lessons, each stating a problem and how it was (or should be) solved; empty if there are none`
+
+// maxSummaryEpisodeChars caps each episode line sent to the model
+const maxSummaryEpisodeChars = 300
+
+// LMStudioSummarizer implements Summarizer using an OpenAI-compatible chat
+// completions endpoint (LM Studio)
+type LMStudioSummarizer struct {
+	baseURL string
+	model   string
+	client  *http.Client
+}
+
+// NewLMStudioSummarizer creates a new LM Studio summarizer
+func NewLMStudioSummarizer(baseURL, model string) *LMStudioSummarizer {
+	return &LMStudioSummarizer{
+		baseURL: baseURL,
+		model:   model,
+		client: &http.Client{
+			Timeout: 120 * time.Second, // local models can be slow to generate
+		},
+	}
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model       string        `json:"model"`
+	Messages    []chatMessage `json:"messages"`
+	Temperature float64       `json:"temperature"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+}
+
+func (l *LMStudioSummarizer) Summarize(sessionID string, episodes []*Episode) (*EpisodeSummary, error) {
+	req := chatRequest{
+		Model: l.model,
+		Messages: []chatMessage{
+			{Role: "system", Content: summarizerSystemPrompt},
+			{Role: "user", Content: formatEpisodeLog(episodes)},
+		},
+		Temperature: 0.2,
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := l.client.Post(l.baseURL+"/chat/completions", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to call chat API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("chat API error: %s - %s", resp.Status, string(respBody))
+	}
+
+	var chatResp chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if len(chatResp.Choices) == 0 {
+		return nil, fmt.Errorf("no completion returned")
+	}
+
+	summary, err := parseSummaryReply(chatResp.Choices[0].Message.Content)
+	if err != nil {
+		return nil, err
+	}
+	summary.SessionID = sessionID
+	return summary, nil
+}
+
+// formatEpisodeLog renders episodes one per line for the model
+func formatEpisodeLog(episodes []*Episode) string {
+	var sb strings.Builder
+	for _, ep := range episodes {
+		line := fmt.Sprintf("%s [%s] %s", ep.Timestamp.Format("15:04:05"), ep.EventType, ep.Content)
+		if ep.Outcome != "" {
+			line += " -> " + ep.Outcome
+		}
+		line = strings.ReplaceAll(line, "\n", " ")
+		if runes := []rune(line); len(runes) > maxSummaryEpisodeChars {
+			line = string(runes[:maxSummaryEpisodeChars]) + "…"
+		}
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// parseSummaryReply extracts the JSON object from a model reply, tolerating
+// code fences or text around it
+func parseSummaryReply(reply string) (*EpisodeSummary, error) {
+	start := strings.Index(reply, "{")
+	end := strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no JSON object in summary reply")
+	}
+
+	var summary EpisodeSummary
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &summary); err != nil {
+		return nil, fmt.Errorf("failed to parse summary reply: %w", err)
+	}
+	if strings.TrimSpace(summary.Summary) == "" {
+		return nil, fmt.Errorf("summary reply has no summary")
+	}
+	return &summary, nil
+}
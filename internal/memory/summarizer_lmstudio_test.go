@@ -0,0 +1,115 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingSummarizer stands in for a model that is not running
+type failingSummarizer struct{}
+
+func (failingSummarizer) Summarize(sessionID string, episodes []*Episode) (*EpisodeSummary, error) {
+	return nil, fmt.Errorf("connection refused")
+}
+
+func recordSessionEpisodes(t *testing.T, db *SQLiteLearningDB, sessionID string) {
+	t.Helper()
+	start := time.Now().Add(-time.Hour)
+	episodes := []*Episode{
+		{EventType: "action", Content: "Prompt: Fix login", Importance: 0.4},
+		{EventType: "error", Content: "Tests failed: go test ./...", Outcome: "Run go generate before testing", Importance: 0.7},
+		{EventType: "action", Content: "Committed abc1234: fix login", Importance: 0.6},
+	}
+	for i, ep := range episodes {
+		ep.SessionID = sessionID
+		ep.AgentID = "aider-1"
+		ep.Timestamp = start.Add(time.Duration(i) * time.Minute)
+		if err := db.RecordEpisode(ep); err != nil {
+			t.Fatalf("RecordEpisode failed: %v", err)
+		}
+	}
+}
+
+func TestLMStudioSummarizer(t *testing.T) {
+	var got chatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		reply := "```json\n{\"summary\": \"Fixed login.\", \"key_events\": [\"tests failed\"], \"lessons_learned\": [\"Run go generate first\"]}\n```"
+		fmt.Fprintf(w, `{"choices": [{"message": {"role": "assistant", "content": %q}}]}`, reply)
+	}))
+	defer server.Close()
+
+	summarizer := NewLMStudioSummarizer(server.URL, "qwen")
+	summary, err := summarizer.Summarize("s1", []*Episode{
+		{EventType: "error", Content: "Tests failed", Outcome: "ran go generate", Timestamp: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("Summarize failed: %v", err)
+	}
+
+	if summary.Summary != "Fixed login." || len(summary.KeyEvents) != 1 || summary.LessonsLearned[0] != "Run go generate first" {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+	if len(got.Messages) != 2 || !strings.Contains(got.Messages[1].Content, "[error] Tests failed -> ran go generate") {
+		t.Errorf("Unexpected request: %+v", got)
+	}
+}
+
+func TestRegenerateSummaryFallsBack(t *testing.T) {
+	db := setupTestLearningDB(t)
+	recordSessionEpisodes(t, db, "s1")
+	db.SetSummarizer(failingSummarizer{})
+
+	summary, err := db.RegenerateSummary("s1")
+	if err != nil {
+		t.Fatalf("RegenerateSummary failed: %v", err)
+	}
+	if !strings.HasPrefix(summary.Summary, "Session with 3 episodes (2 action, 1 error)") {
+		t.Errorf("Unexpected basic summary: %q", summary.Summary)
+	}
+	if len(summary.KeyEvents) != 1 || len(summary.LessonsLearned) != 1 {
+		t.Errorf("Unexpected key events or lessons: %+v", summary)
+	}
+
+	// Regenerating replaces the stored summary
+	db.SetSummarizer(nil)
+	db.RecordEpisode(&Episode{SessionID: "s1", EventType: "observation", Content: "done"})
+	if _, err := db.RegenerateSummary("s1"); err != nil {
+		t.Fatalf("RegenerateSummary failed: %v", err)
+	}
+	stored, _ := db.SummarizeEpisodes("s1")
+	if !strings.HasPrefix(stored.Summary, "Session with 4 episodes") {
+		t.Errorf("Expected regenerated summary, got %q", stored.Summary)
+	}
+}
+
+func TestPromoteLessons(t *testing.T) {
+	db := setupTestLearningDB(t)
+	recordSessionEpisodes(t, db, "s1")
+
+	promoted, err := db.PromoteLessons("s1")
+	if err != nil {
+		t.Fatalf("PromoteLessons failed: %v", err)
+	}
+	if len(promoted) != 1 {
+		t.Fatalf("Expected 1 lesson promoted, got %d", len(promoted))
+	}
+	k := promoted[0]
+	if k.Category != "error_solution" || k.Source != "session:s1" || k.Content != "Run go generate before testing" {
+		t.Errorf("Unexpected knowledge: %+v", k)
+	}
+
+	// Promoting again does not duplicate
+	promoted, _ = db.PromoteLessons("s1")
+	if len(promoted) != 0 {
+		t.Errorf("Expected no new lessons, got %d", len(promoted))
+	}
+}
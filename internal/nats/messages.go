@@ -13,12 +13,24 @@ const (
 	// SubjectAgentOutput is the pattern for agent stdout/stderr output
 	SubjectAgentOutput = "agent.%s.output"
 
+	// SubjectAgentPrompt is the pattern for request-reply prompts (PromptRequest/PromptResponse)
+	SubjectAgentPrompt = "agent.%s.prompt"
+
+	// SubjectAgentQueue is the pattern for request-reply prompt queue snapshots (PromptQueueMessage)
+	SubjectAgentQueue = "agent.%s.queue"
+
 	// SubjectAllStatus subscribes to all agent status updates
 	SubjectAllStatus = "agent.*.status"
 
 	// SubjectAllOutput subscribes to all agent output
 	SubjectAllOutput = "agent.*.output"
 
+	// SubjectAgentEvent is the pattern for structured events parsed from agent output
+	SubjectAgentEvent = "agent.%s.event"
+
+	// SubjectAllEvents subscribes to all agent events
+	SubjectAllEvents = "agent.*.event"
+
 	// SubjectSergeantStatus is used for Sergeant (orchestrator) status
 	SubjectSergeantStatus = "sergeant.status"
 
@@ -33,6 +45,9 @@ const (
 
 	// SubjectEscalationResponse is the pattern for responses to escalations
 	SubjectEscalationResponse = "escalation.response.%s"
+
+	// SubjectAllEscalations subscribes to escalations and their responses
+	SubjectAllEscalations = "escalation.>"
 )
 
 // StatusMessage represents an agent status update
@@ -50,6 +65,56 @@ type CommandMessage struct {
 	Payload map[string]interface{} `json:"payload"`
 }
 
+// PromptRequest asks an agent to run one prompt and reply when it is done
+type PromptRequest struct {
+	Text           string `json:"text"`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty"` // 0 uses the agent default
+	InjectMemory   *bool  `json:"inject_memory,omitempty"`   // nil uses the agent default
+	TaskType       string `json:"task_type,omitempty"`       // selects procedures when injecting memory
+	TaskID         string `json:"task_id,omitempty"`         // the Sergeant task the prompt works on
+}
+
+// PromptResponse is the outcome of one prompt: everything Aider printed
+// until it returned to its input prompt
+type PromptResponse struct {
+	AgentID     string          `json:"agent_id"`
+	PromptID    string          `json:"prompt_id,omitempty"`
+	Prompt      string          `json:"prompt"`
+	Transcript  []string        `json:"transcript"`
+	EditedFiles []string        `json:"edited_files"`
+	Commits     []CommitEvent   `json:"commits,omitempty"`
+	Tokens      TokensUsedEvent `json:"tokens"`
+	Errors      []string        `json:"errors,omitempty"`   // errors Aider reported; they fail a task
+	Warnings    []string        `json:"warnings,omitempty"` // failed edits and checks Aider retries itself, and stderr lines mentioning an error
+	TimedOut    bool            `json:"timed_out"`
+	Error       string          `json:"error,omitempty"`     // set when the prompt could not be run
+	Knowledge   []string        `json:"knowledge,omitempty"` // IDs of knowledge injected with the prompt
+	StartedAt   time.Time       `json:"started_at"`
+	CompletedAt time.Time       `json:"completed_at"`
+}
+
+// QueuedPrompt describes a prompt waiting in, or running from, an agent's queue
+type QueuedPrompt struct {
+	ID           string     `json:"id"`
+	Text         string     `json:"text"`
+	InjectMemory *bool      `json:"inject_memory,omitempty"`
+	TaskType     string     `json:"task_type,omitempty"`
+	Command      bool       `json:"command,omitempty"` // a slash command such as /add, sent as is
+	TaskID       string     `json:"task_id,omitempty"`
+	QueuedAt     time.Time  `json:"queued_at"`
+	Deadline     time.Time  `json:"deadline"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	SentAt       *time.Time `json:"sent_at,omitempty"` // written to Aider; its turn is under way
+}
+
+// PromptQueueMessage is a snapshot of an agent's prompt queue
+type PromptQueueMessage struct {
+	AgentID   string         `json:"agent_id"`
+	Running   *QueuedPrompt  `json:"running,omitempty"`
+	Pending   []QueuedPrompt `json:"pending"`
+	Timestamp time.Time      `json:"timestamp"`
+}
+
 // OutputMessage represents stdout/stderr output from an agent
 type OutputMessage struct {
 	AgentID   string    `json:"agent_id"`
@@ -58,17 +123,97 @@ type OutputMessage struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// Event types published on SubjectAgentEvent
+const (
+	EventPrompt       = "prompt"        // PromptEvent
+	EventTokensUsed   = "tokens_used"   // TokensUsedEvent
+	EventEditProposed = "edit_proposed" // EditProposedEvent
+	EventEditApplied  = "edit_applied"  // EditAppliedEvent
+	EventEditFailed   = "edit_failed"   // EditFailedEvent
+	EventCommit       = "commit"        // CommitEvent
+	EventFileAdded    = "file_added"    // FileEvent
+	EventFileDropped  = "file_dropped"  // FileEvent
+	EventLintFailed   = "lint_failed"   // CheckFailedEvent
+	EventTestFailed   = "test_failed"   // CheckFailedEvent
+	EventError        = "error"         // ErrorEvent
+)
+
+// EventMessage represents a structured event parsed from agent output.
+// Data holds the event struct matching Type.
+type EventMessage struct {
+	AgentID   string      `json:"agent_id"`
+	Type      string      `json:"type"`
+	Data      interface{} `json:"data"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// PromptEvent is emitted when Aider shows its input prompt
+type PromptEvent struct {
+	Mode string `json:"mode,omitempty"` // e.g. "diff", "architect"; empty for the default prompt
+}
+
+// TokensUsedEvent reports token usage and cost for one LLM exchange
+type TokensUsedEvent struct {
+	Sent        int     `json:"sent"`
+	Received    int     `json:"received"`
+	Cost        float64 `json:"cost"`
+	SessionCost float64 `json:"session_cost"`
+}
+
+// EditProposedEvent is one SEARCH/REPLACE block from the model's reply
+type EditProposedEvent struct {
+	File    string `json:"file"`
+	Search  string `json:"search"`
+	Replace string `json:"replace"`
+}
+
+// EditAppliedEvent is emitted when Aider writes an edit to a file
+type EditAppliedEvent struct {
+	File  string `json:"file"`
+	Hunks int    `json:"hunks"`
+}
+
+// EditFailedEvent is emitted when proposed edits could not be applied
+type EditFailedEvent struct {
+	Blocks  int    `json:"blocks,omitempty"`
+	Message string `json:"message"`
+}
+
+// CommitEvent is emitted when Aider commits its changes
+type CommitEvent struct {
+	Hash    string `json:"hash"`
+	Message string `json:"message"`
+}
+
+// FileEvent is emitted when a file is added to or dropped from the chat
+type FileEvent struct {
+	File string `json:"file"`
+}
+
+// CheckFailedEvent reports failing lint or test output
+type CheckFailedEvent struct {
+	Command string   `json:"command,omitempty"`
+	Output  []string `json:"output,omitempty"`
+}
+
+// ErrorEvent reports an error printed by Aider
+type ErrorEvent struct {
+	Message string `json:"message"`
+}
+
 // SergeantStatusMessage represents Sergeant orchestrator status
 type SergeantStatusMessage struct {
-	Status       string    `json:"status"` // idle, busy, error
+	Status       string    `json:"status"` // idle, busy, paused, error
 	ActiveAgents int       `json:"active_agents"`
+	BusyAgents   int       `json:"busy_agents"`
+	PendingTasks int       `json:"pending_tasks"`
 	CurrentOp    string    `json:"current_op,omitempty"`
 	Timestamp    time.Time `json:"timestamp"`
 }
 
 // SergeantCommandMessage represents commands to Sergeant
 type SergeantCommandMessage struct {
-	Type    string                 `json:"type"` // spawn_agent, kill_agent, pause, resume
+	Type    string                 `json:"type"` // spawn_agent, kill_agent, pause, resume, dispatch
 	Payload map[string]interface{} `json:"payload"`
 	From    string                 `json:"from"`
 }
@@ -92,7 +237,7 @@ type EscalationResponseMessage struct {
 
 // SystemBroadcastMessage represents system-wide announcements
 type SystemBroadcastMessage struct {
-	Type      string                 `json:"type"` // shutdown, agent_crashed, config_change
+	Type      string                 `json:"type"` // shutdown, agent_crashed, agent_restarted, agent_crash_loop, config_change, agent_scaled_up, agent_scaled_down
 	Message   string                 `json:"message"`
 	Data      map[string]interface{} `json:"data,omitempty"`
 	Timestamp time.Time              `json:"timestamp"`
@@ -0,0 +1,236 @@
+package sergeant
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/CLIAIRMONITOR/internal/aider"
+	"github.com/CLIAIRMONITOR/internal/memory"
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+)
+
+// scaleUpCooldown gives a freshly spawned agent time to reach its prompt
+// before the same project can trigger another spawn
+const scaleUpCooldown = 30 * time.Second
+
+// scaleDecision is a single spawn or stop chosen by autoscale
+type scaleDecision struct {
+	Up           bool
+	AgentID      string
+	ProjectPath  string
+	PendingTasks int
+	Reason       string
+}
+
+// autoscale spawns agents for projects with a deep queue and stops the ones it
+// spawned once they are idle past IdleTimeout
+func (s *Sergeant) autoscale() {
+	pending, err := s.opDB.ListTasks(memory.TaskFilter{Status: memory.TaskStatusPending})
+	if err != nil {
+		log.Printf("[SERGEANT] Failed to list pending tasks for autoscale: %v", err)
+		return
+	}
+
+	agents := s.spawner.ListAgents()
+	now := time.Now()
+
+	s.mu.Lock()
+	paused := s.paused
+	s.trackIdle(agents, now)
+	decisions := s.planScaling(pending, agents, s.spawner.IsManaged, now)
+	s.mu.Unlock()
+
+	if paused {
+		return
+	}
+
+	// Spawning and stopping can block for seconds, so run them outside the lock
+	for _, d := range decisions {
+		if d.Up {
+			s.scaleUp(d)
+		} else {
+			s.scaleDown(d)
+		}
+	}
+}
+
+// trackIdle records since when each unassigned agent has been idle. Caller
+// must hold s.mu.
+func (s *Sergeant) trackIdle(agents []*aider.Agent, now time.Time) {
+	for _, agent := range agents {
+		status, _ := agent.Bridge.GetStatus()
+		_, assigned := s.assignments[agent.ID]
+		if assigned || (status != "idle" && status != "connected") {
+			delete(s.idleSince, agent.ID)
+			continue
+		}
+		if _, ok := s.idleSince[agent.ID]; !ok {
+			s.idleSince[agent.ID] = now
+		}
+	}
+}
+
+// planScaling decides which projects need another agent and which agents it
+// spawned have been idle past the timeout. Agents started for the agents:
+// section or by hand are never stopped. Caller must hold s.mu.
+func (s *Sergeant) planScaling(pending []*memory.Task, agents []*aider.Agent, isManaged func(agentID string) bool, now time.Time) []scaleDecision {
+	cfg := s.config.Sergeant
+	var decisions []scaleDecision
+
+	agentsByProject := make(map[string]int)
+	for _, agent := range agents {
+		agentsByProject[filepath.Clean(agent.ProjectPath)]++
+	}
+
+	// Scale up: one new agent per project per cooldown while under the agent cap
+	pendingByProject := make(map[string]int)
+	for _, task := range pending {
+		if task.ProjectPath == "" {
+			continue // no project to spawn into
+		}
+		pendingByProject[filepath.Clean(task.ProjectPath)]++
+	}
+
+	running := len(agents)
+	for project, count := range pendingByProject {
+		if cfg.MaxConcurrentAgents > 0 && running >= cfg.MaxConcurrentAgents {
+			break
+		}
+		if agentsByProject[project] > 0 && count <= cfg.ScaleUpThreshold {
+			continue
+		}
+		if last, ok := s.lastScaleUp[project]; ok && now.Sub(last) < scaleUpCooldown {
+			continue
+		}
+
+		reason := fmt.Sprintf("%d pending tasks exceed threshold %d", count, cfg.ScaleUpThreshold)
+		if agentsByProject[project] == 0 {
+			reason = fmt.Sprintf("%d pending tasks and no agent on project", count)
+		}
+
+		s.lastScaleUp[project] = now
+		running++
+		decisions = append(decisions, scaleDecision{
+			Up:           true,
+			ProjectPath:  project,
+			PendingTasks: count,
+			Reason:       reason,
+		})
+	}
+
+	// Scale down: stop agents the autoscaler spawned once idle longer than IdleTimeout
+	if cfg.IdleTimeout > 0 {
+		timeout := time.Duration(cfg.IdleTimeout) * time.Second
+		for _, agent := range agents {
+			if !s.scaled[agent.ID] || isManaged(agent.ID) {
+				continue
+			}
+			since, ok := s.idleSince[agent.ID]
+			if !ok || now.Sub(since) < timeout {
+				continue
+			}
+			if pendingByProject[filepath.Clean(agent.ProjectPath)] > 0 {
+				continue // work is waiting for this agent
+			}
+
+			delete(s.idleSince, agent.ID)
+			decisions = append(decisions, scaleDecision{
+				AgentID:     agent.ID,
+				ProjectPath: agent.ProjectPath,
+				Reason:      fmt.Sprintf("idle for %s (timeout %s)", now.Sub(since).Round(time.Second), timeout),
+			})
+		}
+	}
+
+	return decisions
+}
+
+// scaleUp spawns an agent for a project with queued work
+func (s *Sergeant) scaleUp(d scaleDecision) {
+	agent, err := s.spawner.SpawnAgent(aider.AgentConfig{
+		Name:        "Qwen-Autoscale",
+		Role:        "developer",
+		ProjectPath: d.ProjectPath,
+	})
+	if err != nil {
+		log.Printf("[SERGEANT] Scale-up for %s failed: %v", d.ProjectPath, err)
+		s.recordScaling(d, fmt.Sprintf("spawn failed: %v", err))
+		return
+	}
+
+	d.AgentID = agent.ID
+	log.Printf("[SERGEANT] Scaled up: spawned %s for %s (%s)", agent.ID, d.ProjectPath, d.Reason)
+
+	s.mu.Lock()
+	s.scaled[agent.ID] = true
+	s.currentOp = fmt.Sprintf("Spawned %s for %s", agent.ID, d.ProjectPath)
+	s.mu.Unlock()
+
+	s.recordScaling(d, "spawned")
+}
+
+// scaleDown stops an agent that has been idle too long
+func (s *Sergeant) scaleDown(d scaleDecision) {
+	log.Printf("[SERGEANT] Scaled down: stopping %s (%s)", d.AgentID, d.Reason)
+
+	if err := s.spawner.StopAgent(d.AgentID); err != nil {
+		log.Printf("[SERGEANT] Scale-down of %s failed: %v", d.AgentID, err)
+		s.recordScaling(d, fmt.Sprintf("stop failed: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	delete(s.agentStatus, d.AgentID)
+	delete(s.scaled, d.AgentID)
+	s.currentOp = fmt.Sprintf("Stopped idle agent %s", d.AgentID)
+	s.mu.Unlock()
+
+	s.recordScaling(d, "stopped")
+}
+
+// recordScaling logs a scaling decision as an episode and broadcasts it for the dashboard
+func (s *Sergeant) recordScaling(d scaleDecision, outcome string) {
+	msgType := "agent_scaled_down"
+	action := fmt.Sprintf("Stop agent %s", d.AgentID)
+	if d.Up {
+		msgType = "agent_scaled_up"
+		action = fmt.Sprintf("Spawn agent for %s", d.ProjectPath)
+	}
+
+	s.mu.Lock()
+	learnDB := s.learnDB
+	s.mu.Unlock()
+
+	if learnDB != nil {
+		episode := &memory.Episode{
+			SessionID:  s.sessionID,
+			AgentID:    "sergeant",
+			EventType:  "decision",
+			Content:    fmt.Sprintf("%s: %s", action, d.Reason),
+			Context:    d.ProjectPath,
+			Outcome:    outcome,
+			Importance: 0.4,
+		}
+		if err := learnDB.RecordEpisode(episode); err != nil {
+			log.Printf("[SERGEANT] Failed to record scaling episode: %v", err)
+		}
+	}
+
+	broadcast := natslib.SystemBroadcastMessage{
+		Type:    msgType,
+		Message: fmt.Sprintf("%s: %s (%s)", action, d.Reason, outcome),
+		Data: map[string]interface{}{
+			"agent_id":      d.AgentID,
+			"project_path":  d.ProjectPath,
+			"pending_tasks": d.PendingTasks,
+			"reason":        d.Reason,
+			"outcome":       outcome,
+		},
+		Timestamp: time.Now(),
+	}
+	if err := s.client.PublishJSON(natslib.SubjectSystemBroadcast, broadcast); err != nil {
+		log.Printf("[SERGEANT] Failed to broadcast scaling decision: %v", err)
+	}
+}
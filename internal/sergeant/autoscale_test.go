@@ -0,0 +1,69 @@
+package sergeant
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CLIAIRMONITOR/internal/aider"
+	"github.com/CLIAIRMONITOR/internal/memory"
+)
+
+func notManaged(string) bool { return false }
+
+func TestPlanScalingSpawnsForUnservedProject(t *testing.T) {
+	config := aider.DefaultConfig()
+	config.Sergeant.MaxConcurrentAgents = 2
+	s := New("", config, nil, nil)
+
+	pending := []*memory.Task{
+		{ID: "t1", ProjectPath: "/work/alpha"},
+		{ID: "t2", ProjectPath: "/work/alpha"},
+		{ID: "t3"},
+	}
+	now := time.Now()
+
+	decisions := s.planScaling(pending, nil, notManaged, now)
+	if len(decisions) != 1 || !decisions[0].Up || decisions[0].ProjectPath != "/work/alpha" {
+		t.Fatalf("Expected one scale-up for /work/alpha, got %+v", decisions)
+	}
+
+	// Within the cooldown the same project must not trigger another spawn
+	decisions = s.planScaling(pending, nil, notManaged, now.Add(time.Second))
+	if len(decisions) != 0 {
+		t.Fatalf("Expected no decisions during cooldown, got %+v", decisions)
+	}
+}
+
+func TestPlanScalingStopsOnlyScaledAgents(t *testing.T) {
+	config := aider.DefaultConfig()
+	config.Sergeant.IdleTimeout = 60
+	s := New("", config, nil, nil)
+
+	agents := []*aider.Agent{
+		{ID: "aider-scaled", ProjectPath: "/work/alpha"},
+		{ID: "aider-fresh", ProjectPath: "/work/alpha"},
+		{ID: "aider-manual", ProjectPath: "/work/alpha"},
+		{ID: "aider-managed", ProjectPath: "/work/alpha"},
+	}
+	now := time.Now()
+	for _, agent := range agents {
+		s.idleSince[agent.ID] = now.Add(-time.Hour)
+	}
+	s.idleSince["aider-fresh"] = now.Add(-time.Second)
+	s.scaled["aider-scaled"] = true
+	s.scaled["aider-fresh"] = true
+	s.scaled["aider-managed"] = true // and also started for an agents: entry
+	isManaged := func(agentID string) bool { return agentID == "aider-managed" }
+
+	decisions := s.planScaling(nil, agents, isManaged, now)
+	if len(decisions) != 1 || decisions[0].Up || decisions[0].AgentID != "aider-scaled" {
+		t.Fatalf("Expected only aider-scaled to be stopped, got %+v", decisions)
+	}
+
+	// Queued work for the project keeps even a scaled agent around
+	s.idleSince["aider-scaled"] = now.Add(-time.Hour)
+	pending := []*memory.Task{{ID: "t1", ProjectPath: "/work/alpha"}}
+	if decisions := s.planScaling(pending, agents, isManaged, now); len(decisions) != 0 {
+		t.Fatalf("Expected no decisions with work pending, got %+v", decisions)
+	}
+}
@@ -0,0 +1,383 @@
+package sergeant
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/CLIAIRMONITOR/internal/aider"
+	"github.com/CLIAIRMONITOR/internal/memory"
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+	nc "github.com/nats-io/nats.go"
+)
+
+// dispatch claims pending tasks (highest priority first) and hands them to idle agents
+func (s *Sergeant) dispatch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.paused {
+		return
+	}
+
+	maxAgents := s.config.Sergeant.MaxConcurrentAgents
+	if maxAgents > 0 && len(s.assignments) >= maxAgents {
+		return
+	}
+
+	// ListTasks orders by priority DESC, created_at ASC
+	tasks, err := s.opDB.ListTasks(memory.TaskFilter{Status: memory.TaskStatusPending})
+	if err != nil {
+		log.Printf("[SERGEANT] Failed to list pending tasks: %v", err)
+		return
+	}
+	if len(tasks) == 0 {
+		return
+	}
+
+	idle := s.idleAgents()
+
+	for _, task := range tasks {
+		if maxAgents > 0 && len(s.assignments) >= maxAgents {
+			break
+		}
+		if len(idle) == 0 {
+			break
+		}
+
+		agent := pickAgent(idle, task)
+		if agent == nil {
+			continue
+		}
+
+		if err := s.assign(task, agent); err != nil {
+			log.Printf("[SERGEANT] Failed to assign task %s to %s: %v", task.ID, agent.ID, err)
+			continue
+		}
+
+		idle = removeAgent(idle, agent.ID)
+	}
+}
+
+// idleAgents returns running agents that have no assignment and are waiting for input.
+// Caller must hold s.mu.
+func (s *Sergeant) idleAgents() []*aider.Agent {
+	var idle []*aider.Agent
+	for _, agent := range s.spawner.ListAgents() {
+		if _, busy := s.assignments[agent.ID]; busy {
+			continue
+		}
+		if !agent.Bridge.IsConnected() {
+			continue
+		}
+		status, _ := agent.Bridge.GetStatus()
+		if status == "idle" || status == "connected" {
+			idle = append(idle, agent)
+		}
+	}
+	return idle
+}
+
+// assign claims a task for an agent and sends it as a prompt. Caller must hold s.mu.
+func (s *Sergeant) assign(task *memory.Task, agent *aider.Agent) error {
+	if err := s.opDB.ClaimTask(task.ID, agent.ID); err != nil {
+		return err
+	}
+
+	note := fmt.Sprintf("Sent to agent %s", agent.ID)
+	if err := s.opDB.UpdateTaskProgress(task.ID, memory.TaskStatusInProgress, note); err != nil {
+		log.Printf("[SERGEANT] Failed to update task %s: %v", task.ID, err)
+	}
+
+	a := &assignment{
+		TaskID:      task.ID,
+		TaskTitle:   task.Title,
+		AgentID:     agent.ID,
+		SessionID:   agent.SessionID,
+		ProjectPath: agent.ProjectPath,
+		Supervised:  agent.Supervised(),
+		StartedAt:   time.Now(),
+	}
+	s.assignments[agent.ID] = a
+	s.currentOp = fmt.Sprintf("Dispatched %q to %s", task.Title, agent.ID)
+
+	req := natslib.PromptRequest{
+		Text:           buildPrompt(task),
+		TimeoutSeconds: int(taskPromptTimeout / time.Second),
+		TaskType:       task.TaskType,
+		TaskID:         task.ID,
+	}
+	go s.runAssignment(a, req)
+
+	log.Printf("[SERGEANT] Dispatched task %s (priority %d) to agent %s", task.ID, task.Priority, agent.ID)
+	return nil
+}
+
+// runAssignment sends a task's prompt over the agent's request-reply prompt
+// subject and settles the task from that prompt's own outcome, so other
+// prompts the agent runs, or errors between them, don't finish it
+func (s *Sergeant) runAssignment(a *assignment, req natslib.PromptRequest) {
+	subject := fmt.Sprintf(natslib.SubjectAgentPrompt, a.AgentID)
+	var response natslib.PromptResponse
+	err := s.client.RequestJSON(subject, req, &response, taskPromptTimeout+time.Minute)
+	s.settle(a, &response, err)
+}
+
+// adoptTasks takes back tasks a previous monitor left with supervised agents
+// that were reattached, settling each from the prompt still queued or running
+// for it. A task its agent no longer has returns to the queue.
+func (s *Sergeant) adoptTasks(agents []*aider.Agent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, agent := range agents {
+		tasks, err := s.opDB.ListTasks(memory.TaskFilter{AssignedTo: agent.ID})
+		if err != nil {
+			log.Printf("[SERGEANT] Failed to list tasks of agent %s: %v", agent.ID, err)
+			continue
+		}
+
+		for _, task := range tasks {
+			if task.Status != memory.TaskStatusClaimed && task.Status != memory.TaskStatusInProgress {
+				continue
+			}
+
+			result, ok := agent.Bridge.AwaitTask(task.ID)
+			if !ok || s.assignments[agent.ID] != nil {
+				note := fmt.Sprintf("Returned to queue: agent %s lost it across a monitor restart", agent.ID)
+				if err := s.opDB.ReleaseTask(task.ID, note); err != nil {
+					log.Printf("[SERGEANT] Failed to release task %s: %v", task.ID, err)
+				}
+				continue
+			}
+
+			a := &assignment{
+				TaskID:      task.ID,
+				TaskTitle:   task.Title,
+				AgentID:     agent.ID,
+				SessionID:   agent.SessionID,
+				ProjectPath: agent.ProjectPath,
+				Supervised:  agent.Supervised(),
+				StartedAt:   time.Now(),
+			}
+			s.assignments[agent.ID] = a
+			go func() {
+				response := <-result
+				s.settle(a, response, nil)
+			}()
+			log.Printf("[SERGEANT] Adopted task %s still running on agent %s", task.ID, agent.ID)
+		}
+	}
+}
+
+// settle finishes an assignment from its prompt's outcome
+func (s *Sergeant) settle(a *assignment, response *natslib.PromptResponse, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Already settled, e.g. returned to the queue when the agent disconnected
+	if s.assignments[a.AgentID] != a {
+		return
+	}
+
+	switch {
+	case errors.Is(err, nc.ErrNoResponders) || response.Error == aider.PromptErrorStopped:
+		s.requeue(a, "stopped")
+	case err != nil:
+		s.failTask(a, fmt.Sprintf("No result from agent %s: %v", a.AgentID, err))
+	case response.Error != "" || response.TimedOut || len(response.Errors) > 0:
+		// Warnings, such as stderr noise, leave the task to complete
+		s.failTask(a, promptFailure(response))
+	default:
+		s.completeTask(a, response)
+	}
+}
+
+// completeTask marks an assignment's task completed. Caller must hold s.mu.
+func (s *Sergeant) completeTask(a *assignment, response *natslib.PromptResponse) {
+	summary := fmt.Sprintf("Completed by %s in %s", a.AgentID, time.Since(a.StartedAt).Round(time.Second))
+	if tail := outputTail(response.Transcript); len(tail) > 0 {
+		summary += "\n" + strings.Join(tail, "\n")
+	}
+	if err := s.opDB.CompleteTask(a.TaskID, summary); err != nil {
+		log.Printf("[SERGEANT] Failed to complete task %s: %v", a.TaskID, err)
+	}
+	s.countCompletedTask(a.AgentID)
+	s.recordCompletion(a, summary)
+	delete(s.assignments, a.AgentID)
+	delete(s.taskAttempts, a.TaskID)
+	s.currentOp = fmt.Sprintf("Task %s completed by %s", a.TaskID, a.AgentID)
+	log.Printf("[SERGEANT] Task %s completed by agent %s (prompt %s)", a.TaskID, a.AgentID, response.PromptID)
+}
+
+// failTask marks an assignment's task failed. Caller must hold s.mu.
+func (s *Sergeant) failTask(a *assignment, note string) {
+	if err := s.opDB.UpdateTaskProgress(a.TaskID, memory.TaskStatusFailed, note); err != nil {
+		log.Printf("[SERGEANT] Failed to update task %s: %v", a.TaskID, err)
+	}
+	delete(s.assignments, a.AgentID)
+	delete(s.taskAttempts, a.TaskID)
+	s.currentOp = fmt.Sprintf("Task %s failed on %s", a.TaskID, a.AgentID)
+	log.Printf("[SERGEANT] Task %s failed on agent %s: %s", a.TaskID, a.AgentID, note)
+}
+
+// requeue returns an assignment's task to the queue after its agent went away,
+// so a restarted or other agent can pick it up; the task fails once it has
+// taken down too many agents. The Sergeant alone re-queues the tasks it
+// dispatched, the spawner leaves them alone. Caller must hold s.mu.
+func (s *Sergeant) requeue(a *assignment, reason string) {
+	delete(s.assignments, a.AgentID)
+	s.taskAttempts[a.TaskID]++
+
+	var err error
+	note := fmt.Sprintf("Returned to queue: agent %s %s", a.AgentID, reason)
+	if s.taskAttempts[a.TaskID] >= maxTaskAttempts {
+		note = fmt.Sprintf("Failed after %d attempts: agent %s %s", s.taskAttempts[a.TaskID], a.AgentID, reason)
+		delete(s.taskAttempts, a.TaskID)
+		err = s.opDB.UpdateTaskProgress(a.TaskID, memory.TaskStatusFailed, note)
+	} else {
+		err = s.opDB.ReleaseTask(a.TaskID, note)
+	}
+
+	if err != nil {
+		log.Printf("[SERGEANT] Failed to update task %s: %v", a.TaskID, err)
+	}
+	log.Printf("[SERGEANT] Task %s: %s", a.TaskID, note)
+}
+
+// handleAgentStatus records task progress from bridge status updates and
+// returns tasks whose agent went away to the queue
+func (s *Sergeant) handleAgentStatus(msg *natslib.Message) {
+	var status natslib.StatusMessage
+	if err := json.Unmarshal(msg.Data, &status); err != nil {
+		log.Printf("[SERGEANT] Invalid status JSON: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.agentStatus[status.AgentID] = status.Status
+
+	a, ok := s.assignments[status.AgentID]
+	if !ok {
+		return
+	}
+
+	switch status.Status {
+	case "working":
+		if err := s.opDB.UpdateTaskProgress(a.TaskID, memory.TaskStatusInProgress, status.CurrentTask); err != nil {
+			log.Printf("[SERGEANT] Failed to update task %s: %v", a.TaskID, err)
+		}
+
+	case "error":
+		// The prompt's outcome decides whether the task failed
+		note := fmt.Sprintf("Error: %s", status.CurrentTask)
+		if err := s.opDB.UpdateTaskProgress(a.TaskID, memory.TaskStatusInProgress, note); err != nil {
+			log.Printf("[SERGEANT] Failed to update task %s: %v", a.TaskID, err)
+		}
+
+	case "disconnected", "stopping":
+		s.requeue(a, status.Status)
+	}
+}
+
+// recordCompletion remembers a completed task as an episode of the agent's
+// session. Caller must hold s.mu.
+func (s *Sergeant) recordCompletion(a *assignment, summary string) {
+	if s.learnDB == nil {
+		return
+	}
+
+	episode := &memory.Episode{
+		SessionID:   a.SessionID,
+		AgentID:     a.AgentID,
+		EventType:   "observation",
+		Content:     fmt.Sprintf("Completed task %q", a.TaskTitle),
+		Context:     a.TaskID,
+		Outcome:     summary,
+		Importance:  0.6,
+		ProjectPath: a.ProjectPath,
+	}
+	if err := s.learnDB.RecordEpisode(episode); err != nil {
+		log.Printf("[SERGEANT] Failed to record completion episode: %v", err)
+	}
+}
+
+// countCompletedTask bumps TasksCompleted on the agent's active session
+func (s *Sergeant) countCompletedTask(agentID string) {
+	session, err := s.opDB.GetActiveSession(agentID)
+	if err != nil || session == nil {
+		return
+	}
+	session.TasksCompleted++
+	if err := s.opDB.UpdateSession(session); err != nil {
+		log.Printf("[SERGEANT] Failed to update session %s: %v", session.ID, err)
+	}
+}
+
+// promptFailure describes why a task's prompt failed: it could not be run,
+// timed out or Aider reported errors. Warnings are not reasons.
+func promptFailure(response *natslib.PromptResponse) string {
+	var reasons []string
+	if response.Error != "" {
+		reasons = append(reasons, response.Error)
+	}
+	if response.TimedOut {
+		reasons = append(reasons, "timed out")
+	}
+	reasons = append(reasons, response.Errors...)
+	return fmt.Sprintf("Failed on %s: %s", response.AgentID, strings.Join(reasons, "; "))
+}
+
+// outputTail returns the last non-empty lines of a prompt's transcript
+func outputTail(transcript []string) []string {
+	var tail []string
+	for i := len(transcript) - 1; i >= 0 && len(tail) < outputTailLines; i-- {
+		if line := strings.TrimSpace(transcript[i]); line != "" {
+			tail = append([]string{line}, tail...)
+		}
+	}
+	return tail
+}
+
+// pickAgent chooses an idle agent for a task, matching the task's project path when set
+func pickAgent(idle []*aider.Agent, task *memory.Task) *aider.Agent {
+	for _, agent := range idle {
+		if task.ProjectPath == "" || sameProject(agent.ProjectPath, task.ProjectPath) {
+			return agent
+		}
+	}
+	return nil
+}
+
+// sameProject compares two project paths after cleaning
+func sameProject(a, b string) bool {
+	return filepath.Clean(a) == filepath.Clean(b)
+}
+
+// removeAgent returns agents without the one with the given ID
+func removeAgent(agents []*aider.Agent, agentID string) []*aider.Agent {
+	result := agents[:0]
+	for _, agent := range agents {
+		if agent.ID != agentID {
+			result = append(result, agent)
+		}
+	}
+	return result
+}
+
+// buildPrompt renders a task as an Aider prompt
+func buildPrompt(task *memory.Task) string {
+	var sb strings.Builder
+	sb.WriteString(task.Title)
+	if task.Description != "" {
+		sb.WriteString("\n\n")
+		sb.WriteString(task.Description)
+	}
+	return sb.String()
+}
@@ -0,0 +1,313 @@
+package sergeant
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CLIAIRMONITOR/internal/aider"
+	"github.com/CLIAIRMONITOR/internal/memory"
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+func TestPickAgentMatchesProject(t *testing.T) {
+	idle := []*aider.Agent{
+		{ID: "aider-a", ProjectPath: "/work/alpha"},
+		{ID: "aider-b", ProjectPath: "/work/beta/"},
+	}
+
+	agent := pickAgent(idle, &memory.Task{ID: "t1", ProjectPath: "/work/beta"})
+	if agent == nil || agent.ID != "aider-b" {
+		t.Fatalf("Expected aider-b for beta task, got %v", agent)
+	}
+
+	agent = pickAgent(idle, &memory.Task{ID: "t2"})
+	if agent == nil || agent.ID != "aider-a" {
+		t.Fatalf("Expected first idle agent for task without project, got %v", agent)
+	}
+
+	agent = pickAgent(idle, &memory.Task{ID: "t3", ProjectPath: "/work/gamma"})
+	if agent != nil {
+		t.Fatalf("Expected no agent for unknown project, got %s", agent.ID)
+	}
+}
+
+func TestBuildPrompt(t *testing.T) {
+	prompt := buildPrompt(&memory.Task{Title: "Fix login", Description: "Handle empty password"})
+	if prompt != "Fix login\n\nHandle empty password" {
+		t.Errorf("Unexpected prompt: %q", prompt)
+	}
+
+	prompt = buildPrompt(&memory.Task{Title: "Fix login"})
+	if prompt != "Fix login" {
+		t.Errorf("Unexpected prompt: %q", prompt)
+	}
+}
+
+// setupDispatch returns a started Sergeant on an embedded NATS server with a
+// real operational database, and a client to play the agent's bridge
+func setupDispatch(t *testing.T) (*Sergeant, *natslib.Client) {
+	t.Helper()
+
+	ns, err := server.NewServer(&server.Options{Port: server.RANDOM_PORT, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("Failed to create NATS server: %v", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	t.Cleanup(ns.Shutdown)
+
+	opDB, err := memory.NewSQLiteOperationalDB(filepath.Join(t.TempDir(), "operational.db"))
+	if err != nil {
+		t.Fatalf("Failed to open operational DB: %v", err)
+	}
+	t.Cleanup(func() { opDB.Close() })
+
+	s := New(ns.ClientURL(), aider.DefaultConfig(), nil, opDB)
+	client, err := natslib.NewClient(ns.ClientURL(), "sergeant")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(client.Close)
+	s.client = client
+
+	agent, err := natslib.NewClient(ns.ClientURL(), "agent")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(agent.Close)
+	return s, agent
+}
+
+// dispatchTask creates a task and assigns it to agent a1
+func dispatchTask(t *testing.T, s *Sergeant) string {
+	t.Helper()
+
+	task := &memory.Task{ID: "task-1", Title: "Fix login", Status: memory.TaskStatusPending, CreatedAt: time.Now()}
+	if err := s.opDB.CreateTask(task); err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+
+	s.mu.Lock()
+	err := s.assign(task, &aider.Agent{ID: "a1", ProjectPath: "/work/alpha"})
+	s.mu.Unlock()
+	if err != nil {
+		t.Fatalf("Failed to assign task: %v", err)
+	}
+	return task.ID
+}
+
+// waitTaskStatus polls until the task reaches the status
+func waitTaskStatus(t *testing.T, s *Sergeant, taskID string, want memory.TaskStatus) *memory.Task {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		task, err := s.opDB.GetTask(taskID)
+		if err != nil {
+			t.Fatalf("Failed to get task: %v", err)
+		}
+		if task.Status == want {
+			return task
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected task %s to be %s, got %s (%s)", taskID, want, task.Status, task.Progress)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// respond answers the agent's prompt requests with the response once release
+// is closed, or right away when release is nil
+func respond(t *testing.T, agent *natslib.Client, response natslib.PromptResponse, release chan struct{}) <-chan natslib.PromptRequest {
+	t.Helper()
+
+	requests := make(chan natslib.PromptRequest, 1)
+	_, err := agent.Subscribe(fmt.Sprintf(natslib.SubjectAgentPrompt, "a1"), func(msg *natslib.Message) {
+		var req natslib.PromptRequest
+		json.Unmarshal(msg.Data, &req)
+		requests <- req
+		if release != nil {
+			<-release
+		}
+		agent.PublishJSON(msg.Reply, response)
+	})
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	agent.Flush()
+	return requests
+}
+
+func TestDispatchCompletesFromPromptResult(t *testing.T) {
+	s, agent := setupDispatch(t)
+	release := make(chan struct{})
+	requests := respond(t, agent, natslib.PromptResponse{
+		AgentID:    "a1",
+		PromptID:   "p-1",
+		Transcript: []string{"Applied edit to login.go", "", "Commit abc123 fix login"},
+	}, release)
+
+	taskID := dispatchTask(t, s)
+	req := <-requests
+	if req.Text != "Fix login" {
+		t.Errorf("Unexpected prompt text: %q", req.Text)
+	}
+
+	// Turns ending before the task's prompt returns, even in an error, don't settle it
+	for _, status := range []string{"working", "idle", "error", "idle"} {
+		data, _ := json.Marshal(natslib.StatusMessage{AgentID: "a1", Status: status})
+		s.handleAgentStatus(&natslib.Message{Data: data})
+	}
+	if task, _ := s.opDB.GetTask(taskID); task.Status != memory.TaskStatusInProgress {
+		t.Fatalf("Expected the task in progress until its prompt returns, got %s", task.Status)
+	}
+	close(release)
+
+	task := waitTaskStatus(t, s, taskID, memory.TaskStatusCompleted)
+	if !strings.Contains(task.Summary, "Commit abc123 fix login") {
+		t.Errorf("Expected the transcript tail in the summary, got %q", task.Summary)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.assignments) != 0 {
+		t.Errorf("Expected the assignment to be cleared, got %v", s.assignments)
+	}
+}
+
+func TestDispatchFailsOnPromptError(t *testing.T) {
+	s, agent := setupDispatch(t)
+	respond(t, agent, natslib.PromptResponse{
+		AgentID:  "a1",
+		PromptID: "p-1",
+		Errors:   []string{"litellm.APIError: model not loaded"},
+	}, nil)
+
+	taskID := dispatchTask(t, s)
+
+	task := waitTaskStatus(t, s, taskID, memory.TaskStatusFailed)
+	if !strings.Contains(task.Progress, "model not loaded") {
+		t.Errorf("Expected the error in the progress note, got %q", task.Progress)
+	}
+}
+
+func TestDispatchCompletesDespiteWarnings(t *testing.T) {
+	s, agent := setupDispatch(t)
+	respond(t, agent, natslib.PromptResponse{
+		AgentID:    "a1",
+		PromptID:   "p-1",
+		Transcript: []string{"Applied edit to login.go"},
+		Warnings:   []string{"litellm: error fetching model info", "lint_failed"},
+	}, nil)
+
+	taskID := dispatchTask(t, s)
+
+	waitTaskStatus(t, s, taskID, memory.TaskStatusCompleted)
+}
+
+func TestDispatchRequeuesWhenBridgeStops(t *testing.T) {
+	s, agent := setupDispatch(t)
+	respond(t, agent, natslib.PromptResponse{AgentID: "a1", Error: aider.PromptErrorStopped}, nil)
+
+	taskID := dispatchTask(t, s)
+
+	waitTaskStatus(t, s, taskID, memory.TaskStatusPending)
+}
+
+func TestDispatchRequeuesOnceWhenAgentDisconnects(t *testing.T) {
+	s, agent := setupDispatch(t)
+	release := make(chan struct{})
+	respond(t, agent, natslib.PromptResponse{AgentID: "a1", Error: aider.PromptErrorStopped}, release)
+
+	taskID := dispatchTask(t, s)
+
+	// The bridge reports the disconnect, then fails the prompt it was running
+	data, _ := json.Marshal(natslib.StatusMessage{AgentID: "a1", Status: "disconnected"})
+	s.handleAgentStatus(&natslib.Message{Data: data})
+	close(release)
+
+	task := waitTaskStatus(t, s, taskID, memory.TaskStatusPending)
+	if task.AssignedTo != "" {
+		t.Errorf("Expected the re-queued task unassigned, got %q", task.AssignedTo)
+	}
+
+	// Let the prompt reply arrive; it must not count as a second attempt
+	time.Sleep(100 * time.Millisecond)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if attempts := s.taskAttempts[taskID]; attempts != 1 {
+		t.Errorf("Expected 1 attempt, got %d", attempts)
+	}
+}
+
+// claimTask creates a task already in progress on an agent
+func claimTask(t *testing.T, s *Sergeant, taskID, agentID string) {
+	t.Helper()
+
+	task := &memory.Task{ID: taskID, Title: "Fix " + taskID, Status: memory.TaskStatusPending, CreatedAt: time.Now()}
+	if err := s.opDB.CreateTask(task); err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	if err := s.opDB.ClaimTask(taskID, agentID); err != nil {
+		t.Fatalf("Failed to claim task: %v", err)
+	}
+	if err := s.opDB.UpdateTaskProgress(taskID, memory.TaskStatusInProgress, "Sent to agent "+agentID); err != nil {
+		t.Fatalf("Failed to update task: %v", err)
+	}
+}
+
+func TestDetachLeavesSupervisedAgentsTheirTasks(t *testing.T) {
+	s, _ := setupDispatch(t)
+	claimTask(t, s, "task-1", "a1")
+	claimTask(t, s, "task-2", "a2")
+	s.assignments["a1"] = &assignment{TaskID: "task-1", AgentID: "a1", Supervised: true}
+	s.assignments["a2"] = &assignment{TaskID: "task-2", AgentID: "a2"}
+
+	// Detach publishes the Sergeant's final status, which lists the agents
+	s.spawner = aider.NewSpawner(s.natsURL, s.config)
+	t.Cleanup(s.spawner.StopAll)
+	s.Detach()
+
+	kept := waitTaskStatus(t, s, "task-1", memory.TaskStatusInProgress)
+	if kept.AssignedTo != "a1" {
+		t.Errorf("Expected task-1 left with a1, got %q", kept.AssignedTo)
+	}
+	waitTaskStatus(t, s, "task-2", memory.TaskStatusPending)
+}
+
+func TestAdoptTasksFromReattachedAgent(t *testing.T) {
+	s, _ := setupDispatch(t)
+	claimTask(t, s, "task-1", "a1")
+	claimTask(t, s, "task-2", "a1")
+
+	// The reattached bridge still has task-1 queued, but lost task-2
+	bridge := aider.NewBridge("a1", nil, nil, nil, nil)
+	bridge.Resume(natslib.PromptQueueMessage{
+		Pending: []natslib.QueuedPrompt{{ID: "p-1", Text: "Fix task-1", TaskID: "task-1"}},
+	})
+
+	s.adoptTasks([]*aider.Agent{{ID: "a1", Bridge: bridge}})
+
+	waitTaskStatus(t, s, "task-2", memory.TaskStatusPending)
+	s.mu.Lock()
+	a := s.assignments["a1"]
+	s.mu.Unlock()
+	if a == nil || a.TaskID != "task-1" {
+		t.Fatalf("Expected task-1 adopted, got %+v", a)
+	}
+
+	// The adopted task settles from its prompt's outcome
+	if err := bridge.Cancel("p-1"); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	task := waitTaskStatus(t, s, "task-1", memory.TaskStatusFailed)
+	if !strings.Contains(task.Progress, "cancelled") {
+		t.Errorf("Expected the prompt's error in the progress note, got %q", task.Progress)
+	}
+}
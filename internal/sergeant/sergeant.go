@@ -0,0 +1,288 @@
+// Package sergeant implements the Aider Sergeant, the orchestrator that feeds
+// tasks from the operational database to idle Aider agents.
+package sergeant
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/CLIAIRMONITOR/internal/aider"
+	"github.com/CLIAIRMONITOR/internal/memory"
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+)
+
+// outputTailLines is how many lines of the prompt's output go into the task summary
+const outputTailLines = 10
+
+// taskPromptTimeout bounds one task's prompt, including time queued on the agent
+const taskPromptTimeout = 30 * time.Minute
+
+// maxTaskAttempts is how many times a task is re-queued after its agent died
+const maxTaskAttempts = 3
+
+// assignment tracks a task that has been handed to an agent
+type assignment struct {
+	TaskID      string
+	TaskTitle   string
+	AgentID     string
+	SessionID   string // the agent's session, for episodes
+	ProjectPath string // the agent's project path, for episodes
+	Supervised  bool   // the agent outlives the monitor, and keeps the task on Detach
+	StartedAt   time.Time
+}
+
+// Sergeant dispatches pending tasks to idle agents and tracks their completion
+type Sergeant struct {
+	natsURL string
+	config  *aider.Config
+	spawner *aider.Spawner
+	opDB    memory.OperationalDB
+	learnDB memory.LearningDB
+	client  *natslib.Client
+
+	// sessionID groups the Sergeant's own episodes (scaling decisions)
+	sessionID string
+
+	assignments  map[string]*assignment // keyed by agent ID
+	agentStatus  map[string]string      // last status seen per agent
+	idleSince    map[string]time.Time   // when each unassigned agent became idle
+	lastScaleUp  map[string]time.Time   // last scale-up per project path
+	scaled       map[string]bool        // agents spawned by autoscale, the only ones it stops
+	taskAttempts map[string]int         // dispatch attempts per task ID
+	paused       bool
+	currentOp    string
+	mu           sync.Mutex
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New creates a new Sergeant
+func New(natsURL string, config *aider.Config, spawner *aider.Spawner, opDB memory.OperationalDB) *Sergeant {
+	return &Sergeant{
+		natsURL:      natsURL,
+		config:       config,
+		spawner:      spawner,
+		opDB:         opDB,
+		sessionID:    fmt.Sprintf("sergeant-%s", time.Now().Format("20060102-150405")),
+		assignments:  make(map[string]*assignment),
+		agentStatus:  make(map[string]string),
+		idleSince:    make(map[string]time.Time),
+		lastScaleUp:  make(map[string]time.Time),
+		scaled:       make(map[string]bool),
+		taskAttempts: make(map[string]int),
+		stopCh:       make(chan struct{}),
+	}
+}
+
+// SetLearningDB enables recording of Sergeant decisions as episodes
+func (s *Sergeant) SetLearningDB(db memory.LearningDB) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.learnDB = db
+}
+
+// Start connects to NATS and begins dispatching tasks
+func (s *Sergeant) Start() error {
+	client, err := natslib.NewClient(s.natsURL, "sergeant")
+	if err != nil {
+		return fmt.Errorf("failed to create NATS client for sergeant: %w", err)
+	}
+	s.client = client
+
+	if _, err := client.Subscribe(natslib.SubjectAllStatus, s.handleAgentStatus); err != nil {
+		client.Close()
+		return fmt.Errorf("failed to subscribe to agent status: %w", err)
+	}
+	if _, err := client.Subscribe(natslib.SubjectSergeantCommands, s.handleCommand); err != nil {
+		client.Close()
+		return fmt.Errorf("failed to subscribe to sergeant commands: %w", err)
+	}
+
+	if s.spawner != nil {
+		s.adoptTasks(s.spawner.ListAgents())
+	}
+
+	s.wg.Add(1)
+	go s.run()
+
+	log.Printf("[SERGEANT] Started (max agents: %d, auto-scale: %v)",
+		s.config.Sergeant.MaxConcurrentAgents, s.config.Sergeant.AutoScale)
+	return nil
+}
+
+// Stop halts dispatching and returns in-flight tasks to the queue
+func (s *Sergeant) Stop() {
+	s.halt(false)
+}
+
+// Detach halts dispatching ahead of detaching from supervised agents. Their
+// tasks stay assigned for the next monitor to adopt; tasks on other agents,
+// which are about to be stopped, return to the queue.
+func (s *Sergeant) Detach() {
+	s.halt(true)
+}
+
+func (s *Sergeant) halt(detach bool) {
+	select {
+	case <-s.stopCh:
+		return
+	default:
+		close(s.stopCh)
+	}
+
+	s.wg.Wait()
+
+	s.mu.Lock()
+	for agentID, a := range s.assignments {
+		delete(s.assignments, agentID)
+		if detach && a.Supervised {
+			log.Printf("[SERGEANT] Leaving task %s with agent %s", a.TaskID, agentID)
+			continue
+		}
+		if err := s.opDB.ReleaseTask(a.TaskID, "Returned to queue: sergeant stopped"); err != nil {
+			log.Printf("[SERGEANT] Failed to release task %s: %v", a.TaskID, err)
+		}
+	}
+	s.currentOp = "Stopped"
+	s.mu.Unlock()
+
+	s.publishStatus()
+
+	if s.client != nil {
+		s.client.Close()
+	}
+
+	log.Println("[SERGEANT] Stopped")
+}
+
+// run is the main dispatch loop
+func (s *Sergeant) run() {
+	defer s.wg.Done()
+
+	pollTicker := time.NewTicker(secondsOr(s.config.Sergeant.PollInterval, 5))
+	defer pollTicker.Stop()
+
+	statusTicker := time.NewTicker(secondsOr(s.config.Sergeant.StatusInterval, 30))
+	defer statusTicker.Stop()
+
+	s.publishStatus()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+
+		case <-pollTicker.C:
+			s.dispatch()
+			if s.config.Sergeant.AutoScale {
+				s.autoscale()
+			}
+
+		case <-statusTicker.C:
+			s.publishStatus()
+		}
+	}
+}
+
+// handleCommand processes commands sent to sergeant.commands
+func (s *Sergeant) handleCommand(msg *natslib.Message) {
+	var cmd natslib.SergeantCommandMessage
+	if err := json.Unmarshal(msg.Data, &cmd); err != nil {
+		log.Printf("[SERGEANT] Invalid command JSON: %v", err)
+		return
+	}
+
+	log.Printf("[SERGEANT] Received command: %s from %s", cmd.Type, cmd.From)
+
+	switch cmd.Type {
+	case "pause":
+		s.mu.Lock()
+		s.paused = true
+		s.currentOp = "Paused"
+		s.mu.Unlock()
+		s.publishStatus()
+
+	case "resume":
+		s.mu.Lock()
+		s.paused = false
+		s.currentOp = "Resumed"
+		s.mu.Unlock()
+		s.dispatch()
+		s.publishStatus()
+
+	case "dispatch":
+		s.dispatch()
+
+	case "spawn_agent":
+		projectPath, _ := cmd.Payload["project_path"].(string)
+		name, _ := cmd.Payload["name"].(string)
+		if name == "" {
+			name = "Qwen-Agent"
+		}
+		agent, err := s.spawner.SpawnAgent(aider.AgentConfig{
+			Name:        name,
+			Role:        "developer",
+			ProjectPath: projectPath,
+		})
+		if err != nil {
+			log.Printf("[SERGEANT] Failed to spawn agent: %v", err)
+			return
+		}
+		log.Printf("[SERGEANT] Spawned agent %s on request", agent.ID)
+
+	case "kill_agent":
+		agentID, _ := cmd.Payload["agent_id"].(string)
+		if err := s.spawner.StopAgent(agentID); err != nil {
+			log.Printf("[SERGEANT] Failed to stop agent %s: %v", agentID, err)
+		}
+
+	default:
+		log.Printf("[SERGEANT] Unknown command type: %s", cmd.Type)
+	}
+}
+
+// publishStatus publishes the Sergeant status to NATS
+func (s *Sergeant) publishStatus() {
+	if s.client == nil {
+		return
+	}
+
+	pending, err := s.opDB.ListTasks(memory.TaskFilter{Status: memory.TaskStatusPending})
+	if err != nil {
+		log.Printf("[SERGEANT] Failed to count pending tasks: %v", err)
+	}
+
+	s.mu.Lock()
+	status := "idle"
+	switch {
+	case s.paused:
+		status = "paused"
+	case len(s.assignments) > 0:
+		status = "busy"
+	}
+	msg := natslib.SergeantStatusMessage{
+		Status:       status,
+		ActiveAgents: len(s.spawner.ListAgents()),
+		BusyAgents:   len(s.assignments),
+		PendingTasks: len(pending),
+		CurrentOp:    s.currentOp,
+		Timestamp:    time.Now(),
+	}
+	s.mu.Unlock()
+
+	if err := s.client.PublishJSON(natslib.SubjectSergeantStatus, msg); err != nil {
+		log.Printf("[SERGEANT] Failed to publish status: %v", err)
+	}
+}
+
+// secondsOr converts a config value in seconds to a duration, using def when unset
+func secondsOr(seconds, def int) time.Duration {
+	if seconds <= 0 {
+		seconds = def
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,143 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is one NATS message as delivered to stream clients
+type Message struct {
+	Seq      uint64          `json:"seq"` // increasing per monitor run
+	Subject  string          `json:"subject"`
+	Data     json.RawMessage `json:"data"` // the JSON payload; other payloads as a JSON string
+	Received time.Time       `json:"received"`
+}
+
+// Gap tells a client that messages it asked for are gone, because it fell
+// further behind than the buffer holds or resumed from another monitor run
+type Gap struct {
+	Missed     uint64 `json:"missed"`          // messages skipped, as far as known
+	Reset      bool   `json:"reset,omitempty"` // the sequence restarted; missed is unknown
+	ResumeFrom uint64 `json:"resume_from"`     // next sequence number delivered
+}
+
+// ring holds the most recent messages by sequence number. Readers keep their
+// own cursor, so a slow client never holds up NATS or other clients; it only
+// risks losing messages that have been overwritten, which it is told about.
+type ring struct {
+	mu      sync.Mutex
+	items   []Message // items[seq % len(items)]
+	last    uint64    // sequence number of the newest message, 0 when empty
+	changed chan struct{}
+}
+
+func newRing(size int) *ring {
+	return &ring{items: make([]Message, size), changed: make(chan struct{})}
+}
+
+// append stores a message and wakes every waiting reader
+func (r *ring) append(subject string, data []byte) {
+	if !json.Valid(data) {
+		data, _ = json.Marshal(string(data))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.last++
+	r.items[r.last%uint64(len(r.items))] = Message{
+		Seq:      r.last,
+		Subject:  subject,
+		Data:     data,
+		Received: time.Now(),
+	}
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// head returns the newest sequence number
+func (r *ring) head() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.last
+}
+
+// read returns up to max messages after the given sequence number, a gap if
+// some of them are no longer held, and a channel closed on the next append
+func (r *ring) read(after uint64, max int) ([]Message, *Gap, <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	oldest := uint64(1)
+	if size := uint64(len(r.items)); r.last > size {
+		oldest = r.last - size + 1
+	}
+
+	var gap *Gap
+	switch {
+	case after > r.last:
+		gap = &Gap{Reset: true, ResumeFrom: oldest}
+		after = oldest - 1
+	case after+1 < oldest:
+		gap = &Gap{Missed: oldest - after - 1, ResumeFrom: oldest}
+		after = oldest - 1
+	}
+
+	var msgs []Message
+	for seq := after + 1; seq <= r.last && len(msgs) < max; seq++ {
+		msgs = append(msgs, r.items[seq%uint64(len(r.items))])
+	}
+	return msgs, gap, r.changed
+}
+
+// ================================================
+// Subject filters
+// ================================================
+
+// validatePattern checks a NATS subject pattern: dot-separated tokens where
+// "*" matches one token and a final ">" matches the rest
+func validatePattern(pattern string) error {
+	tokens := strings.Split(pattern, ".")
+	for i, token := range tokens {
+		switch {
+		case token == "":
+			return fmt.Errorf("invalid subject %q: empty token", pattern)
+		case token == ">" && i != len(tokens)-1:
+			return fmt.Errorf("invalid subject %q: > must be the last token", pattern)
+		case token != "*" && token != ">" && strings.ContainsAny(token, "*> \t"):
+			return fmt.Errorf("invalid subject %q: wildcards must be whole tokens", pattern)
+		}
+	}
+	return nil
+}
+
+// subjectMatches reports whether a subject matches a NATS subject pattern
+func subjectMatches(pattern, subject string) bool {
+	patternTokens := strings.Split(pattern, ".")
+	subjectTokens := strings.Split(subject, ".")
+	for i, token := range patternTokens {
+		if token == ">" {
+			return len(subjectTokens) > i
+		}
+		if i >= len(subjectTokens) || (token != "*" && token != subjectTokens[i]) {
+			return false
+		}
+	}
+	return len(subjectTokens) == len(patternTokens)
+}
+
+// matchesAny reports whether a subject matches one of the patterns; no
+// patterns match everything
+func matchesAny(patterns []string, subject string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if subjectMatches(pattern, subject) {
+			return true
+		}
+	}
+	return false
+}
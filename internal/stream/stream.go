@@ -0,0 +1,446 @@
+// Package stream bridges NATS to HTTP clients that cannot speak NATS:
+// browsers and simple scripts. Agent status and output, system broadcasts
+// and escalations are streamed as server-sent events or over a WebSocket,
+// filtered by subject and resumable by sequence number. Commands can be
+// posted back to agents.
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+	nc "github.com/nats-io/nats.go"
+)
+
+const (
+	// bufferSize is how many recent messages are kept for resuming clients
+	bufferSize = 4096
+
+	// batchSize is how many messages are written before checking for shutdown
+	batchSize = 256
+
+	// writeTimeout drops clients that stop reading altogether
+	writeTimeout = 10 * time.Second
+
+	// heartbeatInterval keeps idle streams open through proxies and detects
+	// dead WebSocket peers
+	heartbeatInterval = 15 * time.Second
+)
+
+// Subjects are the NATS subjects bridged to clients
+var Subjects = []string{
+	natslib.SubjectAllStatus,
+	natslib.SubjectAllOutput,
+	natslib.SubjectSystemBroadcast,
+	natslib.SubjectAllEscalations,
+}
+
+var (
+	errInvalidCommand = errors.New("invalid command")
+	errUnknownAgent   = errors.New("unknown agent")
+)
+
+// Bridge relays NATS messages to HTTP streams and commands back to NATS
+type Bridge struct {
+	agentExists func(agentID string) bool
+	client      *natslib.Client
+	ring        *ring
+	mux         *http.ServeMux
+
+	stopCh    chan struct{}
+	stopOnce  sync.Once
+	streams   sync.WaitGroup
+	receiving sync.WaitGroup
+}
+
+// New creates a bridge; agentExists guards commands against unknown agents
+func New(agentExists func(agentID string) bool) *Bridge {
+	b := &Bridge{
+		agentExists: agentExists,
+		ring:        newRing(bufferSize),
+		mux:         http.NewServeMux(),
+		stopCh:      make(chan struct{}),
+	}
+	b.mux.HandleFunc("GET /api/stream", b.handleStream)
+	b.mux.HandleFunc("POST /api/stream/agents/{id}/command", b.handleCommand)
+	return b
+}
+
+// Start connects to NATS and begins buffering messages
+func (b *Bridge) Start(natsURL string) error {
+	client, err := natslib.NewClient(natsURL, "stream")
+	if err != nil {
+		return fmt.Errorf("failed to create NATS client for stream: %w", err)
+	}
+
+	// One channel for all subjects keeps messages in the order they arrived;
+	// asynchronous subscriptions would each deliver on their own goroutine
+	msgs := make(chan *nc.Msg, bufferSize)
+	for _, subject := range Subjects {
+		if _, err := client.RawConn().ChanSubscribe(subject, msgs); err != nil {
+			client.Close()
+			return fmt.Errorf("failed to subscribe stream to %s: %w", subject, err)
+		}
+	}
+	b.client = client
+
+	b.receiving.Add(1)
+	go func() {
+		defer b.receiving.Done()
+		for {
+			select {
+			case <-b.stopCh:
+				return
+			case msg := <-msgs:
+				b.ring.append(msg.Subject, msg.Data)
+			}
+		}
+	}()
+
+	log.Printf("[STREAM] Started (bridging %s)", strings.Join(Subjects, ", "))
+	return nil
+}
+
+// Stop ends open streams and disconnects from NATS. Call it before shutting
+// down the HTTP server, which waits for event streams and does not track
+// WebSockets at all.
+func (b *Bridge) Stop() {
+	b.stopOnce.Do(func() { close(b.stopCh) })
+	b.streams.Wait()
+	b.receiving.Wait()
+	if b.client != nil {
+		b.client.Close()
+	}
+}
+
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mux.ServeHTTP(w, r)
+}
+
+// ================================================
+// Streaming
+// ================================================
+
+// subscription is what a client asked to receive
+type subscription struct {
+	subjects []string // patterns; none means all bridged subjects
+	cursor   uint64   // last sequence number seen
+}
+
+// parseSubscription reads the subject filters and the resume point: the
+// SSE Last-Event-ID header, else ?since=<seq>, else only new messages
+func (b *Bridge) parseSubscription(r *http.Request) (*subscription, error) {
+	query := r.URL.Query()
+	sub := &subscription{cursor: b.ring.head()}
+
+	for _, value := range query["subject"] {
+		for _, pattern := range strings.Split(value, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern == "" {
+				continue
+			}
+			if err := validatePattern(pattern); err != nil {
+				return nil, err
+			}
+			sub.subjects = append(sub.subjects, pattern)
+		}
+	}
+
+	since := r.Header.Get("Last-Event-ID")
+	if since == "" {
+		since = query.Get("since")
+	}
+	if since != "" {
+		seq, err := strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sequence number %q", since)
+		}
+		sub.cursor = seq
+	}
+	return sub, nil
+}
+
+// sink is a client connection that events are written to
+type sink interface {
+	message(msg Message) error
+	gap(gap *Gap) error
+	heartbeat() error
+	flush() error
+}
+
+// pump writes messages matching the subscription until ctx ends, the bridge
+// stops or a write fails
+func (b *Bridge) pump(ctx context.Context, sub *subscription, out sink) error {
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		msgs, gap, changed := b.ring.read(sub.cursor, batchSize)
+		if gap != nil {
+			if err := out.gap(gap); err != nil {
+				return err
+			}
+		}
+		for _, msg := range msgs {
+			sub.cursor = msg.Seq
+			if !matchesAny(sub.subjects, msg.Subject) {
+				continue
+			}
+			if err := out.message(msg); err != nil {
+				return err
+			}
+		}
+		if err := out.flush(); err != nil {
+			return err
+		}
+		if len(msgs) == batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-b.stopCh:
+			return nil
+		case <-heartbeat.C:
+			if err := out.heartbeat(); err != nil {
+				return err
+			}
+		case <-changed:
+		}
+	}
+}
+
+func (b *Bridge) handleStream(w http.ResponseWriter, r *http.Request) {
+	sub, err := b.parseSubscription(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case <-b.stopCh:
+		http.Error(w, "Server stopping", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+	b.streams.Add(1)
+	defer b.streams.Done()
+
+	if isWebSocketRequest(r) {
+		b.serveWebSocket(w, r, sub)
+		return
+	}
+	b.serveSSE(w, r, sub)
+}
+
+// sseSink writes server-sent events; the event ID is the sequence number
+type sseSink struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (s *sseSink) write(format string, args ...interface{}) error {
+	s.rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := fmt.Fprintf(s.w, format, args...)
+	return err
+}
+
+func (s *sseSink) message(msg Message) error {
+	data, _ := json.Marshal(msg)
+	return s.write("id: %d\nevent: message\ndata: %s\n\n", msg.Seq, data)
+}
+
+func (s *sseSink) gap(gap *Gap) error {
+	data, _ := json.Marshal(gap)
+	return s.write("event: gap\ndata: %s\n\n", data)
+}
+
+func (s *sseSink) heartbeat() error {
+	if err := s.write(": ping\n\n"); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+func (s *sseSink) flush() error {
+	return s.rc.Flush()
+}
+
+func (b *Bridge) serveSSE(w http.ResponseWriter, r *http.Request, sub *subscription) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	out := &sseSink{w: w, rc: http.NewResponseController(w)}
+	if err := out.write("retry: 2000\n\n"); err != nil {
+		return
+	}
+
+	if err := b.pump(r.Context(), sub, out); err != nil {
+		log.Printf("[STREAM] Event stream to %s ended: %v", r.RemoteAddr, err)
+	}
+}
+
+// wsEvent is one WebSocket message to the client
+type wsEvent struct {
+	Type string `json:"type"` // message, gap, ack or error
+	*Message
+	*Gap
+	Ref   string `json:"ref,omitempty"` // echoes the command's ref
+	Error string `json:"error,omitempty"`
+}
+
+// CommandRequest is a command sent by a WebSocket client
+type CommandRequest struct {
+	Type    string                 `json:"type"` // "command"
+	Ref     string                 `json:"ref,omitempty"`
+	AgentID string                 `json:"agent_id"`
+	Command natslib.CommandMessage `json:"command"`
+}
+
+// wsSink writes events as JSON text messages
+type wsSink struct {
+	conn *wsConn
+}
+
+func (s *wsSink) send(event wsEvent) error {
+	data, _ := json.Marshal(event)
+	return s.conn.writeText(data)
+}
+
+func (s *wsSink) message(msg Message) error {
+	return s.send(wsEvent{Type: "message", Message: &msg})
+}
+
+func (s *wsSink) gap(gap *Gap) error {
+	return s.send(wsEvent{Type: "gap", Gap: gap})
+}
+
+func (s *wsSink) heartbeat() error {
+	return s.conn.write(opPing, nil)
+}
+
+func (s *wsSink) flush() error {
+	return nil
+}
+
+func (b *Bridge) serveWebSocket(w http.ResponseWriter, r *http.Request, sub *subscription) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		log.Printf("[STREAM] WebSocket upgrade from %s failed: %v", r.RemoteAddr, err)
+		return
+	}
+	out := &wsSink{conn: conn}
+
+	// The hijacked connection outlives the request context
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Read commands until the client goes away
+	go func() {
+		defer cancel()
+		for {
+			_, data, err := conn.readMessage()
+			if err != nil {
+				var protocolErr *closeError
+				if errors.As(err, &protocolErr) {
+					conn.close(protocolErr.code, protocolErr.reason)
+				}
+				return
+			}
+			b.handleWebSocketCommand(out, data)
+		}
+	}()
+
+	err = b.pump(ctx, sub, out)
+	switch {
+	case err != nil:
+		log.Printf("[STREAM] WebSocket to %s ended: %v", r.RemoteAddr, err)
+		conn.close(closeGoingAway, "write failed")
+	case ctx.Err() == nil:
+		conn.close(closeGoingAway, "server stopping")
+	default:
+		conn.close(closeNormal, "")
+	}
+}
+
+// handleWebSocketCommand publishes a command and acknowledges it
+func (b *Bridge) handleWebSocketCommand(out *wsSink, data []byte) {
+	var req CommandRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		out.send(wsEvent{Type: "error", Error: fmt.Sprintf("invalid JSON: %v", err)})
+		return
+	}
+	if req.Type != "command" {
+		out.send(wsEvent{Type: "error", Ref: req.Ref, Error: fmt.Sprintf("unknown message type %q", req.Type)})
+		return
+	}
+	if err := b.sendCommand(req.AgentID, req.Command); err != nil {
+		out.send(wsEvent{Type: "error", Ref: req.Ref, Error: err.Error()})
+		return
+	}
+	out.send(wsEvent{Type: "ack", Ref: req.Ref})
+}
+
+// ================================================
+// Commands
+// ================================================
+
+// sendCommand publishes a command to agent.<id>.command
+func (b *Bridge) sendCommand(agentID string, cmd natslib.CommandMessage) error {
+	if agentID == "" || strings.ContainsAny(agentID, ".*> \t\r\n") {
+		return fmt.Errorf("%w: invalid agent ID %q", errInvalidCommand, agentID)
+	}
+	if cmd.Type == "" {
+		return fmt.Errorf("%w: type is required", errInvalidCommand)
+	}
+	if b.agentExists != nil && !b.agentExists(agentID) {
+		return fmt.Errorf("%w: %s", errUnknownAgent, agentID)
+	}
+	if b.client == nil {
+		return errors.New("not connected to NATS")
+	}
+	return b.client.PublishJSON(fmt.Sprintf(natslib.SubjectAgentCommand, agentID), cmd)
+}
+
+func (b *Bridge) handleCommand(w http.ResponseWriter, r *http.Request) {
+	// Requiring JSON keeps other sites' pages from posting forms here
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	var cmd natslib.CommandMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMessageBytes)).Decode(&cmd); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	agentID := r.PathValue("id")
+	if err := b.sendCommand(agentID, cmd); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, errInvalidCommand):
+			status = http.StatusBadRequest
+		case errors.Is(err, errUnknownAgent):
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "sent",
+		"subject": fmt.Sprintf(natslib.SubjectAgentCommand, agentID),
+	})
+}
@@ -0,0 +1,236 @@
+package stream
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+func TestSubjectMatches(t *testing.T) {
+	cases := []struct {
+		pattern, subject string
+		want             bool
+	}{
+		{"agent.*.output", "agent.a1.output", true},
+		{"agent.*.output", "agent.a1.status", false},
+		{"agent.a1.*", "agent.a1.status", true},
+		{"agent.a1.*", "agent.a2.status", false},
+		{"escalation.>", "escalation.create", true},
+		{"escalation.>", "escalation.response.e1", true},
+		{"escalation.>", "escalation", false},
+		{"escalation.*", "escalation.response.e1", false},
+		{"system.broadcast", "system.broadcast", true},
+		{"agent.*", "agent.a1.status", false},
+	}
+	for _, c := range cases {
+		if got := subjectMatches(c.pattern, c.subject); got != c.want {
+			t.Errorf("subjectMatches(%q, %q) = %v, want %v", c.pattern, c.subject, got, c.want)
+		}
+	}
+
+	for _, pattern := range []string{"agent..status", "agent.>.status", "agent.a*.status", ""} {
+		if validatePattern(pattern) == nil {
+			t.Errorf("Expected %q to be rejected", pattern)
+		}
+	}
+}
+
+func TestRingGapsAndReset(t *testing.T) {
+	r := newRing(4)
+	for i := 1; i <= 10; i++ {
+		r.append("agent.a1.output", []byte(fmt.Sprintf(`{"n":%d}`, i)))
+	}
+
+	msgs, gap, _ := r.read(2, 100)
+	if gap == nil || gap.Missed != 4 || gap.ResumeFrom != 7 || gap.Reset {
+		t.Errorf("Expected 4 missed, resuming from 7; got %+v", gap)
+	}
+	if len(msgs) != 4 || msgs[0].Seq != 7 || msgs[3].Seq != 10 || string(msgs[3].Data) != `{"n":10}` {
+		t.Errorf("Expected messages 7-10, got %+v", msgs)
+	}
+
+	if _, gap, _ := r.read(50, 100); gap == nil || !gap.Reset || gap.ResumeFrom != 7 {
+		t.Errorf("Expected a reset for a cursor from another run, got %+v", gap)
+	}
+
+	msgs, gap, changed := r.read(10, 100)
+	if len(msgs) != 0 || gap != nil {
+		t.Errorf("Expected nothing new, got %+v %+v", msgs, gap)
+	}
+	r.append("system.broadcast", []byte("not json"))
+	select {
+	case <-changed:
+	default:
+		t.Error("Expected readers to be woken")
+	}
+	if msgs, _, _ := r.read(10, 100); len(msgs) != 1 || string(msgs[0].Data) != `"not json"` {
+		t.Errorf("Expected the payload as a JSON string, got %+v", msgs)
+	}
+}
+
+// setupTestBridge starts an embedded NATS server and a bridge in front of it
+func setupTestBridge(t *testing.T) (*httptest.Server, *natslib.Client) {
+	t.Helper()
+
+	ns, err := server.NewServer(&server.Options{Port: server.RANDOM_PORT, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("Failed to create NATS server: %v", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	t.Cleanup(ns.Shutdown)
+
+	bridge := New(func(agentID string) bool { return agentID == "a1" })
+	if err := bridge.Start(ns.ClientURL()); err != nil {
+		t.Fatalf("Failed to start bridge: %v", err)
+	}
+	t.Cleanup(bridge.Stop)
+
+	client, err := natslib.NewClient(ns.ClientURL(), "test")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(client.Close)
+
+	srv := httptest.NewServer(bridge)
+	t.Cleanup(srv.Close)
+	return srv, client
+}
+
+// sseEvent is one parsed server-sent event
+type sseEvent struct {
+	id, event, data string
+}
+
+// openSSE opens an event stream and waits for it to be subscribed
+func openSSE(t *testing.T, url, lastEventID string) (*bufio.Reader, func()) {
+	t.Helper()
+	req, _ := http.NewRequest("GET", url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s returned %d", url, resp.StatusCode)
+	}
+	reader := bufio.NewReader(resp.Body)
+	if line, _ := reader.ReadString('\n'); line != "retry: 2000\n" {
+		t.Fatalf("Unexpected preamble %q", line)
+	}
+	reader.ReadString('\n')
+	return reader, func() { resp.Body.Close() }
+}
+
+func readSSE(t *testing.T, reader *bufio.Reader) sseEvent {
+	t.Helper()
+	var ev sseEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Stream ended: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return ev
+		case strings.HasPrefix(line, "id: "):
+			ev.id = line[4:]
+		case strings.HasPrefix(line, "event: "):
+			ev.event = line[7:]
+		case strings.HasPrefix(line, "data: "):
+			ev.data = line[6:]
+		}
+	}
+}
+
+func TestSSEFiltersAndResumes(t *testing.T) {
+	srv, client := setupTestBridge(t)
+	url := srv.URL + "/api/stream?subject=agent.*.output"
+
+	reader, closeStream := openSSE(t, url, "")
+	client.PublishJSON("agent.a1.status", natslib.StatusMessage{AgentID: "a1", Status: "working"})
+	client.PublishJSON("agent.a1.output", natslib.OutputMessage{AgentID: "a1", Content: "first"})
+	client.PublishJSON("agent.a1.output", natslib.OutputMessage{AgentID: "a1", Content: "second"})
+
+	first := readSSE(t, reader)
+	if first.event != "message" || !strings.Contains(first.data, `"subject":"agent.a1.output"`) || !strings.Contains(first.data, "first") {
+		t.Fatalf("Expected the first output line, got %+v", first)
+	}
+	if second := readSSE(t, reader); !strings.Contains(second.data, "second") {
+		t.Fatalf("Expected the second output line, got %+v", second)
+	}
+	closeStream()
+
+	// A browser reconnecting sends the last ID it saw and gets the rest
+	reader, closeStream = openSSE(t, url, first.id)
+	defer closeStream()
+	if replayed := readSSE(t, reader); !strings.Contains(replayed.data, "second") {
+		t.Errorf("Expected the second line replayed, got %+v", replayed)
+	}
+
+	resp, _ := http.Get(srv.URL + "/api/stream?subject=agent.>.output")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid pattern, got %d", resp.StatusCode)
+	}
+}
+
+func TestPostCommand(t *testing.T) {
+	srv, client := setupTestBridge(t)
+
+	received := make(chan natslib.CommandMessage, 1)
+	client.Subscribe("agent.a1.command", func(msg *natslib.Message) {
+		var cmd natslib.CommandMessage
+		json.Unmarshal(msg.Data, &cmd)
+		received <- cmd
+	})
+	client.Flush()
+
+	post := func(agentID, contentType, body string) int {
+		resp, err := http.Post(srv.URL+"/api/stream/agents/"+agentID+"/command", contentType, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := post("a1", "application/json", `{"type":"prompt","payload":{"text":"add tests"}}`); status != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d", status)
+	}
+	select {
+	case cmd := <-received:
+		if cmd.Type != "prompt" || cmd.Payload["text"] != "add tests" {
+			t.Errorf("Unexpected command %+v", cmd)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Command not published")
+	}
+
+	for _, c := range []struct {
+		agentID, contentType, body string
+		status                     int
+	}{
+		{"a2", "application/json", `{"type":"stop"}`, http.StatusNotFound},
+		{"a1", "application/json", `{"payload":{}}`, http.StatusBadRequest},
+		{"a1.x", "application/json", `{"type":"stop"}`, http.StatusBadRequest},
+		{"a1", "text/plain", `{"type":"stop"}`, http.StatusUnsupportedMediaType},
+	} {
+		if status := post(c.agentID, c.contentType, c.body); status != c.status {
+			t.Errorf("POST to %s with %s: expected %d, got %d", c.agentID, c.body, c.status, status)
+		}
+	}
+}
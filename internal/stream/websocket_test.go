@@ -0,0 +1,216 @@
+package stream
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	natslib "github.com/CLIAIRMONITOR/internal/nats"
+)
+
+// wsClient is just enough of a WebSocket client to test the server
+type wsClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, serverURL, path string, header http.Header) (*wsClient, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(serverURL, "http://"))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	key := make([]byte, 16)
+	rand.Read(key)
+	req, _ := http.NewRequest("GET", serverURL+path, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatalf("Failed to read handshake: %v", err)
+	}
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		sum := sha1.Sum([]byte(req.Header.Get("Sec-WebSocket-Key") + websocketGUID))
+		if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+			t.Fatal("Wrong Sec-WebSocket-Accept")
+		}
+	}
+	return &wsClient{conn: conn, reader: reader}, resp
+}
+
+// writeFrame sends a masked frame, as browsers do
+func (c *wsClient) writeFrame(fin bool, opcode int, payload []byte) {
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+	if len(payload) <= 125 {
+		frame = append(frame, 0x80|byte(len(payload)))
+	} else {
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	c.conn.Write(frame)
+}
+
+// readFrame reads one unmasked frame
+func (c *wsClient) readFrame(t *testing.T) (int, []byte) {
+	t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if header[1]&0x80 != 0 {
+		t.Fatal("Server frames must not be masked")
+	}
+	length := int(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.reader, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.reader, ext[:])
+		length = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload := make([]byte, length)
+	io.ReadFull(c.reader, payload)
+	return int(header[0] & 0x0F), payload
+}
+
+// readEvent skips control frames and decodes the next event
+func (c *wsClient) readEvent(t *testing.T) map[string]interface{} {
+	t.Helper()
+	for {
+		opcode, payload := c.readFrame(t)
+		if opcode != opText {
+			continue
+		}
+		var event map[string]interface{}
+		if err := json.Unmarshal(payload, &event); err != nil {
+			t.Fatalf("Invalid event %q: %v", payload, err)
+		}
+		return event
+	}
+}
+
+func TestWebSocketStreamAndCommands(t *testing.T) {
+	srv, client := setupTestBridge(t)
+
+	received := make(chan natslib.CommandMessage, 1)
+	client.Subscribe("agent.a1.command", func(msg *natslib.Message) {
+		var cmd natslib.CommandMessage
+		json.Unmarshal(msg.Data, &cmd)
+		received <- cmd
+	})
+	client.Flush()
+
+	ws, resp := dialWebSocket(t, srv.URL, "/api/stream?subject=escalation.>", nil)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %d", resp.StatusCode)
+	}
+
+	// Ping round trip: the server is reading by now
+	ws.writeFrame(true, opPing, []byte("hi"))
+	if opcode, payload := ws.readFrame(t); opcode != opPong || string(payload) != "hi" {
+		t.Fatalf("Expected pong, got %d %q", opcode, payload)
+	}
+
+	client.PublishJSON("agent.a1.output", natslib.OutputMessage{AgentID: "a1", Content: "filtered out"})
+	client.PublishJSON(natslib.SubjectEscalationCreate, natslib.EscalationCreateMessage{ID: "e1", AgentID: "a1", Question: "Which DB?"})
+	event := ws.readEvent(t)
+	if event["type"] != "message" || event["subject"] != natslib.SubjectEscalationCreate || event["seq"] != float64(2) {
+		t.Fatalf("Expected the escalation, got %v", event)
+	}
+	if data, _ := event["data"].(map[string]interface{}); data["question"] != "Which DB?" {
+		t.Errorf("Expected the payload inline, got %v", event["data"])
+	}
+
+	// A command split across two frames
+	command := []byte(`{"type":"command","ref":"r1","agent_id":"a1","command":{"type":"interrupt"}}`)
+	ws.writeFrame(false, opText, command[:10])
+	ws.writeFrame(true, opContinuation, command[10:])
+	if event := ws.readEvent(t); event["type"] != "ack" || event["ref"] != "r1" {
+		t.Fatalf("Expected an ack, got %v", event)
+	}
+	select {
+	case cmd := <-received:
+		if cmd.Type != "interrupt" {
+			t.Errorf("Unexpected command %+v", cmd)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Command not published")
+	}
+
+	ws.writeFrame(true, opText, []byte(`{"type":"command","ref":"r2","agent_id":"nobody","command":{"type":"stop"}}`))
+	if event := ws.readEvent(t); event["type"] != "error" || event["ref"] != "r2" || !strings.Contains(event["error"].(string), "unknown agent") {
+		t.Errorf("Expected an unknown agent error, got %v", event)
+	}
+
+	// Unmasked frames are a protocol error
+	ws.conn.Write([]byte{0x81, 0x01, 'x'})
+	opcode, payload := ws.readFrame(t)
+	if opcode != opClose || binary.BigEndian.Uint16(payload) != closeProtocolError {
+		t.Errorf("Expected close %d, got opcode %d %q", closeProtocolError, opcode, payload)
+	}
+}
+
+func TestWebSocketResumeAndOrigin(t *testing.T) {
+	srv, client := setupTestBridge(t)
+
+	// Buffer two messages, then ask for everything after the first
+	reader, closeStream := openSSE(t, srv.URL+"/api/stream", "")
+	client.PublishJSON(natslib.SubjectSystemBroadcast, natslib.SystemBroadcastMessage{Type: "config_change", Message: "one"})
+	client.PublishJSON(natslib.SubjectSystemBroadcast, natslib.SystemBroadcastMessage{Type: "config_change", Message: "two"})
+	readSSE(t, reader)
+	readSSE(t, reader)
+	closeStream()
+
+	ws, _ := dialWebSocket(t, srv.URL, "/api/stream?since=1", nil)
+	if event := ws.readEvent(t); event["seq"] != float64(2) {
+		t.Errorf("Expected message 2 replayed, got %v", event)
+	}
+
+	ws, _ = dialWebSocket(t, srv.URL, "/api/stream?since=99", nil)
+	if event := ws.readEvent(t); event["type"] != "gap" || event["reset"] != true || event["resume_from"] != float64(1) {
+		t.Errorf("Expected a reset gap, got %v", event)
+	}
+
+	_, resp := dialWebSocket(t, srv.URL, "/api/stream", http.Header{"Origin": {"http://evil.example"}})
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a cross-origin upgrade to be refused, got %d", resp.StatusCode)
+	}
+	_, resp = dialWebSocket(t, srv.URL, "/api/stream", http.Header{"Origin": {srv.URL}})
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("Expected a same-origin upgrade, got %d", resp.StatusCode)
+	}
+}